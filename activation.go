@@ -0,0 +1,71 @@
+package socketeer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// Environment variables of the socket activation protocol of systemd.
+const (
+	envListenPID = "LISTEN_PID"
+	envListenFDs = "LISTEN_FDS"
+	envFDNames   = "LISTEN_FDNAMES"
+)
+
+// InheritedListeners returns the listeners passed to the process with
+// the socket activation protocol of systemd: the LISTEN_FDS file
+// descriptors from 3 on, when LISTEN_PID is the process or is unset,
+// the latter for a parent process passing its own listener to the new
+// binary of a zero-downtime upgrade. It returns nil when none was
+// passed, and unsets the variables so that the children don't inherit
+// them.
+//
+// # Example:
+//
+// 	listeners, err := socketeer.InheritedListeners()
+// 	if err != nil {
+// 		log.Fatal(err)
+// 	}
+// 	if len(listeners) > 0 {
+// 		s.Listener = listeners[0]
+// 	}
+func InheritedListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv(envListenPID)
+		os.Unsetenv(envListenFDs)
+		os.Unsetenv(envFDNames)
+	}()
+
+	if pid := os.Getenv(envListenPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	value := os.Getenv(envListenFDs)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("socketeer: invalid %s %q", envListenFDs, value)
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "listener-"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socketeer: file descriptor %d: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
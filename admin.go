@@ -0,0 +1,164 @@
+package socketeer
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// AdminStreamPath is the path of the admin websocket endpoint
+// pushing the Stats of the socketeer every AdminStreamInterval.
+const AdminStreamPath = "/admin/stream"
+
+// recentSize is the number of recent messages kept for the Stats.
+const recentSize = 20
+
+// AdminStreamInterval is the interval between two Stats
+// pushed on the admin stream.
+const AdminStreamInterval = time.Second
+
+// Stats are the real-time statistics of a running socketeer.
+//
+// 	- Time is when the statistics were taken.
+// 	- Uptime is the time since Start() in seconds.
+// 	- EventsReceived is the number of events read from the change source.
+// 	- EventRate is the number of events per second since the previous
+// 		Stats of the admin stream, 0 for the first one.
+// 	- Ready is whether the socketeer is ready, see Ready().
+// 	- Errors is the number of unexpected failures, see Reporter.
+// 	- Topics is the number of events received per topic.
+// 	- Recent are the last dispatched messages, oldest first.
+// 	- Clients, Identities, Sessions, QueueDepth, MaxQueueDepth and History
+// 		are the statistics of the websocket clients, see WebSocketStats.
+type Stats struct {
+	Time           time.Time         `json:"time"`
+	Uptime         float64           `json:"uptime"`
+	EventsReceived uint64            `json:"eventsReceived"`
+	EventRate      float64           `json:"eventRate"`
+	Ready          bool              `json:"ready"`
+	Errors         uint64            `json:"errors"`
+	Topics         map[string]uint64 `json:"topics"`
+	Recent         []Message         `json:"recent"`
+	WebSocketStats
+}
+
+// WebSocketStats are the statistics of the websocket clients:
+// the number of clients, identities and resumable sessions,
+// and the depth of the send queues.
+type WebSocketStats = ws.Stats
+
+// statser is implemented by the broadcasters which
+// report the statistics of their clients.
+type statser interface {
+	Stats() ws.Stats
+}
+
+// streamer is implemented by the broadcasters which
+// can push values to a websocket connection.
+type streamer interface {
+	Stream(res http.ResponseWriter, req *http.Request, interval time.Duration, next func() any)
+}
+
+// track counts a dispatched message in the Stats.
+func (s *Socketeer) track(msg Message) {
+	s.statsMux.Lock()
+	defer s.statsMux.Unlock()
+
+	if s.topics == nil {
+		s.topics = make(map[string]uint64)
+	}
+	s.topics[msg.Topic]++
+
+	if len(s.recent) >= recentSize {
+		s.recent = s.recent[1:]
+	}
+	s.recent = append(s.recent, msg)
+}
+
+// Stats returns the real-time statistics of the socketeer.
+//
+// # Example:
+//
+// 	stats := s.Stats()
+// 	fmt.Println(stats.Clients, stats.QueueDepth)
+func (s *Socketeer) Stats() Stats {
+	stats := Stats{
+		Time:           time.Now(),
+		EventsReceived: s.events.Load(),
+		Ready:          s.Ready() == nil,
+		Errors:         s.errors.Load(),
+		Topics:         make(map[string]uint64),
+	}
+
+	s.statsMux.Lock()
+	for topic, n := range s.topics {
+		stats.Topics[topic] = n
+	}
+	stats.Recent = append([]Message(nil), s.recent...)
+	s.statsMux.Unlock()
+
+	if started := s.started.Load(); started != 0 {
+		stats.Uptime = time.Since(time.Unix(0, started)).Seconds()
+	}
+	if st, ok := s.WS.(statser); ok {
+		stats.WebSocketStats = st.Stats()
+	}
+
+	return stats
+}
+
+// authorizeAdmin reports whether a request carries the AdminToken,
+// as a bearer token or as the "token" query parameter for browsers
+// which can't set headers on websocket connections. Admin requests
+// are refused when no AdminToken is set.
+func (s *Socketeer) authorizeAdmin(req *http.Request) bool {
+	if s.AdminToken == "" {
+		return false
+	}
+
+	token := req.URL.Query().Get("token")
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1
+}
+
+// serveAdminStream serves the admin stream, a websocket connection
+// receiving the Stats of the socketeer every AdminStreamInterval.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the upgrade request.
+//
+// # Example:
+//
+// 	r.Handle(AdminStreamPath, http.HandlerFunc(s.serveAdminStream))
+func (s *Socketeer) serveAdminStream(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	st, ok := s.WS.(streamer)
+	if !ok {
+		http.Error(res, ErrUnsupported.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	var previous Stats
+	st.Stream(res, req, AdminStreamInterval, func() any {
+		stats := s.Stats()
+		if !previous.Time.IsZero() {
+			elapsed := stats.Time.Sub(previous.Time).Seconds()
+			stats.EventRate = float64(stats.EventsReceived-previous.EventsReceived) / elapsed
+		}
+		previous = stats
+
+		return stats
+	})
+}
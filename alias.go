@@ -0,0 +1,122 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// checkAliases checks that no two fields of the Aliases share a
+// payload name, and keeps the database name of every payload name.
+func (s *Socketeer) checkAliases() error {
+	fields := make(map[string]string, len(s.Aliases))
+	for field, name := range s.Aliases {
+		if name == "" {
+			return fmt.Errorf("socketeer: empty alias of %q", field)
+		}
+		if other, ok := fields[name]; ok {
+			return fmt.Errorf("socketeer: %q and %q have the same alias %q", field, other, name)
+		}
+		fields[name] = field
+	}
+	s.aliasFields = fields
+
+	return nil
+}
+
+// alias returns the payload name of a database field.
+func (s *Socketeer) alias(field string) string {
+	if name, ok := s.Aliases[field]; ok {
+		return name
+	}
+
+	return field
+}
+
+// unalias returns the database field of a payload name.
+func (s *Socketeer) unalias(name string) string {
+	if field, ok := s.aliasFields[name]; ok {
+		return field
+	}
+
+	return name
+}
+
+// aliasMap returns a map with the fields of m renamed to their
+// payload name, m itself when there is no alias.
+func (s *Socketeer) aliasMap(m map[string]string) map[string]string {
+	if len(s.Aliases) == 0 || m == nil {
+		return m
+	}
+
+	aliased := make(map[string]string, len(m))
+	for field, value := range m {
+		aliased[s.alias(field)] = value
+	}
+
+	return aliased
+}
+
+// aliasMessage renames the fields of the data, the document key,
+// the full document, the array changes and the patch of a message to
+// their payload name. The descriptions of the schema operations, the
+// renames and the drops are left as is, the documents of the snapshots
+// are renamed by find().
+//
+// # Parameters:
+//
+// 	- msg (Message): the message.
+//
+// # Example:
+//
+// 	msg = s.aliasMessage(msg)
+func (s *Socketeer) aliasMessage(msg Message) Message {
+	if len(s.Aliases) == 0 || event.IsCollectionOp(msg.OperationType) {
+		return msg
+	}
+
+	msg.Data = s.aliasMap(msg.Data)
+	msg.DocumentKey = s.aliasMap(msg.DocumentKey)
+	msg.FullDocument = s.aliasMap(msg.FullDocument)
+	if len(msg.ArrayChanges) > 0 {
+		changes := make([]ArrayChange, len(msg.ArrayChanges))
+		for i, change := range msg.ArrayChanges {
+			change.Field = s.alias(change.Field)
+			changes[i] = change
+		}
+		msg.ArrayChanges = changes
+	}
+	if len(msg.Patch) > 0 {
+		var patch map[string]json.RawMessage
+		err := json.Unmarshal(msg.Patch, &patch)
+		if err == nil {
+			aliased := make(map[string]json.RawMessage, len(patch))
+			for field, value := range patch {
+				aliased[s.alias(field)] = value
+			}
+			msg.Patch, err = json.Marshal(aliased)
+		}
+		if err != nil {
+			s.report(err, map[string]any{"component": "aliases", "collection": msg.Topic})
+		}
+	}
+
+	return msg
+}
+
+// unaliasFilter returns a filter with the payload names of its fields
+// turned back into the database fields, for the queries and the
+// filters pushed down to the change stream.
+func (s *Socketeer) unaliasFilter(f map[string]string) map[string]string {
+	if len(s.aliasFields) == 0 || f == nil {
+		return f
+	}
+
+	unaliased := make(map[string]string, len(f))
+	for name, value := range f {
+		unaliased[s.unalias(name)] = value
+	}
+
+	return unaliased
+}
@@ -0,0 +1,367 @@
+// Package archive provides a sink of the socketeer batching the
+// messages by time window into gzip compressed JSON lines objects
+// uploaded to an object storage, S3 or GCS, for long-term retention
+// and offline reprocessing.
+//
+// # Usage:
+//
+// 	store := archive.NewS3("events-archive", "eu-west-1", os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+// 	cold := archive.New("cold", store)
+// 	cold.Prefix = "socketeer/orders/"
+// 	cold.Window = 10 * time.Minute
+// 	s.Sinks = []socketeer.Sink{cold}
+//
+// The objects are named with the time their window opened, example:
+// socketeer/orders/2024/01/02/150405.000000.jsonl.gz
+//
+// The Format of a Sink encodes its objects, gzip compressed JSON lines
+// by default, see the parquet package for columnar files.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// DefaultWindow is the time window of the objects when the Window of
+// a Sink is not set.
+const DefaultWindow = 5 * time.Minute
+
+// DefaultTimeout bounds the uploads which aren't bound by the context
+// of a delivery, those of the expired windows and of Close().
+const DefaultTimeout = 30 * time.Second
+
+// keyFormat is the format of the time of the object keys, after
+// the Prefix.
+const keyFormat = "2006/01/02/150405.000000"
+
+// Uploader uploads an object to a storage, see S3.
+//
+// 	- Upload stores body under key with its content type, replacing
+// 		any object with the same key.
+type Uploader interface {
+	Upload(ctx context.Context, key string, contentType string, body []byte) error
+}
+
+// Format encodes the batches of a Sink into objects, see JSONLines.
+//
+// 	- Extension is appended to the keys of the objects, like ".jsonl.gz".
+// 	- ContentType is the content type of the objects.
+// 	- NewBatch returns a new empty batch.
+type Format interface {
+	Extension() string
+	ContentType() string
+	NewBatch() Batch
+}
+
+// Batch is the content of an object being batched.
+//
+// 	- Add adds a message to the batch, a failure leaves the batch as it was.
+// 	- Seal returns the content of the object, the batch is not used afterwards.
+type Batch interface {
+	Add(msg socketeer.Message) error
+	Seal() ([]byte, error)
+}
+
+// JSONLines is the default Format of a Sink, one JSON object per
+// message and per line, gzip compressed.
+type JSONLines struct{}
+
+// Extension returns the extension of the objects, ".jsonl.gz".
+func (JSONLines) Extension() string {
+	return ".jsonl.gz"
+}
+
+// ContentType returns the content type of the objects, the one of
+// JSON lines since the compression is part of the object.
+func (JSONLines) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// NewBatch returns a new empty batch.
+func (JSONLines) NewBatch() Batch {
+	b := &jsonBatch{}
+	b.gz = gzip.NewWriter(&b.buf)
+
+	return b
+}
+
+// jsonBatch is a batch of the JSONLines format.
+//
+// 	- buf is the compressed content of the batch.
+// 	- gz compresses the lines into buf.
+type jsonBatch struct {
+	buf bytes.Buffer
+	gz  *gzip.Writer
+}
+
+// Add appends a message as a JSON line.
+func (b *jsonBatch) Add(msg socketeer.Message) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = b.gz.Write(append(line, '\n'))
+
+	return err
+}
+
+// Seal flushes the compression and returns the content.
+func (b *jsonBatch) Seal() ([]byte, error) {
+	err := b.gz.Close()
+
+	return b.buf.Bytes(), err
+}
+
+// object is a sealed batch waiting for its upload.
+//
+// 	- key is the key of the object.
+// 	- contentType is the content type of the object.
+// 	- body is the content of the object.
+type object struct {
+	key         string
+	contentType string
+	body        []byte
+}
+
+// Sink is a socketeer.Sink batching the messages into compressed
+// objects, one per Window, uploaded with the Uploader. An object is
+// uploaded once its window is over, or once it holds MaxEvents
+// messages, and the failed uploads are kept and tried again with the
+// next ones, so that an outage of the storage loses nothing but delays
+// the objects.
+//
+// 	- name is the name of the sink.
+// 	- Uploader uploads the objects.
+// 	- Prefix is prepended to the keys of the objects, like "events/".
+// 	- Window is the time window of an object, defaults to DefaultWindow.
+// 	- MaxEvents is the number of messages from which an object is
+// 		uploaded before the end of its window, 0 for no limit.
+// 	- Format encodes the objects, JSONLines when nil.
+// 	- mux guards the batch for thread safety.
+// 	- batch is the open batch, nil without open batch.
+// 	- count is the number of messages of the open batch.
+// 	- opened is when the open batch was opened.
+// 	- timer seals the open batch at the end of its window.
+// 	- last is the sequence number of the last batched message, so that
+// 		the retries of its delivery don't batch it twice.
+// 	- pending are the sealed batches waiting for their upload.
+type Sink struct {
+	name      string
+	Uploader  Uploader
+	Prefix    string
+	Window    time.Duration
+	MaxEvents int
+	Format    Format
+	mux       sync.Mutex
+	batch     Batch
+	count     int
+	opened    time.Time
+	timer     *time.Timer
+	last      uint64
+	pending   []object
+}
+
+// New returns a new Sink uploading its objects with uploader.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the sink.
+// 	- uploader (Uploader): uploads the objects.
+//
+// # Example:
+//
+// 	cold := archive.New("cold", archive.NewGCS("events-archive", accessKey, secretKey))
+func New(name string, uploader Uploader) *Sink {
+	return &Sink{name: name, Uploader: uploader}
+}
+
+// Name returns the name of the sink.
+func (s *Sink) Name() string {
+	return s.name
+}
+
+// Deliver adds a message to the open batch, then uploads the sealed
+// batches. A failed upload fails the delivery while the message stays
+// batched: the retries of the delivery only retry the upload.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the uploads.
+// 	- msg (socketeer.Message): the message.
+//
+// # Example:
+//
+// 	err := cold.Deliver(ctx, msg)
+func (s *Sink) Deliver(ctx context.Context, msg socketeer.Message) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if msg.Seq == 0 || msg.Seq != s.last {
+		if s.batch != nil && time.Since(s.opened) >= s.window() {
+			err := s.seal()
+			if err != nil {
+				return err
+			}
+		}
+		if s.batch == nil {
+			s.open()
+		}
+		err := s.batch.Add(msg)
+		if err != nil {
+			return err
+		}
+		s.count++
+		s.last = msg.Seq
+		if s.MaxEvents > 0 && s.count >= s.MaxEvents {
+			err = s.seal()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.upload(ctx)
+}
+
+// Close seals the open batch and uploads every sealed one, it fails
+// when some could not be uploaded within DefaultTimeout.
+//
+// # Example:
+//
+// 	err := cold.Close()
+func (s *Sink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var err error
+	if s.batch != nil {
+		err = s.seal()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	return errors.Join(err, s.upload(ctx))
+}
+
+// RetryPolicy retries the failed uploads for a few minutes, an outage
+// of the storage longer than that dead letters the messages delivered
+// meanwhile, which are still uploaded once the storage is back.
+//
+// # Example:
+//
+// 	policy := cold.RetryPolicy()
+func (s *Sink) RetryPolicy() socketeer.RetryPolicy {
+	return socketeer.RetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		Jitter:      0.2,
+	}
+}
+
+// window returns the Window with its default.
+func (s *Sink) window() time.Duration {
+	if s.Window > 0 {
+		return s.Window
+	}
+
+	return DefaultWindow
+}
+
+// format returns the Format with its default.
+func (s *Sink) format() Format {
+	if s.Format != nil {
+		return s.Format
+	}
+
+	return JSONLines{}
+}
+
+// open opens a new batch, sealed by its timer at the end of its window.
+func (s *Sink) open() {
+	s.batch = s.format().NewBatch()
+	s.opened = time.Now()
+	opened := s.opened
+	s.timer = time.AfterFunc(s.window(), func() {
+		s.expire(opened)
+	})
+}
+
+// seal closes the open batch and queues it for upload, a batch which
+// fails to seal is dropped.
+func (s *Sink) seal() error {
+	s.timer.Stop()
+	body, err := s.batch.Seal()
+	s.batch = nil
+	s.count = 0
+	if err != nil {
+		return err
+	}
+
+	format := s.format()
+	key := s.Prefix + s.opened.UTC().Format(keyFormat) + format.Extension()
+	s.pending = append(s.pending, object{key: key, contentType: format.ContentType(), body: body})
+
+	return nil
+}
+
+// expire seals the batch opened at the given time once its window is
+// over and uploads the sealed batches, a failure is left to the next
+// delivery.
+//
+// # Parameters:
+//
+// 	- opened (time.Time): when the batch was opened, a batch sealed
+// 		meanwhile is left alone.
+//
+// # Example:
+//
+// 	time.AfterFunc(s.window(), func() { s.expire(opened) })
+func (s *Sink) expire(opened time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.batch == nil || !s.opened.Equal(opened) {
+		return
+	}
+	err := s.seal()
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	s.upload(ctx)
+}
+
+// upload uploads the sealed batches in order, it stops at the first
+// failure, the failed batch and the following ones are kept.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the uploads.
+//
+// # Example:
+//
+// 	err := s.upload(ctx)
+func (s *Sink) upload(ctx context.Context) error {
+	for len(s.pending) > 0 {
+		p := s.pending[0]
+		err := s.Uploader.Upload(ctx, p.key, p.contentType, p.body)
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", p.key, err)
+		}
+		s.pending = s.pending[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// keyPattern is the key of an object documented in the README,
+// like orders/2024/01/02/150405.000000.jsonl.gz
+var keyPattern = regexp.MustCompile(`^orders/\d{4}/\d{2}/\d{2}/\d{6}\.\d{6}\.jsonl\.gz$`)
+
+// memory is an Uploader keeping the objects in memory.
+type memory struct {
+	mux     sync.Mutex
+	keys    []string
+	objects map[string][]byte
+}
+
+func (m *memory) Upload(ctx context.Context, key string, contentType string, body []byte) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.objects == nil {
+		m.objects = make(map[string][]byte)
+	}
+	m.keys = append(m.keys, key)
+	m.objects[key] = body
+
+	return nil
+}
+
+func (m *memory) uploaded() []string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return append([]string(nil), m.keys...)
+}
+
+// lines returns the number of JSON lines of an object.
+func lines(t *testing.T, body []byte) int {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+// checkKey checks that a key is named with a time between from and to.
+func checkKey(t *testing.T, key string, from time.Time, to time.Time) {
+	t.Helper()
+	if !keyPattern.MatchString(key) {
+		t.Fatalf("key %q doesn't match %s", key, keyPattern)
+	}
+	opened, err := time.Parse(keyFormat, key[len("orders/"):len(key)-len(".jsonl.gz")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Before(from.UTC().Truncate(time.Microsecond)) || opened.After(to.UTC()) {
+		t.Errorf("key %q not opened between %s and %s", key, from.UTC(), to.UTC())
+	}
+}
+
+func TestMaxEventsRollover(t *testing.T) {
+	store := &memory{}
+	sink := New("cold", store)
+	sink.Prefix = "orders/"
+	sink.Window = time.Hour
+	sink.MaxEvents = 2
+
+	start := time.Now()
+	for seq := uint64(1); seq <= 5; seq++ {
+		err := sink.Deliver(context.Background(), socketeer.Message{Seq: seq, Topic: "orders"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq == 2 {
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+	if got := len(store.uploaded()); got != 2 {
+		t.Fatalf("%d objects uploaded before Close(), want 2", got)
+	}
+	err := sink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := store.uploaded()
+	if len(keys) != 3 {
+		t.Fatalf("%d objects uploaded, want 3: %v", len(keys), keys)
+	}
+	for i, want := range []int{2, 2, 1} {
+		checkKey(t, keys[i], start, time.Now())
+		if got := lines(t, store.objects[keys[i]]); got != want {
+			t.Errorf("object %s has %d lines, want %d", keys[i], got, want)
+		}
+	}
+	if keys[0] >= keys[1] || keys[1] > keys[2] {
+		t.Errorf("keys not ordered by window: %v", keys)
+	}
+}
+
+func TestWindowRollover(t *testing.T) {
+	store := &memory{}
+	sink := New("cold", store)
+	sink.Prefix = "orders/"
+	sink.Window = 50 * time.Millisecond
+
+	start := time.Now()
+	err := sink.Deliver(context.Background(), socketeer.Message{Seq: 1, Topic: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Deliver(context.Background(), socketeer.Message{Seq: 2, Topic: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.uploaded()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	keys := store.uploaded()
+	if len(keys) != 1 {
+		t.Fatalf("%d objects uploaded at the end of the window, want 1", len(keys))
+	}
+	checkKey(t, keys[0], start, start.Add(sink.Window))
+
+	second := time.Now()
+	err = sink.Deliver(context.Background(), socketeer.Message{Seq: 3, Topic: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys = store.uploaded()
+	if len(keys) != 2 {
+		t.Fatalf("%d objects uploaded, want 2: %v", len(keys), keys)
+	}
+	checkKey(t, keys[1], second, time.Now())
+	if got := lines(t, store.objects[keys[0]]); got != 2 {
+		t.Errorf("first object has %d lines, want 2", got)
+	}
+	if got := lines(t, store.objects[keys[1]]); got != 1 {
+		t.Errorf("second object has %d lines, want 1", got)
+	}
+}
+
+func TestRetriedDeliveryBatchedOnce(t *testing.T) {
+	store := &memory{}
+	sink := New("cold", store)
+	sink.Prefix = "orders/"
+	sink.Window = time.Hour
+
+	msg := socketeer.Message{Seq: 7, Topic: "orders"}
+	for i := 0; i < 3; i++ {
+		err := sink.Deliver(context.Background(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := sink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := store.uploaded()
+	if len(keys) != 1 {
+		t.Fatalf("%d objects uploaded, want 1", len(keys))
+	}
+	if got := lines(t, store.objects[keys[0]]); got != 1 {
+		t.Errorf("object has %d lines, want 1", got)
+	}
+}
@@ -0,0 +1,262 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 is an Uploader putting the objects into a bucket of S3, or of any
+// storage with an S3 compatible API like GCS with HMAC keys, MinIO or
+// R2, with requests signed with AWS Signature Version 4.
+//
+// 	- Endpoint is the URL of the storage, the S3 endpoint of the Region
+// 		when empty, the objects are addressed by path: Endpoint/Bucket/key.
+// 	- Region is the region of the bucket, "auto" for GCS.
+// 	- Bucket is the name of the bucket.
+// 	- AccessKey is the access key ID.
+// 	- SecretKey is the secret access key.
+// 	- SessionToken is the token of temporary credentials, empty for
+// 		long-term ones.
+// 	- Client sends the requests, a client with DefaultTimeout when nil.
+type S3 struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+// StatusError is the failure of an upload answered with a status
+// other than 2xx.
+//
+// 	- Status is the status code of the response.
+// 	- Body is the beginning of the body of the response, the error
+// 		document of the storage.
+type StatusError struct {
+	Status int
+	Body   string
+}
+
+// Error returns the description of the failure.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("archive: status %d: %s", e.Status, e.Body)
+}
+
+// NewS3 returns a new S3 uploader to a bucket of AWS S3.
+//
+// # Parameters:
+//
+// 	- bucket (string): the name of the bucket.
+// 	- region (string): the region of the bucket.
+// 	- accessKey (string): the access key ID.
+// 	- secretKey (string): the secret access key.
+//
+// # Example:
+//
+// 	store := archive.NewS3("events-archive", "eu-west-1", accessKey, secretKey)
+func NewS3(bucket string, region string, accessKey string, secretKey string) *S3 {
+	return &S3{Region: region, Bucket: bucket, AccessKey: accessKey, SecretKey: secretKey}
+}
+
+// NewGCS returns a new S3 uploader to a bucket of Google Cloud Storage,
+// through its XML API with an HMAC key of a service account.
+//
+// # Parameters:
+//
+// 	- bucket (string): the name of the bucket.
+// 	- accessKey (string): the access ID of the HMAC key.
+// 	- secretKey (string): the secret of the HMAC key.
+//
+// # Example:
+//
+// 	store := archive.NewGCS("events-archive", accessKey, secretKey)
+func NewGCS(bucket string, accessKey string, secretKey string) *S3 {
+	return &S3{
+		Endpoint:  "https://storage.googleapis.com",
+		Region:    "auto",
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	}
+}
+
+// Upload puts an object into the bucket, it fails unless the response
+// status is 2xx.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the request.
+// 	- key (string): the key of the object.
+// 	- contentType (string): the content type of the object.
+// 	- body ([]byte): the content of the object.
+//
+// # Example:
+//
+// 	err := store.Upload(ctx, "events/2024/01/02/150405.000000.jsonl.gz", "application/x-ndjson", body)
+func (s *S3) Upload(ctx context.Context, key string, contentType string, body []byte) error {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+	path := "/" + escapePath(s.Bucket) + "/" + escapePath(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, path, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return &StatusError{Status: res.StatusCode, Body: string(detail)}
+	}
+
+	return nil
+}
+
+// sign signs a request to S3 with AWS Signature Version 4, with the
+// hash of its body and the session token in its x-amz-* headers.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the request, without query.
+// 	- path (string): the escaped path of the request.
+// 	- body ([]byte): the body of the request.
+// 	- now (time.Time): the time of the signature, in UTC.
+//
+// # Example:
+//
+// 	s.sign(req, path, body, time.Now().UTC())
+func (s *S3) sign(req *http.Request, path string, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	s.signV4(req, path, body, now, "s3")
+}
+
+// signV4 signs a request to a service with AWS Signature Version 4,
+// over its host, its path and its x-amz-* headers.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the request, without query.
+// 	- path (string): the escaped path of the request.
+// 	- body ([]byte): the body of the request.
+// 	- now (time.Time): the time of the signature, in UTC.
+// 	- service (string): the name of the service, example: s3
+//
+// # Example:
+//
+// 	s.signV4(req, path, body, now, "s3")
+func (s *S3) signV4(req *http.Request, path string, body []byte, now time.Time, service string) {
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+
+	headers := map[string]string{"host": req.URL.Host}
+	names := []string{"host"}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method, path, "", canonical.String(), signed, hex.EncodeToString(payload[:]),
+	}, "\n")
+	scope := now.Format("20060102") + "/" + s.Region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signed, s.signature(now, service, toSign),
+	))
+}
+
+// signature returns the signature of a string to sign, with the
+// signing key of the day, the region and the service.
+//
+// # Parameters:
+//
+// 	- now (time.Time): the time of the signature, in UTC.
+// 	- service (string): the name of the service.
+// 	- toSign (string): the string to sign.
+//
+// # Example:
+//
+// 	signature := s.signature(now, "s3", toSign)
+func (s *S3) signature(now time.Time, service string, toSign string) string {
+	mac := hmac.New(sha256.New, s.signingKey(now, service))
+	mac.Write([]byte(toSign))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signingKey returns the key derived from the secret key for the day,
+// the region and the service.
+//
+// # Parameters:
+//
+// 	- now (time.Time): the time of the signature, in UTC.
+// 	- service (string): the name of the service.
+//
+// # Example:
+//
+// 	key := s.signingKey(now, "s3")
+func (s *S3) signingKey(now time.Time, service string) []byte {
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{now.Format("20060102"), s.Region, service, "aws4_request"} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+
+	return key
+}
+
+// escapePath escapes a path the way Signature Version 4 expects it,
+// every byte but the unreserved characters and the slashes.
+func escapePath(path string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || c == '/' {
+			escaped.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", c)
+	}
+
+	return escaped.String()
+}
@@ -0,0 +1,141 @@
+package archive
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The credentials and the time of the AWS Signature Version 4 test suite.
+const (
+	suiteAccessKey = "AKIDEXAMPLE"
+	suiteSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+var suiteTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func TestSignV4Suite(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		signature string
+	}{
+		{"get-vanilla", http.MethodGet, "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", http.MethodPost, "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &S3{Region: "us-east-1", AccessKey: suiteAccessKey, SecretKey: suiteSecretKey}
+			req, err := http.NewRequest(tt.method, "https://example.amazonaws.com/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.signV4(req, "/", nil, suiteTime, "service")
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=" + tt.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want %q", got, "20150830T123600Z")
+			}
+		})
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		region string
+		now    time.Time
+		key    string
+	}{
+		{"iam-2012", "us-east-1", time.Date(2012, 2, 15, 0, 0, 0, 0, time.UTC), "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"},
+		{"iam-2015", "us-east-1", suiteTime, "c4afb1cc5771d871763a393e44b703571b55cc28424d1a5e86da6ed3c154a4b9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &S3{Region: tt.region, SecretKey: suiteSecretKey}
+			if got := hex.EncodeToString(s.signingKey(tt.now, "iam")); got != tt.key {
+				t.Errorf("signingKey() = %s, want %s", got, tt.key)
+			}
+		})
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/events/2024/01/02/150405.000000.jsonl.gz", "/events/2024/01/02/150405.000000.jsonl.gz"},
+		{"/a b/c+d", "/a%20b/c%2Bd"},
+		{"/é~_-", "/%C3%A9~_-"},
+	}
+	for _, tt := range tests {
+		if got := escapePath(tt.path); got != tt.want {
+			t.Errorf("escapePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGCSUpload(t *testing.T) {
+	var req *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		req, body = r, string(b)
+	}))
+	defer server.Close()
+
+	store := NewGCS("events", "GOOGTS7C7FUP3AIRVJTE2BCD", "bGoa+V7g/yqDXvKRqq+JTFn4uQZbPiQJo4pf9RzJ")
+	if store.Region != "auto" || store.Endpoint != "https://storage.googleapis.com" {
+		t.Fatalf("NewGCS() = region %q, endpoint %q", store.Region, store.Endpoint)
+	}
+	store.Endpoint = server.URL
+	err := store.Upload(context.Background(), "orders/a b.jsonl.gz", "application/x-ndjson", []byte("{}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Method != http.MethodPut || req.URL.EscapedPath() != "/events/orders/a%20b.jsonl.gz" {
+		t.Errorf("request = %s %s", req.Method, req.URL.EscapedPath())
+	}
+	if body != "{}\n" || req.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("body = %q, content type %q", body, req.Header.Get("Content-Type"))
+	}
+	auth := req.Header.Get("Authorization")
+	date := req.Header.Get("X-Amz-Date")
+	prefix := "AWS4-HMAC-SHA256 Credential=GOOGTS7C7FUP3AIRVJTE2BCD/" + date[:8] + "/auto/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="
+	if !strings.HasPrefix(auth, prefix) {
+		t.Errorf("Authorization = %q, want prefix %q", auth, prefix)
+	}
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != "ca3d163bab055381827226140568f3bef7eaac187cebd76878e0b63e9e442356" {
+		t.Errorf("X-Amz-Content-Sha256 = %s", got)
+	}
+}
+
+func TestUploadStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		http.Error(res, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	store := NewS3("events", "eu-west-1", suiteAccessKey, suiteSecretKey)
+	store.Endpoint = server.URL
+	err := store.Upload(context.Background(), "key", "text/plain", nil)
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.Status != http.StatusForbidden || !strings.Contains(statusErr.Body, "AccessDenied") {
+		t.Errorf("Upload() = %v, want a 403 StatusError", err)
+	}
+}
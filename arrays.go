@@ -0,0 +1,83 @@
+package socketeer
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// ArrayChange is a change of an element of an array field, dispatched
+// instead of the dotted keys of the updated fields, like "items.3",
+// when ArrayChanges is set.
+//
+// 	- Field is the name of the array field, example: "items".
+// 	- Index is the index of the changed element, or the new size of
+// 		the array for a truncation.
+// 	- Path is the changed field of the element, empty when the
+// 		whole element changed.
+// 	- Action is ArraySet or ArrayTruncate.
+// 	- Value is the new value, as JSON unless it is a string.
+type ArrayChange = event.ArrayChange
+
+// Actions of the array changes.
+//
+// 	- ArraySet is the element at Index set to Value.
+// 	- ArrayTruncate is the array truncated to Index elements,
+// 		the elements from Index on are removed.
+const (
+	ArraySet      = event.ArraySet
+	ArrayTruncate = event.ArrayTruncate
+)
+
+// arrayChange parses an updated field naming an element of an array,
+// like "items.3" or "items.3.price", into an ArrayChange, it reports
+// false for the other fields.
+//
+// # Parameters:
+//
+// 	- key (string): the name of the updated field.
+// 	- value (any): the new value of the field.
+//
+// # Example:
+//
+// 	change, ok := arrayChange("items.3", item)
+func arrayChange(key string, value any) (ArrayChange, bool) {
+	parts := strings.Split(key, ".")
+	for i := 1; i < len(parts); i++ {
+		index, err := strconv.Atoi(parts[i])
+		if err != nil || index < 0 {
+			continue
+		}
+
+		return ArrayChange{
+			Field:  strings.Join(parts[:i], "."),
+			Index:  index,
+			Path:   strings.Join(parts[i+1:], "."),
+			Action: ArraySet,
+			Value:  jsonString(value),
+		}, true
+	}
+
+	return ArrayChange{}, false
+}
+
+// sortArrayChanges orders array changes by field and index, with
+// the truncations before the elements set at the same index.
+func sortArrayChanges(changes []ArrayChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		if a.Action != b.Action {
+			return a.Action == ArrayTruncate
+		}
+
+		return a.Path < b.Path
+	})
+}
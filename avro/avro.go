@@ -0,0 +1,354 @@
+// Package avro is an Avro binary encoder for the messages of
+// the socketeer, meant for the broker sinks whose downstream
+// tooling expects Avro rather than JSON.
+//
+// The schema of a topic is either derived from its keys with
+// DeriveSchema() or provided by the user. When a schema registry
+// is configured, the schema is registered on first use and every
+// encoded message is framed with its schema ID.
+//
+// # Example:
+//
+// 	enc, err := avro.NewEncoder(avro.DeriveSchema("posts", []string{"title", "text"}))
+// 	enc.Registry = schemaregistry.New("http://localhost:8081")
+// 	enc.Subject = schemaregistry.Subject("posts")
+//
+// 	data, err := enc.Encode(msg)
+//
+// Messages are mapped to the record {"seq", "topic", "op", "data"}, where
+// "data" holds the selected keys renamed with Name(). User-provided schemas
+// may use any subset of these fields, string values are converted to the
+// numeric and boolean types of the schema.
+package avro
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/darthsalad/socketeer"
+	"github.com/darthsalad/socketeer/schemaregistry"
+)
+
+// Encoder encodes messages with an Avro schema.
+//
+// 	- schema is the parsed schema.
+// 	- raw is the schema as provided.
+// 	- Registry is the optional schema registry the schema is registered with.
+// 	- Subject is the subject of the schema in the registry.
+// 	- id is the schema ID, once registered.
+// 	- idMux is a mutex for id for thread safety.
+type Encoder struct {
+	schema   *schemaNode
+	raw      string
+	Registry *schemaregistry.Client
+	Subject  string
+	id       int
+	idMux    sync.Mutex
+}
+
+// NewEncoder returns a new Encoder for the given schema.
+//
+// # Parameters:
+//
+// 	- schema (string): the Avro schema in its JSON form.
+//
+// # Example:
+//
+// 	enc, err := avro.NewEncoder(avro.DeriveSchema("posts", keys))
+func NewEncoder(schema string) (*Encoder, error) {
+	node, err := parseSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encoder{
+		schema: node,
+		raw:    schema,
+	}, nil
+}
+
+// Schema returns the schema of the Encoder in its JSON form.
+func (e *Encoder) Schema() string {
+	return e.raw
+}
+
+// Encode encodes a message, framed with the schema ID
+// when a Registry is configured.
+//
+// # Parameters:
+//
+// 	- msg (socketeer.Message): the message to encode.
+//
+// # Example:
+//
+// 	data, err := enc.Encode(msg)
+func (e *Encoder) Encode(msg socketeer.Message) ([]byte, error) {
+	data := make(map[string]any, len(msg.Data))
+	for key, value := range msg.Data {
+		data[Name(key)] = value
+	}
+	value := map[string]any{
+		"seq":   int64(msg.Seq),
+		"topic": msg.Topic,
+		"op":    msg.OperationType,
+		"data":  data,
+	}
+
+	payload, err := appendValue(nil, e.schema, value)
+	if err != nil {
+		return nil, err
+	}
+	if e.Registry == nil {
+		return payload, nil
+	}
+
+	id, err := e.schemaID()
+	if err != nil {
+		return nil, err
+	}
+
+	return schemaregistry.Frame(id, payload), nil
+}
+
+// schemaID registers the schema on first use and returns its ID.
+func (e *Encoder) schemaID() (int, error) {
+	e.idMux.Lock()
+	defer e.idMux.Unlock()
+
+	if e.id != 0 {
+		return e.id, nil
+	}
+
+	id, err := e.Registry.Register(context.Background(), e.Subject, schemaregistry.Avro, e.raw)
+	if err != nil {
+		return 0, err
+	}
+	e.id = id
+
+	return id, nil
+}
+
+// appendValue appends the binary encoding of v with schema n to buf.
+func appendValue(buf []byte, n *schemaNode, v any) ([]byte, error) {
+	switch n.typ {
+	case "null":
+		if v != nil {
+			return nil, fmt.Errorf("avro: %v is not null", v)
+		}
+		return buf, nil
+
+	case "boolean":
+		b, err := toBool(v)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+
+	case "int", "long":
+		i, err := toInt(v)
+		if err != nil {
+			return nil, err
+		}
+		return binary.AppendVarint(buf, i), nil
+
+	case "float":
+		f, err := toFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(f))), nil
+
+	case "double":
+		f, err := toFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f)), nil
+
+	case "string", "bytes":
+		s, err := toString(v)
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendVarint(buf, int64(len(s)))
+		return append(buf, s...), nil
+
+	case "fixed":
+		s, err := toString(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(s) != n.size {
+			return nil, fmt.Errorf("avro: %s needs %d bytes, got %d", n.name, n.size, len(s))
+		}
+		return append(buf, s...), nil
+
+	case "enum":
+		s, err := toString(v)
+		if err != nil {
+			return nil, err
+		}
+		for i, symbol := range n.symbols {
+			if symbol == s {
+				return binary.AppendVarint(buf, int64(i)), nil
+			}
+		}
+		return nil, fmt.Errorf("avro: %q is not a symbol of %s", s, n.name)
+
+	case "record":
+		m, _ := v.(map[string]any)
+		for _, f := range n.fields {
+			fv, ok := m[f.name]
+			if !ok {
+				fv = f.def
+				if !f.hasDef && !nullable(f.schema) {
+					return nil, fmt.Errorf("avro: missing field %s of %s", f.name, n.name)
+				}
+			}
+			var err error
+			buf, err = appendValue(buf, f.schema, fv)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	case "map":
+		m, _ := v.(map[string]any)
+		if len(m) > 0 {
+			buf = binary.AppendVarint(buf, int64(len(m)))
+			for key, value := range m {
+				buf = binary.AppendVarint(buf, int64(len(key)))
+				buf = append(buf, key...)
+				var err error
+				buf, err = appendValue(buf, n.items, value)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(buf, 0), nil
+
+	case "array":
+		items, _ := v.([]any)
+		if len(items) > 0 {
+			buf = binary.AppendVarint(buf, int64(len(items)))
+			for _, item := range items {
+				var err error
+				buf, err = appendValue(buf, n.items, item)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(buf, 0), nil
+
+	case "union":
+		for i, branch := range n.branches {
+			if !matches(branch, v) {
+				continue
+			}
+			return appendValue(binary.AppendVarint(buf, int64(i)), branch, v)
+		}
+		return nil, fmt.Errorf("avro: no union branch matches %v", v)
+	}
+
+	return nil, fmt.Errorf("avro: unsupported type %s", n.typ)
+}
+
+// nullable reports whether a missing field can be encoded as null.
+func nullable(n *schemaNode) bool {
+	if n.typ == "null" {
+		return true
+	}
+	for _, branch := range n.branches {
+		if branch.typ == "null" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches reports whether v can be encoded with the union branch n.
+func matches(n *schemaNode, v any) bool {
+	if v == nil {
+		return n.typ == "null"
+	}
+	if n.typ == "null" {
+		return false
+	}
+
+	_, err := appendValue(nil, n, v)
+
+	return err == nil
+}
+
+// toBool converts v to a boolean.
+func toBool(v any) (bool, error) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case string:
+		return strconv.ParseBool(t)
+	}
+
+	return false, fmt.Errorf("avro: %v is not a boolean", v)
+}
+
+// toInt converts v to an integer.
+func toInt(v any) (int64, error) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), nil
+	case int32:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case uint64:
+		return int64(t), nil
+	case float64:
+		return int64(t), nil
+	case string:
+		return strconv.ParseInt(t, 10, 64)
+	}
+
+	return 0, fmt.Errorf("avro: %v is not an integer", v)
+}
+
+// toFloat converts v to a floating point number.
+func toFloat(v any) (float64, error) {
+	switch t := v.(type) {
+	case float32:
+		return float64(t), nil
+	case float64:
+		return t, nil
+	case int:
+		return float64(t), nil
+	case int64:
+		return float64(t), nil
+	case string:
+		return strconv.ParseFloat(t, 64)
+	}
+
+	return 0, fmt.Errorf("avro: %v is not a number", v)
+}
+
+// toString converts v to a string.
+func toString(v any) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case []byte:
+		return string(t), nil
+	}
+
+	return "", fmt.Errorf("avro: %v is not a string", v)
+}
@@ -0,0 +1,193 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/darthsalad/socketeer"
+	"github.com/darthsalad/socketeer/schemaregistry"
+)
+
+// The vectors below follow the binary encoding of the Avro
+// specification: zigzag varints for int and long, a long length
+// before strings and bytes, the branch index before a union value
+// and blocks ended by a zero count for arrays and maps.
+func TestAppendValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  any
+		want   []byte
+	}{
+		{"long 0", `"long"`, int64(0), []byte{0x00}},
+		{"long -1", `"long"`, int64(-1), []byte{0x01}},
+		{"long 1", `"long"`, int64(1), []byte{0x02}},
+		{"long -2", `"long"`, int64(-2), []byte{0x03}},
+		{"long 2", `"long"`, int64(2), []byte{0x04}},
+		{"long -64", `"long"`, int64(-64), []byte{0x7f}},
+		{"long 64", `"long"`, int64(64), []byte{0x80, 0x01}},
+		{"long 8192", `"long"`, int64(8192), []byte{0x80, 0x80, 0x01}},
+		{"int from a string", `"int"`, "-65", []byte{0x81, 0x01}},
+		{"boolean true", `"boolean"`, true, []byte{0x01}},
+		{"boolean from a string", `"boolean"`, "false", []byte{0x00}},
+		{"float 1", `"float"`, 1.0, []byte{0x00, 0x00, 0x80, 0x3f}},
+		{"double 1", `"double"`, "1", []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f}},
+		{"string", `"string"`, "foo", []byte{0x06, 'f', 'o', 'o'}},
+		{"empty string", `"string"`, "", []byte{0x00}},
+		{"bytes", `"bytes"`, []byte{0xff, 0x00}, []byte{0x04, 0xff, 0x00}},
+		{"fixed", `{"type":"fixed","name":"Pair","size":2}`, "ab", []byte{'a', 'b'}},
+		{"enum", `{"type":"enum","name":"Op","symbols":["insert","update","delete"]}`, "delete", []byte{0x04}},
+		{"union null", `["null","string"]`, nil, []byte{0x00}},
+		{"union string", `["null","string"]`, "a", []byte{0x02, 0x02, 'a'}},
+		{"union first match", `["null","long","string"]`, "12", []byte{0x02, 0x18}},
+		{"union later branch", `["null","long","string"]`, "twelve", []byte{0x04, 0x0c, 't', 'w', 'e', 'l', 'v', 'e'}},
+		{"array", `{"type":"array","items":"long"}`, []any{int64(3), int64(27)}, []byte{0x04, 0x06, 0x36, 0x00}},
+		{"empty array", `{"type":"array","items":"long"}`, []any{}, []byte{0x00}},
+		{"map", `{"type":"map","values":"long"}`, map[string]any{"a": int64(1)}, []byte{0x02, 0x02, 'a', 0x02, 0x00}},
+		{
+			"record defaults",
+			`{"type":"record","name":"R","fields":[` +
+				`{"name":"id","type":"long"},` +
+				`{"name":"count","type":"long","default":5},` +
+				`{"name":"label","type":"string","default":"x"},` +
+				`{"name":"note","type":["null","string"]},` +
+				`{"name":"tag","type":["string","null"],"default":"t"}]}`,
+			map[string]any{"id": int64(1)},
+			[]byte{0x02, 0x0a, 0x02, 'x', 0x00, 0x00, 0x02, 't'},
+		},
+		{
+			"nested named type",
+			`{"type":"record","name":"Outer","namespace":"ns","fields":[` +
+				`{"name":"a","type":{"type":"enum","name":"E","symbols":["x","y"]}},` +
+				`{"name":"b","type":"E"}]}`,
+			map[string]any{"a": "y", "b": "x"},
+			[]byte{0x02, 0x00},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := parseSchema(tt.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := appendValue(nil, n, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("appendValue(%v) = % x, want % x", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendValueErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  any
+	}{
+		{"null not nil", `"null"`, "a"},
+		{"long not a number", `"long"`, "a"},
+		{"boolean not a boolean", `"boolean"`, "maybe"},
+		{"string not a string", `"string"`, int64(1)},
+		{"fixed of another size", `{"type":"fixed","name":"Pair","size":2}`, "abc"},
+		{"enum unknown symbol", `{"type":"enum","name":"Op","symbols":["insert"]}`, "drop"},
+		{"union no branch", `["null","long"]`, "a"},
+		{"record missing field", `{"type":"record","name":"R","fields":[{"name":"id","type":"long"}]}`, map[string]any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := parseSchema(tt.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := appendValue(nil, n, tt.value)
+			if err == nil {
+				t.Errorf("appendValue(%v) = % x, want an error", tt.value, got)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	msg := socketeer.Message{Seq: 1, Topic: "posts", OperationType: "insert", Data: map[string]string{"title": "Hi"}}
+	// seq, topic, op, then the data record with title in the string
+	// branch and text in the null branch.
+	payload := []byte{
+		0x02,
+		0x0a, 'p', 'o', 's', 't', 's',
+		0x0c, 'i', 'n', 's', 'e', 'r', 't',
+		0x02, 0x04, 'H', 'i',
+		0x00,
+	}
+
+	enc, err := NewEncoder(DeriveSchema("posts", []string{"title", "text"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := enc.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("Encode() = % x, want % x", got, payload)
+	}
+
+	var registrations atomic.Int32
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Schema string `json:"schema"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != http.MethodPost || r.URL.Path != "/subjects/posts-value/versions" || body.Schema != enc.Schema() {
+			t.Errorf("registration %s %s of %q", r.Method, r.URL.Path, body.Schema)
+		}
+		registrations.Add(1)
+		w.Write([]byte(`{"id":258}`))
+	}))
+	defer registry.Close()
+
+	enc.Registry = schemaregistry.New(registry.URL)
+	enc.Subject = schemaregistry.Subject("posts")
+	// The magic byte then the schema ID as a big-endian uint32.
+	want := append([]byte{0x00, 0x00, 0x00, 0x01, 0x02}, payload...)
+	for i := 0; i < 2; i++ {
+		got, err = enc.Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Encode() with a registry = % x, want % x", got, want)
+		}
+	}
+	if n := registrations.Load(); n != 1 {
+		t.Errorf("%d registrations, want 1", n)
+	}
+}
+
+func TestEncodeRegistryError(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error_code":409,"message":"incompatible schema"}`))
+	}))
+	defer registry.Close()
+
+	enc, err := NewEncoder(DeriveSchema("posts", []string{"title"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Registry = schemaregistry.New(registry.URL)
+	enc.Subject = schemaregistry.Subject("posts")
+	got, err := enc.Encode(socketeer.Message{Topic: "posts"})
+	if err == nil {
+		t.Errorf("Encode() = % x, want the error of the registry", got)
+	}
+	if enc.id != 0 {
+		t.Errorf("schema ID %d kept after a failed registration", enc.id)
+	}
+}
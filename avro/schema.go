@@ -0,0 +1,220 @@
+package avro
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// invalidName matches the characters not allowed in Avro names.
+var invalidName = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// schemaNode is a parsed Avro schema.
+//
+// 	- typ is the type, a primitive name or "record", "enum",
+// 		"array", "map", "fixed" or "union".
+// 	- name is the full name of named types.
+// 	- fields are the fields of a record.
+// 	- symbols are the symbols of an enum.
+// 	- items is the schema of the items of an array or the values of a map.
+// 	- branches are the schemas of a union.
+// 	- size is the size of a fixed.
+type schemaNode struct {
+	typ      string
+	name     string
+	fields   []fieldNode
+	symbols  []string
+	items    *schemaNode
+	branches []*schemaNode
+	size     int
+}
+
+// fieldNode is a field of a record.
+//
+// 	- name is the name of the field.
+// 	- schema is the schema of the field.
+// 	- def is the default value of the field, when hasDef is set.
+type fieldNode struct {
+	name   string
+	schema *schemaNode
+	def    any
+	hasDef bool
+}
+
+// primitives are the primitive type names of Avro.
+var primitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// Name turns a key or a collection name into a valid Avro name,
+// example: "e-mail" becomes "e_mail".
+//
+// # Example:
+//
+// 	avro.Name("1st-place") // "_1st_place"
+func Name(s string) string {
+	s = invalidName.ReplaceAllString(s, "_")
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+
+	return s
+}
+
+// DeriveSchema returns the Avro schema of the messages of a topic,
+// a record holding the sequence number, the topic, the operation
+// type and a nested record with one optional string per key.
+//
+// Keys are turned into valid Avro names with Name().
+//
+// # Parameters:
+//
+// 	- topic (string): the topic, which is the collection name.
+// 	- keys ([]string): the keys dispatched for the topic.
+//
+// # Example:
+//
+// 	schema := avro.DeriveSchema("posts", []string{"title", "text"})
+func DeriveSchema(topic string, keys []string) string {
+	fields := make([]map[string]any, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, map[string]any{
+			"name":    Name(key),
+			"type":    []string{"null", "string"},
+			"default": nil,
+		})
+	}
+
+	name := Name(topic)
+	name = strings.ToUpper(name[:1]) + name[1:]
+	schema := map[string]any{
+		"type":      "record",
+		"name":      name,
+		"namespace": "socketeer",
+		"fields": []any{
+			map[string]any{"name": "seq", "type": "long"},
+			map[string]any{"name": "topic", "type": "string"},
+			map[string]any{"name": "op", "type": "string"},
+			map[string]any{"name": "data", "type": map[string]any{
+				"type":   "record",
+				"name":   name + "Data",
+				"fields": fields,
+			}},
+		},
+	}
+
+	data, _ := json.Marshal(schema)
+
+	return string(data)
+}
+
+// parseSchema parses an Avro schema in its JSON form.
+func parseSchema(schema string) (*schemaNode, error) {
+	var v any
+	err := json.Unmarshal([]byte(schema), &v)
+	if err != nil {
+		return nil, fmt.Errorf("avro: parsing schema: %w", err)
+	}
+
+	return parseNode(v, "", make(map[string]*schemaNode))
+}
+
+// parseNode parses a schema node, resolving references
+// to the named types defined so far.
+func parseNode(v any, namespace string, named map[string]*schemaNode) (*schemaNode, error) {
+	switch t := v.(type) {
+	case string:
+		if primitives[t] {
+			return &schemaNode{typ: t}, nil
+		}
+		if n, ok := named[fullName(t, namespace)]; ok {
+			return n, nil
+		}
+		if n, ok := named[t]; ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("avro: unknown type %q", t)
+
+	case []any:
+		n := &schemaNode{typ: "union"}
+		for _, branch := range t {
+			b, err := parseNode(branch, namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			n.branches = append(n.branches, b)
+		}
+		return n, nil
+
+	case map[string]any:
+		typ, _ := t["type"].(string)
+		if ns, ok := t["namespace"].(string); ok {
+			namespace = ns
+		}
+
+		switch typ {
+		case "record", "error":
+			name, _ := t["name"].(string)
+			n := &schemaNode{typ: "record", name: fullName(name, namespace)}
+			named[n.name] = n
+
+			rawFields, _ := t["fields"].([]any)
+			for _, rf := range rawFields {
+				f, _ := rf.(map[string]any)
+				fieldName, _ := f["name"].(string)
+				fs, err := parseNode(f["type"], namespace, named)
+				if err != nil {
+					return nil, err
+				}
+				def, hasDef := f["default"]
+				n.fields = append(n.fields, fieldNode{name: fieldName, schema: fs, def: def, hasDef: hasDef})
+			}
+			return n, nil
+
+		case "enum":
+			name, _ := t["name"].(string)
+			n := &schemaNode{typ: "enum", name: fullName(name, namespace)}
+			rawSymbols, _ := t["symbols"].([]any)
+			for _, s := range rawSymbols {
+				symbol, _ := s.(string)
+				n.symbols = append(n.symbols, symbol)
+			}
+			named[n.name] = n
+			return n, nil
+
+		case "array", "map":
+			key := "items"
+			if typ == "map" {
+				key = "values"
+			}
+			items, err := parseNode(t[key], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			return &schemaNode{typ: typ, items: items}, nil
+
+		case "fixed":
+			name, _ := t["name"].(string)
+			size, _ := t["size"].(float64)
+			n := &schemaNode{typ: "fixed", name: fullName(name, namespace), size: int(size)}
+			named[n.name] = n
+			return n, nil
+
+		default:
+			return parseNode(t["type"], namespace, named)
+		}
+	}
+
+	return nil, fmt.Errorf("avro: invalid schema node %v", v)
+}
+
+// fullName qualifies a name with a namespace unless it already is.
+func fullName(name string, namespace string) string {
+	if namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+
+	return namespace + "." + name
+}
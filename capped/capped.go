@@ -0,0 +1,326 @@
+// Package capped provides a ChangeSource tailing a capped collection
+// with a tailable cursor, a lightweight alternative to the change
+// streams for the log-style collections, which also works on the
+// standalone servers without a replica set.
+//
+// Every document inserted in the collection is dispatched as an
+// insert, with its fields and its _id as document key. The capped
+// collections keep their insertion order and can't be updated in
+// a way which changes the size of the documents, nor deleted from,
+// so the inserts are the only events.
+//
+// # Usage:
+//
+// 	src, err := capped.Connect("mongodb://localhost:27017", "mydb", "logs")
+// 	if err != nil {
+// 		log.Fatal(err)
+// 	}
+// 	s := socketeer.NewSocketeerWithSource(src)
+// 	s.Start([]string{"level", "message"}, "localhost:8080", "/listen")
+package capped
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Defaults of the Source settings.
+const (
+	DefaultMaxAwaitTime = time.Second
+	DefaultRetryDelay   = time.Second
+)
+
+// Source is a socketeer.ChangeSource dispatching the documents
+// inserted in a capped collection.
+//
+// The tailable cursor dies when the collection is empty, or when the
+// document it points to is overwritten because the Source fell behind:
+// it is then reopened after RetryDelay, after the _id of the last
+// document dispatched, so the ids must increase with the inserts, like
+// the default ObjectIDs.
+//
+// 	- Coll is the capped collection.
+// 	- Topic is the topic of the events, the name of the collection
+// 		when empty.
+// 	- FromStart dispatches the documents of the collection when the
+// 		Source starts, instead of the ones inserted afterwards only.
+// 	- MaxAwaitTime is how long the server waits for new documents
+// 		before answering a round trip of the cursor.
+// 	- RetryDelay is the wait before a dead cursor is reopened.
+// 	- client is the client disconnected with the Source, nil if it
+// 		is not owned by the Source.
+// 	- ctx is cancelled when the Source is disconnected.
+// 	- cancel cancels ctx.
+// 	- heartbeat is called after every round trip of the cursor,
+// 		set with OnHeartbeat().
+type Source struct {
+	Coll         *mongo.Collection
+	Topic        string
+	FromStart    bool
+	MaxAwaitTime time.Duration
+	RetryDelay   time.Duration
+	client       *mongo.Client
+	ctx          context.Context
+	cancel       context.CancelFunc
+	heartbeat    func()
+}
+
+// New returns a new Source tailing coll.
+//
+// # Parameters:
+//
+// 	- coll (*mongo.Collection): the capped collection.
+//
+// # Example:
+//
+// 	src := capped.New(client.Database("mydb").Collection("logs"))
+func New(coll *mongo.Collection) *Source {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Source{
+		Coll:         coll,
+		MaxAwaitTime: DefaultMaxAwaitTime,
+		RetryDelay:   DefaultRetryDelay,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Connect returns a new Source tailing the capped collection collName,
+// the client is disconnected with the Source.
+//
+// # Parameters:
+//
+// 	- uriString (string): the MongoDB connection string.
+// 	- dbName (string): the MongoDB database name.
+// 	- collName (string): the name of the capped collection.
+//
+// # Example:
+//
+// 	src, err := capped.Connect("mongodb://localhost:27017", "mydb", "logs")
+func Connect(uriString string, dbName string, collName string) (*Source, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uriString))
+	if err != nil {
+		return nil, err
+	}
+	err = client.Ping(context.Background(), nil)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	s := New(client.Database(dbName).Collection(collName))
+	s.client = client
+
+	return s, nil
+}
+
+// Listen dispatches the documents inserted in the collection until the
+// Source is disconnected, and the documents already in it first with
+// FromStart. It fails right away when the collection isn't capped.
+//
+// # Parameters:
+//
+// 	- handle (func(socketeer.Event) error): the function called for every
+// 		document, the Source stops and returns the error if it fails.
+//
+// # Example:
+//
+// 	err := src.Listen(s.process)
+func (s *Source) Listen(handle func(socketeer.Event) error) error {
+	isCapped, err := s.capped()
+	if err != nil {
+		return s.stopped(err)
+	}
+	if !isCapped {
+		return errors.New("capped: " + s.Coll.Name() + " is not a capped collection")
+	}
+
+	var last any
+	if !s.FromStart {
+		last, err = s.lastID()
+		if err != nil {
+			return s.stopped(err)
+		}
+	}
+
+	for {
+		last, err = s.tail(last, handle)
+		if err != nil {
+			return s.stopped(err)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-time.After(s.RetryDelay):
+		}
+	}
+}
+
+// tail dispatches the documents after the one with the _id last, every
+// document when last is nil, until the cursor dies.
+//
+// # Parameters:
+//
+// 	- last (any): the _id of the last document dispatched, if any.
+// 	- handle (func(socketeer.Event) error): the function called for every document.
+//
+// # Example:
+//
+// 	last, err = s.tail(last, handle)
+func (s *Source) tail(last any, handle func(socketeer.Event) error) (any, error) {
+	filter := bson.M{}
+	if last != nil {
+		filter["_id"] = bson.M{"$gt": last}
+	}
+	opts := options.Find().
+		SetCursorType(options.TailableAwait).
+		SetMaxAwaitTime(s.MaxAwaitTime)
+	cursor, err := s.Coll.Find(s.ctx, filter, opts)
+	if err != nil {
+		return last, err
+	}
+	defer cursor.Close(context.Background())
+
+	for {
+		if !cursor.TryNext(s.ctx) {
+			if cursor.Err() != nil || cursor.ID() == 0 {
+				return last, cursor.Err()
+			}
+			s.beat()
+			continue
+		}
+		s.beat()
+
+		var doc bson.M
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return last, err
+		}
+		err = handle(s.event(doc))
+		if err != nil {
+			return last, err
+		}
+		last = doc["_id"]
+	}
+}
+
+// event returns the event of a document.
+func (s *Source) event(doc bson.M) socketeer.Event {
+	topic := s.Topic
+	if topic == "" {
+		topic = s.Coll.Name()
+	}
+
+	return socketeer.Event{
+		OperationType: "insert",
+		Collection:    topic,
+		Fields:        doc,
+		DocumentKey:   map[string]any{"_id": doc["_id"]},
+	}
+}
+
+// capped reports whether the collection is a capped collection.
+func (s *Source) capped() (bool, error) {
+	var stats struct {
+		Capped bool `bson:"capped"`
+	}
+	cmd := bson.D{{Key: "collStats", Value: s.Coll.Name()}}
+	err := s.Coll.Database().RunCommand(s.ctx, cmd).Decode(&stats)
+
+	return stats.Capped, err
+}
+
+// lastID returns the _id of the last document of the collection,
+// nil when it is empty.
+func (s *Source) lastID() (any, error) {
+	var doc struct {
+		ID any `bson:"_id"`
+	}
+	opts := options.FindOne().SetSort(bson.M{"$natural": -1}).SetProjection(bson.M{"_id": 1})
+	err := s.Coll.FindOne(s.ctx, bson.M{}, opts).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+
+	return doc.ID, err
+}
+
+// stopped returns nil once the Source is disconnected, as the
+// failures it causes are expected, and err otherwise.
+func (s *Source) stopped(err error) error {
+	if s.ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// Collections returns the topic of the events of the Source.
+//
+// # Example:
+//
+// 	topics := src.Collections()
+func (s *Source) Collections() []string {
+	if s.Topic != "" {
+		return []string{s.Topic}
+	}
+
+	return []string{s.Coll.Name()}
+}
+
+// OnHeartbeat sets the function called after every round trip of the
+// cursor, it has to be called before Listen().
+//
+// # Parameters:
+//
+// 	- heartbeat (func()): the function to call.
+//
+// # Example:
+//
+// 	src.OnHeartbeat(func() { last.Store(time.Now().UnixNano()) })
+func (s *Source) OnHeartbeat(heartbeat func()) {
+	s.heartbeat = heartbeat
+}
+
+// beat calls the heartbeat function, if any.
+func (s *Source) beat() {
+	if s.heartbeat != nil {
+		s.heartbeat()
+	}
+}
+
+// Ping checks that the database of the collection can be reached.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the round trip to the database.
+//
+// # Example:
+//
+// 	err := src.Ping(ctx)
+func (s *Source) Ping(ctx context.Context) error {
+	return s.Coll.Database().Client().Ping(ctx, nil)
+}
+
+// Disconnect stops the Source, and disconnects its client when it
+// was created with Connect().
+//
+// # Example:
+//
+// 	src.Disconnect()
+func (s *Source) Disconnect() error {
+	s.cancel()
+	if s.client != nil {
+		return s.client.Disconnect(context.Background())
+	}
+
+	return nil
+}
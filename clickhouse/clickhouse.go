@@ -0,0 +1,476 @@
+// Package clickhouse provides a sink of the socketeer inserting the
+// messages in batches into a ClickHouse table, through its HTTP
+// interface, for real-time analytics over the change feed.
+//
+// # Usage:
+//
+// 	events := clickhouse.New("analytics", "http://clickhouse:8123", "shop.order_events")
+// 	events.Columns = map[string]string{
+// 		"seq":    "seq",
+// 		"ts":     "ts",
+// 		"op":     "op",
+// 		"id":     "documentKey._id",
+// 		"status": "data.status",
+// 		"total":  "data.total",
+// 	}
+// 	s.Sinks = []socketeer.Sink{events}
+//
+// for a table like:
+//
+// 	CREATE TABLE shop.order_events (
+// 		seq UInt64, ts DateTime64(3), op LowCardinality(String),
+// 		id String, status String, total Float64
+// 	) ENGINE = MergeTree ORDER BY (ts, seq)
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// Defaults of a Sink.
+//
+// 	- DefaultMaxRows is the number of rows from which a batch is
+// 		inserted when MaxRows is not set.
+// 	- DefaultInterval is the time from which a batch is inserted when
+// 		Interval is not set.
+// 	- DefaultTimeout bounds every request when Client is nil, and the
+// 		inserts which aren't bound by the context of a delivery.
+const (
+	DefaultMaxRows  = 10000
+	DefaultInterval = time.Second
+	DefaultTimeout  = 30 * time.Second
+)
+
+// tsFormat is the format of the times, the one ClickHouse parses into
+// a DateTime64(3) column by default.
+const tsFormat = "2006-01-02 15:04:05.000"
+
+// DefaultColumns are the columns inserted when Columns is nil, by
+// column, see Columns.
+var DefaultColumns = map[string]string{
+	"seq":         "seq",
+	"topic":       "topic",
+	"op":          "op",
+	"ts":          "ts",
+	"documentKey": "documentKey",
+	"data":        "data",
+}
+
+// StatusError is the failure of an insert answered with a status
+// other than 2xx.
+//
+// 	- Status is the status code of the response.
+// 	- Body is the beginning of the body of the response, the exception
+// 		of ClickHouse.
+type StatusError struct {
+	Status int
+	Body   string
+}
+
+// Error returns the description of the failure.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("clickhouse: status %d: %s", e.Status, strings.TrimSpace(e.Body))
+}
+
+// insert is a sealed batch waiting to be inserted.
+//
+// 	- rows are the JSONEachRow lines of the batch.
+// 	- token deduplicates the retries of the insert, empty for the
+// 		messages without sequence number.
+type insert struct {
+	rows  []byte
+	token string
+}
+
+// Sink is a socketeer.Sink inserting the messages into a ClickHouse
+// table, in batches of MaxRows rows or of Interval. The failed inserts
+// are kept and tried again with the next ones, with a deduplication
+// token, so that a retry of an insert which succeeded in fact doesn't
+// duplicate its rows in a replicated table.
+//
+// 	- name is the name of the sink.
+// 	- URL is the URL of the HTTP interface of ClickHouse.
+// 	- Table is the table the rows are inserted into, like "db.table".
+// 	- Columns are the sources of the columns by column: "seq", "topic",
+// 		"op", "ts", "data", "documentKey" or "fullDocument" (the last
+// 		three as JSON), or a field of them like "data.status", the
+// 		columns of a missing field get their default. DefaultColumns
+// 		when nil.
+// 	- User and Password authenticate the requests, the default user
+// 		when empty.
+// 	- Client sends the requests, a client with DefaultTimeout when nil.
+// 	- MaxRows is the number of rows from which a batch is inserted,
+// 		defaults to DefaultMaxRows.
+// 	- Interval is the time from which a batch is inserted, defaults
+// 		to DefaultInterval.
+// 	- mux guards the batch for thread safety.
+// 	- rows are the JSONEachRow lines of the open batch.
+// 	- count is the number of rows of the open batch.
+// 	- first is the sequence number of the first message of the batch.
+// 	- opened is when the open batch was opened.
+// 	- timer seals the open batch at the end of its interval.
+// 	- last is the sequence number of the last batched message, so that
+// 		the retries of its delivery don't batch it twice.
+// 	- pending are the sealed batches waiting to be inserted.
+type Sink struct {
+	name     string
+	URL      string
+	Table    string
+	Columns  map[string]string
+	User     string
+	Password string
+	Client   *http.Client
+	MaxRows  int
+	Interval time.Duration
+	mux      sync.Mutex
+	rows     bytes.Buffer
+	count    int
+	first    uint64
+	opened   time.Time
+	timer    *time.Timer
+	last     uint64
+	pending  []insert
+}
+
+// New returns a new Sink inserting into a table.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the sink.
+// 	- url (string): the URL of the HTTP interface of ClickHouse.
+// 	- table (string): the table the rows are inserted into.
+//
+// # Example:
+//
+// 	events := clickhouse.New("analytics", "http://localhost:8123", "order_events")
+func New(name string, url string, table string) *Sink {
+	return &Sink{name: name, URL: url, Table: table}
+}
+
+// Name returns the name of the sink.
+func (s *Sink) Name() string {
+	return s.name
+}
+
+// Deliver adds a message to the open batch, then inserts the sealed
+// batches. A failed insert fails the delivery while the message stays
+// batched: the retries of the delivery only retry the insert.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the inserts.
+// 	- msg (socketeer.Message): the message.
+//
+// # Example:
+//
+// 	err := events.Deliver(ctx, msg)
+func (s *Sink) Deliver(ctx context.Context, msg socketeer.Message) error {
+	row, err := s.row(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if msg.Seq == 0 || msg.Seq != s.last {
+		if s.count > 0 && time.Since(s.opened) >= s.interval() {
+			s.seal()
+		}
+		if s.count == 0 {
+			s.open(msg.Seq)
+		}
+		s.rows.Write(row)
+		s.count++
+		s.last = msg.Seq
+		if s.count >= s.maxRows() {
+			s.seal()
+		}
+	}
+
+	return s.flush(ctx)
+}
+
+// Close inserts the open batch and every sealed one, it fails when
+// some could not be inserted within DefaultTimeout.
+//
+// # Example:
+//
+// 	err := events.Close()
+func (s *Sink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.count > 0 {
+		s.seal()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	return s.flush(ctx)
+}
+
+// RetryPolicy retries the failed inserts for a few minutes, except
+// the 4xx statuses, a wrong column or table that a retry won't fix.
+//
+// # Example:
+//
+// 	policy := events.RetryPolicy()
+func (s *Sink) RetryPolicy() socketeer.RetryPolicy {
+	return socketeer.RetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		Jitter:      0.2,
+		Retryable: func(err error) bool {
+			var status *StatusError
+			if !errors.As(err, &status) {
+				return true
+			}
+			return status.Status >= 500 || status.Status == http.StatusTooManyRequests
+		},
+	}
+}
+
+// maxRows returns the MaxRows with its default.
+func (s *Sink) maxRows() int {
+	if s.MaxRows > 0 {
+		return s.MaxRows
+	}
+
+	return DefaultMaxRows
+}
+
+// interval returns the Interval with its default.
+func (s *Sink) interval() time.Duration {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+
+	return DefaultInterval
+}
+
+// row returns the JSONEachRow line of a message, with its columns.
+//
+// # Parameters:
+//
+// 	- msg (socketeer.Message): the message.
+//
+// # Example:
+//
+// 	row, err := s.row(msg)
+func (s *Sink) row(msg socketeer.Message) ([]byte, error) {
+	columns := s.Columns
+	if columns == nil {
+		columns = DefaultColumns
+	}
+
+	row := make(map[string]any, len(columns))
+	for column, source := range columns {
+		value, ok, err := extract(msg, source)
+		if err != nil {
+			return nil, fmt.Errorf("clickhouse: column %q: %w", column, err)
+		}
+		if ok {
+			row[column] = value
+		}
+	}
+	line, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(line, '\n'), nil
+}
+
+// extract returns the value of a source of a column for a message,
+// and whether the message has it.
+//
+// # Parameters:
+//
+// 	- msg (socketeer.Message): the message.
+// 	- source (string): the source, see the Columns of Sink.
+//
+// # Example:
+//
+// 	value, ok, err := extract(msg, "data.status")
+func extract(msg socketeer.Message, source string) (any, bool, error) {
+	switch source {
+	case "seq":
+		return msg.Seq, true, nil
+	case "topic":
+		return msg.Topic, true, nil
+	case "op":
+		return msg.OperationType, true, nil
+	case "ts":
+		return msg.Time.UTC().Format(tsFormat), true, nil
+	}
+
+	name, field, nested := strings.Cut(source, ".")
+	var fields map[string]string
+	switch name {
+	case "data":
+		fields = msg.Data
+	case "documentKey":
+		fields = msg.DocumentKey
+	case "fullDocument":
+		fields = msg.FullDocument
+	default:
+		return nil, false, fmt.Errorf("unknown source %q", source)
+	}
+	if !nested {
+		if fields == nil {
+			return nil, false, nil
+		}
+		value, err := json.Marshal(fields)
+		return string(value), true, err
+	}
+	value, ok := fields[field]
+
+	return value, ok, nil
+}
+
+// open opens a new batch, sealed by its timer at the end of its interval.
+//
+// # Parameters:
+//
+// 	- seq (uint64): the sequence number of its first message.
+//
+// # Example:
+//
+// 	s.open(msg.Seq)
+func (s *Sink) open(seq uint64) {
+	s.first = seq
+	s.opened = time.Now()
+	opened := s.opened
+	s.timer = time.AfterFunc(s.interval(), func() {
+		s.expire(opened)
+	})
+}
+
+// seal closes the open batch and queues it to be inserted, with the
+// range of its sequence numbers as deduplication token.
+func (s *Sink) seal() {
+	s.timer.Stop()
+	batch := insert{rows: append([]byte(nil), s.rows.Bytes()...)}
+	if s.first != 0 {
+		batch.token = s.name + "-" + strconv.FormatUint(s.first, 10) + "-" + strconv.FormatUint(s.last, 10)
+	}
+	s.pending = append(s.pending, batch)
+	s.rows.Reset()
+	s.count = 0
+}
+
+// expire seals the batch opened at the given time once its interval
+// is over and inserts the sealed batches, a failure is left to the
+// next delivery.
+//
+// # Parameters:
+//
+// 	- opened (time.Time): when the batch was opened, a batch sealed
+// 		meanwhile is left alone.
+//
+// # Example:
+//
+// 	time.AfterFunc(s.interval(), func() { s.expire(opened) })
+func (s *Sink) expire(opened time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.count == 0 || !s.opened.Equal(opened) {
+		return
+	}
+	s.seal()
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	s.flush(ctx)
+}
+
+// flush inserts the sealed batches in order, it stops at the first
+// failure, the failed batch and the following ones are kept.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the inserts.
+//
+// # Example:
+//
+// 	err := s.flush(ctx)
+func (s *Sink) flush(ctx context.Context) error {
+	for len(s.pending) > 0 {
+		err := s.insert(ctx, s.pending[0])
+		if err != nil {
+			return err
+		}
+		s.pending = s.pending[1:]
+	}
+
+	return nil
+}
+
+// insert posts a batch to the HTTP interface, it fails unless the
+// response status is 2xx.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the request.
+// 	- batch (insert): the batch.
+//
+// # Example:
+//
+// 	err := s.insert(ctx, s.pending[0])
+func (s *Sink) insert(ctx context.Context, batch insert) error {
+	columns := s.Columns
+	if columns == nil {
+		columns = DefaultColumns
+	}
+	names := make([]string, 0, len(columns))
+	for column := range columns {
+		names = append(names, "`"+strings.ReplaceAll(column, "`", "\\`")+"`")
+	}
+	sort.Strings(names)
+
+	query := url.Values{"query": {"INSERT INTO " + s.Table + " (" + strings.Join(names, ", ") + ") FORMAT JSONEachRow"}}
+	if batch.token != "" {
+		query.Set("insert_deduplication_token", batch.token)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.URL, "/")+"/?"+query.Encode(), bytes.NewReader(batch.rows))
+	if err != nil {
+		return err
+	}
+	if s.User != "" {
+		req.Header.Set("X-ClickHouse-User", s.User)
+		req.Header.Set("X-ClickHouse-Key", s.Password)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return &StatusError{Status: res.StatusCode, Body: string(detail)}
+	}
+
+	return nil
+}
@@ -0,0 +1,667 @@
+// Package client is a Go client for socketeer servers.
+//
+// It connects to the websocket endpoint of a socketeer, authenticates
+// with a bearer token, keeps the connection alive with pings, reconnects
+// with exponential backoff when the connection drops, resumes the
+// session of the previous connection, restoring its subscriptions and
+// receiving the updates missed in the meantime, and delivers the
+// received updates as Event values on a channel.
+//
+// This package is used in the following way:
+//
+// 	1. Connect to the server with Dial().
+// 	2. Manage subscriptions with Subscribe() and Unsubscribe().
+// 	3. Receive the updates from Events().
+// 	4. Close the client with Close().
+//
+// # Example:
+//
+// 	c, err := client.Dial(ctx, "ws://localhost:8080/listen", &client.Options{
+// 		Token:  token,
+// 		Topics: []string{"posts"},
+// 	})
+// 	if err != nil {
+// 		log.Fatal(err)
+// 	}
+// 	defer c.Close()
+//
+// 	for ev := range c.Events() {
+// 		fmt.Println(ev.Data["title"])
+// 	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrClosed is returned by the methods of a closed Client.
+var ErrClosed = errors.New("client: closed")
+
+// Close codes of the server after which the client stops
+// reconnecting, any other close is retried with backoff.
+//
+// 	- ClosePolicyViolation is sent when the client broke a policy.
+// 	- CloseKicked is sent when an operator kicked the client.
+// 	- CloseAuthExpired is sent when the credentials of the client expired.
+const (
+	ClosePolicyViolation = 1008
+	CloseKicked          = 4000
+	CloseAuthExpired     = 4001
+)
+
+// Options is a struct for configuring a Client,
+// the zero value of every field selects its default.
+//
+// 	- Token is sent as a bearer token in the Authorization header.
+// 	- Header are additional headers sent with the handshake.
+// 	- Topics are the topics subscribed to on connect.
+// 	- PingInterval is the interval between pings, defaults to 30s.
+// 	- PongTimeout is how long to wait for a pong before the connection
+// 		is considered dead, defaults to 10s.
+// 	- MinBackoff is the first delay between reconnection attempts, defaults to 500ms.
+// 	- MaxBackoff is the maximal delay between reconnection attempts, defaults to 30s.
+// 	- Buffer is the capacity of the Events channel, defaults to 64.
+// 	- OnReconnect is called before every reconnection attempt with
+// 		the attempt number and the error which caused the reconnection.
+// 	- Compress asks the server to compress the large messages with
+// 		zstd, for document-heavy feeds. Servers without compression
+// 		keep sending them as is.
+type Options struct {
+	Token        string
+	Header       http.Header
+	Topics       []string
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	Buffer       int
+	OnReconnect  func(attempt int, err error)
+	Compress     bool
+}
+
+// Event is an update received from the server.
+//
+// 	- Cursor is the position of the update in the stream of the server,
+// 		it is empty when the server only speaks the first protocol version.
+// 	- Topic is the topic of the update, which is the collection name.
+// 	- Op is the type of operation, example: "insert", "update".
+// 	- ClusterTime is the second of the change in the database.
+// 	- Time is the time the server dispatched the update at,
+// 		comparing it with ClusterTime measures the staleness of the update.
+// 	- Data are the selected keys of the changed document.
+// 	- ArrayChanges are the changes of the elements of the selected
+// 		array fields, when the server normalizes them.
+// 	- DocumentKey is the _id (and shard key) of the changed document,
+// 		when the server includes it.
+// 	- FullDocument is the whole changed document, when the server
+// 		includes it.
+// 	- Patch is the change as a JSON Merge Patch (RFC 7386) of the
+// 		selected keys, when the server sends it.
+// 	- RawChange is the complete change document of MongoDB, as relaxed
+// 		Extended JSON, when the server forwards it.
+// 	- Raw is the message as received from the server, decompressed.
+type Event struct {
+	Cursor       string
+	Topic        string
+	Op           string
+	ClusterTime  time.Time
+	Time         time.Time
+	Data         map[string]string
+	ArrayChanges []ArrayChange
+	DocumentKey  map[string]string
+	FullDocument map[string]string
+	Patch        json.RawMessage
+	RawChange    json.RawMessage
+	Raw          []byte
+}
+
+// ArrayChange is a change of an element of an array field.
+//
+// 	- Field is the name of the array field.
+// 	- Index is the index of the changed element, or the new size of
+// 		the array for a truncation.
+// 	- Path is the changed field of the element, empty when the
+// 		whole element changed.
+// 	- Action is "set" or "truncate".
+// 	- Value is the new value, as JSON unless it is a string.
+type ArrayChange struct {
+	Field  string `json:"field"`
+	Index  int    `json:"index"`
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Value  string `json:"value"`
+}
+
+// envelope is a message of the second version of the protocol.
+type envelope struct {
+	V           int    `json:"v"`
+	Type        string `json:"type"`
+	Session     string `json:"session"`
+	Seq         uint64 `json:"seq"`
+	Topic       string `json:"topic"`
+	Op          string `json:"op"`
+	ClusterTime struct {
+		T int64 `json:"t"`
+	} `json:"clusterTime"`
+	Time         time.Time         `json:"ts"`
+	Data         map[string]string `json:"data"`
+	ArrayChanges []ArrayChange     `json:"arrayChanges"`
+	DocumentKey  map[string]string `json:"documentKey"`
+	FullDocument map[string]string `json:"fullDocument"`
+	Patch        json.RawMessage   `json:"patch"`
+	RawChange    json.RawMessage   `json:"rawChange"`
+}
+
+// control is a control message sent to the server.
+//
+// 	- Type is the type of the message, "subscribe" or "unsubscribe".
+// 	- Topic is the topic.
+// 	- Filter is the filter of a subscription, if any.
+// 	- Fields are the fields asked for in a subscription, if any.
+type control struct {
+	Type   string            `json:"type"`
+	Topic  string            `json:"topic"`
+	Filter map[string]string `json:"filter,omitempty"`
+	Fields []string          `json:"fields,omitempty"`
+}
+
+// subprotocols are the protocol versions offered to the
+// server, the newest first.
+var subprotocols = []string{"socketeer.v2", "socketeer.v1"}
+
+// zstdDecoder decompresses the compressed messages of the server,
+// DecodeAll() is safe for concurrent use.
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// zstdMagic starts the messages compressed with zstd.
+var zstdMagic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+
+// Client is a connection to a socketeer server which survives
+// network failures by reconnecting in the background.
+//
+// 	- url is the websocket url of the server.
+// 	- opts are the options of the client with defaults applied.
+// 	- events is the channel the updates are delivered on.
+// 	- conn is the current connection, replaced on reconnection.
+// 	- topics are the current subscriptions with their filter, restored
+// 		on reconnection.
+// 	- fields are the fields asked for in the current subscriptions,
+// 		by topic.
+// 	- cursor is the cursor of the last update, sent on reconnection.
+// 	- session is the session token received in the hello message,
+// 		sent on reconnection.
+// 	- mux is a mutex for conn, topics and cursor for thread safety.
+// 	- writeMux serializes the writes to the connection.
+// 	- done is closed when the client is closed.
+// 	- err is the error which stopped the client, if any.
+type Client struct {
+	url      string
+	opts     Options
+	events   chan Event
+	conn     *websocket.Conn
+	topics   map[string]map[string]string
+	fields   map[string][]string
+	cursor   string
+	session  string
+	mux      sync.Mutex
+	writeMux sync.Mutex
+	done     chan struct{}
+	doneOnce sync.Once
+	err      error
+}
+
+// Dial connects to a socketeer server and returns a Client
+// which keeps the connection up until it is closed.
+//
+// The first connection attempt is made synchronously so that
+// configuration errors are reported to the caller, the later
+// ones happen in the background.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the first connection attempt.
+// 	- rawURL (string): the websocket url of the server, example: ws://localhost:8080/listen
+// 	- opts (*Options): the options of the client, nil selects the defaults.
+//
+// # Example:
+//
+// 	c, err := client.Dial(ctx, "ws://localhost:8080/listen", nil)
+func Dial(ctx context.Context, rawURL string, opts *Options) (*Client, error) {
+	c := &Client{
+		url:    rawURL,
+		topics: make(map[string]map[string]string),
+		fields: make(map[string][]string),
+		done:   make(chan struct{}),
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	c.applyDefaults()
+	c.events = make(chan Event, c.opts.Buffer)
+
+	for _, topic := range c.opts.Topics {
+		c.topics[topic] = nil
+	}
+
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	go c.run(conn)
+
+	return c, nil
+}
+
+// Events returns the channel the updates are delivered on,
+// it is closed when the client is closed or stops reconnecting.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Err returns the error which made the client stop reconnecting,
+// a *websocket.CloseError carrying the close code and reason sent by
+// the server, once the Events channel is closed. It is nil when the
+// client was closed with Close().
+func (c *Client) Err() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.err
+}
+
+// Subscribe subscribes to the updates of a topic, which is
+// the name of a collection watched by the server.
+//
+// # Parameters:
+//
+// 	- topic (string): the topic to subscribe to.
+//
+// # Example:
+//
+// 	err := c.Subscribe("posts")
+func (c *Client) Subscribe(topic string) error {
+	return c.SubscribeFilter(topic, nil)
+}
+
+// SubscribeFilter subscribes to the updates of a topic which have
+// the given field values, replacing the filter of a previous
+// subscription to the topic. Updates only match when the server
+// knows the filtered fields: they are among its keys and changed,
+// or the server includes the full document.
+//
+// # Parameters:
+//
+// 	- topic (string): the topic to subscribe to.
+// 	- filter (map[string]string): the field values, nil for every update.
+//
+// # Example:
+//
+// 	err := c.SubscribeFilter("orders", map[string]string{"tenant": "acme"})
+func (c *Client) SubscribeFilter(topic string, filter map[string]string) error {
+	return c.SubscribeFields(topic, filter, nil)
+}
+
+// SubscribeFields subscribes to the updates of a topic like
+// SubscribeFilter(), asking the server to only send the given fields
+// of the updates, among its keys, to save bandwidth.
+//
+// # Parameters:
+//
+// 	- topic (string): the topic to subscribe to.
+// 	- filter (map[string]string): the field values, nil for every update.
+// 	- fields ([]string): the fields to receive, nil for every key.
+//
+// # Example:
+//
+// 	err := c.SubscribeFields("products", nil, []string{"name", "price"})
+func (c *Client) SubscribeFields(topic string, filter map[string]string, fields []string) error {
+	c.mux.Lock()
+	c.topics[topic] = filter
+	c.fields[topic] = fields
+	c.mux.Unlock()
+
+	return c.sendControl("subscribe", topic, filter, fields)
+}
+
+// Unsubscribe stops the updates of a topic.
+//
+// # Parameters:
+//
+// 	- topic (string): the topic to unsubscribe from.
+//
+// # Example:
+//
+// 	err := c.Unsubscribe("posts")
+func (c *Client) Unsubscribe(topic string) error {
+	c.mux.Lock()
+	delete(c.topics, topic)
+	delete(c.fields, topic)
+	c.mux.Unlock()
+
+	return c.sendControl("unsubscribe", topic, nil, nil)
+}
+
+// Close closes the connection and stops reconnecting.
+//
+// # Example:
+//
+// 	c.Close()
+func (c *Client) Close() error {
+	c.doneOnce.Do(func() {
+		close(c.done)
+	})
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
+
+// applyDefaults fills the zero options with their defaults.
+func (c *Client) applyDefaults() {
+	if c.opts.PingInterval <= 0 {
+		c.opts.PingInterval = 30 * time.Second
+	}
+	if c.opts.PongTimeout <= 0 {
+		c.opts.PongTimeout = 10 * time.Second
+	}
+	if c.opts.MinBackoff <= 0 {
+		c.opts.MinBackoff = 500 * time.Millisecond
+	}
+	if c.opts.MaxBackoff <= 0 {
+		c.opts.MaxBackoff = 30 * time.Second
+	}
+	if c.opts.Buffer <= 0 {
+		c.opts.Buffer = 64
+	}
+}
+
+// connect dials the server, passing the session token and the last
+// cursor so that the server can resume the session, and restores the
+// subscriptions for servers which don't know the session anymore.
+func (c *Client) connect(ctx context.Context) (*websocket.Conn, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	for key, values := range c.opts.Header {
+		header[key] = values
+	}
+	if c.opts.Token != "" {
+		header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+
+	c.mux.Lock()
+	query := u.Query()
+	if c.session != "" {
+		query.Set("session", c.session)
+	}
+	if c.cursor != "" {
+		query.Set("cursor", c.cursor)
+	}
+	if c.opts.Compress {
+		query.Set("compress", "zstd")
+	}
+	u.RawQuery = query.Encode()
+	c.mux.Unlock()
+
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = subprotocols
+	conn, _, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := c.opts.PingInterval + c.opts.PongTimeout
+	conn.SetReadDeadline(time.Now().Add(deadline))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(deadline))
+	})
+
+	c.mux.Lock()
+	c.conn = conn
+	topics := make(map[string]map[string]string, len(c.topics))
+	for topic, filter := range c.topics {
+		topics[topic] = filter
+	}
+	fields := make(map[string][]string, len(c.fields))
+	for topic, f := range c.fields {
+		fields[topic] = f
+	}
+	c.mux.Unlock()
+
+	for topic, filter := range topics {
+		err := c.sendControl("subscribe", topic, filter, fields[topic])
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// run reads from the connection until the client is closed,
+// reconnecting with exponential backoff and jitter on failures.
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.events)
+
+	for {
+		err := c.read(conn)
+		if fatal(err) {
+			c.mux.Lock()
+			c.err = err
+			c.mux.Unlock()
+			c.doneOnce.Do(func() {
+				close(c.done)
+			})
+			return
+		}
+
+		attempt := 0
+		for {
+			select {
+			case <-c.done:
+				return
+			default:
+			}
+
+			attempt++
+			if c.opts.OnReconnect != nil {
+				c.opts.OnReconnect(attempt, err)
+			}
+
+			select {
+			case <-time.After(c.backoff(attempt)):
+			case <-c.done:
+				return
+			}
+
+			conn, err = c.connect(context.Background())
+			if err == nil {
+				break
+			}
+		}
+	}
+}
+
+// fatal reports whether the server closed the connection
+// with a code after which the client must not reconnect.
+func fatal(err error) bool {
+	return websocket.IsCloseError(err, ClosePolicyViolation, CloseKicked, CloseAuthExpired)
+}
+
+// read delivers the messages of the connection until it fails,
+// pinging the server in the meantime.
+func (c *Client) read(conn *websocket.Conn) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.ping(conn, stop)
+
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if typ == websocket.BinaryMessage && bytes.HasPrefix(msg, zstdMagic) {
+			msg, err = zstdDecoder.DecodeAll(msg, nil)
+			if err != nil {
+				continue
+			}
+		}
+
+		if session := hello(conn.Subprotocol(), msg); session != "" {
+			c.mux.Lock()
+			c.session = session
+			c.mux.Unlock()
+			continue
+		}
+
+		ev, ok := decode(conn.Subprotocol(), msg)
+		if !ok {
+			continue
+		}
+
+		if ev.Cursor != "" {
+			c.mux.Lock()
+			c.cursor = ev.Cursor
+			c.mux.Unlock()
+		}
+
+		select {
+		case c.events <- ev:
+		case <-c.done:
+			return ErrClosed
+		}
+	}
+}
+
+// hello returns the session token of a hello message,
+// it is empty for the other messages.
+func hello(subprotocol string, msg []byte) string {
+	if subprotocol != "socketeer.v2" {
+		return ""
+	}
+
+	var env envelope
+	err := json.Unmarshal(msg, &env)
+	if err != nil || env.Type != "hello" {
+		return ""
+	}
+
+	return env.Session
+}
+
+// decode decodes a message of the negotiated protocol version,
+// it reports false for messages which are not updates.
+func decode(subprotocol string, msg []byte) (Event, bool) {
+	ev := Event{Raw: msg}
+
+	if subprotocol != "socketeer.v2" {
+		err := json.Unmarshal(msg, &ev.Data)
+		return ev, err == nil
+	}
+
+	var env envelope
+	err := json.Unmarshal(msg, &env)
+	if err != nil || env.Type != "event" {
+		return ev, false
+	}
+
+	ev.Cursor = strconv.FormatUint(env.Seq, 10)
+	ev.Topic = env.Topic
+	ev.Op = env.Op
+	ev.Time = env.Time
+	ev.Data = env.Data
+	ev.ArrayChanges = env.ArrayChanges
+	ev.DocumentKey = env.DocumentKey
+	ev.FullDocument = env.FullDocument
+	ev.Patch = env.Patch
+	ev.RawChange = env.RawChange
+	if env.ClusterTime.T != 0 {
+		ev.ClusterTime = time.Unix(env.ClusterTime.T, 0)
+	}
+
+	return ev, true
+}
+
+// ping pings the server every PingInterval until stop is closed.
+func (c *Client) ping(conn *websocket.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(c.opts.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			deadline := time.Now().Add(c.opts.PongTimeout)
+			err := conn.WriteControl(websocket.PingMessage, nil, deadline)
+			if err != nil {
+				conn.Close()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// backoff returns the delay before the given reconnection attempt,
+// a random duration up to MinBackoff doubled per attempt, capped at MaxBackoff.
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.opts.MaxBackoff
+	if attempt < 32 {
+		d := c.opts.MinBackoff << uint(attempt-1)
+		if d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// sendControl sends a control message on the current connection,
+// when the client is disconnected the message is sent after reconnecting.
+func (c *Client) sendControl(typ string, topic string, filter map[string]string, fields []string) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+
+	c.mux.Lock()
+	conn := c.conn
+	c.mux.Unlock()
+	if conn == nil {
+		return nil
+	}
+
+	msg, err := json.Marshal(control{Type: typ, Topic: topic, Filter: filter, Fields: fields})
+	if err != nil {
+		return err
+	}
+
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// connection is what a fake server received on a connection.
+//
+// 	- query is the query of the handshake.
+// 	- topics are the subscribed topics, sorted.
+type connection struct {
+	query  url.Values
+	topics []string
+}
+
+// subscriptions reads n subscribe messages from a connection
+// and returns their topics, sorted.
+func subscriptions(t *testing.T, conn *websocket.Conn, n int) []string {
+	t.Helper()
+	var topics []string
+	for i := 0; i < n; i++ {
+		var msg control
+		err := conn.ReadJSON(&msg)
+		if err != nil {
+			t.Errorf("ReadJSON() = %v", err)
+			return topics
+		}
+		if msg.Type != "subscribe" {
+			t.Errorf("control type = %q, want subscribe", msg.Type)
+		}
+		topics = append(topics, msg.Topic)
+	}
+	sort.Strings(topics)
+
+	return topics
+}
+
+// next returns the next event of a client, failing after a second.
+func next(t *testing.T, c *Client) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-c.Events():
+		if !ok {
+			t.Fatalf("events closed: %v", c.Err())
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+
+	return Event{}
+}
+
+func TestReconnectResumes(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"socketeer.v2"}}
+	connections := make(chan connection, 2)
+	subscribed := make(chan struct{})
+	var attempt atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		switch attempt.Add(1) {
+		case 1:
+			// The first connection delivers an update, waits for a
+			// subscription made afterwards and drops without closing.
+			topics := subscriptions(t, conn, 1)
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"v":2,"type":"hello","session":"s1"}`))
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"v":2,"type":"event","seq":5,"topic":"posts","op":"insert","data":{"title":"First"}}`))
+			topics = append(topics, subscriptions(t, conn, 1)...)
+			sort.Strings(topics)
+			connections <- connection{r.URL.Query(), topics}
+			close(subscribed)
+		case 2:
+			connections <- connection{r.URL.Query(), subscriptions(t, conn, 2)}
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"v":2,"type":"event","seq":6,"topic":"orders","op":"update","data":{"status":"paid"}}`))
+			conn.ReadMessage()
+		}
+	}))
+	defer server.Close()
+
+	var reconnects []int
+	c, err := Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), &Options{
+		Topics:      []string{"posts"},
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		OnReconnect: func(attempt int, err error) { reconnects = append(reconnects, attempt) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ev := next(t, c)
+	if ev.Cursor != "5" || ev.Topic != "posts" || ev.Data["title"] != "First" {
+		t.Fatalf("first event = %+v", ev)
+	}
+	err = c.Subscribe("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-subscribed
+
+	ev = next(t, c)
+	if ev.Cursor != "6" || ev.Topic != "orders" || ev.Data["status"] != "paid" {
+		t.Fatalf("event after reconnecting = %+v", ev)
+	}
+	first, second := <-connections, <-connections
+	if first.query.Get("session") != "" || first.query.Get("cursor") != "" {
+		t.Errorf("first handshake query = %v, want no session nor cursor", first.query)
+	}
+	if second.query.Get("session") != "s1" || second.query.Get("cursor") != "5" {
+		t.Errorf("handshake query after reconnecting = %v, want session s1 and cursor 5", second.query)
+	}
+	if want := []string{"orders", "posts"}; !reflect.DeepEqual(first.topics, want) || !reflect.DeepEqual(second.topics, want) {
+		t.Errorf("subscriptions = %v then %v, want %v on both connections", first.topics, second.topics, want)
+	}
+	if !reflect.DeepEqual(reconnects, []int{1}) {
+		t.Errorf("reconnection attempts = %v, want [1]", reconnects)
+	}
+}
+
+func TestFatalClose(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"socketeer.v2"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg := websocket.FormatCloseMessage(CloseKicked, "kicked")
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	c, err := Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), &Options{
+		MinBackoff:  10 * time.Millisecond,
+		OnReconnect: func(attempt int, err error) { t.Errorf("reconnection after %v", err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case _, ok := <-c.Events():
+		if ok {
+			t.Fatal("event before the close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("events not closed")
+	}
+	var closeErr *websocket.CloseError
+	if err := c.Err(); !errors.As(err, &closeErr) || closeErr.Code != CloseKicked {
+		t.Errorf("Err() = %v, want the close code %d", err, CloseKicked)
+	}
+}
@@ -0,0 +1,89 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// AdminClientsPath is the path the connected clients are listed on,
+// with a GET behind the AdminToken.
+const AdminClientsPath = "/admin/clients"
+
+// Codes of the error messages sent to the clients of the second
+// protocol version when a control message is rejected, example:
+// {"v": 2, "type": "error", "code": "subscription_limit", "topic": "posts",
+// "error": "too many subscriptions, the limit is 16"}
+//
+// 	- ErrorSubscriptionLimit is a subscription over MaxSubscriptions.
+const (
+	ErrorSubscriptionLimit = ws.ErrorSubscriptionLimit
+)
+
+// DefaultMeterInterval is the period of the usage summaries when
+// MeterInterval is not set.
+const DefaultMeterInterval = ws.DefaultMeterInterval
+
+// Usage is the consumption of an identity over a period, the messages
+// and bytes written to its clients, reported to Meter.
+type Usage = ws.Usage
+
+// ClientInfo describes a connected client, with the round-trip time
+// of its pings when PingInterval is set, so that the clients on bad
+// networks can be spotted and kicked. See Clients().
+type ClientInfo = ws.ClientInfo
+
+// clientLister is implemented by the broadcasters which
+// can list their clients, like the default WebSocket server.
+type clientLister interface {
+	Clients() []ws.ClientInfo
+}
+
+// Clients returns the connected clients, oldest first.
+//
+// # Example:
+//
+// 	clients, err := s.Clients()
+func (s *Socketeer) Clients() ([]ClientInfo, error) {
+	l, ok := s.WS.(clientLister)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return l.Clients(), nil
+}
+
+// serveClients answers the GET requests on AdminClientsPath
+// with the connected clients.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(AdminClientsPath, http.HandlerFunc(s.serveClients))
+func (s *Socketeer) serveClients(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodGet {
+		res.Header().Set("Allow", "GET")
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clients, err := s.Clients()
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(clients)
+}
@@ -0,0 +1,348 @@
+package socketeer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/metrics"
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// ClusterPath is the path the instances of a cluster stream the
+// messages of their topics to each other on, with a websocket
+// connection behind the Secret of the Cluster.
+const ClusterPath = "/cluster/stream"
+
+// DefaultClusterReplicas is the number of points of every instance
+// on the hash ring when the Replicas of a Cluster is not set.
+const DefaultClusterReplicas = 128
+
+// Bounds of the backoff between two connections of a cluster link.
+const (
+	clusterMinBackoff = time.Second
+	clusterMaxBackoff = 30 * time.Second
+)
+
+// ErrInvalidCluster is returned by Start() and Validate() for a
+// Cluster without secret, with an invalid instance URL, or whose
+// Self is not one of its Instances.
+var ErrInvalidCluster = errors.New("socketeer: invalid cluster")
+
+// Cluster partitions the topics across the instances of a cluster
+// with consistent hashing, so that every topic is watched, and its
+// history kept, by a single instance: its owner. Every instance
+// forwards the messages of its topics to the other ones, which
+// dispatch them to their own clients, whatever instance the clients
+// are connected to. Adding or removing an instance only moves the
+// topics of its share of the ring.
+//
+// 	- Self is the URL of this instance, one of the Instances.
+// 	- Instances are the URLs of every instance of the cluster, the
+// 		ws:// or wss:// URLs of their server, the same on every instance.
+// 	- Secret is the bearer token of the links between the instances.
+// 	- Replicas is the number of points of every instance on the hash
+// 		ring, defaults to DefaultClusterReplicas.
+//
+// # Example:
+//
+// 	s.Cluster = &socketeer.Cluster{
+// 		Self:      "ws://10.0.0.1:8080",
+// 		Instances: []string{"ws://10.0.0.1:8080", "ws://10.0.0.2:8080", "ws://10.0.0.3:8080"},
+// 		Secret:    os.Getenv("CLUSTER_SECRET"),
+// 	}
+type Cluster struct {
+	Self      string   `json:"self"`
+	Instances []string `json:"instances"`
+	Secret    string   `json:"secret"`
+	Replicas  int      `json:"replicas,omitempty"`
+}
+
+// ring is a consistent hash ring of the instances of a cluster.
+//
+// 	- points are the hashes of the points, sorted.
+// 	- owners are the instances of the points, by hash.
+type ring struct {
+	points []uint64
+	owners map[uint64]string
+}
+
+// hashKey returns the position of a key on the ring, the first bytes
+// of its SHA-256 hash, which spreads the similar keys like "t1" and
+// "t2" evenly.
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// newRing returns the ring of the instances of a cluster.
+//
+// # Parameters:
+//
+// 	- instances ([]string): the URLs of the instances.
+// 	- replicas (int): the number of points of every instance.
+//
+// # Example:
+//
+// 	r := newRing(c.Instances, DefaultClusterReplicas)
+func newRing(instances []string, replicas int) *ring {
+	r := &ring{owners: make(map[uint64]string, len(instances)*replicas)}
+	for _, instance := range instances {
+		for i := 0; i < replicas; i++ {
+			point := hashKey(instance + "#" + strconv.Itoa(i))
+			if _, ok := r.owners[point]; ok {
+				continue
+			}
+			r.owners[point] = instance
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i] < r.points[j]
+	})
+
+	return r
+}
+
+// owner returns the instance owning a topic, the one of the first
+// point of the ring after the hash of the topic.
+func (r *ring) owner(topic string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(topic)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.owners[r.points[i]]
+}
+
+// checkCluster checks the Cluster and builds its ring.
+func (s *Socketeer) checkCluster() error {
+	c := s.Cluster
+	if c == nil {
+		return nil
+	}
+	if c.Secret == "" {
+		return fmt.Errorf("%w: no secret", ErrInvalidCluster)
+	}
+	if c.Replicas < 0 {
+		return fmt.Errorf("%w: negative replicas", ErrInvalidCluster)
+	}
+	self := false
+	for _, instance := range c.Instances {
+		u, err := url.Parse(instance)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			return fmt.Errorf("%w: instance %q is not a ws:// or wss:// URL", ErrInvalidCluster, instance)
+		}
+		self = self || instance == c.Self
+	}
+	if !self {
+		return fmt.Errorf("%w: self %q is not an instance", ErrInvalidCluster, c.Self)
+	}
+
+	replicas := c.Replicas
+	if replicas == 0 {
+		replicas = DefaultClusterReplicas
+	}
+	s.ring = newRing(c.Instances, replicas)
+
+	return nil
+}
+
+// owns reports whether this instance owns a topic, always true
+// without Cluster. The default change sources don't watch the
+// collections of the other instances, the events are still checked
+// for the sources which watch every collection.
+func (s *Socketeer) owns(topic string) bool {
+	return s.ring == nil || s.ring.owner(topic) == s.Cluster.Self
+}
+
+// Owner returns the URL of the instance of the Cluster owning a topic,
+// empty without Cluster or before Start().
+//
+// # Parameters:
+//
+// 	- topic (string): the topic.
+//
+// # Example:
+//
+// 	instance := s.Owner("orders")
+func (s *Socketeer) Owner(topic string) string {
+	if s.ring == nil {
+		return ""
+	}
+
+	return s.ring.owner(topic)
+}
+
+// serveCluster serves the cluster link of another instance, a
+// websocket connection receiving a TapRecord for every message of
+// the topics of this instance, the messages forwarded from the other
+// instances excluded.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the upgrade request.
+//
+// # Example:
+//
+// 	r.Handle(ClusterPath, http.HandlerFunc(s.serveCluster))
+func (s *Socketeer) serveCluster(res http.ResponseWriter, req *http.Request) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if s.Cluster == nil || subtle.ConstantTimeCompare([]byte(token), []byte(s.Cluster.Secret)) != 1 {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	f, ok := s.WS.(forwarder)
+	if !ok {
+		http.Error(res, ErrUnsupported.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	frames := make(frameWriter, tapBuffer)
+	untap := s.tapWhere(frames, func(msg Message) bool {
+		return s.owns(msg.Topic)
+	})
+	defer untap()
+
+	f.Forward(res, req, frames)
+}
+
+// link receives the messages of the topics of another instance and
+// dispatches them to the clients of this one, reconnecting with an
+// exponential backoff until the socketeer is stopped. The forwarded
+// messages are numbered and dispatched with publish(), like the ones of
+// the local change stream, so that the clients of this instance receive
+// every message in the order of its sequence number. They are neither
+// delivered to the sinks, which the owner does, nor forwarded again.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Parameters:
+//
+// 	- instance (string): the URL of the other instance.
+//
+// # Example:
+//
+// 	go s.link("ws://10.0.0.2:8080")
+func (s *Socketeer) link(instance string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	header := http.Header{"Authorization": {"Bearer " + s.Cluster.Secret}}
+	backoff := clusterMinBackoff
+	for {
+		conn, err := ws.Dial(ctx, strings.TrimSuffix(instance, "/")+ClusterPath, header)
+		if err == nil {
+			s.log.Info("cluster link up", "instance", instance)
+			backoff = clusterMinBackoff
+			err = s.forward(conn, instance)
+		}
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		s.log.Warn("cluster link down", "instance", instance, "error", err, "retry", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > clusterMaxBackoff {
+			backoff = clusterMaxBackoff
+		}
+	}
+}
+
+// forward dispatches the messages read from a cluster link until the
+// connection fails or the socketeer is stopped.
+//
+// # Parameters:
+//
+// 	- conn (ws.Conn): the connection of the link.
+// 	- instance (string): the URL of the other instance.
+//
+// # Example:
+//
+// 	err := s.forward(conn, instance)
+func (s *Socketeer) forward(conn ws.Conn, instance string) error {
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-s.done:
+			conn.WriteClose(ws.CloseGoingAway, "server shutting down")
+			conn.Close()
+		case <-closed:
+			conn.Close()
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var record TapRecord
+		err = json.Unmarshal(data, &record)
+		if err != nil {
+			s.report(err, map[string]any{"component": "cluster", "instance": instance})
+			continue
+		}
+		msg := record.Message
+		s.Metrics.Count(metrics.MessagesForwarded, 1, map[string]string{metrics.TagCollection: msg.Topic})
+		s.publish(msg)
+	}
+}
+
+// startCluster starts the links to the other instances of the Cluster.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Example:
+//
+// 	s.startCluster()
+func (s *Socketeer) startCluster() {
+	if s.Cluster == nil {
+		return
+	}
+	for _, instance := range s.Cluster.Instances {
+		if instance == s.Cluster.Self {
+			continue
+		}
+		s.wg.Add(1)
+		go func(instance string) {
+			defer s.wg.Done()
+			s.link(instance)
+		}(instance)
+	}
+}
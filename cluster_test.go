@@ -0,0 +1,137 @@
+package socketeer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+var clusterInstances = []string{"ws://10.0.0.1:8080", "ws://10.0.0.2:8080", "ws://10.0.0.3:8080"}
+
+// topics returns n topic names.
+func topics(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprint("topic-", i)
+	}
+
+	return names
+}
+
+func TestRingOwner(t *testing.T) {
+	if owner := newRing(nil, DefaultClusterReplicas).owner("orders"); owner != "" {
+		t.Errorf("owner() on an empty ring = %q, want none", owner)
+	}
+	single := newRing(clusterInstances[:1], DefaultClusterReplicas)
+	for _, topic := range topics(100) {
+		if owner := single.owner(topic); owner != clusterInstances[0] {
+			t.Fatalf("owner(%q) on a single instance = %q", topic, owner)
+		}
+	}
+
+	r := newRing(clusterInstances, DefaultClusterReplicas)
+	again := newRing([]string{clusterInstances[2], clusterInstances[0], clusterInstances[1]}, DefaultClusterReplicas)
+	shares := make(map[string]int)
+	for _, topic := range topics(3000) {
+		owner := r.owner(topic)
+		if owner != again.owner(topic) {
+			t.Fatalf("owner(%q) depends on the order of the instances", topic)
+		}
+		shares[owner]++
+	}
+	for _, instance := range clusterInstances {
+		if shares[instance] < 700 {
+			t.Errorf("%s owns %d of 3000 topics, want a third", instance, shares[instance])
+		}
+	}
+	if len(shares) != len(clusterInstances) {
+		t.Errorf("owners %v, want the instances only", shares)
+	}
+}
+
+func TestRingStability(t *testing.T) {
+	added := "ws://10.0.0.4:8080"
+	tests := []struct {
+		name          string
+		before, after []string
+		// moved reports whether a topic may move between two owners.
+		moved func(before, after string) bool
+	}{
+		{
+			"instance added",
+			clusterInstances,
+			append(append([]string(nil), clusterInstances...), added),
+			func(before, after string) bool { return after == added },
+		},
+		{
+			"instance removed",
+			clusterInstances,
+			clusterInstances[:2],
+			func(before, after string) bool { return before == clusterInstances[2] },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := newRing(tt.before, DefaultClusterReplicas)
+			after := newRing(tt.after, DefaultClusterReplicas)
+			moves := 0
+			for _, topic := range topics(3000) {
+				from, to := before.owner(topic), after.owner(topic)
+				if from == to {
+					continue
+				}
+				moves++
+				if !tt.moved(from, to) {
+					t.Fatalf("%q moved from %s to %s", topic, from, to)
+				}
+			}
+			if moves == 0 || moves > 1300 {
+				t.Errorf("%d of 3000 topics moved, want about a third or a quarter", moves)
+			}
+		})
+	}
+}
+
+func TestCheckCluster(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster *Cluster
+		err     bool
+	}{
+		{"none", nil, false},
+		{"valid", &Cluster{Self: clusterInstances[1], Instances: clusterInstances, Secret: "s"}, false},
+		{"no secret", &Cluster{Self: clusterInstances[0], Instances: clusterInstances}, true},
+		{"negative replicas", &Cluster{Self: clusterInstances[0], Instances: clusterInstances, Secret: "s", Replicas: -1}, true},
+		{"http instance", &Cluster{Self: "http://10.0.0.1:8080", Instances: []string{"http://10.0.0.1:8080"}, Secret: "s"}, true},
+		{"no host", &Cluster{Self: "ws://", Instances: []string{"ws://"}, Secret: "s"}, true},
+		{"invalid URL", &Cluster{Self: "ws://%zz", Instances: []string{"ws://%zz"}, Secret: "s"}, true},
+		{"self not an instance", &Cluster{Self: "ws://10.0.0.9:8080", Instances: clusterInstances, Secret: "s"}, true},
+		{"no instances", &Cluster{Self: clusterInstances[0], Secret: "s"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Socketeer{Cluster: tt.cluster}
+			err := s.checkCluster()
+			if tt.err {
+				if !errors.Is(err, ErrInvalidCluster) {
+					t.Errorf("checkCluster() = %v, want ErrInvalidCluster", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.cluster == nil {
+				if !s.owns("orders") || s.Owner("orders") != "" {
+					t.Errorf("a socketeer without cluster doesn't own every topic")
+				}
+				return
+			}
+			for _, topic := range topics(100) {
+				if s.owns(topic) != (s.Owner(topic) == tt.cluster.Self) {
+					t.Fatalf("owns(%q) disagrees with Owner() = %q", topic, s.Owner(topic))
+				}
+			}
+		})
+	}
+}
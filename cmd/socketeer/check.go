@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/darthsalad/socketeer"
+	"github.com/darthsalad/socketeer/internal/config"
+)
+
+// check validates a configuration file and pings the database
+// without starting any listener, it prints a report of the checks
+// and fails when any of them failed.
+//
+// # Parameters:
+//
+// 	- args ([]string): the flags of the command.
+//
+// # Example:
+//
+// 	socketeer check -config socketeer.json -json
+func check(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	configPath := flags.String("config", "socketeer.json", "configuration file")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	report := socketeer.ValidationReport{OK: true}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		report.Add("config", err)
+	} else {
+		errs := cfg.Validate()
+		for _, err := range errs {
+			report.Add("config", err)
+		}
+		if len(errs) == 0 {
+			report.Add("config", nil)
+			s, err := socketeer.NewSocketeer(cfg.URI, cfg.Database, cfg.CollectionNames()...)
+			if err != nil {
+				report.Add("source", err)
+			} else {
+				err = configure(s, cfg)
+				report.Add("parsing", err)
+				r := s.Validate()
+				report.Checks = append(report.Checks, r.Checks...)
+				report.OK = report.OK && r.OK
+				s.DB.Disconnect()
+			}
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, c := range report.Checks {
+			status := "ok"
+			if !c.OK {
+				status = "FAIL"
+			}
+			fmt.Printf("%-4s  %-14s  %s\n", status, c.Name, c.Error)
+		}
+	}
+
+	if !report.OK {
+		return errors.New("check: invalid configuration")
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/darthsalad/socketeer/internal/config"
+)
+
+// identifier matches the keys usable as unquoted TypeScript property names.
+var identifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// tsCollection is a collection as seen by the TypeScript template.
+//
+// 	- Topic is the name of the collection, used as the topic.
+// 	- Type is the name of the generated interface.
+// 	- Fields are the property names of the interface, quoted when needed.
+type tsCollection struct {
+	Topic  string
+	Type   string
+	Fields []string
+}
+
+// tsTemplate renders the TypeScript types and browser client.
+var tsTemplate = template.Must(template.New("ts").Parse(`// Code generated by "socketeer gen-ts"; DO NOT EDIT.
+{{range .}}
+export interface {{.Type}} {
+{{- range .Fields}}
+  {{.}}?: string;
+{{- end}}
+}
+{{end}}
+export interface Events {
+{{- range .}}
+  {{printf "%q" .Topic}}: {{.Type}};
+{{- end}}
+}
+
+export type Topic = keyof Events;
+
+type Handler<T extends Topic> = (data: Events[T]) => void;
+
+export class SocketeerClient {
+  private conn: WebSocket;
+  private handlers: { [T in Topic]?: Handler<T>[] } = {};
+
+  constructor(url: string) {
+    this.conn = new WebSocket(url, ["socketeer.v2"]);
+    this.conn.onopen = () => {
+      for (const topic of Object.keys(this.handlers)) {
+        this.subscribe(topic as Topic);
+      }
+    };
+    this.conn.onmessage = (e: MessageEvent) => this.dispatch(JSON.parse(e.data));
+  }
+
+  on<T extends Topic>(topic: T, handler: Handler<T>): void {
+    const handlers = (this.handlers[topic] ?? []) as Handler<T>[];
+    if (handlers.length === 0 && this.conn.readyState === WebSocket.OPEN) {
+      this.subscribe(topic);
+    }
+    handlers.push(handler);
+    this.handlers[topic] = handlers as never;
+  }
+
+  close(): void {
+    this.conn.close();
+  }
+
+  private subscribe(topic: Topic): void {
+    this.conn.send(JSON.stringify({ type: "subscribe", topic }));
+  }
+
+  private dispatch(msg: { type: string; topic: Topic; data: Record<string, string> }): void {
+    if (msg.type !== "event") {
+      return;
+    }
+    for (const handler of (this.handlers[msg.topic] ?? []) as Handler<Topic>[]) {
+      handler(msg.data as never);
+    }
+  }
+}
+`))
+
+// genTS generates TypeScript interfaces for the configured
+// collections plus a thin browser client with typed handlers.
+//
+// # Parameters:
+//
+// 	- args ([]string): the flags of the command.
+//
+// # Example:
+//
+// 	socketeer gen-ts -config socketeer.json -out src/socketeer.ts
+func genTS(args []string) error {
+	flags := flag.NewFlagSet("gen-ts", flag.ContinueOnError)
+	configPath := flags.String("config", "socketeer.json", "configuration or schema file")
+	outPath := flags.String("out", "", "output file, stdout when empty")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return writeTS(out, cfg.Collections)
+}
+
+// writeTS renders the TypeScript code for the collections to out.
+func writeTS(out io.Writer, collections []config.Collection) error {
+	data := make([]tsCollection, 0, len(collections))
+	for _, coll := range collections {
+		fields := make([]string, 0, len(coll.Keys))
+		for _, key := range coll.Keys {
+			if !identifier.MatchString(key) {
+				key = strconv.Quote(key)
+			}
+			fields = append(fields, key)
+		}
+
+		data = append(data, tsCollection{
+			Topic:  coll.Name,
+			Type:   typeName(coll.Name),
+			Fields: fields,
+		})
+	}
+
+	return tsTemplate.Execute(out, data)
+}
+
+// typeName turns a collection name into a TypeScript type name,
+// example: user_profiles becomes UserProfiles.
+func typeName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	if b.Len() == 0 || (b.String()[0] >= '0' && b.String()[0] <= '9') {
+		return "Collection" + b.String()
+	}
+
+	return b.String()
+}
@@ -0,0 +1,73 @@
+// Command socketeer is the command line tool of the socketeer package.
+//
+// # Usage:
+//
+// 	socketeer serve -config socketeer.json
+// 	socketeer serve -config socketeer.json -since 2024-01-01T00:00:00Z
+// 	socketeer check -config socketeer.json
+// 	socketeer gen-ts -config socketeer.json -out socketeer.ts
+// 	socketeer tap -url ws://localhost:8080/admin/tap -token $ADMIN_TOKEN
+// 	socketeer version
+//
+// # Commands:
+//
+// 	- serve: runs a socketeer from a configuration file.
+// 	- check: validates a configuration file and pings the database
+// 		without starting any listener.
+// 	- gen-ts: generates TypeScript types and a browser client from the
+// 		collections and keys of a configuration or schema file.
+// 	- tap: writes every message dispatched by a running socketeer
+// 		with its recipients, one JSON object per line.
+// 	- version: prints the build information.
+//
+// The build information is set with ldflags, it is served on /version:
+//
+// 	go build -ldflags "-X github.com/darthsalad/socketeer.Commit=$(git rev-parse HEAD) \
+// 		-X github.com/darthsalad/socketeer.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+// 		./cmd/socketeer
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commands are the subcommands of the tool by name.
+var commands = map[string]func(args []string) error{
+	"serve":   serve,
+	"check":   check,
+	"gen-ts":  genTS,
+	"tap":     tap,
+	"version": version,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	err := cmd(os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the list of subcommands.
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: socketeer <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  serve     run a socketeer from a configuration file")
+	fmt.Fprintln(os.Stderr, "  check     validate a configuration file without serving")
+	fmt.Fprintln(os.Stderr, "  gen-ts    generate TypeScript types and client")
+	fmt.Fprintln(os.Stderr, "  tap       print the messages dispatched by a running socketeer")
+	fmt.Fprintln(os.Stderr, "  version   print the build information")
+}
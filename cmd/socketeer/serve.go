@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+	"github.com/darthsalad/socketeer/archive"
+	"github.com/darthsalad/socketeer/capped"
+	"github.com/darthsalad/socketeer/clickhouse"
+	"github.com/darthsalad/socketeer/cockroach"
+	"github.com/darthsalad/socketeer/elasticsearch"
+	"github.com/darthsalad/socketeer/internal/config"
+	"github.com/darthsalad/socketeer/internal/logger"
+	"github.com/darthsalad/socketeer/jsonl"
+	"github.com/darthsalad/socketeer/lease"
+	"github.com/darthsalad/socketeer/outbox"
+	"github.com/darthsalad/socketeer/parquet"
+	"github.com/darthsalad/socketeer/replay"
+	"github.com/darthsalad/socketeer/resume"
+	"github.com/darthsalad/socketeer/webhook"
+)
+
+// serve runs a socketeer from a configuration file until
+// it is interrupted.
+//
+// The configured collections are watched, each with its own change
+// stream, or the outbox or capped collection when one is configured. With -replay,
+// a recording made with -record is replayed instead and the
+// command returns once it is over. With -since, the change stream,
+// or the replay, starts at an operation time in the past, given as
+// RFC 3339 or as a Unix timestamp.
+//
+// The server accepts the connections on the socket passed by systemd,
+// when started by a socket unit, and listens on the host otherwise.
+// On SIGUSR2, the binary is started again with the socket and the
+// current process drains its clients and exits, for a zero-downtime
+// upgrade.
+//
+// # Parameters:
+//
+// 	- args ([]string): the flags of the command.
+//
+// # Example:
+//
+// 	socketeer serve -config socketeer.json
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configPath := flags.String("config", "socketeer.json", "configuration file")
+	logFormat := flags.String("log-format", "", "format of the logs, text or json (overrides the configuration)")
+	logLevel := flags.String("log-level", "", "minimal level of the logs, debug, info, warn, error or silent (overrides the configuration)")
+	quiet := flags.Bool("quiet", false, "disable the logs")
+	tapPath := flags.String("tap", "", "file every dispatched message is appended to, - for stdout")
+	recordPath := flags.String("record", "", "file the events of the change stream are recorded to")
+	replayPath := flags.String("replay", "", "recording replayed instead of watching the database")
+	speed := flags.Float64("speed", 1, "speed factor of the replay, 0 to replay without waiting")
+	sinceFlag := flags.String("since", "", "operation time the change stream starts at, RFC 3339 or Unix timestamp")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var since time.Time
+	if *sinceFlag != "" {
+		since, err = parseSince(*sinceFlag)
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Collections) == 0 {
+		return errors.New("serve: no collection configured")
+	}
+	coll := cfg.Collections[0]
+
+	var s *socketeer.Socketeer
+	var crdb *cockroach.Source
+	if *replayPath != "" {
+		src, err := replay.Open(*replayPath, *speed)
+		if err != nil {
+			return err
+		}
+		s = socketeer.NewSocketeerWithSource(src)
+	} else if cfg.Outbox != nil {
+		src, err := outbox.Connect(cfg.URI, cfg.Database, cfg.Outbox.Collection)
+		if err != nil {
+			return err
+		}
+		if cfg.Outbox.Mode != "" {
+			src.Mode = cfg.Outbox.Mode
+		}
+		src.MarkField = cfg.Outbox.MarkField
+		s = socketeer.NewSocketeerWithSource(src)
+	} else if cfg.Capped != nil {
+		src, err := capped.Connect(cfg.URI, cfg.Database, cfg.Capped.Collection)
+		if err != nil {
+			return err
+		}
+		src.FromStart = cfg.Capped.FromStart
+		s = socketeer.NewSocketeerWithSource(src)
+	} else if cfg.Cockroach != nil {
+		crdb = cockroach.New(cfg.Cockroach.Secret)
+		crdb.Tables = cfg.Cockroach.Tables
+		crdb.Addr = cfg.Cockroach.Addr
+		crdb.CertFile = cfg.Cockroach.CertFile
+		crdb.KeyFile = cfg.Cockroach.KeyFile
+		s = socketeer.NewSocketeerWithSource(crdb)
+	} else {
+		s, err = socketeer.NewSocketeer(cfg.URI, cfg.Database, cfg.CollectionNames()...)
+		if err != nil {
+			return err
+		}
+	}
+	err = configure(s, cfg)
+	if err != nil {
+		return err
+	}
+	if crdb != nil && crdb.Addr == "" {
+		path := cfg.Cockroach.Path
+		if path == "" {
+			path = "/crdb"
+		}
+		s.Handlers = map[string]http.Handler{path: crdb}
+	}
+	if f := cfg.Failover; f != nil && *replayPath == "" {
+		collName, name := f.Collection, f.Name
+		if collName == "" {
+			collName = "leases"
+		}
+		if name == "" {
+			name = coll.Name
+		}
+		leases, err := lease.Connect(cfg.URI, cfg.Database, collName, name)
+		if err != nil {
+			return err
+		}
+		defer leases.Close()
+
+		s.Failover = &socketeer.Failover{
+			Leases:     leases,
+			Region:     f.Region,
+			TTL:        time.Duration(f.TTLMS) * time.Millisecond,
+			PrimaryURL: f.PrimaryURL,
+			FailAfter:  f.FailAfter,
+		}
+	}
+	if r := cfg.Resume; r != nil && *replayPath == "" {
+		switch r.Type {
+		case "file":
+			s.Resume = resume.NewFile(r.Path)
+		case "mongo":
+			collName, name := r.Collection, r.Name
+			if collName == "" {
+				collName = "resumeTokens"
+			}
+			if name == "" {
+				name = coll.Name
+			}
+			tokens, err := resume.Connect(cfg.URI, cfg.Database, collName, name)
+			if err != nil {
+				return err
+			}
+			defer tokens.Close()
+			s.Resume = tokens
+		case "redis":
+			key := r.Key
+			if key == "" {
+				key = "socketeer:" + coll.Name
+			}
+			tokens := resume.NewRedis(r.Addr, key)
+			tokens.Password = r.Password
+			tokens.DB = r.DB
+			defer tokens.Close()
+			s.Resume = tokens
+		}
+	}
+	s.Since = since
+	if *logFormat != "" {
+		s.LogFormat = *logFormat
+	}
+	if *logLevel != "" {
+		s.LogLevel = *logLevel
+	}
+	if *quiet {
+		s.LogLevel = socketeer.LogSilent
+	}
+	if s.LogLevel != "" && !logger.ValidLevel(s.LogLevel) {
+		return fmt.Errorf("serve: unknown log level %q", s.LogLevel)
+	}
+
+	if *tapPath != "" {
+		out, closeOut, err := openOut(*tapPath)
+		if err != nil {
+			return err
+		}
+		defer closeOut()
+
+		untap := s.Tap(out)
+		defer untap()
+	}
+
+	if *recordPath != "" {
+		f, err := os.Create(*recordPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		stop := s.Record(f)
+		defer stop()
+	}
+
+	listeners, err := socketeer.InheritedListeners()
+	if err != nil {
+		return err
+	}
+	if len(listeners) > 0 {
+		s.Listener = listeners[0]
+	} else {
+		s.Listener, err = net.Listen("tcp", cfg.Host)
+		if err != nil {
+			return err
+		}
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Start(coll.Keys, cfg.Host, cfg.Endpoint)
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	upgradeCh := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgradeCh, upgradeSignals...)
+	}
+	err = await(errCh, sigCh, upgradeCh, s.Listener)
+
+	ctx := context.Background()
+	if cfg.ShutdownTimeoutMS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.ShutdownTimeoutMS)*time.Millisecond)
+		defer cancel()
+	}
+	stopErr := s.StopContext(ctx)
+	if err == nil && stopErr != nil {
+		err = fmt.Errorf("serve: shutdown: %w", stopErr)
+	}
+
+	return err
+}
+
+// parseSince parses the value of the -since flag, an RFC 3339 time
+// or a Unix timestamp in seconds.
+//
+// # Parameters:
+//
+// 	- value (string): the value of the flag.
+//
+// # Example:
+//
+// 	since, err := parseSince("2024-01-01T00:00:00Z")
+func parseSince(value string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("serve: -since %q is neither RFC 3339 nor a Unix timestamp", value)
+	}
+
+	return t, nil
+}
+
+// configure applies the settings of a configuration file to a socketeer,
+// it fails when a transformer or the template of a sink can't be parsed.
+//
+// # Parameters:
+//
+// 	- s (*socketeer.Socketeer): the socketeer to configure.
+// 	- cfg (*config.Config): the configuration.
+//
+// # Example:
+//
+// 	err := configure(s, cfg)
+func configure(s *socketeer.Socketeer, cfg *config.Config) error {
+	s.LogFormat = cfg.LogFormat
+	s.LogLevel = cfg.LogLevel
+	s.AdminToken = cfg.AdminToken
+	s.TLSCertFile = cfg.TLSCertFile
+	s.TLSKeyFile = cfg.TLSKeyFile
+	s.Keys = make(map[string][]string, len(cfg.Collections))
+	for _, coll := range cfg.Collections {
+		s.Keys[coll.Name] = coll.Keys
+	}
+	s.BatchSize = cfg.BatchSize
+	s.MaxAwaitTime = time.Duration(cfg.MaxAwaitTimeMS) * time.Millisecond
+	s.ShowExpandedEvents = cfg.ShowExpandedEvents
+	s.FollowRename = cfg.FollowRename
+	s.ReopenOnDrop = cfg.ReopenOnDrop
+	s.UpdateLookup = cfg.UpdateLookup
+	s.ReconnectBackoff = time.Duration(cfg.ReconnectBackoffMS) * time.Millisecond
+	s.ReconnectMaxBackoff = time.Duration(cfg.ReconnectMaxMS) * time.Millisecond
+	s.MaxReconnects = cfg.MaxReconnects
+	s.RawChanges = cfg.RawChanges
+	s.IncludeDocumentKey = cfg.IncludeDocumentKey
+	s.IncludeFullDocument = cfg.IncludeFullDocument
+	s.MergePatch = cfg.MergePatch
+	s.AllowCIDRs = cfg.AllowCIDRs
+	s.DenyCIDRs = cfg.DenyCIDRs
+	s.TrustedProxies = cfg.TrustedProxies
+	s.CompressThreshold = cfg.CompressThreshold
+	s.BinaryFrames = cfg.BinaryFrames
+	s.FilterPushdown = cfg.FilterPushdown
+	s.DocumentIDs = cfg.DocumentIDs
+	s.SnapshotInterval = time.Duration(cfg.SnapshotIntervalMS) * time.Millisecond
+	s.ClientHeartbeat = time.Duration(cfg.ClientHeartbeatMS) * time.Millisecond
+	s.PingInterval = time.Duration(cfg.PingIntervalMS) * time.Millisecond
+	s.MaxBatch = cfg.MaxBatch
+	s.MaintenanceBuffer = cfg.MaintenanceBuffer
+	s.MaxSubscriptions = cfg.MaxSubscriptions
+	if cfg.Cluster != nil {
+		s.Cluster = (*socketeer.Cluster)(cfg.Cluster)
+	}
+	if len(cfg.Views) > 0 {
+		s.Views = make(map[string]socketeer.View, len(cfg.Views))
+		for coll, view := range cfg.Views {
+			s.Views[coll] = view
+		}
+	}
+	if cfg.Match != nil {
+		s.Match = (*socketeer.Match)(cfg.Match)
+	}
+	pipeline, err := cfg.Pipeline.Stages()
+	if err != nil {
+		return err
+	}
+	s.Pipeline = pipeline
+	if cfg.Collation != nil {
+		s.Collation = cfg.Collation.Options()
+	}
+	s.Templates = cfg.Templates
+	s.Aliases = cfg.Aliases
+	if len(cfg.Transforms) > 0 {
+		s.Transforms = make(map[string]socketeer.Transformer, len(cfg.Transforms))
+		for field, spec := range cfg.Transforms {
+			t, err := socketeer.ParseTransformer(spec)
+			if err != nil {
+				return fmt.Errorf("transform of %q: %w", field, err)
+			}
+			s.Transforms[field] = t
+		}
+	}
+	if len(cfg.Throttles) > 0 {
+		s.Throttles = make(map[string]socketeer.Throttle, len(cfg.Throttles))
+		for topic, t := range cfg.Throttles {
+			s.Throttles[topic] = socketeer.Throttle(t)
+		}
+	}
+	for _, sink := range cfg.Sinks {
+		if sink.Type == "jsonl" {
+			trail := jsonl.New(sink.Name, sink.Path)
+			trail.MaxSize = sink.MaxSize
+			trail.MaxAge = time.Duration(sink.MaxAgeMS) * time.Millisecond
+			trail.MaxBackups = sink.MaxBackups
+			s.Sinks = append(s.Sinks, trail)
+			continue
+		}
+		if sink.Type == "s3" || sink.Type == "gcs" {
+			store := archive.NewS3(sink.Bucket, sink.Region, sink.AccessKey, sink.SecretKey)
+			if sink.Type == "gcs" {
+				store = archive.NewGCS(sink.Bucket, sink.AccessKey, sink.SecretKey)
+			}
+			if sink.Endpoint != "" {
+				store.Endpoint = sink.Endpoint
+			}
+			cold := archive.New(sink.Name, store)
+			cold.Prefix = sink.Prefix
+			cold.Window = time.Duration(sink.WindowMS) * time.Millisecond
+			cold.MaxEvents = sink.MaxEvents
+			if sink.Format == "parquet" {
+				cold.Format = parquet.Format{Columns: sink.Columns}
+			}
+			s.Sinks = append(s.Sinks, cold)
+			continue
+		}
+		if sink.Type == "clickhouse" {
+			events := clickhouse.New(sink.Name, sink.URL, sink.Table)
+			if len(sink.Mapping) > 0 {
+				events.Columns = sink.Mapping
+			}
+			events.User = sink.User
+			events.Password = sink.Password
+			events.MaxRows = sink.MaxEvents
+			events.Interval = time.Duration(sink.WindowMS) * time.Millisecond
+			s.Sinks = append(s.Sinks, events)
+			continue
+		}
+		if sink.Type == "elasticsearch" {
+			search := elasticsearch.New(sink.Name, sink.URL, sink.Index)
+			search.Username = sink.User
+			search.Password = sink.Password
+			search.APIKey = sink.APIKey
+			search.MaxActions = sink.MaxEvents
+			search.Interval = time.Duration(sink.WindowMS) * time.Millisecond
+			s.Sinks = append(s.Sinks, search)
+			continue
+		}
+		hook := webhook.New(sink.Name, sink.URL)
+		hook.Headers = sink.Headers
+		hook.ContentType = sink.ContentType
+		if sink.Template != "" {
+			t, err := socketeer.ParseTemplate(sink.Name, sink.Template)
+			if err != nil {
+				return fmt.Errorf("sink %q: %w", sink.Name, err)
+			}
+			hook.Template = t
+		}
+		s.Sinks = append(s.Sinks, hook)
+	}
+	if cfg.Flow != nil {
+		s.Flow = &socketeer.Flow{Sinks: cfg.Flow.Sinks}
+		for _, stage := range cfg.Flow.Stages {
+			s.Flow.Stages = append(s.Flow.Stages, socketeer.FlowStage(stage))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/gorilla/websocket"
+)
+
+// tap connects to the admin tap of a running socketeer and writes
+// every dispatched message with its recipients to stdout or a file,
+// one JSON object per line, until it is interrupted.
+//
+// # Parameters:
+//
+// 	- args ([]string): the flags of the command.
+//
+// # Example:
+//
+// 	socketeer tap -url ws://localhost:8080/admin/tap -token $ADMIN_TOKEN -topic posts
+func tap(args []string) error {
+	flags := flag.NewFlagSet("tap", flag.ContinueOnError)
+	rawURL := flags.String("url", "ws://localhost:8080/admin/tap", "url of the admin tap")
+	token := flags.String("token", os.Getenv("SOCKETEER_ADMIN_TOKEN"), "admin token, defaults to $SOCKETEER_ADMIN_TOKEN")
+	outPath := flags.String("out", "-", "file the records are appended to, - for stdout")
+	topic := flags.String("topic", "", "only write the records of this topic")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	out, closeOut, err := openOut(*outPath)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+*token)
+	conn, _, err := websocket.DefaultDialer.Dial(*rawURL, header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		<-sigCh
+		conn.Close()
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if *topic != "" {
+			var record struct {
+				Topic string `json:"topic"`
+			}
+			if json.Unmarshal(msg, &record) != nil || record.Topic != *topic {
+				continue
+			}
+		}
+
+		_, err = out.Write(msg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// openOut opens the output of a command, stdout for "-"
+// or a file the output is appended to.
+func openOut(path string) (io.Writer, func() error, error) {
+	if path == "-" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return f, f.Close, nil
+}
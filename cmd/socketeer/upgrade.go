@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+)
+
+// filer is implemented by the listeners backed by a file descriptor,
+// like the TCP and Unix listeners.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// handoff starts the binary of the command again, with the same
+// arguments, passing it the listener of the server with the socket
+// activation protocol, so that the new binary accepts the connections
+// on the same socket while the current one drains its clients.
+//
+// # Parameters:
+//
+// 	- l (net.Listener): the listener of the server.
+//
+// # Example:
+//
+// 	err := handoff(s.Listener)
+func handoff(l net.Listener) error {
+	fl, ok := l.(filer)
+	if !ok {
+		return errors.New("serve: the listener has no file descriptor")
+	}
+	f, err := fl.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(), "LISTEN_FDS=1")
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("serve: starting %s: %w", exe, err)
+	}
+
+	return cmd.Process.Release()
+}
+
+// await waits for the socketeer to return, for a stop signal or for an
+// upgrade signal followed by a successful handoff, a failed handoff
+// being printed and the socketeer kept serving.
+//
+// # Parameters:
+//
+// 	- errCh (<-chan error): receives the error returned by Start().
+// 	- sigCh (<-chan os.Signal): receives the stop signals.
+// 	- upgradeCh (<-chan os.Signal): receives the upgrade signals.
+// 	- l (net.Listener): the listener of the server.
+//
+// # Example:
+//
+// 	err = await(errCh, sigCh, upgradeCh, s.Listener)
+func await(errCh <-chan error, sigCh <-chan os.Signal, upgradeCh <-chan os.Signal, l net.Listener) error {
+	for {
+		select {
+		case err := <-errCh:
+			return err
+		case <-sigCh:
+			return nil
+		case <-upgradeCh:
+			err := handoff(l)
+			if err == nil {
+				return nil
+			}
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// upgradeSignals are the signals starting a zero-downtime upgrade,
+// none on the platforms without SIGUSR2.
+var upgradeSignals []os.Signal
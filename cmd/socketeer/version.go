@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// version prints the build information of the binary.
+//
+// # Example:
+//
+// 	socketeer version
+func version(args []string) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(socketeer.BuildInfo{
+		Version:   socketeer.Version,
+		Commit:    socketeer.Commit,
+		BuildDate: socketeer.BuildDate,
+	})
+}
@@ -0,0 +1,278 @@
+// Package cockroach provides a ChangeSource receiving the changefeeds
+// of CockroachDB through their webhook sink, so that the applications
+// backed by CockroachDB get the same realtime fan-out as the MongoDB
+// ones, without Kafka in between.
+//
+// The Source is an HTTP handler, served on its own Addr or mounted on
+// the server of the socketeer with its Handlers, the changefeed posts
+// its rows to it:
+//
+// 	CREATE CHANGEFEED FOR TABLE orders, customers
+// 		INTO 'webhook-https://socketeer:9443/crdb'
+// 		WITH updated, diff, resolved = '10s',
+// 			webhook_auth_header = 'Bearer s3cr3t';
+//
+// Every row is dispatched on the topic of its table, with its columns
+// as fields and its primary key as document key. With the diff option
+// the inserts and the updates are told apart, without it every upsert
+// is a replace. The resolved timestamps are the heartbeats of the
+// Source, the webhook sink only posts HTTPS, see CertFile.
+//
+// # Usage:
+//
+// 	src := cockroach.New("Bearer " + os.Getenv("CRDB_WEBHOOK_SECRET"))
+// 	src.Tables = []string{"orders", "customers"}
+// 	s := socketeer.NewSocketeerWithSource(src)
+// 	s.TLSCertFile, s.TLSKeyFile = "cert.pem", "key.pem"
+// 	s.Handlers = map[string]http.Handler{"/crdb": src}
+// 	s.Start([]string{"status", "total"}, "0.0.0.0:9443", "/listen")
+package cockroach
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// ErrNotListening is the failure of the requests received before
+// Listen() or after Disconnect(), answered with 503 so that the
+// changefeed retries them.
+var ErrNotListening = errors.New("cockroach: source not listening")
+
+// row is a row of the payload of a changefeed request, with the
+// wrapped envelope.
+//
+// 	- Topic is the table of the row.
+// 	- Key is the primary key of the row.
+// 	- After is the row after the change, null for a delete.
+// 	- Before is the row before the change, with the diff option.
+// 	- Updated is the MVCC timestamp of the change, with the updated
+// 		option.
+type row struct {
+	Topic   string          `json:"topic"`
+	Key     []any           `json:"key"`
+	After   map[string]any  `json:"after"`
+	Before  json.RawMessage `json:"before"`
+	Updated string          `json:"updated"`
+}
+
+// request is the body of a changefeed request, a batch of rows or
+// a resolved timestamp.
+//
+// 	- Payload are the rows.
+// 	- Resolved is the resolved timestamp.
+type request struct {
+	Payload  []row  `json:"payload"`
+	Resolved string `json:"resolved"`
+}
+
+// Source is a socketeer.ChangeSource dispatching the rows posted by
+// the webhook sink of CockroachDB changefeeds. A request is answered
+// once its rows are handled, so that the changefeed retries the
+// batches which weren't: the delivery is at least once.
+//
+// 	- Secret is the value of the Authorization header of the requests,
+// 		the webhook_auth_header option of the changefeed, no check when
+// 		empty.
+// 	- Tables are the tables of the changefeed, the topics of the Source.
+// 	- Addr is the address the Source serves on itself, when it isn't
+// 		mounted on the server of the socketeer.
+// 	- CertFile and KeyFile are the certificate of the server on Addr,
+// 		which serves plain HTTP without them.
+// 	- mux serializes the handling of the requests.
+// 	- handle is the function called for every row, set by Listen().
+// 	- err is the failure of handle which stopped the Source.
+// 	- ctx is cancelled when the Source is stopped.
+// 	- cancel cancels ctx.
+// 	- heartbeat is called for every request, set with OnHeartbeat().
+type Source struct {
+	Secret    string
+	Tables    []string
+	Addr      string
+	CertFile  string
+	KeyFile   string
+	mux       sync.Mutex
+	handle    func(socketeer.Event) error
+	err       error
+	ctx       context.Context
+	cancel    context.CancelFunc
+	heartbeat func()
+}
+
+// New returns a new Source checking the Authorization header of the
+// requests against secret.
+//
+// # Parameters:
+//
+// 	- secret (string): the value of the webhook_auth_header option.
+//
+// # Example:
+//
+// 	src := cockroach.New("Bearer s3cr3t")
+func New(secret string) *Source {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Source{Secret: secret, ctx: ctx, cancel: cancel}
+}
+
+// Listen dispatches the rows of the requests until the Source is
+// disconnected, serving on Addr when it is set.
+//
+// # Parameters:
+//
+// 	- handle (func(socketeer.Event) error): the function called for every
+// 		row, the Source stops and returns the error if it fails.
+//
+// # Example:
+//
+// 	err := src.Listen(s.process)
+func (s *Source) Listen(handle func(socketeer.Event) error) error {
+	s.mux.Lock()
+	s.handle = handle
+	s.mux.Unlock()
+
+	if s.Addr != "" {
+		server := &http.Server{Addr: s.Addr, Handler: s}
+		go func() {
+			<-s.ctx.Done()
+			server.Close()
+		}()
+		var err error
+		if s.CertFile != "" {
+			err = server.ListenAndServeTLS(s.CertFile, s.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			s.cancel()
+			return err
+		}
+	}
+	<-s.ctx.Done()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.handle = nil
+
+	return s.err
+}
+
+// ServeHTTP handles a request of the changefeed: it dispatches its
+// rows, in order, and answers 200 once they are all handled, 401 for
+// a wrong secret, 400 for a malformed body, and 500 when the handling
+// fails, which stops the Source.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request of the changefeed.
+//
+// # Example:
+//
+// 	s.Handlers = map[string]http.Handler{"/crdb": src}
+func (s *Source) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if s.Secret != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(s.Secret)) != 1 {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body request
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.handle == nil || s.ctx.Err() != nil {
+		http.Error(res, ErrNotListening.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	s.beat()
+	for _, r := range body.Payload {
+		err = s.handle(event(r))
+		if err != nil {
+			s.err = err
+			s.cancel()
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// event returns the event of a row.
+func event(r row) socketeer.Event {
+	ev := socketeer.Event{Collection: r.Topic, Fields: r.After}
+	switch {
+	case r.After == nil:
+		ev.OperationType = "delete"
+		ev.Fields = map[string]any{}
+	case r.Before == nil:
+		ev.OperationType = "replace"
+	case string(r.Before) == "null":
+		ev.OperationType = "insert"
+	default:
+		ev.OperationType = "update"
+	}
+
+	var id any = r.Key
+	if len(r.Key) == 1 {
+		id = r.Key[0]
+	}
+	ev.DocumentKey = map[string]any{"_id": id}
+
+	return ev
+}
+
+// Collections returns the Tables, the topics of the events of the Source.
+//
+// # Example:
+//
+// 	topics := src.Collections()
+func (s *Source) Collections() []string {
+	return s.Tables
+}
+
+// OnHeartbeat sets the function called for every request of the
+// changefeed, the resolved timestamps included, it has to be called
+// before Listen().
+//
+// # Parameters:
+//
+// 	- heartbeat (func()): the function to call.
+//
+// # Example:
+//
+// 	src.OnHeartbeat(func() { last.Store(time.Now().UnixNano()) })
+func (s *Source) OnHeartbeat(heartbeat func()) {
+	s.heartbeat = heartbeat
+}
+
+// beat calls the heartbeat function, if any.
+func (s *Source) beat() {
+	if s.heartbeat != nil {
+		s.heartbeat()
+	}
+}
+
+// Disconnect stops the Source, the following requests are answered
+// with 503.
+//
+// # Example:
+//
+// 	src.Disconnect()
+func (s *Source) Disconnect() error {
+	s.cancel()
+
+	return nil
+}
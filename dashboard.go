@@ -0,0 +1,44 @@
+package socketeer
+
+import (
+	"embed"
+	"net/http"
+)
+
+// DashboardPath is the path the monitoring dashboard is served on.
+const DashboardPath = "/admin/"
+
+// dashboard holds the static assets of the monitoring dashboard.
+//
+//go:embed dashboard/index.html
+var dashboard embed.FS
+
+// serveDashboard serves the monitoring dashboard, a single page showing
+// the connected clients, the throughput of every topic, the recent events
+// and the error rate, fed by the admin stream. The AdminToken is passed
+// as the "token" query parameter, example: /admin/?token=secret
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(DashboardPath, http.HandlerFunc(s.serveDashboard))
+func (s *Socketeer) serveDashboard(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	page, err := dashboard.ReadFile("dashboard/index.html")
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "no-store")
+	res.Write(page)
+}
@@ -0,0 +1,197 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/metrics"
+)
+
+// AdminRedrivePath is the path the dead letters are re-driven on,
+// with a POST behind the AdminToken.
+const AdminRedrivePath = "/admin/redrive"
+
+// ErrCorruptDeadLetter is wrapped by the error a DeadLetterQueue
+// returns with the dead letters it read, when it skipped some it can't
+// decode, like a line truncated by a crash.
+var ErrCorruptDeadLetter = errors.New("socketeer: corrupt dead letter")
+
+// DeadLetter is a message a sink failed to deliver.
+//
+// 	- ID identifies the dead letter in its queue, set by Peek().
+// 	- Sink is the name of the sink.
+// 	- Message is the undelivered message.
+// 	- Error is the last failure.
+// 	- Attempts is the number of attempts made.
+// 	- Time is the time the message was dead lettered at.
+type DeadLetter struct {
+	ID       string    `json:"-"`
+	Sink     string    `json:"sink"`
+	Message  Message   `json:"message"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	Time     time.Time `json:"time"`
+}
+
+// DeadLetterQueue keeps the messages the sinks failed to deliver until
+// they are re-driven, the dlq package provides a file and a MongoDB
+// implementation.
+//
+// 	- Put adds a dead letter to the queue.
+// 	- Peek returns every dead letter of the queue, oldest first, without
+// 		removing them. The ones it can't decode are skipped, and reported
+// 		with an error wrapping ErrCorruptDeadLetter along with the others.
+// 	- Remove removes the dead letter of an ID from the queue.
+type DeadLetterQueue interface {
+	Put(dl DeadLetter) error
+	Peek() ([]DeadLetter, error)
+	Remove(id string) error
+}
+
+// RedriveResult is the result of Redrive().
+//
+// 	- Delivered is the number of dead letters delivered.
+// 	- Failed is the number of dead letters which failed again,
+// 		they are back in the queue.
+type RedriveResult struct {
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+}
+
+// deadLetter writes an undelivered message to the DeadLetterQueue,
+// the message is reported as lost when there is none or it fails.
+//
+// # Parameters:
+//
+// 	- sink (Sink): the sink which failed.
+// 	- msg (Message): the undelivered message.
+// 	- attempts (int): the number of attempts made.
+// 	- err (error): the last failure.
+//
+// # Example:
+//
+// 	s.deadLetter(sink, msg, attempts, err)
+func (s *Socketeer) deadLetter(sink Sink, msg Message, attempts int, err error) {
+	ctx := map[string]any{"component": "sink", "sink": sink.Name(), "seq": msg.Seq}
+	if s.DeadLetters == nil {
+		s.log.Error("message lost", "sink", sink.Name(), "seq", msg.Seq, "error", err)
+		s.report(err, ctx)
+		return
+	}
+
+	dl := DeadLetter{
+		Sink:     sink.Name(),
+		Message:  msg,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Time:     time.Now(),
+	}
+	putErr := s.DeadLetters.Put(dl)
+	if putErr != nil {
+		s.log.Error("message lost", "sink", sink.Name(), "seq", msg.Seq, "error", putErr)
+		s.report(putErr, ctx)
+		return
+	}
+	s.log.Warn("message dead lettered", "sink", sink.Name(), "seq", msg.Seq, "error", err)
+	s.Metrics.Count(metrics.DeadLetters, 1, map[string]string{metrics.TagSink: sink.Name()})
+}
+
+// Redrive delivers the dead letters again to their sink, the ones
+// failing again are put back in the queue, and the ones whose sink is
+// gone are left in it. A dead letter is only removed from the queue
+// once it is delivered or put back, so that a failure or a crash in
+// the middle of a redrive loses none, the ones delivered before being
+// delivered again at worst. The dead letters the queue can't decode
+// are left in it and reported.
+//
+// # Example:
+//
+// 	res, err := s.Redrive()
+func (s *Socketeer) Redrive() (RedriveResult, error) {
+	var res RedriveResult
+	if s.DeadLetters == nil {
+		return res, ErrUnsupported
+	}
+
+	letters, err := s.DeadLetters.Peek()
+	if errors.Is(err, ErrCorruptDeadLetter) {
+		s.log.Warn("corrupt dead letters skipped", "error", err)
+		s.report(err, map[string]any{"component": "sink"})
+	} else if err != nil {
+		return res, err
+	}
+
+	sinks := make(map[string]Sink, len(s.Sinks))
+	for _, sink := range s.Sinks {
+		sinks[sink.Name()] = sink
+	}
+	for _, dl := range letters {
+		sink, ok := sinks[dl.Sink]
+		if !ok {
+			res.Failed++
+			continue
+		}
+		attempts, err := s.deliverTo(sink, dl.Message)
+		if err != nil {
+			// The updated dead letter is put back before the old one
+			// is removed.
+			dl.Attempts += attempts
+			dl.Error = err.Error()
+			dl.Time = time.Now()
+			putErr := s.DeadLetters.Put(dl)
+			if putErr != nil {
+				return res, putErr
+			}
+		}
+		removeErr := s.DeadLetters.Remove(dl.ID)
+		if removeErr != nil {
+			return res, removeErr
+		}
+		if err != nil {
+			res.Failed++
+		} else {
+			res.Delivered++
+		}
+	}
+	s.log.Info("dead letters re-driven", "delivered", res.Delivered, "failed", res.Failed)
+
+	return res, nil
+}
+
+// serveRedrive re-drives the dead letters on POST and responds
+// with the RedriveResult.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(AdminRedrivePath, http.HandlerFunc(s.serveRedrive))
+func (s *Socketeer) serveRedrive(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", "POST")
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := s.Redrive()
+	if err == ErrUnsupported {
+		http.Error(res, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(result)
+}
@@ -0,0 +1,355 @@
+// Package dlq provides the dead letter queues of the socketeer,
+// keeping the messages its sinks failed to deliver until they
+// are re-driven with the Redrive() method of the socketeer.
+//
+// # Usage:
+//
+// 	s.DeadLetters = dlq.NewFile("dead-letters.jsonl")
+//
+// or, to share the queue between the servers:
+//
+// 	s.DeadLetters = dlq.NewMongo(client.Database("mydb").Collection("dead_letters"))
+package dlq
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// File is a dead letter queue appending the dead letters to a file,
+// one JSON object per line.
+//
+// 	- path is the path of the file.
+// 	- mux is a mutex for the file for thread safety.
+type File struct {
+	path string
+	mux  sync.Mutex
+}
+
+// NewFile returns a new File queue writing to the file at path,
+// which is created on the first dead letter.
+//
+// # Parameters:
+//
+// 	- path (string): the path of the file.
+//
+// # Example:
+//
+// 	s.DeadLetters = dlq.NewFile("dead-letters.jsonl")
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Put appends a dead letter to the file.
+//
+// # Parameters:
+//
+// 	- dl (socketeer.DeadLetter): the dead letter.
+//
+// # Example:
+//
+// 	err := q.Put(dl)
+func (f *File) Put(dl socketeer.DeadLetter) error {
+	line, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	// A line left unterminated by a crash is ended first, so that it
+	// doesn't corrupt this one.
+	info, err := file.Stat()
+	if err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		_, err = file.ReadAt(last, info.Size()-1)
+		if err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Peek reads every dead letter of the file, identified by the SHA-256
+// of their line. The lines it can't decode, like one truncated by a
+// crash, are skipped and left in the file.
+//
+// # Example:
+//
+// 	letters, err := q.Peek()
+func (f *File) Peek() ([]socketeer.DeadLetter, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	lines, err := f.lines()
+	if err != nil {
+		return nil, err
+	}
+
+	var letters []socketeer.DeadLetter
+	var corrupt []int
+	var corruptErr error
+	for i, line := range lines {
+		var dl socketeer.DeadLetter
+		err = json.Unmarshal(line, &dl)
+		if err != nil {
+			if corruptErr == nil {
+				corruptErr = err
+			}
+			corrupt = append(corrupt, i+1)
+			continue
+		}
+		dl.ID = lineID(line)
+		letters = append(letters, dl)
+	}
+	if corrupt != nil {
+		return letters, fmt.Errorf("dlq: lines %v of %s skipped: %w: %w", corrupt, f.path, socketeer.ErrCorruptDeadLetter, corruptErr)
+	}
+
+	return letters, nil
+}
+
+// Remove removes the line of a dead letter from the file, rewriting it
+// to a temporary file renamed over it.
+//
+// # Parameters:
+//
+// 	- id (string): the ID of the dead letter, set by Peek().
+//
+// # Example:
+//
+// 	err := q.Remove(dl.ID)
+func (f *File) Remove(id string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	lines, err := f.lines()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	removed := false
+	for _, line := range lines {
+		if !removed && lineID(line) == id {
+			removed = true
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if !removed {
+		return nil
+	}
+
+	tmp := f.path + ".tmp"
+	err = os.WriteFile(tmp, buf.Bytes(), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f.path)
+}
+
+// lines returns the non-empty lines of the file, none when it doesn't
+// exist.
+func (f *File) lines() ([][]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lines [][]byte
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, nil
+}
+
+// lineID returns the ID of the dead letter of a line, the hexadecimal
+// SHA-256 of the line.
+func lineID(line []byte) string {
+	sum := sha256.Sum256(line)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// DefaultTimeout bounds every operation of a Mongo queue by default.
+const DefaultTimeout = 10 * time.Second
+
+// Mongo is a dead letter queue storing the dead letters in a
+// MongoDB collection, one document per dead letter.
+//
+// 	- coll is the collection.
+// 	- Timeout bounds every operation on the collection, defaults to
+// 		DefaultTimeout.
+type Mongo struct {
+	coll    *mongo.Collection
+	Timeout time.Duration
+}
+
+// NewMongo returns a new Mongo queue storing the dead letters in coll.
+//
+// # Parameters:
+//
+// 	- coll (*mongo.Collection): the collection.
+//
+// # Example:
+//
+// 	s.DeadLetters = dlq.NewMongo(client.Database("mydb").Collection("dead_letters"))
+func NewMongo(coll *mongo.Collection) *Mongo {
+	return &Mongo{coll: coll}
+}
+
+// record is a dead letter as stored in the collection, the
+// message is kept as JSON so that it is read back unchanged.
+//
+// 	- ID is the _id of the document.
+// 	- Sink is the name of the sink, for queries.
+// 	- Error is the last failure, for queries.
+// 	- DeadLetter is the dead letter as JSON.
+type record struct {
+	ID         any    `bson:"_id,omitempty"`
+	Sink       string `bson:"sink"`
+	Error      string `bson:"error"`
+	DeadLetter string `bson:"deadLetter"`
+}
+
+// Put inserts a dead letter in the collection.
+//
+// # Parameters:
+//
+// 	- dl (socketeer.DeadLetter): the dead letter.
+//
+// # Example:
+//
+// 	err := q.Put(dl)
+func (m *Mongo) Put(dl socketeer.DeadLetter) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := m.context()
+	defer cancel()
+	_, err = m.coll.InsertOne(ctx, record{
+		Sink:       dl.Sink,
+		Error:      dl.Error,
+		DeadLetter: string(data),
+	})
+
+	return err
+}
+
+// Peek reads every dead letter of the collection, identified by the
+// hexadecimal ObjectID of their document, oldest first. The documents
+// it can't decode are skipped and left in the collection.
+//
+// # Example:
+//
+// 	letters, err := q.Peek()
+func (m *Mongo) Peek() ([]socketeer.DeadLetter, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+	cursor, err := m.coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]socketeer.DeadLetter, 0, len(records))
+	var corrupt []any
+	var corruptErr error
+	for _, r := range records {
+		var dl socketeer.DeadLetter
+		err = json.Unmarshal([]byte(r.DeadLetter), &dl)
+		id, ok := r.ID.(primitive.ObjectID)
+		if err == nil && !ok {
+			err = fmt.Errorf("dlq: _id %v not an ObjectID", r.ID)
+		}
+		if err != nil {
+			if corruptErr == nil {
+				corruptErr = err
+			}
+			corrupt = append(corrupt, r.ID)
+			continue
+		}
+		dl.ID = id.Hex()
+		letters = append(letters, dl)
+	}
+	if corrupt != nil {
+		return letters, fmt.Errorf("dlq: documents %v skipped: %w: %w", corrupt, socketeer.ErrCorruptDeadLetter, corruptErr)
+	}
+
+	return letters, nil
+}
+
+// Remove deletes the document of a dead letter from the collection.
+//
+// # Parameters:
+//
+// 	- id (string): the ID of the dead letter, set by Peek().
+//
+// # Example:
+//
+// 	err := q.Remove(dl.ID)
+func (m *Mongo) Remove(id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := m.context()
+	defer cancel()
+	_, err = m.coll.DeleteOne(ctx, bson.M{"_id": oid})
+
+	return err
+}
+
+// context returns the context of an operation on the collection,
+// bounded by the Timeout.
+func (m *Mongo) context() (context.Context, context.CancelFunc) {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return context.WithTimeout(context.Background(), timeout)
+}
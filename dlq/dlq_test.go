@@ -0,0 +1,80 @@
+package dlq
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/darthsalad/socketeer"
+)
+
+func TestFilePeekRemove(t *testing.T) {
+	q := NewFile(filepath.Join(t.TempDir(), "dead-letters.jsonl"))
+
+	letters, err := q.Peek()
+	if err != nil || len(letters) != 0 {
+		t.Fatalf("Peek() of a missing file = %v, %v, want none", letters, err)
+	}
+	for _, seq := range []uint64{1, 2, 3} {
+		err = q.Put(socketeer.DeadLetter{Sink: "audit", Message: socketeer.Message{Seq: seq}, Attempts: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	letters, err = q.Peek()
+	if err != nil || len(letters) != 3 {
+		t.Fatalf("Peek() = %d letters, %v, want 3", len(letters), err)
+	}
+
+	err = q.Remove(letters[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Remove("unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	letters, err = q.Peek()
+	if err != nil || len(letters) != 2 || letters[0].Message.Seq != 1 || letters[1].Message.Seq != 3 {
+		t.Errorf("Peek() after Remove() = %v, %v, want the letters 1 and 3", letters, err)
+	}
+}
+
+func TestFileCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	q := NewFile(path)
+	err := q.Put(socketeer.DeadLetter{Sink: "audit", Message: socketeer.Message{Seq: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A line truncated by a crash in the middle of an append.
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"sink":"audit","mess`)
+	file.Close()
+	err = q.Put(socketeer.DeadLetter{Sink: "audit", Message: socketeer.Message{Seq: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	letters, err := q.Peek()
+	if !errors.Is(err, socketeer.ErrCorruptDeadLetter) {
+		t.Errorf("Peek() error = %v, want ErrCorruptDeadLetter", err)
+	}
+	if len(letters) != 2 || letters[0].Message.Seq != 1 || letters[1].Message.Seq != 2 {
+		t.Fatalf("Peek() = %v, want the letters 1 and 2", letters)
+	}
+	for _, dl := range letters {
+		err = q.Remove(dl.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != `{"sink":"audit","mess`+"\n" {
+		t.Errorf("file left = %q, %v, want the corrupt line", data, err)
+	}
+}
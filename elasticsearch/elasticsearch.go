@@ -0,0 +1,453 @@
+// Package elasticsearch provides a sink of the socketeer keeping a
+// search index of Elasticsearch or OpenSearch in sync with the watched
+// collections: the inserted and replaced documents are indexed, the
+// updated ones are updated and the deleted ones are deleted, in
+// batches through the Bulk API.
+//
+// # Usage:
+//
+// 	search := elasticsearch.New("search", "https://search:9200", "{topic}")
+// 	search.APIKey = os.Getenv("ELASTIC_API_KEY")
+// 	s.Sinks = []socketeer.Sink{search}
+//
+// The documents are identified by the _id of their document key, their
+// source is their full document when the socketeer includes it, their
+// selected keys otherwise, with the values formatted like the data of
+// the messages.
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// Defaults of a Sink.
+//
+// 	- DefaultMaxActions is the number of actions from which a batch is
+// 		sent when MaxActions is not set.
+// 	- DefaultInterval is the time from which a batch is sent when
+// 		Interval is not set.
+// 	- DefaultTimeout bounds every request when Client is nil, and the
+// 		batches which aren't bound by the context of a delivery.
+const (
+	DefaultMaxActions = 1000
+	DefaultInterval   = time.Second
+	DefaultTimeout    = 30 * time.Second
+)
+
+// StatusError is the failure of a bulk request answered with a status
+// other than 2xx.
+//
+// 	- Status is the status code of the response.
+// 	- Body is the beginning of the body of the response.
+type StatusError struct {
+	Status int
+	Body   string
+}
+
+// Error returns the description of the failure.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("elasticsearch: status %d: %s", e.Status, e.Body)
+}
+
+// BulkError is the failure of some actions of a bulk request, which
+// a retry won't fix, like a document rejected by the mapping of its
+// index. The batch is dropped.
+//
+// 	- Failures are the reasons of the failures by document _id.
+type BulkError struct {
+	Failures map[string]string
+}
+
+// Error returns the description of the failure.
+func (e *BulkError) Error() string {
+	for id, reason := range e.Failures {
+		return fmt.Sprintf("elasticsearch: %d actions failed, %s: %s", len(e.Failures), id, reason)
+	}
+
+	return "elasticsearch: actions failed"
+}
+
+// bulkResponse is the part of the response of a bulk request read
+// by a Sink.
+//
+// 	- Errors reports whether some actions failed.
+// 	- Items are the results of the actions, by type of action.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// Sink is a socketeer.Sink applying the changes of the documents to
+// a search index, in batches of MaxActions actions or of Interval.
+// The actions are idempotent: the failed batches are kept and sent
+// again with the next ones.
+//
+// 	- name is the name of the sink.
+// 	- URL is the URL of the cluster.
+// 	- Index is the index of the documents, "{topic}" is replaced with
+// 		the topic of the message.
+// 	- Username and Password authenticate the requests with basic auth.
+// 	- APIKey authenticates the requests with an API key instead.
+// 	- Client sends the requests, a client with DefaultTimeout when nil.
+// 	- MaxActions is the number of actions from which a batch is sent,
+// 		defaults to DefaultMaxActions.
+// 	- Interval is the time from which a batch is sent, defaults to
+// 		DefaultInterval.
+// 	- mux guards the batch for thread safety.
+// 	- actions are the NDJSON lines of the actions of the open batch.
+// 	- count is the number of actions of the open batch.
+// 	- opened is when the open batch was opened.
+// 	- timer seals the open batch at the end of its interval.
+// 	- last is the sequence number of the last batched message, so that
+// 		the retries of its delivery don't batch it twice.
+// 	- pending are the sealed batches waiting to be sent.
+type Sink struct {
+	name       string
+	URL        string
+	Index      string
+	Username   string
+	Password   string
+	APIKey     string
+	Client     *http.Client
+	MaxActions int
+	Interval   time.Duration
+	mux        sync.Mutex
+	actions    bytes.Buffer
+	count      int
+	opened     time.Time
+	timer      *time.Timer
+	last       uint64
+	pending    [][]byte
+}
+
+// New returns a new Sink indexing into index.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the sink.
+// 	- url (string): the URL of the cluster.
+// 	- index (string): the index, "{topic}" is replaced with the topic.
+//
+// # Example:
+//
+// 	search := elasticsearch.New("search", "http://localhost:9200", "orders")
+func New(name string, url string, index string) *Sink {
+	return &Sink{name: name, URL: url, Index: index}
+}
+
+// Name returns the name of the sink.
+func (s *Sink) Name() string {
+	return s.name
+}
+
+// Deliver adds the action of a message to the open batch, then sends
+// the sealed batches. The messages without action, like the changes
+// of the collections themselves or the documents without _id, are
+// skipped. A failed request fails the delivery while the action stays
+// batched: the retries of the delivery only retry the request.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the requests.
+// 	- msg (socketeer.Message): the message.
+//
+// # Example:
+//
+// 	err := search.Deliver(ctx, msg)
+func (s *Sink) Deliver(ctx context.Context, msg socketeer.Message) error {
+	action, err := s.action(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if action != nil && (msg.Seq == 0 || msg.Seq != s.last) {
+		if s.count > 0 && time.Since(s.opened) >= s.interval() {
+			s.seal()
+		}
+		if s.count == 0 {
+			s.open()
+		}
+		s.actions.Write(action)
+		s.count++
+		s.last = msg.Seq
+		if s.count >= s.maxActions() {
+			s.seal()
+		}
+	}
+
+	return s.flush(ctx)
+}
+
+// Close sends the open batch and every sealed one, it fails when some
+// could not be sent within DefaultTimeout.
+//
+// # Example:
+//
+// 	err := search.Close()
+func (s *Sink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.count > 0 {
+		s.seal()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	return s.flush(ctx)
+}
+
+// RetryPolicy retries the failed requests for a few minutes, except
+// the BulkError and the 4xx statuses other than 408 and 429.
+//
+// # Example:
+//
+// 	policy := search.RetryPolicy()
+func (s *Sink) RetryPolicy() socketeer.RetryPolicy {
+	return socketeer.RetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		Jitter:      0.2,
+		Retryable: func(err error) bool {
+			var bulk *BulkError
+			if errors.As(err, &bulk) {
+				return false
+			}
+			var status *StatusError
+			if !errors.As(err, &status) {
+				return true
+			}
+			return status.Status >= 500 || status.Status == http.StatusRequestTimeout || status.Status == http.StatusTooManyRequests
+		},
+	}
+}
+
+// maxActions returns the MaxActions with its default.
+func (s *Sink) maxActions() int {
+	if s.MaxActions > 0 {
+		return s.MaxActions
+	}
+
+	return DefaultMaxActions
+}
+
+// interval returns the Interval with its default.
+func (s *Sink) interval() time.Duration {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+
+	return DefaultInterval
+}
+
+// action returns the NDJSON lines of the bulk action of a message, nil
+// for a message without action: an index of the inserted and replaced
+// documents, an update of the updated ones, upserted unless the full
+// document is known, and a delete of the deleted ones.
+//
+// # Parameters:
+//
+// 	- msg (socketeer.Message): the message.
+//
+// # Example:
+//
+// 	action, err := s.action(msg)
+func (s *Sink) action(msg socketeer.Message) ([]byte, error) {
+	id := msg.DocumentKey["_id"]
+	if id == "" {
+		return nil, nil
+	}
+	source := msg.FullDocument
+	if len(source) == 0 {
+		source = msg.Data
+	}
+	if source == nil {
+		source = map[string]string{}
+	}
+
+	var kind string
+	var body any
+	switch msg.OperationType {
+	case "insert", "replace":
+		kind, body = "index", source
+	case "update":
+		kind, body = "index", source
+		if len(msg.FullDocument) == 0 {
+			kind, body = "update", map[string]any{"doc": source, "doc_as_upsert": true}
+		}
+	case "delete":
+		kind = "delete"
+	default:
+		return nil, nil
+	}
+
+	meta := map[string]map[string]string{
+		kind: {"_index": strings.ReplaceAll(s.Index, "{topic}", msg.Topic), "_id": id},
+	}
+	line, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	line = append(line, '\n')
+	if body == nil {
+		return line, nil
+	}
+	doc, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append(line, doc...), '\n'), nil
+}
+
+// open opens a new batch, sealed by its timer at the end of its interval.
+func (s *Sink) open() {
+	s.opened = time.Now()
+	opened := s.opened
+	s.timer = time.AfterFunc(s.interval(), func() {
+		s.expire(opened)
+	})
+}
+
+// seal closes the open batch and queues it to be sent.
+func (s *Sink) seal() {
+	s.timer.Stop()
+	s.pending = append(s.pending, append([]byte(nil), s.actions.Bytes()...))
+	s.actions.Reset()
+	s.count = 0
+}
+
+// expire seals the batch opened at the given time once its interval
+// is over and sends the sealed batches, a failure is left to the next
+// delivery.
+//
+// # Parameters:
+//
+// 	- opened (time.Time): when the batch was opened, a batch sealed
+// 		meanwhile is left alone.
+//
+// # Example:
+//
+// 	time.AfterFunc(s.interval(), func() { s.expire(opened) })
+func (s *Sink) expire(opened time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.count == 0 || !s.opened.Equal(opened) {
+		return
+	}
+	s.seal()
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	s.flush(ctx)
+}
+
+// flush sends the sealed batches in order, it stops at the first
+// failure, the failed batch and the following ones are kept, but a
+// batch failing with a BulkError is dropped.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the requests.
+//
+// # Example:
+//
+// 	err := s.flush(ctx)
+func (s *Sink) flush(ctx context.Context) error {
+	for len(s.pending) > 0 {
+		err := s.bulk(ctx, s.pending[0])
+		var bulk *BulkError
+		if err != nil && !errors.As(err, &bulk) {
+			return err
+		}
+		s.pending = s.pending[1:]
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bulk sends a batch to the Bulk API. It fails with a StatusError
+// unless the response status is 2xx, or when some actions failed with
+// a status worth a retry, with a BulkError when the other failed.
+// A delete of a missing document succeeds.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the request.
+// 	- actions ([]byte): the NDJSON lines of the actions.
+//
+// # Example:
+//
+// 	err := s.bulk(ctx, s.pending[0])
+func (s *Sink) bulk(ctx context.Context, actions []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.URL, "/")+"/_bulk", bytes.NewReader(actions))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.APIKey)
+	} else if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return &StatusError{Status: res.StatusCode, Body: string(detail)}
+	}
+
+	var result bulkResponse
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil || !result.Errors {
+		return err
+	}
+	failures := make(map[string]string)
+	for _, item := range result.Items {
+		for kind, r := range item {
+			if r.Status < 300 || (kind == "delete" && r.Status == http.StatusNotFound) {
+				continue
+			}
+			if r.Status >= 500 || r.Status == http.StatusTooManyRequests {
+				return &StatusError{Status: r.Status, Body: string(r.Error)}
+			}
+			failures[r.ID] = string(r.Error)
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return &BulkError{Failures: failures}
+}
@@ -0,0 +1,53 @@
+package socketeer
+
+import "github.com/darthsalad/socketeer/internal/ws"
+
+// Message types of the frames returned by an Encoder.
+const (
+	TextMessage   = ws.TextMessage
+	BinaryMessage = ws.BinaryMessage
+)
+
+// Encoder encodes the messages sent to the websocket clients in a
+// bespoke wire format or envelope shape, instead of the JSON of their
+// protocol version: the data of the only key for the first version,
+// the envelope for the second one.
+//
+// Encode returns the data of the frame of a message and its type,
+// TextMessage or BinaryMessage, the clients asking for binary frames
+// get a binary frame whatever the type. The message is sent as is,
+// without the zstd compression, and the hello and heartbeat messages
+// of the second protocol version stay JSON. Encode returns
+// ErrDefaultEncoding for the messages it leaves to the JSON.
+//
+// # Example:
+//
+// 	type csv struct{}
+//
+// 	func (csv) Encode(msg socketeer.Message) ([]byte, int, error) {
+// 		return []byte(msg.Topic + "," + msg.Data["title"]), socketeer.TextMessage, nil
+// 	}
+//
+// 	s.Encoder = csv{}
+type Encoder interface {
+	Encode(msg Message) (data []byte, messageType int, err error)
+}
+
+// ErrDefaultEncoding is returned by an Encoder for the messages sent
+// in the JSON of the protocol version of the clients.
+var ErrDefaultEncoding = ws.ErrDefaultEncoding
+
+// EncoderFunc is a function used as an Encoder.
+//
+// # Example:
+//
+// 	s.Encoder = socketeer.EncoderFunc(func(msg socketeer.Message) ([]byte, int, error) {
+// 		data, err := avroEncoder.Encode(msg)
+// 		return data, socketeer.BinaryMessage, err
+// 	})
+type EncoderFunc func(msg Message) ([]byte, int, error)
+
+// Encode calls the function.
+func (f EncoderFunc) Encode(msg Message) ([]byte, int, error) {
+	return f(msg)
+}
@@ -0,0 +1,114 @@
+package socketeer
+
+import (
+	"github.com/darthsalad/socketeer/internal/db"
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// Event is a change that happened in a watched collection,
+// it is produced by a ChangeSource and fed into the dispatch
+// pipeline which selects the configured keys and broadcasts
+// them to the websocket clients.
+//
+// 	- OperationType is the type of operation, example: "insert", "update".
+// 	- Collection is the name of the collection the change happened in.
+// 	- ClusterTime is the cluster time of the change, zero when unknown.
+// 	- Fields are the fields carried by the change, the updated fields
+// 		for an update, the full document for an insert and the
+// 		description of the operation for a schema operation.
+type Event = event.Event
+
+// ChangeSource is the interface implemented by everything
+// that can feed change events into the socketeer.
+//
+// The MongoDB change stream listener (internal/db.go) is the default
+// implementation, the sourcetest package provides an in-memory one.
+//
+// 	- Listen blocks and calls handle for every change until the
+// 		source is disconnected or fails.
+// 	- Disconnect stops the source and releases its resources.
+type ChangeSource interface {
+	Listen(handle func(Event) error) error
+	Disconnect() error
+}
+
+// Operation types of the schema operations, they are dispatched
+// when ShowExpandedEvents is enabled, with the description of the
+// operation as data.
+//
+// 	- OpCreateIndexes is the creation of indexes.
+// 	- OpDropIndexes is the removal of indexes.
+// 	- OpModify is a change of the options of the collection.
+// 	- OpShardCollection is the sharding of the collection.
+const (
+	OpCreateIndexes   = event.OpCreateIndexes
+	OpDropIndexes     = event.OpDropIndexes
+	OpModify          = event.OpModify
+	OpShardCollection = event.OpShardCollection
+)
+
+// OpRename is the operation type of the rename of a watched collection,
+// it is dispatched on the topic of the former name with the "from" and
+// "to" namespaces as data, example: {"from": "mydb.posts", "to": "mydb.articles"}.
+// See the FollowRename field of Socketeer.
+const OpRename = event.OpRename
+
+// Operation types of the end of a watched collection, they are
+// dispatched on its topic so that the clients know its changes stopped.
+//
+// 	- OpDrop is the drop of the collection, or of its database, with
+// 		its namespace as data, example: {"ns": "mydb.posts"}.
+// 	- OpInvalidate is the end of the change stream which follows, with
+// 		"reopened" as data, "true" when the collection is watched again,
+// 		see the ReopenOnDrop field of Socketeer.
+const (
+	OpDrop       = event.OpDrop
+	OpInvalidate = event.OpInvalidate
+)
+
+// ErrInvalidated is returned by Start() when the watched collection is
+// dropped without ReopenOnDrop.
+var ErrInvalidated = db.ErrInvalidated
+
+// OpReplace is the operation type of the replacement of a document,
+// by ReplaceOne() or a full-document save, it is dispatched like an
+// insert, with the selected keys of the new document as data.
+const OpReplace = event.OpReplace
+
+// OpDelete is the operation type of the deletion of a document, it is
+// dispatched with the document key of the deleted document and no data,
+// so that the clients remove it.
+const OpDelete = event.OpDelete
+
+// OpSnapshot is the operation type of the snapshots of the current
+// documents of a collection, see Snapshot().
+const OpSnapshot = event.OpSnapshot
+
+// Message is the update dispatched to clients for an event,
+// it is encoded according to the protocol version of every client.
+//
+// 	- Seq is the position of the message in the stream of the socketeer.
+// 	- Topic is the topic of the message, which is the collection name.
+// 	- OperationType is the type of operation of the event.
+// 	- ClusterTime is the cluster time of the event.
+// 	- Time is the wall-clock time the message was dispatched at.
+// 	- Data are the selected keys of the event.
+type Message = event.Message
+
+// Timestamp is a MongoDB cluster time, the seconds since the epoch
+// and an increment ordering the operations within a second.
+type Timestamp = event.Timestamp
+
+// Broadcaster is the interface implemented by everything
+// the socketeer can dispatch the selected keys of events with.
+//
+// The WebSocket server (internal/ws.go) is the default implementation.
+//
+// 	- Start blocks and serves clients on the host and endpoint.
+// 	- Stop stops serving and disconnects every client.
+// 	- Dispatch sends a message to every client interested in its topic.
+type Broadcaster interface {
+	Start(host string, endpoint string)
+	Stop()
+	Dispatch(msg Message)
+}
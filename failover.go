@@ -0,0 +1,331 @@
+package socketeer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Defaults of the Failover settings.
+const (
+	DefaultLeaseTTL  = 10 * time.Second
+	DefaultFailAfter = 3
+)
+
+// Errors of the failover.
+//
+// 	- ErrInvalidFailover is returned by Start() and Validate() for a
+// 		Failover without Leases or Region, or with a negative setting.
+// 	- ErrLeaseLost is returned by Start() when the primary lost its
+// 		lease to a standby, or couldn't renew it in time: it stopped
+// 		watching and has to be restarted, as a standby.
+// 	- ErrStandby is returned by Ready() while the instance waits for
+// 		the lease, so that the load balancers send the clients to the
+// 		primary.
+var (
+	ErrInvalidFailover = errors.New("socketeer: invalid failover")
+	ErrLeaseLost       = errors.New("socketeer: failover lease lost")
+	ErrStandby         = errors.New("socketeer: standby, the lease is held by the primary")
+)
+
+// errStopped is returned by acquireLease() when the socketeer is
+// stopped while it waits for the lease.
+var errStopped = errors.New("socketeer: stopped")
+
+// Lease is the lease of the primary of a failover, with the checkpoint
+// of the changes it dispatched.
+//
+// 	- Holder is the Region of the primary.
+// 	- Expires is when the lease expires without renewal, by the clock
+// 		of the store, informative only.
+// 	- ClusterTime is the cluster time of the last change dispatched by
+// 		the primary, zero before the first one.
+// 	- Seq is the sequence number of the last message dispatched then.
+type Lease struct {
+	Holder      string    `json:"holder"`
+	Expires     time.Time `json:"expires"`
+	ClusterTime Timestamp `json:"clusterTime"`
+	Seq         uint64    `json:"seq"`
+}
+
+// LeaseStore keeps the lease of a failover, shared by the regions. The
+// lease package provides a MongoDB store.
+//
+// 	- Acquire takes the lease for holder when it is free, expired or
+// 		already held by holder, or whatever its state with steal, and
+// 		returns it. It reports false, with the current lease, when it
+// 		is held by another holder.
+// 	- Renew extends the lease held by holder by ttl and records the
+// 		checkpoint, a ttl of 0 releasing it. It reports false when the
+// 		lease isn't held by holder anymore.
+type LeaseStore interface {
+	Acquire(holder string, ttl time.Duration, steal bool) (Lease, bool, error)
+	Renew(holder string, ttl time.Duration, clusterTime Timestamp, seq uint64) (bool, error)
+}
+
+// resumer is implemented by the change sources which can resume right
+// after the change of a cluster time, like the default DB.
+type resumer interface {
+	ResumeAt(ts Timestamp)
+}
+
+// Failover runs the socketeer as the primary or the standby of an
+// active/passive pair, in two regions sharing the database. The
+// instance holding the lease is the primary: it watches the change
+// stream, renews the lease while it is ready and records the
+// checkpoint of the changes it dispatched. The standby serves its
+// clients but doesn't watch, until the lease expires or the probes of
+// the primary fail: it then takes the lease over, and resumes the
+// change stream after the checkpoint with the sequence numbers of the
+// primary, so that the clients redirected to it resume their sessions
+// from their cursor, given a shared SessionSecret and Sessions.
+//
+// 	- Leases is the store of the lease, shared by the regions.
+// 	- Region is the name of the instance, the holder of the lease.
+// 	- TTL is how long the lease is held without renewal, defaults to
+// 		DefaultLeaseTTL. It is renewed, and acquired by the standby,
+// 		every third of it.
+// 	- PrimaryURL is the readiness probe of the primary, optional: the
+// 		standby then takes the lease over after FailAfter failed probes,
+// 		without waiting for it to expire.
+// 	- FailAfter is the number of consecutive failed probes of the
+// 		primary, defaults to DefaultFailAfter.
+// 	- Client is the HTTP client of the probes, http.DefaultClient
+// 		when nil.
+//
+// # Example:
+//
+// 	s.Failover = &socketeer.Failover{
+// 		Leases:     lease.NewMongo(client.Database("mydb").Collection("leases"), "orders"),
+// 		Region:     "eu-west-1",
+// 		PrimaryURL: "https://socketeer.us-east-1.example.com/readyz",
+// 	}
+type Failover struct {
+	Leases     LeaseStore
+	Region     string
+	TTL        time.Duration
+	PrimaryURL string
+	FailAfter  int
+	Client     *http.Client
+}
+
+// checkFailover checks the Failover.
+func (s *Socketeer) checkFailover() error {
+	f := s.Failover
+	if f == nil {
+		return nil
+	}
+	if f.Leases == nil {
+		return fmt.Errorf("%w: no lease store", ErrInvalidFailover)
+	}
+	if f.Region == "" {
+		return fmt.Errorf("%w: no region", ErrInvalidFailover)
+	}
+	if f.TTL < 0 || f.FailAfter < 0 {
+		return fmt.Errorf("%w: negative ttl or failAfter", ErrInvalidFailover)
+	}
+
+	return nil
+}
+
+// leaseTTL returns the TTL of the Failover or its default.
+func (s *Socketeer) leaseTTL() time.Duration {
+	if s.Failover.TTL > 0 {
+		return s.Failover.TTL
+	}
+
+	return DefaultLeaseTTL
+}
+
+// acquireLease waits for the lease of the Failover as a standby: it
+// tries to acquire it every third of the TTL, and probes the primary
+// meanwhile, stealing the lease after FailAfter failed probes. It
+// returns the lease and the local time of the request which acquired
+// it, which the lease expires a TTL after at the latest.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Example:
+//
+// 	lease, acquired, err := s.acquireLease()
+func (s *Socketeer) acquireLease() (Lease, time.Time, error) {
+	f := s.Failover
+	failAfter := f.FailAfter
+	if failAfter == 0 {
+		failAfter = DefaultFailAfter
+	}
+	ticker := time.NewTicker(s.leaseTTL() / 3)
+	defer ticker.Stop()
+
+	failed := 0
+	for {
+		steal := f.PrimaryURL != "" && failed >= failAfter
+		acquired := time.Now()
+		lease, ok, err := f.Leases.Acquire(f.Region, s.leaseTTL(), steal)
+		if err != nil {
+			s.log.Warn("acquiring the lease failed", "error", err)
+			s.report(err, map[string]any{"component": "failover"})
+		} else if ok {
+			s.standby.Store(false)
+			s.log.Info("lease acquired, watching as primary", "region", f.Region, "stolen", steal, "seq", lease.Seq)
+			return lease, acquired, nil
+		} else {
+			if !s.standby.Swap(true) {
+				s.log.Info("standing by", "region", f.Region, "primary", lease.Holder)
+			}
+			if f.PrimaryURL != "" {
+				if s.probePrimary() {
+					failed = 0
+				} else {
+					failed++
+					s.log.Warn("primary probe failed", "primary", lease.Holder, "failed", failed)
+				}
+			}
+		}
+
+		select {
+		case <-s.done:
+			return Lease{}, time.Time{}, errStopped
+		case <-ticker.C:
+		}
+	}
+}
+
+// probePrimary reports whether the readiness probe of the primary
+// succeeds within a third of the TTL.
+func (s *Socketeer) probePrimary() bool {
+	client := s.Failover.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, s.Failover.PrimaryURL, nil)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.leaseTTL()/3)
+	defer cancel()
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+
+	return res.StatusCode < 300
+}
+
+// resumeFrom resumes the change source and the sequence numbers from
+// the checkpoint of a lease, the change source resuming to the second
+// when it can't resume right after the change.
+//
+// # Parameters:
+//
+// 	- lease (Lease): the acquired lease.
+//
+// # Example:
+//
+// 	s.resumeFrom(lease)
+func (s *Socketeer) resumeFrom(lease Lease) {
+	if lease.Seq > s.seq.Load() {
+		s.seq.Store(lease.Seq)
+	}
+	s.checkpointMux.Lock()
+	s.checkpoint = lease
+	s.checkpointMux.Unlock()
+	if lease.ClusterTime == (Timestamp{}) {
+		return
+	}
+	if r, ok := s.DB.(resumer); ok {
+		r.ResumeAt(lease.ClusterTime)
+	} else if st, ok := s.DB.(starter); ok {
+		st.StartAt(time.Unix(int64(lease.ClusterTime.T), 0))
+	}
+}
+
+// mark records the checkpoint of an event handled by the primary.
+func (s *Socketeer) mark(ev Event) {
+	if ev.ClusterTime == (Timestamp{}) {
+		return
+	}
+	s.checkpointMux.Lock()
+	s.checkpoint.ClusterTime = ev.ClusterTime
+	s.checkpoint.Seq = s.seq.Load()
+	s.checkpointMux.Unlock()
+}
+
+// holdLease renews the lease of the primary with the checkpoint every
+// third of the TTL while the socketeer is ready, and releases it when
+// the socketeer is stopped. When the lease is lost, or expires without
+// renewal, the change source is disconnected so that a single region
+// watches.
+//
+// The expiry is measured with the local clock, a TTL after the request
+// which acquired or renewed the lease was sent, never with the expiry
+// of the lease set by the clock of the store, so that the clocks don't
+// have to agree.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Parameters:
+//
+// 	- acquired (time.Time): the local time the lease was acquired at.
+//
+// # Example:
+//
+// 	go s.holdLease(acquired)
+func (s *Socketeer) holdLease(acquired time.Time) {
+	f := s.Failover
+	expires := acquired.Add(s.leaseTTL())
+	ticker := time.NewTicker(s.leaseTTL() / 3)
+	defer ticker.Stop()
+
+	for {
+		var ttl time.Duration
+		select {
+		case <-s.done:
+		case <-ticker.C:
+			ttl = s.leaseTTL()
+		}
+		if ttl > 0 && s.Ready() != nil {
+			if time.Now().After(expires) {
+				s.loseLease("not ready")
+				return
+			}
+			continue
+		}
+
+		s.checkpointMux.Lock()
+		checkpoint := s.checkpoint
+		s.checkpointMux.Unlock()
+		now := time.Now()
+		ok, err := f.Leases.Renew(f.Region, ttl, checkpoint.ClusterTime, checkpoint.Seq)
+		if ttl == 0 {
+			if err != nil {
+				s.log.Warn("releasing the lease failed", "error", err)
+			}
+			return
+		}
+		switch {
+		case err != nil:
+			s.log.Warn("renewing the lease failed", "error", err)
+			s.report(err, map[string]any{"component": "failover"})
+			if now.After(expires) {
+				s.loseLease("renewal failed")
+				return
+			}
+		case !ok:
+			s.loseLease("taken over")
+			return
+		default:
+			expires = now.Add(ttl)
+		}
+	}
+}
+
+// loseLease stops the primary which lost its lease.
+func (s *Socketeer) loseLease(reason string) {
+	s.log.Error("lease lost, stopping the change stream", "region", s.Failover.Region, "reason", reason)
+	s.leaseLost.Store(true)
+	s.DB.Disconnect()
+}
@@ -0,0 +1,305 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// Names of the fixed nodes of a Flow.
+//
+// 	- FlowSource is the change source, in the inputs of the stages
+// 		and the sinks.
+// 	- FlowClients is the websocket clients, among the sinks.
+const (
+	FlowSource  = "source"
+	FlowClients = "clients"
+)
+
+// Flow routes the messages from the change source to the websocket
+// clients and the Sinks through named stages, as a directed acyclic
+// graph: every stage and sink lists its inputs, so a node feeds as
+// many nodes as reference it (fan-out) and a node with several inputs
+// merges them (fan-in).
+//
+// With a Flow, the clients and every sink only receive the messages
+// of their inputs, nothing when they aren't in Sinks. A message
+// reaching a sink through several paths is delivered once per path.
+//
+// 	- Stages are the transform stages.
+// 	- Sinks are the inputs of the sinks, by sink name, FlowClients
+// 		for the websocket clients.
+//
+// # Example:
+//
+// 	s.Flow = &socketeer.Flow{
+// 		Stages: []socketeer.FlowStage{
+// 			{Name: "paid", Inputs: []string{socketeer.FlowSource}, Topics: []string{"orders"}, Filter: map[string]string{"status": "paid"}},
+// 			{Name: "public", Inputs: []string{"paid"}, Redact: []string{"card"}},
+// 		},
+// 		Sinks: map[string][]string{
+// 			socketeer.FlowClients: {"public"},
+// 			"billing":             {"paid"},
+// 		},
+// 	}
+type Flow struct {
+	Stages []FlowStage         `json:"stages"`
+	Sinks  map[string][]string `json:"sinks"`
+}
+
+// FlowStage is a transform stage of a Flow. A message goes through
+// the filter first, then the redaction and the enrichment.
+//
+// 	- Name identifies the stage in the inputs of the other nodes.
+// 	- Inputs are the nodes the stage receives the messages of,
+// 		FlowSource or other stages.
+// 	- Topics keeps the messages of these topics only, every topic
+// 		when empty.
+// 	- Filter keeps the messages matching it, compared like the
+// 		filters of the subscriptions.
+// 	- Redact removes these fields from the data, the full document,
+// 		the array changes and the patch of the messages.
+// 	- Enrich adds these fields to the data of the messages.
+type FlowStage struct {
+	Name   string            `json:"name"`
+	Inputs []string          `json:"inputs"`
+	Topics []string          `json:"topics,omitempty"`
+	Filter map[string]string `json:"filter,omitempty"`
+	Redact []string          `json:"redact,omitempty"`
+	Enrich map[string]string `json:"enrich,omitempty"`
+}
+
+// ErrInvalidFlow is returned by Start() and Validate() for a Flow
+// which isn't a directed acyclic graph of known nodes.
+var ErrInvalidFlow = errors.New("socketeer: invalid flow")
+
+// order returns the stages of the flow ordered so that every stage
+// comes after its inputs, it fails when a node is unknown or when the
+// stages form a cycle.
+//
+// # Parameters:
+//
+// 	- sinks ([]Sink): the sinks the flow can deliver to.
+//
+// # Example:
+//
+// 	stages, err := s.Flow.order(s.Sinks)
+func (f *Flow) order(sinks []Sink) ([]FlowStage, error) {
+	stages := make(map[string]FlowStage, len(f.Stages))
+	for _, stage := range f.Stages {
+		if stage.Name == "" || stage.Name == FlowSource {
+			return nil, fmt.Errorf("%w: stage name %q", ErrInvalidFlow, stage.Name)
+		}
+		if _, ok := stages[stage.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate stage %q", ErrInvalidFlow, stage.Name)
+		}
+		if len(stage.Inputs) == 0 {
+			return nil, fmt.Errorf("%w: stage %q has no input", ErrInvalidFlow, stage.Name)
+		}
+		stages[stage.Name] = stage
+	}
+	known := func(input string) bool {
+		_, ok := stages[input]
+		return ok || input == FlowSource
+	}
+
+	names := map[string]bool{FlowClients: true}
+	for _, sink := range sinks {
+		names[sink.Name()] = true
+	}
+	for name, inputs := range f.Sinks {
+		if !names[name] {
+			return nil, fmt.Errorf("%w: unknown sink %q", ErrInvalidFlow, name)
+		}
+		for _, input := range inputs {
+			if !known(input) {
+				return nil, fmt.Errorf("%w: unknown input %q of sink %q", ErrInvalidFlow, input, name)
+			}
+		}
+	}
+
+	ordered := make([]FlowStage, 0, len(f.Stages))
+	done := map[string]bool{FlowSource: true}
+	for len(ordered) < len(f.Stages) {
+		progress := false
+		for _, stage := range f.Stages {
+			if done[stage.Name] {
+				continue
+			}
+			ready := true
+			for _, input := range stage.Inputs {
+				if !known(input) {
+					return nil, fmt.Errorf("%w: unknown input %q of stage %q", ErrInvalidFlow, input, stage.Name)
+				}
+				ready = ready && done[input]
+			}
+			if ready {
+				ordered = append(ordered, stage)
+				done[stage.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("%w: the stages form a cycle", ErrInvalidFlow)
+		}
+	}
+
+	return ordered, nil
+}
+
+// checkFlow checks the Flow, if any, and keeps its ordered stages.
+func (s *Socketeer) checkFlow() error {
+	if s.Flow == nil {
+		return nil
+	}
+	stages, err := s.Flow.order(s.Sinks)
+	if err != nil {
+		return err
+	}
+	s.flowStages = stages
+
+	return nil
+}
+
+// route sends a message through the stages of the Flow, and delivers
+// what reaches its sinks to them.
+//
+// # Parameters:
+//
+// 	- msg (Message): the message from the change source.
+//
+// # Example:
+//
+// 	s.route(msg)
+func (s *Socketeer) route(msg Message) {
+	out := map[string][]Message{FlowSource: {msg}}
+	for _, stage := range s.flowStages {
+		var msgs []Message
+		for _, input := range stage.Inputs {
+			for _, m := range out[input] {
+				if m, ok := stage.apply(m); ok {
+					msgs = append(msgs, m)
+				}
+			}
+		}
+		out[stage.Name] = msgs
+	}
+
+	dispatched := false
+	for _, input := range s.Flow.Sinks[FlowClients] {
+		for _, m := range out[input] {
+			s.dispatch(m)
+			dispatched = true
+		}
+	}
+	if !s.inspected || !dispatched {
+		s.inspect(msg, nil)
+	}
+	for i, sink := range s.Sinks {
+		for _, input := range s.Flow.Sinks[sink.Name()] {
+			for _, m := range out[input] {
+				s.deliverAt(i, m)
+			}
+		}
+	}
+}
+
+// apply runs a message through the stage, and reports whether it
+// passes its filter. The maps of the message are copied before they
+// are changed, as the other paths of the flow share them.
+//
+// # Parameters:
+//
+// 	- msg (Message): the message.
+//
+// # Example:
+//
+// 	msg, ok := stage.apply(msg)
+func (st FlowStage) apply(msg Message) (Message, bool) {
+	if len(st.Topics) > 0 {
+		found := false
+		for _, topic := range st.Topics {
+			found = found || topic == msg.Topic
+		}
+		if !found {
+			return msg, false
+		}
+	}
+	if !event.Filter(st.Filter).Matches(msg) {
+		return msg, false
+	}
+
+	if len(st.Redact) > 0 {
+		msg = redact(msg, st.Redact)
+	}
+	if len(st.Enrich) > 0 {
+		data := make(map[string]string, len(msg.Data)+len(st.Enrich))
+		for field, value := range msg.Data {
+			data[field] = value
+		}
+		for field, value := range st.Enrich {
+			data[field] = value
+		}
+		msg.Data = data
+	}
+
+	return msg, true
+}
+
+// redact returns a message without the given fields.
+//
+// # Parameters:
+//
+// 	- msg (Message): the message.
+// 	- fields ([]string): the fields to remove.
+//
+// # Example:
+//
+// 	msg = redact(msg, []string{"password"})
+func redact(msg Message, fields []string) Message {
+	removed := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		removed[field] = true
+	}
+	without := func(m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+		kept := make(map[string]string, len(m))
+		for field, value := range m {
+			if !removed[field] {
+				kept[field] = value
+			}
+		}
+		return kept
+	}
+
+	msg.Data = without(msg.Data)
+	msg.FullDocument = without(msg.FullDocument)
+	if len(msg.ArrayChanges) > 0 {
+		changes := make([]ArrayChange, 0, len(msg.ArrayChanges))
+		for _, change := range msg.ArrayChanges {
+			if !removed[change.Field] {
+				changes = append(changes, change)
+			}
+		}
+		msg.ArrayChanges = changes
+	}
+	if len(msg.Patch) > 0 {
+		var patch map[string]json.RawMessage
+		err := json.Unmarshal(msg.Patch, &patch)
+		if err == nil {
+			for field := range removed {
+				delete(patch, field)
+			}
+			msg.Patch, err = json.Marshal(patch)
+		}
+		if err != nil {
+			msg.Patch = nil
+		}
+	}
+
+	return msg
+}
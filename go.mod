@@ -3,14 +3,15 @@ module github.com/darthsalad/socketeer
 go 1.20
 
 require (
+	github.com/coder/websocket v1.8.12
 	github.com/gorilla/websocket v1.5.0
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.13.6
 	go.mongodb.org/mongo-driver v1.12.0
 )
 
 require (
 	github.com/golang/snappy v0.0.1 // indirect
-	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/montanaflynn/stats v0.0.0-20171201202039-1bf9dbcd8cbe // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.1.2 // indirect
@@ -0,0 +1,164 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/metrics"
+)
+
+// Paths the health probes are served on, for the liveness
+// and readiness probes of Kubernetes.
+const (
+	LivePath  = "/livez"
+	ReadyPath = "/readyz"
+)
+
+// Defaults of the health probes settings.
+const (
+	DefaultHeartbeatTimeout = 30 * time.Second
+	DefaultDispatchTimeout  = 30 * time.Second
+)
+
+// Errors returned by Live() and Ready().
+var (
+	ErrNotListening  = errors.New("socketeer: change source not listening")
+	ErrNoHeartbeat   = errors.New("socketeer: no heartbeat from the change source")
+	ErrDispatchStuck = errors.New("socketeer: dispatch stuck")
+)
+
+// heartbeater is implemented by the change sources which report
+// their round trips, including the ones without any change, like
+// the default DB.
+type heartbeater interface {
+	OnHeartbeat(heartbeat func())
+}
+
+// Health is the response of the health probes.
+//
+// 	- Status is "ok" or "unavailable".
+// 	- Error is the reason of the failure, if any.
+type Health struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Live reports whether the socketeer is alive: it fails when a
+// dispatch has been running for longer than DispatchTimeout, which
+// means the dispatch loop is stuck and the process has to be restarted.
+//
+// # Example:
+//
+// 	err := s.Live()
+func (s *Socketeer) Live() error {
+	start := s.dispatching.Load()
+	if start != 0 && time.Since(time.Unix(0, start)) > s.dispatchTimeout() {
+		return ErrDispatchStuck
+	}
+
+	return nil
+}
+
+// Ready reports whether the socketeer is ready to serve clients: the
+// change source has to be listening and, for sources reporting their
+// round trips, to have produced a heartbeat within HeartbeatTimeout.
+// A standby of a Failover is not ready.
+//
+// # Example:
+//
+// 	err := s.Ready()
+func (s *Socketeer) Ready() error {
+	if s.standby.Load() {
+		return ErrStandby
+	}
+	if !s.listening.Load() {
+		return ErrNotListening
+	}
+
+	if s.heartbeats {
+		last := s.lastBeat.Load()
+		if last == 0 || time.Since(time.Unix(0, last)) > s.heartbeatTimeout() {
+			return ErrNoHeartbeat
+		}
+	}
+
+	return s.Live()
+}
+
+// beat records a heartbeat of the change source.
+func (s *Socketeer) beat() {
+	s.lastBeat.Store(time.Now().UnixNano())
+}
+
+// reconnecting counts an attempt to reopen the change stream and hands
+// it to OnReconnect.
+func (s *Socketeer) reconnecting(attempt ReconnectAttempt) {
+	s.Metrics.Count(metrics.StreamReconnects, 1, map[string]string{metrics.TagCollection: attempt.Collection})
+	if s.OnReconnect != nil {
+		s.OnReconnect(attempt)
+	}
+}
+
+// heartbeatTimeout returns HeartbeatTimeout or its default.
+func (s *Socketeer) heartbeatTimeout() time.Duration {
+	if s.HeartbeatTimeout > 0 {
+		return s.HeartbeatTimeout
+	}
+
+	return DefaultHeartbeatTimeout
+}
+
+// dispatchTimeout returns DispatchTimeout or its default.
+func (s *Socketeer) dispatchTimeout() time.Duration {
+	if s.DispatchTimeout > 0 {
+		return s.DispatchTimeout
+	}
+
+	return DefaultDispatchTimeout
+}
+
+// serveLive serves the liveness probe, with the status
+// 503 (service unavailable) when the socketeer is not alive.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(LivePath, http.HandlerFunc(s.serveLive))
+func (s *Socketeer) serveLive(res http.ResponseWriter, req *http.Request) {
+	serveHealth(res, s.Live())
+}
+
+// serveReady serves the readiness probe, with the status
+// 503 (service unavailable) when the socketeer is not ready.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(ReadyPath, http.HandlerFunc(s.serveReady))
+func (s *Socketeer) serveReady(res http.ResponseWriter, req *http.Request) {
+	serveHealth(res, s.Ready())
+}
+
+// serveHealth writes the Health of a probe as JSON.
+func serveHealth(res http.ResponseWriter, err error) {
+	health := Health{Status: "ok"}
+	status := http.StatusOK
+	if err != nil {
+		health = Health{Status: "unavailable", Error: err.Error()}
+		status = http.StatusServiceUnavailable
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(health)
+}
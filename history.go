@@ -0,0 +1,207 @@
+package socketeer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/darthsalad/socketeer/internal/event"
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// HistoryPath is the path the history of the dispatched messages is
+// read on, page by page, with the authentication and the Scope of the
+// websocket endpoint, so that a client offline for long backfills the
+// messages it missed incrementally.
+// Example: GET /history?topic=posts&cursor=MTI&limit=100
+const HistoryPath = "/history"
+
+// Limits of the number of messages of a HistoryPage.
+const (
+	DefaultHistoryLimit = 100
+	MaxHistoryLimit     = 1000
+)
+
+// ErrInvalidCursor is returned by History() for a cursor it didn't return.
+var ErrInvalidCursor = errors.New("socketeer: invalid cursor")
+
+// historian is implemented by the broadcasters keeping the history of
+// the dispatched messages, like the default WebSocket server.
+type historian interface {
+	History(after uint64, limit int, want func(event.Message) bool) ([]event.Message, bool, bool)
+}
+
+// HistoryPage is a page of the history, as returned by History().
+//
+// 	- Messages are the messages of the page, by increasing sequence number.
+// 	- Cursor is the cursor of the next page, the given one when the
+// 		page is empty, to poll the history for new messages.
+// 	- More is whether the next page has messages already.
+// 	- Truncated is whether the history dropped messages following the
+// 		given cursor, which the client missed for good.
+type HistoryPage struct {
+	Messages  []Message `json:"messages"`
+	Cursor    string    `json:"cursor"`
+	More      bool      `json:"more"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// History returns a page of the history of the dispatched messages,
+// the messages following a cursor, ordered by sequence number, which
+// match a topic and a filter.
+//
+// # Parameters:
+//
+// 	- cursor (string): the Cursor of the previous page, empty for
+// 		the oldest message kept.
+// 	- limit (int): the maximal number of messages, DefaultHistoryLimit
+// 		when 0, at most MaxHistoryLimit.
+// 	- topic (string): the topic of the messages, every topic when empty.
+// 	- filter (map[string]string): the filter of the messages, compared
+// 		like the filters of the subscriptions, optional.
+//
+// # Example:
+//
+// 	page, err := s.History("", 100, "posts", nil)
+func (s *Socketeer) History(cursor string, limit int, topic string, filter map[string]string) (HistoryPage, error) {
+	return s.history(cursor, limit, func(msg Message) bool {
+		return (topic == "" || msg.Topic == topic) && event.Filter(filter).Matches(msg)
+	})
+}
+
+// history returns a page of the messages following a cursor which
+// want accepts, see History().
+func (s *Socketeer) history(cursor string, limit int, want func(Message) bool) (HistoryPage, error) {
+	h, ok := s.WS.(historian)
+	if !ok {
+		return HistoryPage{}, ErrUnsupported
+	}
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+
+	msgs, more, truncated := h.History(after, limit, want)
+	page := HistoryPage{Messages: msgs, Cursor: cursor, More: more, Truncated: truncated}
+	if page.Messages == nil {
+		page.Messages = []Message{}
+	}
+	if len(msgs) > 0 {
+		page.Cursor = encodeCursor(msgs[len(msgs)-1].Seq)
+	}
+
+	return page, nil
+}
+
+// encodeCursor returns the opaque cursor of a sequence number.
+func encodeCursor(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(seq, 10)))
+}
+
+// decodeCursor returns the sequence number of a cursor, 0 when it is empty.
+func decodeCursor(cursor string) (uint64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	seq, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	return seq, nil
+}
+
+// serveHistory answers the GET requests on HistoryPath with a
+// HistoryPage: the cursor and limit query parameters select the page,
+// the topic and filter parameters, a JSON filter like the one of a
+// subscription, select the messages, which the Scope of the identity
+// of the request restricts.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(HistoryPath, http.HandlerFunc(s.serveHistory))
+func (s *Socketeer) serveHistory(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		res.Header().Set("Allow", "GET")
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var identity string
+	if a, ok := s.WS.(authorizer); ok {
+		var err error
+		identity, err = a.Authorize(req)
+		if errors.Is(err, ws.ErrForbidden) {
+			http.Error(res, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(res, ws.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	params := req.URL.Query()
+	var limit int
+	if l := params.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			http.Error(res, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	var filter event.Filter
+	if f := params.Get("filter"); f != "" {
+		err := json.Unmarshal([]byte(f), &filter)
+		if err != nil {
+			http.Error(res, "invalid filter", http.StatusBadRequest)
+			return
+		}
+	}
+	topic := params.Get("topic")
+
+	page, err := s.history(params.Get("cursor"), limit, func(msg Message) bool {
+		if topic != "" && msg.Topic != topic {
+			return false
+		}
+		if s.Scope != nil && !event.Filter(s.Scope(identity, msg.Topic)).Matches(msg) {
+			return false
+		}
+
+		return filter.Matches(msg)
+	})
+	switch {
+	case errors.Is(err, ErrUnsupported):
+		http.Error(res, err.Error(), http.StatusNotImplemented)
+		return
+	case errors.Is(err, ErrInvalidCursor):
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(page)
+}
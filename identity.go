@@ -0,0 +1,38 @@
+package socketeer
+
+import "github.com/darthsalad/socketeer/internal/ws"
+
+// ErrUnknownIdentity is returned by SendToIdentity() when the identity
+// has no connection and offline queues are disabled.
+var ErrUnknownIdentity = ws.ErrUnknownIdentity
+
+// identitySender is implemented by the broadcasters which track
+// the sessions of the authenticated identities.
+type identitySender interface {
+	SendToIdentity(identity string, msg Message) error
+}
+
+// SendToIdentity sends a message to every connection of an identity
+// returned by Authenticate, example: all the tabs and devices of a user.
+// The message is queued for the next connection of the identity when
+// none is connected, up to OfflineQueue messages.
+//
+// # Parameters:
+//
+// 	- identity (string): the identity of the recipient.
+// 	- msg (Message): the message, its Topic and Data are sent to the clients.
+//
+// # Example:
+//
+// 	err := s.SendToIdentity("user-42", socketeer.Message{
+// 		Topic: "notifications",
+// 		Data:  map[string]string{"text": "Hello"},
+// 	})
+func (s *Socketeer) SendToIdentity(identity string, msg Message) error {
+	sender, ok := s.WS.(identitySender)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	return sender.SendToIdentity(identity, msg)
+}
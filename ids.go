@@ -0,0 +1,226 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/darthsalad/socketeer/internal/event"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminDocumentsPath is the path the document IDs the stream is
+// restricted to are read and updated on, behind the AdminToken.
+const AdminDocumentsPath = "/admin/documents"
+
+// DocumentsUpdate is the body of a request on AdminDocumentsPath.
+//
+// 	- IDs are the _id values of the documents, the hexadecimal strings
+// 		of 24 characters standing for ObjectIDs as well.
+type DocumentsUpdate struct {
+	IDs []any `json:"ids"`
+}
+
+// idSet is a set of document IDs.
+//
+// 	- ids are the IDs, in the order they were added.
+// 	- keys are the IDs as strings, formatted like the document keys
+// 		of the messages, so that an ObjectID and its hex match.
+type idSet struct {
+	ids  []any
+	keys map[string]bool
+}
+
+// newIDSet returns the set of some IDs, without duplicates.
+//
+// # Parameters:
+//
+// 	- ids ([]any): the IDs.
+//
+// # Example:
+//
+// 	set := newIDSet(s.DocumentIDs)
+func newIDSet(ids []any) *idSet {
+	set := &idSet{ids: make([]any, 0, len(ids)), keys: make(map[string]bool, len(ids))}
+	for _, id := range ids {
+		key := jsonString(id)
+		if set.keys[key] {
+			continue
+		}
+		set.keys[key] = true
+		set.ids = append(set.ids, id)
+	}
+
+	return set
+}
+
+// values returns the IDs to match in the change stream: the IDs, and
+// the ObjectID of every hex string standing for one.
+func (set *idSet) values() []any {
+	values := make([]any, 0, len(set.ids))
+	for _, id := range set.ids {
+		values = append(values, id)
+		if str, ok := id.(string); ok {
+			if oid, err := primitive.ObjectIDFromHex(str); err == nil {
+				values = append(values, oid)
+			}
+		}
+	}
+
+	return values
+}
+
+// watches reports whether the stream is restricted to the documents
+// of the event, always true without DocumentIDs and for the operations
+// on the collection, like the renames and the drops, which have no
+// document.
+func (s *Socketeer) watches(ev Event) bool {
+	set := s.documentIDs.Load()
+	if set == nil || event.IsCollectionOp(ev.OperationType) {
+		return true
+	}
+
+	return set.keys[jsonString(ev.DocumentKey["_id"])]
+}
+
+// pushedIDs returns the IDs to match in the change stream, nil without
+// DocumentIDs.
+func (s *Socketeer) pushedIDs() []any {
+	set := s.documentIDs.Load()
+	if set == nil {
+		return nil
+	}
+
+	return set.values()
+}
+
+// SetDocumentIDs restricts the stream to the documents with the given
+// _id values while the socketeer is running, nil lifts the restriction.
+// The change stream is reopened with the new IDs within a second, after
+// the last change read.
+//
+// # Parameters:
+//
+// 	- ids ([]any): the _id values, nil for every document.
+//
+// # Example:
+//
+// 	s.SetDocumentIDs([]any{"s-1", "s-2"})
+func (s *Socketeer) SetDocumentIDs(ids []any) {
+	s.idsMux.Lock()
+	defer s.idsMux.Unlock()
+
+	if ids == nil {
+		s.documentIDs.Store(nil)
+		return
+	}
+	s.documentIDs.Store(newIDSet(ids))
+}
+
+// AddDocumentIDs adds documents to the ones the stream is restricted
+// to, restricting it to these ones when it wasn't.
+//
+// # Parameters:
+//
+// 	- ids (...any): the _id values.
+//
+// # Example:
+//
+// 	s.AddDocumentIDs(sessionID)
+func (s *Socketeer) AddDocumentIDs(ids ...any) {
+	s.idsMux.Lock()
+	defer s.idsMux.Unlock()
+
+	var current []any
+	if set := s.documentIDs.Load(); set != nil {
+		current = set.ids
+	}
+	s.documentIDs.Store(newIDSet(append(append([]any(nil), current...), ids...)))
+}
+
+// RemoveDocumentIDs removes documents from the ones the stream is
+// restricted to, the stream stays restricted when none is left.
+//
+// # Parameters:
+//
+// 	- ids (...any): the _id values.
+//
+// # Example:
+//
+// 	s.RemoveDocumentIDs(sessionID)
+func (s *Socketeer) RemoveDocumentIDs(ids ...any) {
+	s.idsMux.Lock()
+	defer s.idsMux.Unlock()
+
+	set := s.documentIDs.Load()
+	if set == nil {
+		return
+	}
+	removed := newIDSet(ids)
+	var kept []any
+	for _, id := range set.ids {
+		if !removed.keys[jsonString(id)] {
+			kept = append(kept, id)
+		}
+	}
+	s.documentIDs.Store(newIDSet(kept))
+}
+
+// WatchedDocumentIDs returns the _id values the stream is restricted
+// to, nil when it isn't.
+//
+// # Example:
+//
+// 	ids := s.WatchedDocumentIDs()
+func (s *Socketeer) WatchedDocumentIDs() []any {
+	set := s.documentIDs.Load()
+	if set == nil {
+		return nil
+	}
+
+	return append([]any{}, set.ids...)
+}
+
+// serveAdminDocuments serves the document IDs the stream is restricted
+// to on GET, null when it isn't, and updates them with a
+// DocumentsUpdate: PUT replaces them, null lifting the restriction,
+// POST adds to them and DELETE removes from them.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(AdminDocumentsPath, http.HandlerFunc(s.serveAdminDocuments))
+func (s *Socketeer) serveAdminDocuments(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if req.Method != http.MethodGet {
+		var update DocumentsUpdate
+		err := json.NewDecoder(req.Body).Decode(&update)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch req.Method {
+		case http.MethodPut:
+			s.SetDocumentIDs(update.IDs)
+		case http.MethodPost:
+			s.AddDocumentIDs(update.IDs...)
+		case http.MethodDelete:
+			s.RemoveDocumentIDs(update.IDs...)
+		default:
+			res.Header().Set("Allow", "GET, PUT, POST, DELETE")
+			http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.log.Info("document IDs updated", "method", req.Method, "ids", len(update.IDs))
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(DocumentsUpdate{IDs: s.WatchedDocumentIDs()})
+}
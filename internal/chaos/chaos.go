@@ -0,0 +1,114 @@
+// Internal package for injecting faults into the database
+// listener and the websocket server so the resilience of an
+// application built on socketeer can be exercised in tests.
+//
+// This package is used in the following way:
+//
+// 	1. Create a new Injector type with New().
+// 	2. Hand the Injector to the database listener and the websocket server.
+// 	3. Ask the Injector before every risky operation whether a fault
+// 		should be injected with Disconnect(), Drop() and Delay().
+//
+// A nil Injector never injects anything, so callers don't need
+// to check whether chaos mode is enabled.
+package chaos
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjectedDisconnect is returned by the database listener when
+// the Injector decides that the change stream should be torn down.
+var ErrInjectedDisconnect = errors.New("chaos: injected change stream disconnect")
+
+// Config is a struct for configuring the faults to inject.
+// All rates are probabilities in the range [0, 1].
+//
+// 	- Seed is the seed of the random source, the same seed
+// 		always yields the same sequence of faults.
+// 	- DisconnectRate is the rate at which the change stream
+// 		is torn down before an event is processed.
+// 	- SlowClientRate is the rate at which a write to a client
+// 		is delayed by SlowClientDelay.
+// 	- SlowClientDelay is the delay applied to slow writes.
+// 	- DropRate is the rate at which a frame to a client is
+// 		silently dropped.
+type Config struct {
+	Seed            int64
+	DisconnectRate  float64
+	SlowClientRate  float64
+	SlowClientDelay time.Duration
+	DropRate        float64
+}
+
+// Injector is a type for deciding when faults are injected.
+//
+// 	- cfg is the configuration of the faults.
+// 	- rnd is the seeded random source.
+// 	- rndMux is a mutex for rnd for thread safety.
+type Injector struct {
+	cfg    Config
+	rnd    *rand.Rand
+	rndMux sync.Mutex
+}
+
+// New returns a new Injector for the given configuration.
+//
+// # Parameters:
+//
+// 	- cfg (Config): the faults to inject.
+//
+// # Example:
+//
+// 	inj := chaos.New(chaos.Config{Seed: 42, DropRate: 0.1})
+func New(cfg Config) *Injector {
+	return &Injector{
+		cfg: cfg,
+		rnd: rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// Disconnect reports whether the change stream should be
+// torn down before processing the next event.
+func (i *Injector) Disconnect() bool {
+	if i == nil {
+		return false
+	}
+
+	return i.roll(i.cfg.DisconnectRate)
+}
+
+// Drop reports whether the next frame to a client should be dropped.
+func (i *Injector) Drop() bool {
+	if i == nil {
+		return false
+	}
+
+	return i.roll(i.cfg.DropRate)
+}
+
+// Delay returns how long the next write to a client should stall,
+// which is zero for the writes that are not selected as slow.
+func (i *Injector) Delay() time.Duration {
+	if i == nil || !i.roll(i.cfg.SlowClientRate) {
+		return 0
+	}
+
+	return i.cfg.SlowClientDelay
+}
+
+// roll draws from the random source and reports whether
+// the draw falls below the given rate.
+func (i *Injector) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	i.rndMux.Lock()
+	defer i.rndMux.Unlock()
+
+	return i.rnd.Float64() < rate
+}
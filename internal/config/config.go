@@ -0,0 +1,776 @@
+// Internal package for loading the configuration file of
+// the socketeer command line tool.
+//
+// The configuration is a JSON file, environment variables
+// referenced as $VAR or ${VAR} are expanded before parsing:
+//
+// 	{
+// 		"uri": "${MONGODB_URI}",
+// 		"database": "mydb",
+// 		"collections": [
+// 			{"name": "posts", "keys": ["title", "text"]}
+// 		],
+// 		"host": "localhost:8080",
+// 		"endpoint": "/listen"
+// 	}
+//
+// A schema file only needs the "collections" section.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/darthsalad/socketeer/internal/ws"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Config is the configuration of a socketeer.
+//
+// 	- URI is the MongoDB connection string.
+// 	- Database is the MongoDB database name.
+// 	- Collections are the watched collections and their keys.
+// 	- Host is the host address to listen on, example: localhost:8080
+// 	- Endpoint is the endpoint to listen on, example: /listen
+// 	- TLSCertFile and TLSKeyFile are the PEM files of the certificate
+// 		and key the server is served over TLS with, reloaded when they
+// 		change.
+// 	- LogFormat is the format of the logs, "text" or "json".
+// 	- LogLevel is the minimal level of the logs,
+// 		"debug", "info", "warn", "error" or "silent".
+// 	- AdminToken is the bearer token of the admin endpoints.
+// 	- BatchSize is the maximal number of changes per batch of the
+// 		change stream, 0 for the default of the server.
+// 	- MaxAwaitTimeMS is how long the server waits for new changes
+// 		in milliseconds, 0 for the default of the server.
+// 	- Collation is the collation of the change stream, example:
+// 		{"locale": "fr", "strength": 1}
+// 	- Match filters the changes on the server, when set.
+// 	- Pipeline are the aggregation stages appended to the pipeline of
+// 		the change stream, in Extended JSON, example:
+// 		[{"$match": {"operationType": {"$in": ["insert", "update"]}}}]
+// 	- ShowExpandedEvents dispatches the schema operations.
+// 	- FollowRename keeps watching a collection after a rename.
+// 	- ReopenOnDrop keeps watching a collection after it is dropped.
+// 	- UpdateLookup dispatches the current document of the updates.
+// 	- ReconnectBackoffMS is the first delay before the change stream is
+// 		reopened after an error in milliseconds, 0 for the default.
+// 	- ReconnectMaxMS caps the delay in milliseconds, 0 for the default.
+// 	- MaxReconnects is the number of consecutive attempts to reopen the
+// 		change stream, 0 for no limit.
+// 	- RawChanges forwards the complete change documents.
+// 	- IncludeDocumentKey adds the _id of the changed document to the messages.
+// 	- IncludeFullDocument adds the whole changed document to the messages.
+// 	- MergePatch adds the change to the messages as a JSON Merge Patch.
+// 	- AllowCIDRs are the networks allowed to connect, example: ["10.0.0.0/8"]
+// 	- DenyCIDRs are the networks rejected before the upgrade.
+// 	- TrustedProxies are the networks of the proxies whose forwarding
+// 		headers give the address of the clients.
+// 	- CompressThreshold is the size in bytes from which the messages are
+// 		compressed with zstd for the clients asking for it, 0 to never compress.
+// 	- BinaryFrames sends the messages in binary frames.
+// 	- FilterPushdown pushes the filters of the subscriptions down to
+// 		the change stream.
+// 	- DocumentIDs restricts the stream to the documents with these _id
+// 		values, every document when absent.
+// 	- SnapshotIntervalMS is the interval of the periodic snapshots in
+// 		milliseconds, 0 for none.
+// 	- ClientHeartbeatMS is the interval of the heartbeat messages sent
+// 		to the clients in milliseconds, 0 for none.
+// 	- PingIntervalMS is the interval of the pings measuring the
+// 		round-trip time of the clients in milliseconds, 0 for none.
+// 	- MaxBatch is the maximal number of messages written in a frame
+// 		to the clients of the third protocol version, 0 for one.
+// 	- MaintenanceBuffer is the maximal number of messages held for the
+// 		clients during a maintenance, 0 for the default.
+// 	- ShutdownTimeoutMS bounds the shutdown of the server on a stop
+// 		signal in milliseconds, 0 for no bound.
+// 	- MaxSubscriptions is the maximal number of topics a connection
+// 		subscribes to, 0 for no limit.
+// 	- Views are the views applied to the events of the collections,
+// 		by collection name.
+// 	- Outbox dispatches the documents of an outbox collection instead
+// 		of watching the collections, when set.
+// 	- Capped tails a capped collection instead of watching the
+// 		collections, when set.
+// 	- Cockroach receives CockroachDB changefeeds instead of watching
+// 		the collections, when set.
+// 	- Templates are the Go text/template templates the messages sent
+// 		to the clients are rendered with, by topic, "" for every other
+// 		topic.
+// 	- Aliases are the payload names of database fields, example:
+// 		{"created_at": "createdAt"}
+// 	- Transforms are the transformers of the values of the fields, by
+// 		field, example: {"created_at": "rfc3339", "price": "round:2"}
+// 	- Throttles are the ceilings of the event rate by topic, "" for
+// 		every other topic.
+// 	- Sinks are the sinks the messages are delivered to.
+// 	- Flow routes the messages to the clients and the sinks through
+// 		named stages, when set.
+// 	- Cluster partitions the topics across the instances of a cluster,
+// 		when set.
+// 	- Failover runs the socketeer as the primary or the standby of an
+// 		active/passive pair of regions, when set.
+// 	- Resume persists the resume token of the change stream, when set.
+// 	- Unresolved are the environment variables referenced by the
+// 		file which are not set, they are expanded to empty strings.
+type Config struct {
+	URI                 string       `json:"uri"`
+	Database            string       `json:"database"`
+	Collections         []Collection `json:"collections"`
+	Host                string       `json:"host"`
+	Endpoint            string       `json:"endpoint"`
+	TLSCertFile         string       `json:"tlsCertFile"`
+	TLSKeyFile          string       `json:"tlsKeyFile"`
+	LogFormat           string       `json:"logFormat"`
+	LogLevel            string       `json:"logLevel"`
+	AdminToken          string       `json:"adminToken"`
+	BatchSize           int32        `json:"batchSize"`
+	MaxAwaitTimeMS      int64        `json:"maxAwaitTimeMS"`
+	Collation           *Collation   `json:"collation"`
+	Match               *Match       `json:"match"`
+	Pipeline            Pipeline     `json:"pipeline"`
+	ShowExpandedEvents  bool         `json:"showExpandedEvents"`
+	FollowRename        bool         `json:"followRename"`
+	ReopenOnDrop        bool         `json:"reopenOnDrop"`
+	UpdateLookup        bool         `json:"updateLookup"`
+	ReconnectBackoffMS  int64        `json:"reconnectBackoffMS"`
+	ReconnectMaxMS      int64        `json:"reconnectMaxMS"`
+	MaxReconnects       int          `json:"maxReconnects"`
+	RawChanges          bool         `json:"rawChanges"`
+	IncludeDocumentKey  bool         `json:"includeDocumentKey"`
+	IncludeFullDocument bool         `json:"includeFullDocument"`
+	MergePatch          bool         `json:"mergePatch"`
+	AllowCIDRs          []string     `json:"allowCIDRs"`
+	DenyCIDRs           []string     `json:"denyCIDRs"`
+	TrustedProxies      []string     `json:"trustedProxies"`
+	CompressThreshold   int          `json:"compressThreshold"`
+	BinaryFrames        bool         `json:"binaryFrames"`
+	FilterPushdown      bool         `json:"filterPushdown"`
+	DocumentIDs         []any        `json:"documentIDs"`
+	SnapshotIntervalMS  int64        `json:"snapshotIntervalMS"`
+	ClientHeartbeatMS   int64        `json:"clientHeartbeatMS"`
+	PingIntervalMS      int64        `json:"pingIntervalMS"`
+	MaxBatch            int          `json:"maxBatch"`
+	MaintenanceBuffer   int          `json:"maintenanceBuffer"`
+	ShutdownTimeoutMS   int64        `json:"shutdownTimeoutMS"`
+	MaxSubscriptions    int          `json:"maxSubscriptions"`
+	Views               Views        `json:"views"`
+	Outbox              *Outbox      `json:"outbox"`
+	Capped              *Capped      `json:"capped"`
+	Cockroach           *Cockroach   `json:"cockroach"`
+	Templates           Templates    `json:"templates"`
+	Aliases             Aliases      `json:"aliases"`
+	Transforms          Transforms   `json:"transforms"`
+	Throttles           Throttles    `json:"throttles"`
+	Sinks               []Sink       `json:"sinks"`
+	Flow                *Flow        `json:"flow"`
+	Cluster             *Cluster     `json:"cluster"`
+	Failover            *Failover    `json:"failover"`
+	Resume              *Resume      `json:"resume"`
+	Unresolved          []string     `json:"-"`
+}
+
+// Collection is a watched collection.
+//
+// 	- Name is the name of the collection.
+// 	- Keys are the keys of the documents dispatched to clients.
+type Collection struct {
+	Name string   `json:"name"`
+	Keys []string `json:"keys"`
+}
+
+// CollectionNames returns the names of the watched collections.
+//
+// # Example:
+//
+// 	s, err := socketeer.NewSocketeer(cfg.URI, cfg.Database, cfg.CollectionNames()...)
+func (c *Config) CollectionNames() []string {
+	names := make([]string, 0, len(c.Collections))
+	for _, coll := range c.Collections {
+		names = append(names, coll.Name)
+	}
+
+	return names
+}
+
+// Views are the pipelines of aggregation stages applied to the events
+// of the collections, by collection name, example:
+// {"orders": [{"$match": {"status": {"$ne": "draft"}}}]}
+type Views map[string][]map[string]any
+
+// Outbox is the outbox collection of the transactional outbox pattern.
+//
+// 	- Collection is the name of the outbox collection.
+// 	- Mode is "mark" (default) or "delete", what happens to the
+// 		dispatched documents.
+// 	- MarkField is the field set on the dispatched documents in the
+// 		"mark" mode, defaults to "dispatchedAt".
+type Outbox struct {
+	Collection string `json:"collection"`
+	Mode       string `json:"mode"`
+	MarkField  string `json:"markField"`
+}
+
+// Capped is the capped collection tailed with a tailable cursor.
+//
+// 	- Collection is the name of the capped collection.
+// 	- FromStart dispatches the documents of the collection on start,
+// 		instead of the ones inserted afterwards only.
+type Capped struct {
+	Collection string `json:"collection"`
+	FromStart  bool   `json:"fromStart"`
+}
+
+// Cockroach is the webhook receiving the changefeeds of CockroachDB.
+//
+// 	- Path is the path of the webhook on the server of the socketeer,
+// 		"/crdb" when empty.
+// 	- Addr is the address of a server of its own instead, when set.
+// 	- Secret is the value of the Authorization header of the requests,
+// 		the webhook_auth_header option of the changefeeds.
+// 	- Tables are the tables of the changefeeds.
+// 	- CertFile and KeyFile are the certificate of the server on Addr.
+type Cockroach struct {
+	Path     string   `json:"path"`
+	Addr     string   `json:"addr"`
+	Secret   string   `json:"secret"`
+	Tables   []string `json:"tables"`
+	CertFile string   `json:"certFile"`
+	KeyFile  string   `json:"keyFile"`
+}
+
+// Templates are the templates of the messages by topic, example:
+// {"posts": "{{.Data.title}} was {{.OperationType}}ed"}
+type Templates map[string]string
+
+// Aliases are the payload names of database fields.
+type Aliases map[string]string
+
+// Transforms are the transformers of the values by field: "rfc3339",
+// "round:<decimals>", "lower", "upper" or "trim".
+type Transforms map[string]string
+
+// Throttles are the ceilings of the event rate by topic, example:
+// {"prices": {"rate": 50, "policy": "coalesce"}}
+type Throttles map[string]Throttle
+
+// Throttle is the ceiling of the event rate of a topic.
+//
+// 	- Rate is the maximal number of events per second.
+// 	- Policy is what happens to the events over the rate, "drop"
+// 		(default) or "coalesce".
+// 	- Backlog is the maximal number of events held by "coalesce".
+type Throttle struct {
+	Rate    float64 `json:"rate"`
+	Policy  string  `json:"policy"`
+	Backlog int     `json:"backlog"`
+}
+
+// Cluster partitions the topics across the instances of a cluster.
+//
+// 	- Self is the URL of this instance, one of the Instances.
+// 	- Instances are the ws:// or wss:// URLs of every instance.
+// 	- Secret is the bearer token of the links between the instances.
+// 	- Replicas is the number of points of every instance on the hash ring.
+type Cluster struct {
+	Self      string   `json:"self"`
+	Instances []string `json:"instances"`
+	Secret    string   `json:"secret"`
+	Replicas  int      `json:"replicas"`
+}
+
+// Failover runs the socketeer as the primary or the standby of an
+// active/passive pair of regions sharing the database.
+//
+// 	- Region is the name of the instance, the holder of the lease.
+// 	- Collection is the collection of the leases, "leases" when empty.
+// 	- Name is the name of the lease, the watched collection when empty.
+// 	- TTLMS is how long the lease is held without renewal in
+// 		milliseconds, 0 for the default.
+// 	- PrimaryURL is the readiness probe of the primary, optional.
+// 	- FailAfter is the number of failed probes before the standby
+// 		takes over, 0 for the default.
+type Failover struct {
+	Region     string `json:"region"`
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	TTLMS      int64  `json:"ttlMS"`
+	PrimaryURL string `json:"primaryURL"`
+	FailAfter  int    `json:"failAfter"`
+}
+
+// Resume persists the resume token of the change stream, so that a
+// restarted socketeer resumes after the last change it handled.
+//
+// 	- Type is the type of the store, "file", "mongo" or "redis".
+// 	- Path is the path of the file of the token.
+// 	- Collection is the collection of the tokens, "resumeTokens" when
+// 		empty.
+// 	- Name is the name of the token, the watched collection when empty.
+// 	- Addr is the address of the Redis server.
+// 	- Key is the Redis key of the token, "socketeer:" followed by the
+// 		watched collection when empty.
+// 	- Password authenticates to the Redis server, optional.
+// 	- DB is the number of the Redis database, 0 by default.
+type Resume struct {
+	Type       string `json:"type"`
+	Path       string `json:"path"`
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Addr       string `json:"addr"`
+	Key        string `json:"key"`
+	Password   string `json:"password"`
+	DB         int    `json:"db"`
+}
+
+// Sink is a sink the messages are delivered to.
+//
+// 	- Name identifies the sink in the flow, the metrics and the
+// 		dead letters.
+// 	- Type is the type of the sink, "webhook", "jsonl", "s3", "gcs",
+// 		"clickhouse" or "elasticsearch".
+// 	- URL is the URL the webhook posts the messages to, the URL of
+// 		the HTTP interface of ClickHouse, or of the Elasticsearch or
+// 		OpenSearch cluster.
+// 	- Headers are added to the requests of the webhook.
+// 	- Template renders the body of the requests of the webhook, with
+// 		the Go text/template syntax, the JSON of the message when empty.
+// 	- ContentType is the content type of the rendered body.
+// 	- Path is the path of the file of the jsonl sink.
+// 	- MaxSize is the size in bytes from which the file of the jsonl
+// 		sink is rotated, 0 for no limit.
+// 	- MaxAgeMS is the age in milliseconds from which the file of the
+// 		jsonl sink is rotated, 0 for no limit.
+// 	- MaxBackups is the number of rotated files of the jsonl sink
+// 		kept, 0 to keep them all.
+// 	- Bucket is the bucket the s3 and gcs sinks upload their objects to.
+// 	- Region is the region of the bucket of the s3 sink.
+// 	- Endpoint is the URL of an S3 compatible storage, like MinIO,
+// 		the endpoint of the storage of the type when empty.
+// 	- Prefix is prepended to the keys of the objects.
+// 	- AccessKey is the access key ID of the s3 sink, or the access ID
+// 		of the HMAC key of the gcs sink.
+// 	- SecretKey is the secret of the AccessKey.
+// 	- WindowMS is the time window in milliseconds of an object of the
+// 		s3 and gcs sinks, or of a batch of the clickhouse and
+// 		elasticsearch sinks, 0 for the default.
+// 	- MaxEvents is the number of messages from which an object, or a
+// 		batch, is sent before the end of its window, 0 for no limit
+// 		(for the default of the clickhouse and elasticsearch sinks).
+// 	- Format is the format of the objects of the s3 and gcs sinks,
+// 		"jsonl" (the default) or "parquet".
+// 	- Columns are the fields of the data with a column of their own
+// 		in the parquet objects.
+// 	- Table is the table the clickhouse sink inserts into.
+// 	- Mapping are the sources of the columns of the Table by column,
+// 		like {"status": "data.status"}, the default columns when empty.
+// 	- User and Password authenticate the clickhouse and elasticsearch
+// 		sinks.
+// 	- Index is the index of the elasticsearch sink, "{topic}" is
+// 		replaced with the topic of the message.
+// 	- APIKey authenticates the elasticsearch sink instead of the
+// 		User and Password.
+type Sink struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers"`
+	Template    string            `json:"template"`
+	ContentType string            `json:"contentType"`
+	Path        string            `json:"path"`
+	MaxSize     int64             `json:"maxSize"`
+	MaxAgeMS    int64             `json:"maxAgeMS"`
+	MaxBackups  int               `json:"maxBackups"`
+	Bucket      string            `json:"bucket"`
+	Region      string            `json:"region"`
+	Endpoint    string            `json:"endpoint"`
+	Prefix      string            `json:"prefix"`
+	AccessKey   string            `json:"accessKey"`
+	SecretKey   string            `json:"secretKey"`
+	WindowMS    int64             `json:"windowMS"`
+	MaxEvents   int               `json:"maxEvents"`
+	Format      string            `json:"format"`
+	Columns     []string          `json:"columns"`
+	Table       string            `json:"table"`
+	Mapping     map[string]string `json:"mapping"`
+	User        string            `json:"user"`
+	Password    string            `json:"password"`
+	Index       string            `json:"index"`
+	APIKey      string            `json:"apiKey"`
+}
+
+// Flow routes the messages from the source to the clients and the
+// sinks through named stages, see the Flow of the socketeer, example:
+//
+// 	{
+// 		"stages": [
+// 			{"name": "paid", "inputs": ["source"], "filter": {"status": "paid"}},
+// 			{"name": "public", "inputs": ["paid"], "redact": ["card"]}
+// 		],
+// 		"sinks": {"clients": ["public"], "billing": ["paid"]}
+// 	}
+//
+// 	- Stages are the transform stages.
+// 	- Sinks are the inputs of the sinks by name, "clients" for the
+// 		websocket clients.
+type Flow struct {
+	Stages []FlowStage         `json:"stages"`
+	Sinks  map[string][]string `json:"sinks"`
+}
+
+// FlowStage is a transform stage of the flow.
+//
+// 	- Name identifies the stage in the inputs of the other nodes.
+// 	- Inputs are the nodes the stage receives the messages of,
+// 		"source" or other stages.
+// 	- Topics keeps the messages of these topics only.
+// 	- Filter keeps the messages matching it.
+// 	- Redact removes these fields from the messages.
+// 	- Enrich adds these fields to the data of the messages.
+type FlowStage struct {
+	Name   string            `json:"name"`
+	Inputs []string          `json:"inputs"`
+	Topics []string          `json:"topics"`
+	Filter map[string]string `json:"filter"`
+	Redact []string          `json:"redact"`
+	Enrich map[string]string `json:"enrich"`
+}
+
+// Collation is the collation of the change stream, see the
+// collation document of the MongoDB manual for the fields.
+type Collation struct {
+	Locale          string `json:"locale"`
+	CaseLevel       bool   `json:"caseLevel"`
+	CaseFirst       string `json:"caseFirst"`
+	Strength        int    `json:"strength"`
+	NumericOrdering bool   `json:"numericOrdering"`
+	Alternate       string `json:"alternate"`
+	MaxVariable     string `json:"maxVariable"`
+	Normalization   bool   `json:"normalization"`
+	Backwards       bool   `json:"backwards"`
+}
+
+// Options returns the collation as change stream options.
+//
+// # Example:
+//
+// 	s.Collation = cfg.Collation.Options()
+func (c *Collation) Options() *options.Collation {
+	return &options.Collation{
+		Locale:          c.Locale,
+		CaseLevel:       c.CaseLevel,
+		CaseFirst:       c.CaseFirst,
+		Strength:        c.Strength,
+		NumericOrdering: c.NumericOrdering,
+		Alternate:       c.Alternate,
+		MaxVariable:     c.MaxVariable,
+		Normalization:   c.Normalization,
+		Backwards:       c.Backwards,
+	}
+}
+
+// Match filters the changes on the server, the operations on the
+// collection always match.
+//
+// 	- Operations are the operation types matched, every one when empty.
+// 	- Fields are the values of the fields of the full document matched,
+// 		by dotted path, example: {"status": "paid"}
+// 	- KeyPrefixes are the prefixes of the string _id of the documents
+// 		matched, one of them at least.
+type Match struct {
+	Operations  []string       `json:"operations"`
+	Fields      map[string]any `json:"fields"`
+	KeyPrefixes []string       `json:"keyPrefixes"`
+}
+
+// Pipeline are aggregation stages in Extended JSON, which keeps the
+// order of their keys and the BSON types, like {"$oid": "..."}.
+type Pipeline []json.RawMessage
+
+// Stages returns the stages of the pipeline, every one of them has to
+// be a document with a single operator, like {"$match": {...}}.
+//
+// # Example:
+//
+// 	s.Pipeline, err = cfg.Pipeline.Stages()
+func (p Pipeline) Stages() (mongo.Pipeline, error) {
+	stages := make(mongo.Pipeline, 0, len(p))
+	for i, raw := range p {
+		var stage bson.D
+		err := bson.UnmarshalExtJSON(raw, false, &stage)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i, err)
+		}
+		if len(stage) != 1 || !strings.HasPrefix(stage[0].Key, "$") {
+			return nil, fmt.Errorf("stage %d: not a single stage operator", i)
+		}
+		stages = append(stages, stage)
+	}
+
+	return stages, nil
+}
+
+// Load reads and parses the configuration file at path,
+// expanding the environment variables it references.
+//
+// # Parameters:
+//
+// 	- path (string): the path of the configuration file.
+//
+// # Example:
+//
+// 	cfg, err := config.Load("socketeer.json")
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var unresolved []string
+	expanded := os.Expand(string(data), func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			unresolved = append(unresolved, name)
+		}
+		return value
+	})
+
+	var cfg Config
+	err = json.Unmarshal([]byte(expanded), &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	cfg.Unresolved = unresolved
+
+	return &cfg, nil
+}
+
+// Validate checks the configuration without connecting to anything,
+// it returns every problem found, nil when the configuration is valid.
+//
+// # Example:
+//
+// 	for _, err := range cfg.Validate() {
+// 		fmt.Println(err)
+// 	}
+func (c *Config) Validate() []error {
+	var errs []error
+	for _, name := range c.Unresolved {
+		errs = append(errs, fmt.Errorf("environment variable %s is not set", name))
+	}
+	if c.URI == "" {
+		errs = append(errs, errors.New("uri is empty"))
+	}
+	if c.Database == "" {
+		errs = append(errs, errors.New("database is empty"))
+	}
+	if len(c.Collections) == 0 {
+		errs = append(errs, errors.New("no collection configured"))
+	}
+	for i, coll := range c.Collections {
+		if coll.Name == "" {
+			errs = append(errs, fmt.Errorf("collection %d has no name", i))
+		}
+		if len(coll.Keys) == 0 {
+			errs = append(errs, fmt.Errorf("collection %q has no keys", coll.Name))
+		}
+		for _, key := range coll.Keys {
+			if !strings.HasPrefix(key, "^") {
+				continue
+			}
+			if _, err := regexp.Compile(key); err != nil {
+				errs = append(errs, fmt.Errorf("collection %q: key %q: %w", coll.Name, key, err))
+			}
+		}
+	}
+	if _, _, err := net.SplitHostPort(c.Host); err != nil {
+		errs = append(errs, fmt.Errorf("host %q: %w", c.Host, err))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tlsCertFile and tlsKeyFile go together"))
+	}
+	if c.BatchSize < 0 {
+		errs = append(errs, errors.New("batchSize is negative"))
+	}
+	if c.MaxAwaitTimeMS < 0 {
+		errs = append(errs, errors.New("maxAwaitTimeMS is negative"))
+	}
+	if c.ClientHeartbeatMS < 0 {
+		errs = append(errs, errors.New("clientHeartbeatMS is negative"))
+	}
+	if c.PingIntervalMS < 0 {
+		errs = append(errs, errors.New("pingIntervalMS is negative"))
+	}
+	if c.MaxBatch < 0 {
+		errs = append(errs, errors.New("maxBatch is negative"))
+	}
+	if c.MaintenanceBuffer < 0 {
+		errs = append(errs, errors.New("maintenanceBuffer is negative"))
+	}
+	if c.ShutdownTimeoutMS < 0 {
+		errs = append(errs, errors.New("shutdownTimeoutMS is negative"))
+	}
+	if c.ReconnectBackoffMS < 0 || c.ReconnectMaxMS < 0 || c.MaxReconnects < 0 {
+		errs = append(errs, errors.New("reconnectBackoffMS, reconnectMaxMS and maxReconnects can't be negative"))
+	}
+	if c.MaxSubscriptions < 0 {
+		errs = append(errs, errors.New("maxSubscriptions is negative"))
+	}
+	if c.SnapshotIntervalMS < 0 {
+		errs = append(errs, errors.New("snapshotIntervalMS is negative"))
+	}
+	if c.CompressThreshold < 0 {
+		errs = append(errs, errors.New("compressThreshold is negative"))
+	}
+	if c.Outbox != nil {
+		if c.Outbox.Collection == "" {
+			errs = append(errs, errors.New("outbox has no collection"))
+		}
+		if c.Outbox.Mode != "" && c.Outbox.Mode != "mark" && c.Outbox.Mode != "delete" {
+			errs = append(errs, fmt.Errorf("outbox mode %q must be mark or delete", c.Outbox.Mode))
+		}
+	}
+	if c.Capped != nil {
+		if c.Capped.Collection == "" {
+			errs = append(errs, errors.New("capped has no collection"))
+		}
+		if c.Outbox != nil {
+			errs = append(errs, errors.New("outbox and capped are exclusive"))
+		}
+	}
+	if c.Cockroach != nil {
+		if c.Outbox != nil || c.Capped != nil {
+			errs = append(errs, errors.New("cockroach, outbox and capped are exclusive"))
+		}
+		if (c.Cockroach.CertFile == "") != (c.Cockroach.KeyFile == "") {
+			errs = append(errs, errors.New("cockroach: the certificate and key files go together"))
+		}
+		if c.Cockroach.CertFile != "" && c.Cockroach.Addr == "" {
+			errs = append(errs, errors.New("cockroach: a certificate needs an addr"))
+		}
+	}
+	if c.Failover != nil {
+		if c.Failover.Region == "" {
+			errs = append(errs, errors.New("failover has no region"))
+		}
+		if c.Failover.TTLMS < 0 || c.Failover.FailAfter < 0 {
+			errs = append(errs, errors.New("failover: ttlMS and failAfter can't be negative"))
+		}
+	}
+	if c.Resume != nil {
+		switch c.Resume.Type {
+		case "file":
+			if c.Resume.Path == "" {
+				errs = append(errs, errors.New("resume: the file store has no path"))
+			}
+		case "mongo":
+		case "redis":
+			if c.Resume.Addr == "" {
+				errs = append(errs, errors.New("resume: the redis store has no addr"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("resume: unknown type %q", c.Resume.Type))
+		}
+	}
+	sinks := make(map[string]bool, len(c.Sinks))
+	for i, sink := range c.Sinks {
+		if sink.Name == "" {
+			errs = append(errs, fmt.Errorf("sink %d has no name", i))
+		} else if sinks[sink.Name] {
+			errs = append(errs, fmt.Errorf("duplicate sink %q", sink.Name))
+		}
+		sinks[sink.Name] = true
+		switch sink.Type {
+		case "webhook":
+			if sink.URL == "" {
+				errs = append(errs, fmt.Errorf("sink %q has no url", sink.Name))
+			}
+		case "jsonl":
+			if sink.Path == "" {
+				errs = append(errs, fmt.Errorf("sink %q has no path", sink.Name))
+			}
+			if sink.MaxSize < 0 || sink.MaxAgeMS < 0 || sink.MaxBackups < 0 {
+				errs = append(errs, fmt.Errorf("sink %q: negative rotation", sink.Name))
+			}
+		case "s3", "gcs":
+			if sink.Bucket == "" {
+				errs = append(errs, fmt.Errorf("sink %q has no bucket", sink.Name))
+			}
+			if sink.Type == "s3" && sink.Region == "" {
+				errs = append(errs, fmt.Errorf("sink %q has no region", sink.Name))
+			}
+			if sink.AccessKey == "" || sink.SecretKey == "" {
+				errs = append(errs, fmt.Errorf("sink %q has no credentials", sink.Name))
+			}
+			if sink.WindowMS < 0 || sink.MaxEvents < 0 {
+				errs = append(errs, fmt.Errorf("sink %q: negative batching", sink.Name))
+			}
+			if sink.Format != "" && sink.Format != "jsonl" && sink.Format != "parquet" {
+				errs = append(errs, fmt.Errorf("sink %q: format %q must be jsonl or parquet", sink.Name, sink.Format))
+			}
+		case "clickhouse":
+			if sink.URL == "" || sink.Table == "" {
+				errs = append(errs, fmt.Errorf("sink %q has no url or table", sink.Name))
+			}
+			if sink.WindowMS < 0 || sink.MaxEvents < 0 {
+				errs = append(errs, fmt.Errorf("sink %q: negative batching", sink.Name))
+			}
+		case "elasticsearch":
+			if sink.URL == "" || sink.Index == "" {
+				errs = append(errs, fmt.Errorf("sink %q has no url or index", sink.Name))
+			}
+			if sink.WindowMS < 0 || sink.MaxEvents < 0 {
+				errs = append(errs, fmt.Errorf("sink %q: negative batching", sink.Name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("sink %q: type %q must be webhook, jsonl, s3, gcs, clickhouse or elasticsearch", sink.Name, sink.Type))
+		}
+	}
+	if c.Flow != nil {
+		for i, stage := range c.Flow.Stages {
+			if stage.Name == "" {
+				errs = append(errs, fmt.Errorf("flow stage %d has no name", i))
+			}
+		}
+		for name := range c.Flow.Sinks {
+			if name != "clients" && !sinks[name] {
+				errs = append(errs, fmt.Errorf("flow: unknown sink %q", name))
+			}
+		}
+	}
+	if _, err := ws.ParseCIDRs(c.AllowCIDRs); err != nil {
+		errs = append(errs, fmt.Errorf("allowCIDRs: %w", err))
+	}
+	if _, err := ws.ParseCIDRs(c.DenyCIDRs); err != nil {
+		errs = append(errs, fmt.Errorf("denyCIDRs: %w", err))
+	}
+	if _, err := ws.ParseCIDRs(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trustedProxies: %w", err))
+	}
+	if c.Match != nil {
+		for _, prefix := range c.Match.KeyPrefixes {
+			if prefix == "" {
+				errs = append(errs, errors.New("match: empty key prefix"))
+			}
+		}
+	}
+	if _, err := c.Pipeline.Stages(); err != nil {
+		errs = append(errs, fmt.Errorf("pipeline: %w", err))
+	}
+	if c.Collation != nil && c.Collation.Locale == "" {
+		errs = append(errs, errors.New("collation has no locale"))
+	}
+	if !strings.HasPrefix(c.Endpoint, "/") {
+		errs = append(errs, fmt.Errorf("endpoint %q must start with /", c.Endpoint))
+	}
+
+	return errs
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPipelineStages(t *testing.T) {
+	var p Pipeline
+	err := json.Unmarshal([]byte(`[
+		{"$match": {"operationType": {"$in": ["insert", "update"]}, "fullDocument.owner": {"$oid": "65a1f0c2e4b0a1b2c3d4e5f6"}}},
+		{"$project": {"fullDocument.secret": 0, "fullDocument.a": 1}}
+	]`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages, err := p.Stages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != 2 || stages[0][0].Key != "$match" || stages[1][0].Key != "$project" {
+		t.Fatalf("Stages() = %v", stages)
+	}
+	match := stages[0][0].Value.(bson.D)
+	if match[0].Key != "operationType" || match[1].Key != "fullDocument.owner" {
+		t.Errorf("keys of $match not in order: %v", match)
+	}
+	if _, ok := match[1].Value.(primitive.ObjectID); !ok {
+		t.Errorf("$oid decoded as %T, want an ObjectID", match[1].Value)
+	}
+	if project := stages[1][0].Value.(bson.D); project[0].Key != "fullDocument.secret" {
+		t.Errorf("keys of $project not in order: %v", project)
+	}
+}
+
+func TestPipelineStagesInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		stage string
+	}{
+		{"not a document", `[1, 2]`},
+		{"no operator", `{"operationType": "insert"}`},
+		{"two operators", `{"$match": {}, "$project": {}}`},
+		{"empty", `{}`},
+		{"invalid extended JSON", `{"$match": {"_id": {"$oid": "nope"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Pipeline{json.RawMessage(tt.stage)}.Stages()
+			if err == nil {
+				t.Errorf("Stages() of %s succeeded", tt.stage)
+			}
+		})
+	}
+}
@@ -1,6 +1,6 @@
-// Internal package for handling database methods by 
-// listening for changes and dispatching updates to clients
-// with the internal websocket package.
+// Internal package for handling database methods by
+// listening for changes and handing them to the dispatch
+// pipeline of the socketeer as events.
 //
 // This package is used in the following way:
 //
@@ -14,14 +14,23 @@
 package db
 
 import (
-	"encoding/json"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"math/rand"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/darthsalad/socketeer/internal/ws"
+	"github.com/darthsalad/socketeer/internal/chaos"
+	"github.com/darthsalad/socketeer/internal/event"
+	"github.com/darthsalad/socketeer/internal/logger"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -31,36 +40,283 @@ import (
 // 	- Client is a mongo client.
 // 	- DB is a mongo database.
 // 	- Coll is a mongo collection.
+// 	- collMux is a mutex for DB and Coll for thread safety, they
+// 		change when a followed rename happens.
+// 	- Chaos is an optional fault injector, used in tests only.
+// 	- Log is the Logger of the package.
+// 	- BatchSize is the maximal number of changes per batch of the
+// 		change stream, 0 for the default of the server.
+// 	- MaxAwaitTime is how long the server waits for new changes before
+// 		answering a round trip of the change stream, 0 for the default
+// 		of the server.
+// 	- Collation is the collation of the change stream, nil for the
+// 		simple binary comparison.
+// 	- Match filters the changes on the server with a $match stage
+// 		compiled from its criteria, optional.
+// 	- Pipeline are the aggregation stages appended to the pipeline of
+// 		the change stream, to filter or reshape the changes on the
+// 		server, optional. See pipeline().
+// 	- ShowExpandedEvents makes the change stream report the schema
+// 		operations, like createIndexes, which are handed to the
+// 		dispatch pipeline with their description as fields.
+// 	- FollowRename makes Listen() watch the new namespace of the
+// 		collection after a rename, instead of returning.
+// 	- Resume persists the resume token of the change stream, which is
+// 		resumed from it by Listen() unless it starts at an operation time,
+// 		optional.
+// 	- token is the last resume token saved, at checkpointed.
+// 	- MinBackoff is the first delay before reopening the change stream
+// 		after an error, defaults to DefaultMinBackoff.
+// 	- MaxBackoff is the maximal delay before reopening it, defaults to
+// 		DefaultMaxBackoff.
+// 	- MaxReconnects is the number of consecutive attempts to reopen it
+// 		before Listen() returns the error, 0 for no limit.
+// 	- OnReconnect is called before every attempt to reopen the change
+// 		stream, optional.
+// 	- last is the resume token of the last change handled, or of the
+// 		last round trip without change, the change stream is reopened
+// 		after it.
+// 	- attempt is the number of consecutive attempts to reopen the
+// 		change stream, reset by a round trip.
+// 	- UpdateLookup makes the change stream look up the current document
+// 		of the updates, which is handed as their fields instead of the
+// 		updated fields, unless it was deleted since.
+// 	- ReopenOnDrop makes Listen() watch the collection again after it
+// 		is dropped, so that its changes are handed again once it is
+// 		created again, instead of returning ErrInvalidated.
+// 	- Report is called with the panics recovered while decoding
+// 		or handling a change, optional.
+// 	- Filters returns the filters of the subscriptions by topic, which
+// 		are pushed down to the change stream, optional. See pipeline().
+// 	- DocumentIDs returns the _id values of the documents the change
+// 		stream is restricted to, nil for every document, optional.
+// 	- Owns reports whether this instance of a cluster owns a collection,
+// 		the collections of the other instances aren't watched, optional.
+// 	- heartbeat is called after every round trip of the change stream,
+// 		set with OnHeartbeat().
+// 	- startAt is the operation time the change stream starts at, zero
+// 		for now, set with StartAt().
+// 	- resumeAt is the cluster time of the change the change stream
+// 		resumes after, overriding startAt, nil for none, set with
+// 		ResumeAt().
+// 	- invalidated is the cluster time of the invalidate which ended
+// 		the change stream, nil while it runs.
+// 	- borrowed is whether the Client belongs to the application, it
+// 		is then left connected by Disconnect().
+// 	- ctx is cancelled by Disconnect(), ending the change stream.
+// 	- cancel cancels ctx.
+// 	- RawChanges hands the complete change documents to the handle
+// 		function, as relaxed Extended JSON, and the changes the other
+// 		events don't describe, like the deletes, with their raw change.
 type DB struct {
-	Client *mongo.Client
-	DB     *mongo.Database
-	Coll   *mongo.Collection
+	Client             *mongo.Client
+	DB                 *mongo.Database
+	Coll               *mongo.Collection
+	collMux            sync.Mutex
+	Chaos              *chaos.Injector
+	Log                logger.Logger
+	BatchSize          int32
+	MaxAwaitTime       time.Duration
+	Collation          *options.Collation
+	Match              *Match
+	Pipeline           mongo.Pipeline
+	ShowExpandedEvents bool
+	FollowRename       bool
+	ReopenOnDrop       bool
+	UpdateLookup       bool
+	Resume             ResumeStore
+	token              bson.Raw
+	checkpointed       time.Time
+	MinBackoff         time.Duration
+	MaxBackoff         time.Duration
+	MaxReconnects      int
+	OnReconnect        func(attempt ReconnectAttempt)
+	last               bson.Raw
+	attempt            int
+	Report             func(err error, ctx map[string]any)
+	Filters            func() map[string][]event.Filter
+	DocumentIDs        func() []any
+	Owns               func(coll string) bool
+	heartbeat          func()
+	startAt            time.Time
+	resumeAt           *primitive.Timestamp
+	invalidated        *primitive.Timestamp
+	borrowed           bool
+	ctx                context.Context
+	cancel             context.CancelFunc
+	RawChanges         bool
+}
+
+// ResumeStore persists the resume token of the change stream, so that
+// Listen() resumes after the last change handled when it is restarted,
+// and the changes made meanwhile aren't lost.
+//
+// 	- Load returns the saved token, nil when none was saved.
+// 	- Save saves the token of the last change handled, a BSON document.
+type ResumeStore interface {
+	Load() ([]byte, error)
+	Save(token []byte) error
 }
 
+// resumeInterval is the minimal interval between the saves of the
+// resume token, the changes handled meanwhile are handled again after
+// a crash.
+const resumeInterval = time.Second
+
+// ErrInvalidated is returned by Listen() when the change stream is
+// invalidated by the drop of the collection without ReopenOnDrop.
+var ErrInvalidated = errors.New("db: change stream invalidated")
+
+// Defaults of the reconnection of the change stream.
+const (
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// ReconnectAttempt is an attempt to reopen the change stream after an
+// error, handed to OnReconnect before waiting for Delay.
+//
+// 	- Collection is the watched collection.
+// 	- Attempt is the number of the attempt, from 1, reset once the
+// 		change stream is reopened.
+// 	- Delay is the delay before the attempt, a random duration up to
+// 		MinBackoff doubled per attempt, capped at MaxBackoff.
+// 	- Err is the error which ended the change stream, or the one of
+// 		the previous attempt.
+type ReconnectAttempt struct {
+	Collection string
+	Attempt    int
+	Delay      time.Duration
+	Err        error
+}
+
+// resumableCodes are the codes of the server errors after which the
+// change stream can be reopened, like the ones of a primary stepdown.
+var resumableCodes = []int{6, 7, 43, 63, 89, 91, 133, 150, 189, 234, 262, 9001, 10107, 11600, 11602, 13388, 13435, 13436}
+
+// streamError is an error of the change stream itself, as opposed to
+// one of the handle function.
+type streamError struct {
+	err error
+}
+
+func (e *streamError) Error() string {
+	return e.err.Error()
+}
+
+func (e *streamError) Unwrap() error {
+	return e.err
+}
+
+// filterInterval is the interval at which the Filters and the
+// DocumentIDs are polled, the change stream is reopened when they
+// changed.
+const filterInterval = time.Second
+
 // UpdateEvent is a struct for handling 
 // mongo update events from the database.
 //
 // 	- OperationType is the type of operation,
 // 		which is always "update".
+// 	- ClusterTime is the time of the operation in the oplog.
+// 	- DocumentKey is the _id (and shard key) of the document.
+// 	- FullDocument is the document after the update, only
+// 		set when the change stream looks it up.
 // 	- UpdateDescription is a struct for handling
-// 		the updated fields.
+// 		the updated and removed fields and the truncated arrays.
 type UpdateEvent struct {
-	OperationType     string `bson:"operationType"`
+	OperationType     string              `bson:"operationType"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+	DocumentKey       bson.M              `bson:"documentKey"`
+	FullDocument      bson.M              `bson:"fullDocument"`
 	UpdateDescription struct {
-		UpdatedFields bson.M `bson:"updatedFields"`
+		UpdatedFields   bson.M   `bson:"updatedFields"`
+		RemovedFields   []string `bson:"removedFields"`
+		TruncatedArrays []struct {
+			Field   string `bson:"field"`
+			NewSize int    `bson:"newSize"`
+		} `bson:"truncatedArrays"`
 	} `bson:"updateDescription"`
 }
 
+// RenameEvent is a struct for handling
+// mongo rename events from the database.
+//
+// 	- OperationType is the type of operation,
+// 		which is always "rename".
+// 	- ClusterTime is the time of the operation in the oplog.
+// 	- NS is the namespace of the collection before the rename.
+// 	- To is the namespace of the collection after the rename.
+type RenameEvent struct {
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	NS            Namespace           `bson:"ns"`
+	To            Namespace           `bson:"to"`
+}
+
+// Namespace is the database and collection names of a collection.
+//
+// 	- DB is the name of the database.
+// 	- Coll is the name of the collection.
+type Namespace struct {
+	DB   string `bson:"db"`
+	Coll string `bson:"coll"`
+}
+
+// DDLEvent is a struct for handling
+// mongo schema events from the database.
+//
+// 	- OperationType is the type of operation,
+// 		example: "createIndexes".
+// 	- ClusterTime is the time of the operation in the oplog.
+// 	- OperationDescription is the description of the operation,
+// 		example: the created indexes.
+type DDLEvent struct {
+	OperationType        string              `bson:"operationType"`
+	ClusterTime          primitive.Timestamp `bson:"clusterTime"`
+	OperationDescription bson.M              `bson:"operationDescription"`
+}
+
+// DropEvent is a struct for handling
+// mongo drop, dropDatabase and invalidate events from the database.
+//
+// 	- OperationType is the type of operation,
+// 		"drop", "dropDatabase" or "invalidate".
+// 	- ClusterTime is the time of the operation in the oplog.
+// 	- NS is the namespace of the dropped collection or database.
+type DropEvent struct {
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	NS            Namespace           `bson:"ns"`
+}
+
+// DeleteEvent is a struct for handling
+// mongo delete events from the database.
+//
+// 	- OperationType is the type of operation,
+// 		which is always "delete".
+// 	- ClusterTime is the time of the operation in the oplog.
+// 	- DocumentKey is the _id (and shard key) of the deleted document.
+type DeleteEvent struct {
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	DocumentKey   bson.M              `bson:"documentKey"`
+}
+
 // CreateEvent is a struct for handling
-// mongo create events from the database.
+// mongo create and replace events from the database.
 //
 // 	- OperationType is the type of operation,
-// 		which is always "insert".
+// 		"insert" or "replace".
+// 	- ClusterTime is the time of the operation in the oplog.
+// 	- DocumentKey is the _id (and shard key) of the document.
 // 	- FullDocument is a struct for handling
 // 		the full document.
 type CreateEvent struct {
-	OperationType string `bson:"operationType"`
-	FullDocument  bson.M `bson:"fullDocument"`
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	DocumentKey   bson.M              `bson:"documentKey"`
+	FullDocument  bson.M              `bson:"fullDocument"`
 }
 
 // Connect returns a new DB type by
@@ -80,123 +336,761 @@ type CreateEvent struct {
 //
 // 	db.Connect("mongodb://localhost:27017", "mydb", "mycollection")
 func Connect(uriString string, dbName string, collName string) (*DB, error) {
-	clientOptions := options.Client().ApplyURI(uriString).SetBSONOptions(&options.BSONOptions{
-		UseJSONStructTags: true,
-	})
+	return ConnectWithOptions(options.Client().ApplyURI(uriString), dbName, collName)
+}
+
+// ConnectWithOptions returns a new DB type by connecting to the
+// database with fully built client options, for the settings a
+// connection string can't express, like a custom TLS configuration,
+// the AWS IAM credentials or the monitors of the driver.
+//
+// # Parameters:
+//
+// 	- clientOptions (*options.ClientOptions): the options of the client.
+// 	- dbName (string): the name of the database to connect to.
+// 	- collName (string): the name of the collection to connect to.
+//
+// # Example:
+//
+// 	db.ConnectWithOptions(options.Client().ApplyURI(uri).SetTLSConfig(tlsConfig), "mydb", "mycollection")
+func ConnectWithOptions(clientOptions *options.ClientOptions, dbName string, collName string) (*DB, error) {
+	if clientOptions.BSONOptions == nil {
+		clientOptions.SetBSONOptions(&options.BSONOptions{
+			UseJSONStructTags: true,
+		})
+	}
 
 	client, err := mongo.Connect(context.Background(), clientOptions)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
 	err = client.Ping(context.Background(), nil)
 	if err != nil {
-		log.Fatal(err)
+		client.Disconnect(context.Background())
 		return nil, err
 	}
 
+	d := New(client, dbName, collName)
+	d.borrowed = false
+
+	return d, nil
+}
+
+// New returns a new DB type watching a collection with a client
+// connected by the caller, which is left connected by Disconnect(),
+// so that the application shares its client with the socketeer.
+//
+// # Parameters:
+//
+// 	- client (*mongo.Client): the connected client.
+// 	- dbName (string): the name of the database.
+// 	- collName (string): the name of the collection.
+//
+// # Example:
+//
+// 	db.New(client, "mydb", "mycollection")
+func New(client *mongo.Client, dbName string, collName string) *DB {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &DB{
-		Client: client,
-		DB:     client.Database(dbName),
-		Coll:   client.Database(dbName).Collection(os.Getenv(collName)),
-	}, nil
+		Client:   client,
+		DB:       client.Database(dbName),
+		Coll:     client.Database(dbName).Collection(collName),
+		Log:      logger.With(logger.Default, "component", "db"),
+		borrowed: true,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
 }
 
 // Listen listens for changes in the database
-// by the mongo watch & changeStream methods and dispatches updates
-// to clients with the internal websocket package.
+// by the mongo watch & changeStream methods and hands every
+// insert and update to the handle function as an Event.
+//
+// A rename of the collection is handed as an Event too, then the
+// new namespace is watched when FollowRename is set, otherwise
+// the method returns.
+//
+// A drop of the collection is handed as an Event, followed by the
+// invalidate which ends the change stream, then the collection is
+// watched again when ReopenOnDrop is set, from the invalidate on,
+// otherwise the method returns ErrInvalidated.
+//
+// With a Resume store, the change stream is resumed after the token
+// saved, unless it starts at an operation time, and the token of the
+// last change handled is saved every second and when the change
+// stream ends, so that a restart misses no change.
+//
+// When the change stream fails with a resumable error, like a network
+// error or a primary stepdown, it is reopened after the last change
+// handled, with an exponential backoff and jitter, MaxReconnects times
+// at most. The other errors are returned.
+//
+// A collection which Owns reports as owned by another instance isn't
+// watched, Listen() then waits for Disconnect().
+//
+// The heartbeat function is called after every round trip of the
+// change stream, including the ones without any change, so that a
+// stream which stopped producing can be told apart from an idle one.
 //
 // This method is called internally when the socketeer is started.
 //
 // # Parameters:
 //
-// 	- ws (WebSocket): the WebSocket type to dispatch updates to.
-// 	- keys ([]string): the keys in the documents of the collection 
-// 		to listen for changes on.
+// 	- handle (func(event.Event) error): the function called for every change,
+// 		the listener stops and returns the error if it fails.
 //
 // # Example:
 //
-// 	db.Listen(ws, []string{"displayName", "email"})
-func (d *DB) Listen(ws *ws.WebSocket, keys []string) error {
-	coll := d.Coll
-	changeStream, err := coll.Watch(context.Background(), mongo.Pipeline{}, options.ChangeStream())
-	if err != nil {
-		log.Fatal(err)
-		return err
+// 	db.Listen(func(ev event.Event) error {
+// 		fmt.Println(ev.OperationType, ev.Fields)
+// 		return nil
+// 	})
+func (d *DB) Listen(handle func(event.Event) error) error {
+	if d.Owns != nil && !d.Owns(d.Coll.Name()) {
+		d.idle()
+		return nil
 	}
 
-	for changeStream.Next(context.Background()) {
-		var updateResult UpdateEvent
-		var createResult CreateEvent
-		var temp bson.D
-		err := changeStream.Decode(&temp)
+	var startAt *primitive.Timestamp
+	if !d.startAt.IsZero() {
+		startAt = &primitive.Timestamp{T: uint32(d.startAt.Unix())}
+	}
+	if d.resumeAt != nil {
+		startAt = d.resumeAt
+	}
+	var startAfter bson.Raw
+	if d.Resume != nil && startAt == nil {
+		token, err := d.Resume.Load()
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("db: loading the resume token: %w", err)
+		}
+		if token != nil {
+			d.Log.Info("resuming the change stream", "collection", d.Coll.Name())
+			startAfter = token
+		}
+	}
+	for {
+		rename, err := d.watch(handle, startAt, startAfter)
+		var streamErr *streamError
+		if errors.As(err, &streamErr) {
+			err = streamErr.err
+			if d.ctx.Err() != nil {
+				return nil
+			}
+			if !resumable(err) || (d.MaxReconnects > 0 && d.attempt >= d.MaxReconnects) {
+				return err
+			}
+			d.attempt++
+			delay := d.backoff(d.attempt)
+			d.Log.Warn("change stream lost, reconnecting", "collection", d.Coll.Name(), "error", err, "attempt", d.attempt, "retry", delay)
+			if d.OnReconnect != nil {
+				d.OnReconnect(ReconnectAttempt{Collection: d.Coll.Name(), Attempt: d.attempt, Delay: delay, Err: err})
+			}
+			select {
+			case <-d.ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+			if d.last != nil {
+				startAt, startAfter = nil, d.last
+			}
+			continue
+		}
+		startAfter = nil
+		d.last = nil
+		if err == nil && rename == nil && d.invalidated != nil {
+			invalidated := d.invalidated
+			d.invalidated = nil
+			if !d.ReopenOnDrop {
+				return ErrInvalidated
+			}
+			d.Log.Info("collection dropped, watching it again", "collection", d.Coll.Name())
+			startAt = &primitive.Timestamp{T: invalidated.T, I: invalidated.I + 1}
+			continue
+		}
+		if err != nil || rename == nil || !d.FollowRename {
 			return err
 		}
 
-		for _, item := range temp {
-			if item.Key == "operationType" {
-				if item.Value == "update" {
-					updateResult = UpdateEvent{}
-					bsonBytes, err := bson.Marshal(temp)
-					if err != nil {
-						log.Fatal(err)
-						return err
-					}
-					bson.Unmarshal(bsonBytes, &updateResult)
-				} else if item.Value == "insert" {
-					createResult = CreateEvent{}
-					bsonBytes, err := bson.Marshal(temp)
-					if err != nil {
-						log.Fatal(err)
-						return err
-					}
-					bson.Unmarshal(bsonBytes, &createResult)
+		d.Log.Info("following rename", "from", rename.NS.Coll, "to", rename.To.Coll)
+		d.collMux.Lock()
+		d.DB = d.Client.Database(rename.To.DB)
+		d.Coll = d.DB.Collection(rename.To.Coll)
+		d.collMux.Unlock()
+		startAt = &primitive.Timestamp{T: rename.ClusterTime.T, I: rename.ClusterTime.I + 1}
+	}
+}
+
+// idle waits for Disconnect() without watching the collection, which
+// another instance of the cluster owns, with a heartbeat every second
+// like an idle change stream.
+func (d *DB) idle() {
+	d.Log.Info("collection owned by another instance, not watched", "collection", d.Coll.Name())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		d.beat()
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watch watches the collection until the change stream ends, the
+// handle function fails or the collection is renamed, in which case
+// the rename is handed to the handle function and returned.
+//
+// # Parameters:
+//
+// 	- handle (func(event.Event) error): the function called for every change.
+// 	- startAt (*primitive.Timestamp): the cluster time to watch from, nil for now.
+// 	- startAfter (bson.Raw): the resume token to watch after, nil for none.
+//
+// # Example:
+//
+// 	rename, err := d.watch(handle, nil, nil)
+func (d *DB) watch(handle func(event.Event) error, startAt *primitive.Timestamp, startAfter bson.Raw) (*RenameEvent, error) {
+	d.collMux.Lock()
+	coll := d.Coll
+	d.collMux.Unlock()
+	opts := options.ChangeStream()
+	if d.BatchSize > 0 {
+		opts.SetBatchSize(d.BatchSize)
+	}
+	if d.MaxAwaitTime > 0 {
+		opts.SetMaxAwaitTime(d.MaxAwaitTime)
+	}
+	if d.Collation != nil {
+		opts.SetCollation(*d.Collation)
+	}
+	if d.ShowExpandedEvents {
+		opts.SetShowExpandedEvents(true)
+	}
+	if d.UpdateLookup {
+		opts.SetFullDocument(options.UpdateLookup)
+	}
+	if startAt != nil {
+		opts.SetStartAtOperationTime(startAt)
+	} else if startAfter != nil {
+		opts.SetStartAfter(startAfter)
+	}
+	pipeline := d.pipeline(coll.Name())
+	changeStream, err := coll.Watch(d.ctx, pipeline, opts)
+	if err != nil {
+		return nil, &streamError{err}
+	}
+	defer func() {
+		changeStream.Close(context.Background())
+	}()
+	d.remember(changeStream)
+
+	checked := time.Now()
+	for {
+		if (d.Filters != nil || d.DocumentIDs != nil) && time.Since(checked) >= filterInterval {
+			checked = time.Now()
+			next := d.pipeline(coll.Name())
+			if !samePipeline(pipeline, next) {
+				// The stream is reopened right after the last change
+				// read, so that no change is missed or read twice.
+				if token := changeStream.ResumeToken(); token != nil {
+					opts.StartAtOperationTime = nil
+					opts.SetStartAfter(token)
 				}
+				// The old stream is only closed once the new one is
+				// open, the deferred Close closes it otherwise.
+				reopened, err := coll.Watch(d.ctx, next, opts)
+				if err != nil {
+					return nil, &streamError{err}
+				}
+				changeStream.Close(context.Background())
+				changeStream, pipeline = reopened, next
+				d.Log.Debug("change stream filters changed", "collection", coll.Name())
 			}
 		}
-		
-		if updateResult.OperationType == "update" {
-			var responseMap = make(map[string]string)
-			fmt.Println("Update event")
-			for key, value := range updateResult.UpdateDescription.UpdatedFields {
-				for _, k := range keys {
-					if key == k {
-						responseMap[key] = fmt.Sprintf("%v", value)
-					}
-				}
+
+		if !changeStream.TryNext(d.ctx) {
+			if err := changeStream.Err(); err != nil {
+				d.checkpoint(changeStream, true)
+				return nil, &streamError{err}
 			}
-			data, err := json.Marshal(responseMap)
-			if err != nil {
-				log.Fatal(err)
-				return err
+			if changeStream.ID() == 0 {
+				d.checkpoint(changeStream, true)
+				return nil, nil
 			}
-			ws.DispatchUpdate(data)
-		} else if createResult.OperationType == "insert" {
-			fmt.Println("Create event")
-			var responseMap = make(map[string]string)
-			for key, value := range createResult.FullDocument {
-				for _, k := range keys {
-					if key == k {
-						responseMap[key] = fmt.Sprintf("%v", value)
-					}
+			d.beat()
+			d.reconnected(coll)
+			d.remember(changeStream)
+			d.checkpoint(changeStream, false)
+			continue
+		}
+		d.beat()
+		d.reconnected(coll)
+
+		if d.Chaos.Disconnect() {
+			changeStream.Close(context.Background())
+			return nil, &streamError{chaos.ErrInjectedDisconnect}
+		}
+
+		rename, err := d.decode(changeStream, coll, handle)
+		if err != nil || rename != nil {
+			return rename, err
+		}
+		d.remember(changeStream)
+		d.checkpoint(changeStream, d.invalidated != nil)
+		if d.invalidated != nil {
+			return nil, nil
+		}
+	}
+}
+
+// remember records the resume token of the change stream as the one
+// it is reopened after, once the change it points to is handled.
+func (d *DB) remember(changeStream *mongo.ChangeStream) {
+	if token := changeStream.ResumeToken(); token != nil {
+		d.last = append(bson.Raw(nil), token...)
+	}
+}
+
+// reconnected resets the attempts to reopen the change stream, after
+// a round trip.
+func (d *DB) reconnected(coll *mongo.Collection) {
+	if d.attempt > 0 {
+		d.Log.Info("change stream reconnected", "collection", coll.Name(), "attempts", d.attempt)
+		d.attempt = 0
+	}
+}
+
+// backoff returns the delay before the given attempt to reopen the
+// change stream, a random duration up to MinBackoff doubled per
+// attempt, capped at MaxBackoff.
+func (d *DB) backoff(attempt int) time.Duration {
+	minBackoff, maxBackoff := d.MinBackoff, d.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefaultMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	ceiling := maxBackoff
+	if attempt < 32 {
+		delay := minBackoff << uint(attempt-1)
+		if delay > 0 && delay < ceiling {
+			ceiling = delay
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// resumable reports whether the change stream can be reopened after
+// an error: a network error, a timeout, a server error labelled as
+// resumable or with one of the resumableCodes, or an injected
+// disconnect.
+func resumable(err error) bool {
+	if errors.Is(err, chaos.ErrInjectedDisconnect) || mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	if serverErr.HasErrorLabel("ResumableChangeStreamError") {
+		return true
+	}
+	for _, code := range resumableCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkpoint saves the resume token of the change stream to the Resume
+// store, when it changed, at most every resumeInterval unless forced.
+// A failed save is logged and reported, the next one is tried anyway.
+//
+// # Parameters:
+//
+// 	- changeStream (*mongo.ChangeStream): the change stream.
+// 	- force (bool): whether to save before resumeInterval is over.
+//
+// # Example:
+//
+// 	d.checkpoint(changeStream, false)
+func (d *DB) checkpoint(changeStream *mongo.ChangeStream, force bool) {
+	if d.Resume == nil || (!force && time.Since(d.checkpointed) < resumeInterval) {
+		return
+	}
+	token := changeStream.ResumeToken()
+	if token == nil || bytes.Equal(token, d.token) {
+		return
+	}
+
+	err := d.Resume.Save(token)
+	if err != nil {
+		d.Log.Warn("saving the resume token failed", "error", err)
+		if d.Report != nil {
+			d.Report(err, map[string]any{"component": "db", "collection": d.Coll.Name()})
+		}
+		return
+	}
+	d.token = append(bson.Raw(nil), token...)
+	d.checkpointed = time.Now()
+}
+
+// decode decodes the current change of the change stream and hands
+// it to the handle function, it returns the rename of the collection,
+// if the change is one. A change which can't be decoded, or a panic
+// while decoding or handling it, is reported and the change skipped,
+// so the stream keeps running.
+//
+// # Parameters:
+//
+// 	- changeStream (*mongo.ChangeStream): the change stream.
+// 	- coll (*mongo.Collection): the watched collection.
+// 	- handle (func(event.Event) error): the function called with the change.
+//
+// # Example:
+//
+// 	rename, err := d.decode(changeStream, coll, handle)
+func (d *DB) decode(changeStream *mongo.ChangeStream, coll *mongo.Collection, handle func(event.Event) error) (rename *RenameEvent, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := fmt.Errorf("panic: %v", r)
+			d.Log.Error("panic recovered", "collection", coll.Name(), "error", panicErr, "stack", string(debug.Stack()))
+			if d.Report != nil {
+				d.Report(panicErr, map[string]any{"component": "db", "collection": coll.Name()})
+			}
+			rename, err = nil, nil
+		}
+	}()
+
+	var updateResult UpdateEvent
+	var createResult CreateEvent
+	var ddlResult DDLEvent
+	var renameResult RenameEvent
+	var deleteResult DeleteEvent
+	var dropResult DropEvent
+	var temp bson.D
+	err = changeStream.Decode(&temp)
+	if err != nil {
+		return d.skip(err, coll)
+	}
+	var raw json.RawMessage
+	if d.RawChanges {
+		raw, err = bson.MarshalExtJSON(temp, false, false)
+		if err != nil {
+			return d.skip(err, coll)
+		}
+	}
+
+	for _, item := range temp {
+		if item.Key == "operationType" {
+			if item.Value == "update" {
+				updateResult = UpdateEvent{}
+				bsonBytes, err := bson.Marshal(temp)
+				if err != nil {
+					return d.skip(err, coll)
+				}
+				bson.Unmarshal(bsonBytes, &updateResult)
+			} else if item.Value == "insert" || item.Value == event.OpReplace {
+				createResult = CreateEvent{}
+				bsonBytes, err := bson.Marshal(temp)
+				if err != nil {
+					return d.skip(err, coll)
+				}
+				bson.Unmarshal(bsonBytes, &createResult)
+			} else if item.Value == event.OpDelete {
+				bsonBytes, err := bson.Marshal(temp)
+				if err != nil {
+					return d.skip(err, coll)
+				}
+				bson.Unmarshal(bsonBytes, &deleteResult)
+			} else if item.Value == event.OpDrop || item.Value == "dropDatabase" || item.Value == event.OpInvalidate {
+				bsonBytes, err := bson.Marshal(temp)
+				if err != nil {
+					return d.skip(err, coll)
+				}
+				bson.Unmarshal(bsonBytes, &dropResult)
+			} else if item.Value == event.OpRename {
+				bsonBytes, err := bson.Marshal(temp)
+				if err != nil {
+					return d.skip(err, coll)
+				}
+				bson.Unmarshal(bsonBytes, &renameResult)
+			} else if op, ok := item.Value.(string); ok && event.IsDDL(op) {
+				ddlResult = DDLEvent{}
+				bsonBytes, err := bson.Marshal(temp)
+				if err != nil {
+					return d.skip(err, coll)
 				}
+				bson.Unmarshal(bsonBytes, &ddlResult)
 			}
-			data, err := json.Marshal(responseMap)
-			if err != nil {
-				log.Fatal(err)
-				return err
+		}
+	}
+
+	if updateResult.OperationType == "update" {
+		d.Log.Debug("update event", "collection", coll.Name())
+		fields := updateResult.UpdateDescription.UpdatedFields
+		if d.UpdateLookup && updateResult.FullDocument != nil {
+			fields = updateResult.FullDocument
+		}
+		err := handle(event.Event{
+			OperationType: updateResult.OperationType,
+			Collection:    coll.Name(),
+			ClusterTime:   event.Timestamp{T: updateResult.ClusterTime.T, I: updateResult.ClusterTime.I},
+			Fields:        fields,
+			Truncated:     truncated(updateResult),
+			Removed:       updateResult.UpdateDescription.RemovedFields,
+			DocumentKey:   updateResult.DocumentKey,
+			FullDocument:  updateResult.FullDocument,
+			RawChange:     raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if createResult.OperationType == "insert" || createResult.OperationType == event.OpReplace {
+		d.Log.Debug("create event", "collection", coll.Name(), "op", createResult.OperationType)
+		err := handle(event.Event{
+			OperationType: createResult.OperationType,
+			Collection:    coll.Name(),
+			ClusterTime:   event.Timestamp{T: createResult.ClusterTime.T, I: createResult.ClusterTime.I},
+			Fields:        createResult.FullDocument,
+			DocumentKey:   createResult.DocumentKey,
+			FullDocument:  createResult.FullDocument,
+			RawChange:     raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if deleteResult.OperationType == event.OpDelete {
+		d.Log.Debug("delete event", "collection", coll.Name())
+		err := handle(event.Event{
+			OperationType: deleteResult.OperationType,
+			Collection:    coll.Name(),
+			ClusterTime:   event.Timestamp{T: deleteResult.ClusterTime.T, I: deleteResult.ClusterTime.I},
+			Fields:        map[string]any{},
+			DocumentKey:   deleteResult.DocumentKey,
+			RawChange:     raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if event.IsDDL(ddlResult.OperationType) {
+		d.Log.Debug("schema event", "collection", coll.Name(), "op", ddlResult.OperationType)
+		err := handle(event.Event{
+			OperationType: ddlResult.OperationType,
+			Collection:    coll.Name(),
+			ClusterTime:   event.Timestamp{T: ddlResult.ClusterTime.T, I: ddlResult.ClusterTime.I},
+			Fields:        ddlResult.OperationDescription,
+			RawChange:     raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if dropResult.OperationType == event.OpInvalidate {
+		d.Log.Info("change stream invalidated", "collection", coll.Name())
+		d.invalidated = &dropResult.ClusterTime
+		err := handle(event.Event{
+			OperationType: event.OpInvalidate,
+			Collection:    coll.Name(),
+			ClusterTime:   event.Timestamp{T: dropResult.ClusterTime.T, I: dropResult.ClusterTime.I},
+			Fields:        map[string]any{"reopened": d.ReopenOnDrop},
+			RawChange:     raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if dropResult.OperationType != "" {
+		d.Log.Info("collection dropped", "collection", coll.Name(), "op", dropResult.OperationType)
+		err := handle(event.Event{
+			OperationType: event.OpDrop,
+			Collection:    coll.Name(),
+			ClusterTime:   event.Timestamp{T: dropResult.ClusterTime.T, I: dropResult.ClusterTime.I},
+			Fields:        map[string]any{"ns": dropResult.NS.DB + "." + coll.Name()},
+			RawChange:     raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if renameResult.OperationType == event.OpRename {
+		d.Log.Info("collection renamed", "collection", coll.Name(), "to", renameResult.To.Coll)
+		err := handle(event.Event{
+			OperationType: renameResult.OperationType,
+			Collection:    coll.Name(),
+			ClusterTime:   event.Timestamp{T: renameResult.ClusterTime.T, I: renameResult.ClusterTime.I},
+			Fields: map[string]any{
+				"from": renameResult.NS.DB + "." + renameResult.NS.Coll,
+				"to":   renameResult.To.DB + "." + renameResult.To.Coll,
+			},
+			RawChange: raw,
+		})
+		changeStream.Close(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return &renameResult, nil
+	} else if raw != nil {
+		return nil, handle(rawEvent(temp, coll.Name(), raw))
+	}
+
+	return nil, nil
+}
+
+// skip logs and reports a change which can't be decoded, which is
+// skipped so that the stream keeps running.
+//
+// # Parameters:
+//
+// 	- err (error): the decoding failure.
+// 	- coll (*mongo.Collection): the watched collection.
+//
+// # Example:
+//
+// 	return d.skip(err, coll)
+func (d *DB) skip(err error, coll *mongo.Collection) (*RenameEvent, error) {
+	err = fmt.Errorf("db: decoding a change: %w", err)
+	d.Log.Error("change skipped", "collection", coll.Name(), "error", err)
+	if d.Report != nil {
+		d.Report(err, map[string]any{"component": "db", "collection": coll.Name()})
+	}
+
+	return nil, nil
+}
+
+// rawEvent returns the event of a change the other events don't
+// describe, like a delete, carrying its operation type, its cluster
+// time and its document key along with the raw change.
+//
+// # Parameters:
+//
+// 	- change (bson.D): the change document.
+// 	- collName (string): the name of the watched collection.
+// 	- raw (json.RawMessage): the change as Extended JSON.
+//
+// # Example:
+//
+// 	err := handle(rawEvent(temp, coll.Name(), raw))
+func rawEvent(change bson.D, collName string, raw json.RawMessage) event.Event {
+	ev := event.Event{Collection: collName, RawChange: raw}
+	for _, item := range change {
+		switch item.Key {
+		case "operationType":
+			ev.OperationType, _ = item.Value.(string)
+		case "clusterTime":
+			if ts, ok := item.Value.(primitive.Timestamp); ok {
+				ev.ClusterTime = event.Timestamp{T: ts.T, I: ts.I}
+			}
+		case "documentKey":
+			if key, ok := item.Value.(bson.D); ok {
+				ev.DocumentKey = make(map[string]any, len(key))
+				for _, field := range key {
+					ev.DocumentKey[field.Key] = field.Value
+				}
 			}
-			ws.DispatchUpdate(data)
 		}
 	}
 
-	return nil
+	return ev
+}
+
+// truncated returns the new sizes of the arrays truncated by an
+// update by field name, nil when none was truncated.
+func truncated(ev UpdateEvent) map[string]int {
+	if len(ev.UpdateDescription.TruncatedArrays) == 0 {
+		return nil
+	}
+	sizes := make(map[string]int, len(ev.UpdateDescription.TruncatedArrays))
+	for _, t := range ev.UpdateDescription.TruncatedArrays {
+		sizes[t.Field] = t.NewSize
+	}
+
+	return sizes
+}
+
+// OnHeartbeat sets the function called after every round trip of the
+// change stream, it has to be called before Listen().
+//
+// # Parameters:
+//
+// 	- heartbeat (func()): the function to call.
+//
+// # Example:
+//
+// 	db.OnHeartbeat(func() { last.Store(time.Now().UnixNano()) })
+func (d *DB) OnHeartbeat(heartbeat func()) {
+	d.heartbeat = heartbeat
+}
+
+// StartAt makes the change stream start at an operation time in the
+// past, within the oplog window, instead of now, so that the changes
+// since then are dispatched first. It has to be called before Listen().
+//
+// # Parameters:
+//
+// 	- t (time.Time): the operation time, to the second.
+//
+// # Example:
+//
+// 	db.StartAt(time.Now().Add(-time.Hour))
+func (d *DB) StartAt(t time.Time) {
+	d.startAt = t
+}
+
+// ResumeAt makes the change stream resume right after the change of
+// a cluster time, instead of now, like the checkpoint of a failover.
+// It has to be called before Listen().
+//
+// # Parameters:
+//
+// 	- ts (event.Timestamp): the cluster time of the last change read.
+//
+// # Example:
+//
+// 	db.ResumeAt(lease.ClusterTime)
+func (d *DB) ResumeAt(ts event.Timestamp) {
+	d.resumeAt = &primitive.Timestamp{T: ts.T, I: ts.I + 1}
+}
+
+// beat calls the heartbeat function, if any.
+func (d *DB) beat() {
+	if d.heartbeat != nil {
+		d.heartbeat()
+	}
+}
+
+// Ping checks that the database can be reached.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the round trip to the database.
+//
+// # Example:
+//
+// 	err := db.Ping(ctx)
+func (d *DB) Ping(ctx context.Context) error {
+	return d.Client.Ping(ctx, nil)
+}
+
+// Collections returns the names of the watched collections.
+//
+// # Example:
+//
+// 	db.Collections() // []string{"mycollection"}
+func (d *DB) Collections() []string {
+	d.collMux.Lock()
+	defer d.collMux.Unlock()
+
+	return []string{d.Coll.Name()}
 }
 
-// Disconnect ends the connection to the database.
+// Disconnect ends the change stream and the connection to the
+// database, unless the client was given to New().
 //
 // This method is called internally when the socketeer is stopped.
 //
@@ -204,11 +1098,215 @@ func (d *DB) Listen(ws *ws.WebSocket, keys []string) error {
 //
 // 	db.Disconnect()
 func (d *DB) Disconnect() error {
+	d.cancel()
+	if d.borrowed {
+		return nil
+	}
 	err := d.Client.Disconnect(context.Background())
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
 
 	return nil
 }
+
+// pipeline returns the pipeline of the change stream of a collection,
+// which filters the changes with the Filters of its topic: a change is
+// read when its full document matches one of the filters, or when it
+// has no full document, like the updates without a lookup, the deletes
+// and the schema operations, which are filtered by the subscriptions.
+// Every change is read when there is no Filters, or a subscription to
+// the topic without filter, or a client without subscription. The
+// values are compared as strings, like the data of the messages.
+//
+// The changes are then restricted to the DocumentIDs, but for the ones
+// without document key, like the schema operations, and to the Match,
+// then go through the stages of the Pipeline last.
+//
+// # Parameters:
+//
+// 	- coll (string): the name of the collection.
+//
+// # Example:
+//
+// 	changeStream, err := coll.Watch(ctx, d.pipeline(coll.Name()), opts)
+func (d *DB) pipeline(coll string) mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if d.DocumentIDs != nil {
+		if ids := d.DocumentIDs(); ids != nil {
+			or := bson.A{
+				bson.D{{Key: "documentKey._id", Value: bson.D{{Key: "$in", Value: ids}}}},
+				bson.D{{Key: "documentKey", Value: bson.D{{Key: "$exists", Value: false}}}},
+			}
+			pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: or}}}})
+		}
+	}
+	if d.Match != nil {
+		if stage := d.Match.stage(); stage != nil {
+			pipeline = append(pipeline, stage)
+		}
+	}
+	if d.Filters != nil {
+		filters := d.Filters()
+		fs, ok := filters[coll]
+		if filters != nil && (!ok || fs != nil) {
+			or := bson.A{bson.D{{Key: "fullDocument", Value: nil}}}
+			for _, f := range fs {
+				or = append(or, filterExpr(f, "fullDocument."))
+			}
+			pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: or}}}})
+		}
+	}
+
+	return append(pipeline, d.Pipeline...)
+}
+
+// Match is a declarative filter of the changes, compiled into a $match
+// stage of the change stream so that the server only sends the
+// matching ones. A change matches when it matches every criterion set.
+// The operations on the collection, like the renames, the drops and
+// the schema operations, which have no document, always match, so that
+// the change stream still follows them.
+//
+// 	- Operations are the operation types matched, example: "insert",
+// 		every one when empty.
+// 	- Fields are the values of the fields of the full document matched,
+// 		by dotted path, example: {"status": "paid", "address.country":
+// 		"FR"}. The updates have a full document with an update lookup
+// 		only, and the deletes have none, so they never match them.
+// 	- KeyPrefixes are the prefixes of the string _id of the documents
+// 		matched, one of them at least, example: "acme:".
+type Match struct {
+	Operations  []string
+	Fields      map[string]any
+	KeyPrefixes []string
+}
+
+// stage returns the $match stage of the criteria, nil without any.
+//
+// # Example:
+//
+// 	pipeline = append(pipeline, d.Match.stage())
+func (m *Match) stage() bson.D {
+	and := bson.A{}
+	if len(m.Operations) > 0 {
+		and = append(and, bson.D{{Key: "operationType", Value: bson.D{{Key: "$in", Value: m.Operations}}}})
+	}
+	fields := make([]string, 0, len(m.Fields))
+	for field := range m.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		and = append(and, bson.D{{Key: "fullDocument." + field, Value: m.Fields[field]}})
+	}
+	if len(m.KeyPrefixes) > 0 {
+		prefixes := bson.A{}
+		for _, prefix := range m.KeyPrefixes {
+			prefixes = append(prefixes, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)})
+		}
+		and = append(and, bson.D{{Key: "documentKey._id", Value: bson.D{{Key: "$in", Value: prefixes}}}})
+	}
+	if len(and) == 0 {
+		return nil
+	}
+
+	or := bson.A{
+		bson.D{{Key: "$and", Value: and}},
+		bson.D{{Key: "documentKey", Value: bson.D{{Key: "$exists", Value: false}}}},
+	}
+
+	return bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: or}}}}
+}
+
+// filterExpr returns the query matching the documents whose fields,
+// converted to strings, equal the values of a filter, every document
+// for an empty filter.
+//
+// # Parameters:
+//
+// 	- f (event.Filter): the filter.
+// 	- prefix (string): the path of the document, example: "fullDocument."
+// 		for the changes, empty for the documents of a collection.
+//
+// # Example:
+//
+// 	match := filterExpr(event.Filter{"tenant": "acme"}, "fullDocument.")
+func filterExpr(f event.Filter, prefix string) bson.D {
+	if len(f) == 0 {
+		return bson.D{}
+	}
+	fields := make([]string, 0, len(f))
+	for field := range f {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	and := bson.A{}
+	for _, field := range fields {
+		value := bson.D{
+			{Key: "input", Value: "$" + prefix + field},
+			{Key: "to", Value: "string"},
+			{Key: "onError", Value: nil},
+			{Key: "onNull", Value: nil},
+		}
+		and = append(and, bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$convert", Value: value}}, f[field]}}})
+	}
+
+	return bson.D{{Key: "$expr", Value: bson.D{{Key: "$and", Value: and}}}}
+}
+
+// Find returns the documents of a watched collection matching a filter,
+// compared like the filters of the subscriptions, in their natural order.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the query.
+// 	- coll (string): the name of the collection.
+// 	- filter (event.Filter): the filter, every document when empty.
+// 	- fields ([]string): the projected fields, every field when nil,
+// 		the _id is always returned.
+// 	- limit (int64): the maximal number of documents, 0 for no limit.
+//
+// # Example:
+//
+// 	docs, err := d.Find(ctx, "posts", event.Filter{"tenant": "acme"}, []string{"title"}, 100)
+func (d *DB) Find(ctx context.Context, coll string, filter event.Filter, fields []string, limit int64) ([]map[string]any, error) {
+	d.collMux.Lock()
+	db := d.DB
+	d.collMux.Unlock()
+
+	opts := options.Find().SetLimit(limit)
+	if fields != nil {
+		projection := bson.D{}
+		for _, field := range fields {
+			projection = append(projection, bson.E{Key: field, Value: 1})
+		}
+		opts.SetProjection(projection)
+	}
+	cursor, err := db.Collection(coll).Find(ctx, filterExpr(filter, ""), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var docs []map[string]any
+	for cursor.Next(ctx) {
+		var doc map[string]any
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, cursor.Err()
+}
+
+// samePipeline reports whether two pipelines are equal.
+func samePipeline(a mongo.Pipeline, b mongo.Pipeline) bool {
+	x, errA := bson.Marshal(bson.D{{Key: "pipeline", Value: a}})
+	y, errB := bson.Marshal(bson.D{{Key: "pipeline", Value: b}})
+
+	return errA == nil && errB == nil && bytes.Equal(x, y)
+}
@@ -0,0 +1,373 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/chaos"
+	"github.com/darthsalad/socketeer/internal/event"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// extJSON returns a pipeline as canonical Extended JSON, to compare it.
+func extJSON(t *testing.T, pipeline mongo.Pipeline) string {
+	t.Helper()
+	doc, err := bson.MarshalExtJSON(bson.D{{Key: "pipeline", Value: pipeline}}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(doc)
+}
+
+func TestPipeline(t *testing.T) {
+	project := bson.D{{Key: "$project", Value: bson.D{{Key: "fullDocument.secret", Value: 0}}}}
+	custom := bson.D{{Key: "$match", Value: bson.D{{Key: "operationType", Value: "insert"}}}}
+	tests := []struct {
+		name string
+		db   *DB
+		want string
+	}{
+		{"empty", &DB{}, `{"pipeline":[]}`},
+		{
+			"custom stages in order",
+			&DB{Pipeline: mongo.Pipeline{custom, project}},
+			`{"pipeline":[{"$match":{"operationType":"insert"}},{"$project":{"fullDocument.secret":{"$numberInt":"0"}}}]}`,
+		},
+		{
+			"custom stages after the generated ones",
+			&DB{
+				DocumentIDs: func() []any { return []any{"a1"} },
+				Match:       &Match{Operations: []string{"update"}},
+				Filters: func() map[string][]event.Filter {
+					return map[string][]event.Filter{"orders": {{"tenant": "acme"}}}
+				},
+				Pipeline: mongo.Pipeline{project},
+			},
+			`{"pipeline":[` +
+				`{"$match":{"$or":[{"documentKey._id":{"$in":["a1"]}},{"documentKey":{"$exists":false}}]}},` +
+				`{"$match":{"$or":[{"$and":[{"operationType":{"$in":["update"]}}]},{"documentKey":{"$exists":false}}]}},` +
+				`{"$match":{"$or":[{"fullDocument":null},{"$expr":{"$and":[{"$eq":[` +
+				`{"$convert":{"input":"$fullDocument.tenant","to":"string","onError":null,"onNull":null}},"acme"]}]}}]}},` +
+				`{"$project":{"fullDocument.secret":{"$numberInt":"0"}}}]}`,
+		},
+		{
+			"no filters of the other topics",
+			&DB{
+				Filters: func() map[string][]event.Filter {
+					return map[string][]event.Filter{"orders": nil}
+				},
+				Pipeline: mongo.Pipeline{custom},
+			},
+			`{"pipeline":[{"$match":{"operationType":"insert"}}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extJSON(t, tt.db.pipeline("orders")); got != tt.want {
+				t.Errorf("pipeline() = %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchStage(t *testing.T) {
+	tests := []struct {
+		name  string
+		match Match
+		want  string
+	}{
+		{"no criteria", Match{Fields: map[string]any{}}, ``},
+		{
+			"operations",
+			Match{Operations: []string{"insert", "delete"}},
+			`{"$match":{"$or":[{"$and":[{"operationType":{"$in":["insert","delete"]}}]},{"documentKey":{"$exists":false}}]}}`,
+		},
+		{
+			"fields sorted",
+			Match{Fields: map[string]any{"status": "paid", "region": "eu"}},
+			`{"$match":{"$or":[{"$and":[{"fullDocument.region":"eu"},{"fullDocument.status":"paid"}]},{"documentKey":{"$exists":false}}]}}`,
+		},
+		{
+			"key prefixes quoted",
+			Match{KeyPrefixes: []string{"a.b", "c"}},
+			`{"$match":{"$or":[{"$and":[{"documentKey._id":{"$in":[` +
+				`{"$regularExpression":{"pattern":"^a\\.b","options":""}},` +
+				`{"$regularExpression":{"pattern":"^c","options":""}}]}}]},{"documentKey":{"$exists":false}}]}}`,
+		},
+		{
+			"all criteria",
+			Match{Operations: []string{"update"}, Fields: map[string]any{"status": "paid"}, KeyPrefixes: []string{"ord-"}},
+			`{"$match":{"$or":[{"$and":[` +
+				`{"operationType":{"$in":["update"]}},` +
+				`{"fullDocument.status":"paid"},` +
+				`{"documentKey._id":{"$in":[{"$regularExpression":{"pattern":"^ord-","options":""}}]}}]},` +
+				`{"documentKey":{"$exists":false}}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stage := tt.match.stage()
+			if tt.want == "" {
+				if stage != nil {
+					t.Errorf("stage() = %v, want nil", stage)
+				}
+				return
+			}
+			doc, err := bson.MarshalExtJSON(stage, true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(doc) != tt.want {
+				t.Errorf("stage() = %s\nwant %s", doc, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max time.Duration
+		attempt  int
+		ceiling  time.Duration
+	}{
+		{"defaults first attempt", 0, 0, 1, DefaultMinBackoff},
+		{"defaults third attempt", 0, 0, 3, 4 * DefaultMinBackoff},
+		{"defaults capped", 0, 0, 8, DefaultMaxBackoff},
+		{"custom doubling", 100 * time.Millisecond, time.Second, 4, 800 * time.Millisecond},
+		{"custom capped", 100 * time.Millisecond, time.Second, 5, time.Second},
+		{"shift overflow", time.Second, time.Minute, 40, time.Minute},
+		{"many attempts", time.Second, time.Minute, 1000, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DB{MinBackoff: tt.min, MaxBackoff: tt.max}
+			lowest, highest := tt.ceiling, time.Duration(0)
+			for i := 0; i < 2000; i++ {
+				delay := d.backoff(tt.attempt)
+				if delay <= 0 || delay > tt.ceiling {
+					t.Fatalf("backoff(%d) = %s, want within (0, %s]", tt.attempt, delay, tt.ceiling)
+				}
+				if delay < lowest {
+					lowest = delay
+				}
+				if delay > highest {
+					highest = delay
+				}
+			}
+			if lowest > tt.ceiling/4 || highest < tt.ceiling*3/4 {
+				t.Errorf("backoff(%d) between %s and %s, want a jitter over (0, %s]", tt.attempt, lowest, highest, tt.ceiling)
+			}
+		})
+	}
+}
+
+func TestResumable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"injected disconnect", fmt.Errorf("watch: %w", chaos.ErrInjectedDisconnect), true},
+		{"stepdown code", mongo.CommandError{Code: 11602, Message: "InterruptedDueToReplStateChange"}, true},
+		{"not primary code", mongo.CommandError{Code: 10107, Message: "NotWritablePrimary"}, true},
+		{"resumable label", mongo.CommandError{Code: 1, Labels: []string{"ResumableChangeStreamError"}}, true},
+		{"history lost", mongo.CommandError{Code: 286, Message: "ChangeStreamHistoryLost"}, false},
+		{"unauthorized", mongo.CommandError{Code: 13, Message: "Unauthorized"}, false},
+		{"other error", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resumable(tt.err); got != tt.want {
+				t.Errorf("resumable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeMongo is a standalone server speaking enough of the wire protocol
+// for a change stream: the handshake, the aggregate opening the stream
+// and the getMore of its round trips.
+//
+// 	- ln is the listener of the server.
+// 	- mux guards the fields below.
+// 	- aggregates are the pipelines of the aggregates received, in order.
+// 	- fail is the number of the aggregate failing, 0 for none.
+type fakeMongo struct {
+	ln         net.Listener
+	mux        sync.Mutex
+	aggregates []bson.Raw
+	fail       int
+}
+
+func newFakeMongo(t *testing.T) *fakeMongo {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeMongo{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+
+	return f
+}
+
+// client returns a client connected directly to the server.
+func (f *fakeMongo) client(t *testing.T) *mongo.Client {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://"+f.ln.Addr().String()+"/?directConnection=true").
+		SetServerSelectionTimeout(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	return client
+}
+
+// handle answers the messages of a connection, an OP_QUERY for the
+// legacy handshake and OP_MSG for the other commands.
+func (f *fakeMongo) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var header [16]byte
+		_, err := io.ReadFull(conn, header[:])
+		if err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header[:4])-16)
+		_, err = io.ReadFull(conn, body)
+		if err != nil {
+			return
+		}
+		requestID := binary.LittleEndian.Uint32(header[4:8])
+
+		var cmd bson.Raw
+		switch opCode := binary.LittleEndian.Uint32(header[12:]); opCode {
+		case 2004:
+			// flags, then the name of the collection before the skip
+			// and the number of documents returned.
+			name := bytes.IndexByte(body[4:], 0)
+			cmd = bson.Raw(body[4+name+1+8:])
+		case 2013:
+			// flags, then a single section of kind 0.
+			cmd = bson.Raw(body[5:])
+		default:
+			return
+		}
+		reply, err := bson.Marshal(f.reply(cmd))
+		if err != nil {
+			return
+		}
+
+		var msg []byte
+		if binary.LittleEndian.Uint32(header[12:]) == 2004 {
+			// OP_REPLY: flags, cursor id, starting from, number returned.
+			msg = append(make([]byte, 16+20), reply...)
+			binary.LittleEndian.PutUint32(msg[12:], 1)
+			binary.LittleEndian.PutUint32(msg[32:], 1)
+		} else {
+			msg = append(make([]byte, 16+5), reply...)
+			binary.LittleEndian.PutUint32(msg[12:], 2013)
+		}
+		binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+		binary.LittleEndian.PutUint32(msg[8:], requestID)
+		_, err = conn.Write(msg)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// reply returns the answer to a command.
+func (f *fakeMongo) reply(cmd bson.Raw) bson.D {
+	elems, _ := cmd.Elements()
+	token := bson.D{{Key: "_data", Value: "00"}}
+	switch elems[0].Key() {
+	case "aggregate":
+		f.mux.Lock()
+		f.aggregates = append(f.aggregates, cmd.Lookup("pipeline").Array())
+		failed := len(f.aggregates) == f.fail
+		f.mux.Unlock()
+		if failed {
+			return bson.D{{Key: "ok", Value: 0}, {Key: "code", Value: 2}, {Key: "codeName", Value: "BadValue"}, {Key: "errmsg", Value: "aggregate failed"}}
+		}
+
+		return bson.D{{Key: "ok", Value: 1}, {Key: "cursor", Value: bson.D{
+			{Key: "id", Value: int64(1)},
+			{Key: "ns", Value: "db.orders"},
+			{Key: "firstBatch", Value: bson.A{}},
+			{Key: "postBatchResumeToken", Value: token},
+		}}}
+	case "getMore":
+		time.Sleep(20 * time.Millisecond)
+
+		return bson.D{{Key: "ok", Value: 1}, {Key: "cursor", Value: bson.D{
+			{Key: "id", Value: int64(1)},
+			{Key: "ns", Value: "db.orders"},
+			{Key: "nextBatch", Value: bson.A{}},
+			{Key: "postBatchResumeToken", Value: token},
+		}}}
+	case "hello", "isMaster", "ismaster":
+		return bson.D{
+			{Key: "ok", Value: 1},
+			{Key: "ismaster", Value: true},
+			{Key: "helloOk", Value: true},
+			{Key: "minWireVersion", Value: 0},
+			{Key: "maxWireVersion", Value: 17},
+			{Key: "maxBsonObjectSize", Value: 16 * 1024 * 1024},
+			{Key: "maxMessageSizeBytes", Value: 48000000},
+			{Key: "maxWriteBatchSize", Value: 100000},
+		}
+	}
+
+	return bson.D{{Key: "ok", Value: 1}}
+}
+
+func TestWatchReopenFails(t *testing.T) {
+	f := newFakeMongo(t)
+	f.fail = 2
+	d := New(f.client(t), "db", "orders")
+	defer d.Disconnect()
+	var ids atomic.Int32
+	ids.Store(1)
+	d.DocumentIDs = func() []any { return []any{ids.Load()} }
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ids.Store(2)
+	}()
+	_, err := d.watch(func(event.Event) error { return nil }, nil, nil)
+	var streamErr *streamError
+	if !errors.As(err, &streamErr) || !strings.Contains(err.Error(), "aggregate failed") {
+		t.Fatalf("watch() = %v, want the error of the reopened stream", err)
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if len(f.aggregates) != 2 {
+		t.Errorf("%d aggregates, want 2", len(f.aggregates))
+	}
+}
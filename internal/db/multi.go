@@ -0,0 +1,372 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoCollection is returned by ConnectMulti() without a collection.
+var ErrNoCollection = errors.New("db: no collection")
+
+// Multi watches several collections of a database with a change stream
+// per collection, sharing a client, and hands their changes to the
+// handle function one at a time, every event carrying its collection.
+//
+// 	- DBs are the watchers of the collections, configured like a DB.
+// 	- Client is the client shared by the watchers.
+// 	- borrowed is whether the Client belongs to the application, it
+// 		is then left connected by Disconnect().
+type Multi struct {
+	DBs      []*DB
+	Client   *mongo.Client
+	borrowed bool
+}
+
+// ConnectMulti returns a new Multi type by connecting to the database
+// with fully built client options, to watch several collections.
+//
+// # Parameters:
+//
+// 	- clientOptions (*options.ClientOptions): the options of the client.
+// 	- dbName (string): the name of the database to connect to.
+// 	- collNames ([]string): the names of the collections to watch.
+//
+// # Example:
+//
+// 	db.ConnectMulti(options.Client().ApplyURI(uri), "mydb", []string{"posts", "comments"})
+func ConnectMulti(clientOptions *options.ClientOptions, dbName string, collNames []string) (*Multi, error) {
+	if len(collNames) == 0 {
+		return nil, ErrNoCollection
+	}
+	d, err := ConnectWithOptions(clientOptions, dbName, collNames[0])
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMulti(d.Client, dbName, collNames)
+	m.borrowed = false
+
+	return m, nil
+}
+
+// NewMulti returns a new Multi type watching several collections with
+// a client connected by the caller, which is left connected by
+// Disconnect().
+//
+// # Parameters:
+//
+// 	- client (*mongo.Client): the connected client.
+// 	- dbName (string): the name of the database.
+// 	- collNames ([]string): the names of the collections to watch.
+//
+// # Example:
+//
+// 	db.NewMulti(client, "mydb", []string{"posts", "comments"})
+func NewMulti(client *mongo.Client, dbName string, collNames []string) *Multi {
+	m := &Multi{Client: client, borrowed: true}
+	for _, collName := range collNames {
+		m.DBs = append(m.DBs, New(client, dbName, collName))
+	}
+
+	return m
+}
+
+// Listen listens for changes in every collection, see DB.Listen(). The
+// handle function is never called concurrently. It returns once every
+// change stream ended, or as soon as one of them fails, the other ones
+// being ended then, with its error.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Parameters:
+//
+// 	- handle (func(event.Event) error): the function called for every change.
+//
+// # Example:
+//
+// 	m.Listen(func(ev event.Event) error {
+// 		fmt.Println(ev.Collection, ev.OperationType, ev.Fields)
+// 		return nil
+// 	})
+func (m *Multi) Listen(handle func(event.Event) error) error {
+	var handleMux sync.Mutex
+	serial := func(ev event.Event) error {
+		handleMux.Lock()
+		defer handleMux.Unlock()
+
+		return handle(ev)
+	}
+
+	errs := make(chan error, len(m.DBs))
+	for _, d := range m.DBs {
+		go func(d *DB) {
+			errs <- d.Listen(serial)
+		}(d)
+	}
+	var first error
+	for range m.DBs {
+		err := <-errs
+		if err != nil && first == nil {
+			first = err
+			for _, d := range m.DBs {
+				d.cancel()
+			}
+		}
+	}
+
+	return first
+}
+
+// Disconnect ends the change streams and the connection to the
+// database, unless the client was given to NewMulti().
+//
+// This method is called internally when the socketeer is stopped.
+//
+// # Example:
+//
+// 	m.Disconnect()
+func (m *Multi) Disconnect() error {
+	for _, d := range m.DBs {
+		d.Disconnect()
+	}
+	if m.borrowed {
+		return nil
+	}
+
+	return m.Client.Disconnect(context.Background())
+}
+
+// OnHeartbeat sets the function called after every round trip of the
+// change streams, see DB.OnHeartbeat().
+//
+// # Parameters:
+//
+// 	- heartbeat (func()): the function to call.
+//
+// # Example:
+//
+// 	m.OnHeartbeat(func() { last.Store(time.Now().UnixNano()) })
+func (m *Multi) OnHeartbeat(heartbeat func()) {
+	for _, d := range m.DBs {
+		d.OnHeartbeat(heartbeat)
+	}
+}
+
+// StartAt makes the change streams start at an operation time in the
+// past, see DB.StartAt().
+//
+// # Parameters:
+//
+// 	- t (time.Time): the operation time, to the second.
+//
+// # Example:
+//
+// 	m.StartAt(time.Now().Add(-time.Hour))
+func (m *Multi) StartAt(t time.Time) {
+	for _, d := range m.DBs {
+		d.StartAt(t)
+	}
+}
+
+// ResumeAt makes the change streams resume right after the change of
+// a cluster time, see DB.ResumeAt().
+//
+// # Parameters:
+//
+// 	- ts (event.Timestamp): the cluster time of the last change read.
+//
+// # Example:
+//
+// 	m.ResumeAt(lease.ClusterTime)
+func (m *Multi) ResumeAt(ts event.Timestamp) {
+	for _, d := range m.DBs {
+		d.ResumeAt(ts)
+	}
+}
+
+// SetResume persists the resume tokens of every change stream in a
+// single store, as a document of the tokens by collection. The token
+// of a single collection saved by a DB is taken as the token of the
+// first collection.
+//
+// # Parameters:
+//
+// 	- store (ResumeStore): the store.
+//
+// # Example:
+//
+// 	m.SetResume(store)
+func (m *Multi) SetResume(store ResumeStore) {
+	shared := &sharedResume{store: store, first: m.DBs[0].Coll.Name()}
+	for _, d := range m.DBs {
+		d.Resume = &collResume{shared: shared, coll: d.Coll.Name()}
+	}
+}
+
+// Ping checks that the database can be reached.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the round trip to the database.
+//
+// # Example:
+//
+// 	err := m.Ping(ctx)
+func (m *Multi) Ping(ctx context.Context) error {
+	return m.Client.Ping(ctx, nil)
+}
+
+// Collections returns the names of the watched collections.
+//
+// # Example:
+//
+// 	m.Collections() // []string{"posts", "comments"}
+func (m *Multi) Collections() []string {
+	var colls []string
+	for _, d := range m.DBs {
+		colls = append(colls, d.Collections()...)
+	}
+
+	return colls
+}
+
+// Find returns the documents of a collection of the database, see
+// DB.Find().
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the query.
+// 	- coll (string): the name of the collection.
+// 	- filter (event.Filter): the values of the fields of the documents.
+// 	- fields ([]string): the fields returned, nil for every field.
+// 	- limit (int64): the maximal number of documents, 0 for no limit.
+//
+// # Example:
+//
+// 	docs, err := m.Find(ctx, "posts", event.Filter{"tenant": "acme"}, []string{"title"}, 100)
+func (m *Multi) Find(ctx context.Context, coll string, filter event.Filter, fields []string, limit int64) ([]map[string]any, error) {
+	return m.DBs[0].Find(ctx, coll, filter, fields, limit)
+}
+
+// sharedResume keeps the resume tokens of several change streams in a
+// single store, as a document of the tokens by collection.
+//
+// 	- store is the store.
+// 	- first is the name of the first collection, which a token saved
+// 		by a DB watching a single collection belongs to.
+// 	- mux is a mutex for tokens and loaded.
+// 	- tokens are the tokens by collection, in the order of the document.
+// 	- loaded is whether tokens were loaded from the store.
+type sharedResume struct {
+	store  ResumeStore
+	first  string
+	mux    sync.Mutex
+	tokens []bson.E
+	loaded bool
+}
+
+// load loads the tokens from the store, once. A resume token, with
+// its _data string, is the token of the first collection saved before
+// the other collections were watched, it fails on any other document.
+func (s *sharedResume) load() error {
+	if s.loaded {
+		return nil
+	}
+	doc, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+	if doc != nil {
+		raw := bson.Raw(doc)
+		if _, ok := raw.Lookup("_data").StringValueOK(); ok {
+			s.tokens = []bson.E{{Key: s.first, Value: raw}}
+			s.loaded = true
+			return nil
+		}
+		elems, err := raw.Elements()
+		if err != nil {
+			return err
+		}
+		for _, elem := range elems {
+			token, ok := elem.Value().DocumentOK()
+			if !ok {
+				return fmt.Errorf("db: the resume token of %q is not a document", elem.Key())
+			}
+			s.tokens = append(s.tokens, bson.E{Key: elem.Key(), Value: token})
+		}
+	}
+	s.loaded = true
+
+	return nil
+}
+
+// collResume is the view of a sharedResume for a collection.
+//
+// 	- shared is the sharedResume.
+// 	- coll is the name of the collection.
+type collResume struct {
+	shared *sharedResume
+	coll   string
+}
+
+// Load returns the token of the collection, nil when none was saved.
+func (r *collResume) Load() ([]byte, error) {
+	s := r.shared
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range s.tokens {
+		if e.Key == r.coll {
+			return e.Value.(bson.Raw), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Save saves the token of the collection with the ones of the other
+// collections.
+func (r *collResume) Save(token []byte) error {
+	s := r.shared
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return err
+	}
+	tokens := make([]bson.E, 0, len(s.tokens)+1)
+	saved := false
+	for _, e := range s.tokens {
+		if e.Key == r.coll {
+			e.Value, saved = bson.Raw(token), true
+		}
+		tokens = append(tokens, e)
+	}
+	if !saved {
+		tokens = append(tokens, bson.E{Key: r.coll, Value: bson.Raw(token)})
+	}
+	doc, err := bson.Marshal(bson.D(tokens))
+	if err != nil {
+		return err
+	}
+	err = s.store.Save(doc)
+	if err != nil {
+		return err
+	}
+	s.tokens = tokens
+
+	return nil
+}
@@ -0,0 +1,267 @@
+// Internal package for the change event model shared by
+// the database listener, the alternative sources and the
+// dispatch pipeline of the socketeer.
+//
+// This package only holds types, the socketeer package
+// re-exports them so that applications never have to
+// import an internal package.
+package event
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Timestamp is a MongoDB cluster time, the seconds since the epoch
+// and an increment ordering the operations within a second.
+//
+// 	- T is the number of seconds since the epoch.
+// 	- I is the increment.
+type Timestamp struct {
+	T uint32 `json:"t"`
+	I uint32 `json:"i"`
+}
+
+// Time returns the second of the timestamp as a time.Time.
+func (ts Timestamp) Time() time.Time {
+	return time.Unix(int64(ts.T), 0)
+}
+
+// IsZero reports whether the timestamp is unset.
+func (ts Timestamp) IsZero() bool {
+	return ts.T == 0 && ts.I == 0
+}
+
+// Operation types of the schema operations, they are only reported
+// by the change streams showing the expanded events.
+//
+// 	- OpCreateIndexes is the creation of indexes.
+// 	- OpDropIndexes is the removal of indexes.
+// 	- OpModify is a change of the options of the collection.
+// 	- OpShardCollection is the sharding of the collection.
+const (
+	OpCreateIndexes   = "createIndexes"
+	OpDropIndexes     = "dropIndexes"
+	OpModify          = "modify"
+	OpShardCollection = "shardCollection"
+)
+
+// OpRename is the operation type of the rename of a collection, the
+// fields of the event are the "from" and "to" namespaces, example:
+// {"from": "mydb.posts", "to": "mydb.articles"}.
+const OpRename = "rename"
+
+// Operation types of the end of the watched collection, the fields of
+// the events describe it.
+//
+// 	- OpDrop is the drop of the collection, or of its database, the
+// 		field "ns" is its namespace, example: {"ns": "mydb.posts"}.
+// 	- OpInvalidate is the end of the change stream which follows a
+// 		drop, the field "reopened" tells whether the collection is
+// 		watched again.
+const (
+	OpDrop       = "drop"
+	OpInvalidate = "invalidate"
+)
+
+// OpReplace is the operation type of the replacement of a document,
+// like an insert the event carries the new document as fields.
+const OpReplace = "replace"
+
+// OpDelete is the operation type of the deletion of a document, the
+// event carries its DocumentKey and no fields.
+const OpDelete = "delete"
+
+// OpSnapshot is the operation type of the snapshots of the current
+// documents of a collection, which carry the Documents instead of Data.
+const OpSnapshot = "snapshot"
+
+// IsDDL reports whether an operation type is a schema operation.
+//
+// # Parameters:
+//
+// 	- op (string): the operation type.
+//
+// # Example:
+//
+// 	event.IsDDL(ev.OperationType)
+func IsDDL(op string) bool {
+	switch op {
+	case OpCreateIndexes, OpDropIndexes, OpModify, OpShardCollection:
+		return true
+	}
+	return false
+}
+
+// IsCollectionOp reports whether an operation type is an operation on
+// the watched collection rather than on a document: a schema operation,
+// a rename, a drop or an invalidate. Their events carry the description
+// of the operation as fields, which is dispatched whole.
+//
+// # Parameters:
+//
+// 	- op (string): the operation type.
+//
+// # Example:
+//
+// 	event.IsCollectionOp(ev.OperationType)
+func IsCollectionOp(op string) bool {
+	switch op {
+	case OpRename, OpDrop, OpInvalidate:
+		return true
+	}
+	return IsDDL(op)
+}
+
+// Event is a change that happened in a watched collection.
+//
+// 	- OperationType is the type of operation, example: "insert", "update".
+// 	- Collection is the name of the collection the change happened in.
+// 	- ClusterTime is the cluster time of the change, zero when unknown.
+// 	- Fields are the fields carried by the change, the updated fields
+// 		for an update, the full document for an insert and the
+// 		description of the operation for a schema operation.
+// 	- Truncated are the new sizes of the arrays truncated by an
+// 		update, by field name.
+// 	- Removed are the fields removed by an update.
+// 	- DocumentKey is the _id (and shard key) of the changed document.
+// 	- FullDocument is the whole document, for an insert, or for an
+// 		update when the change source looks it up.
+// 	- RawChange is the complete change document of the source, as
+// 		relaxed Extended JSON, when the source forwards it.
+type Event struct {
+	OperationType string          `json:"op"`
+	Collection    string          `json:"collection"`
+	ClusterTime   Timestamp       `json:"clusterTime"`
+	Fields        map[string]any  `json:"fields"`
+	Truncated     map[string]int  `json:"truncated,omitempty"`
+	Removed       []string        `json:"removed,omitempty"`
+	DocumentKey   map[string]any  `json:"documentKey,omitempty"`
+	FullDocument  map[string]any  `json:"fullDocument,omitempty"`
+	RawChange     json.RawMessage `json:"rawChange,omitempty"`
+}
+
+// Actions of the array changes.
+//
+// 	- ArraySet is the element at Index set to Value.
+// 	- ArrayTruncate is the array truncated to Index elements.
+const (
+	ArraySet      = "set"
+	ArrayTruncate = "truncate"
+)
+
+// ArrayChange is a change of an element of an array field, example:
+// {"field": "items", "index": 3, "action": "set", "value": "{\"sku\":\"a1\"}"}
+//
+// 	- Field is the name of the array field.
+// 	- Index is the index of the changed element, or the new size of
+// 		the array for a truncation.
+// 	- Path is the changed field of the element, empty when the
+// 		whole element changed.
+// 	- Action is ArraySet or ArrayTruncate.
+// 	- Value is the new value, as JSON unless it is a string.
+type ArrayChange struct {
+	Field  string `json:"field"`
+	Index  int    `json:"index"`
+	Path   string `json:"path,omitempty"`
+	Action string `json:"action"`
+	Value  string `json:"value,omitempty"`
+}
+
+// Message is the update dispatched to clients for an event,
+// it is encoded according to the protocol version of every client.
+//
+// 	- Seq is the position of the message in the stream of the socketeer.
+// 	- Topic is the topic of the message, which is the collection name.
+// 	- OperationType is the type of operation of the event.
+// 	- ClusterTime is the cluster time of the event.
+// 	- Time is the wall-clock time the message was dispatched at.
+// 	- Data are the selected keys of the event.
+// 	- ArrayChanges are the changes of the selected array fields,
+// 		when they are normalized.
+// 	- DocumentKey is the _id (and shard key) of the changed document,
+// 		when it is included.
+// 	- FullDocument is the whole document, when it is included and known.
+// 	- Patch is the change as a JSON Merge Patch (RFC 7386) of the
+// 		selected keys, when it is enabled.
+// 	- Documents are the current documents of the topic, for a snapshot.
+// 	- RawChange is the complete change document of the source, as
+// 		relaxed Extended JSON, when it is forwarded.
+type Message struct {
+	Seq           uint64            `json:"seq"`
+	Topic         string            `json:"topic"`
+	OperationType string            `json:"op"`
+	ClusterTime   Timestamp         `json:"clusterTime"`
+	Time          time.Time         `json:"ts"`
+	Data          map[string]string `json:"data"`
+	ArrayChanges  []ArrayChange     `json:"arrayChanges,omitempty"`
+	DocumentKey   map[string]string `json:"documentKey,omitempty"`
+	FullDocument  map[string]string `json:"fullDocument,omitempty"`
+	Patch         json.RawMessage   `json:"patch,omitempty"`
+	Documents     []Document        `json:"documents,omitempty"`
+	RawChange     json.RawMessage   `json:"rawChange,omitempty"`
+}
+
+// Document is the current state of a document.
+//
+// 	- DocumentKey is the _id of the document.
+// 	- Data are the fields selected by the keys of its collection,
+// 		formatted like the data of the messages.
+type Document struct {
+	DocumentKey map[string]string `json:"documentKey"`
+	Data        map[string]string `json:"data"`
+}
+
+// Filter is the filter of a subscription, the field values a message
+// must have to be sent to the client, example: {"tenant": "acme"}.
+// The values are compared with the data of the message, then with its
+// full document and its document key when it carries them, a field
+// missing from all of them doesn't match. A nil Filter matches every message of the topic.
+type Filter map[string]string
+
+// Matches reports whether a message has every field value of the
+// filter, in its data, its full document or its document key, so
+// that a filter on the _id or the shard key matches the deletes.
+func (f Filter) Matches(msg Message) bool {
+	for field, want := range f {
+		value, ok := msg.Data[field]
+		if !ok {
+			value, ok = msg.FullDocument[field]
+		}
+		if !ok {
+			value, ok = msg.DocumentKey[field]
+		}
+		if !ok || value != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchesDocument reports whether the data of a document has every
+// field value of the filter.
+func (f Filter) MatchesDocument(doc Document) bool {
+	for field, want := range f {
+		value, ok := doc.Data[field]
+		if !ok || value != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Key returns a canonical representation of the filter, equal for
+// the equivalent filters.
+func (f Filter) Key() string {
+	fields := make([]string, 0, len(f))
+	for field, value := range f {
+		fields = append(fields, field+"\x00"+value)
+	}
+	sort.Strings(fields)
+
+	return strings.Join(fields, "\x01")
+}
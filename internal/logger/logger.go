@@ -0,0 +1,288 @@
+// Internal package for the logs of the socketeer.
+//
+// This package is used in the following way:
+//
+// 	1. Create a new Logger with New(), in the Text or JSON format
+// 		and with a minimal level.
+// 	2. Add the component to the logs of a package with With().
+// 	3. Log with Debug(), Info(), Warn() and Error(), passing the
+// 		attributes of the log as key-value pairs.
+//
+// # Example:
+//
+// 	log := logger.With(logger.New(os.Stderr, logger.JSON, logger.Info), "component", "ws")
+// 	log.Info("client connected", "connID", id)
+//
+// 	// {"time":"2023-11-14T22:13:20.5Z","level":"info","msg":"client connected","component":"ws","connID":"8f2c1e0a9b7d6c5e"}
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the logs.
+//
+// 	- Text writes a line per log, example:
+// 		2023/11/14 22:13:20 INFO client connected component=ws connID=8f2c1e0a9b7d6c5e
+// 	- JSON writes a JSON object per line, example:
+// 		{"time":"2023-11-14T22:13:20.5Z","level":"info","msg":"client connected","component":"ws"}
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Levels of the logs, from the most to the least verbose.
+//
+// 	- Debug logs every event, message and subscription.
+// 	- Info logs the lifecycle of the server and of the connections.
+// 	- Warn logs the recoverable failures, like evicted clients.
+// 	- Error logs the unexpected failures.
+// 	- Silent disables the logs.
+const (
+	LevelDebug  = "debug"
+	LevelInfo   = "info"
+	LevelWarn   = "warn"
+	LevelError  = "error"
+	LevelSilent = "silent"
+)
+
+// levels are the ranks of the levels, a log is written when
+// its rank is at least the rank of the level of the Logger.
+var levels = map[string]int{
+	LevelDebug:  0,
+	LevelInfo:   1,
+	LevelWarn:   2,
+	LevelError:  3,
+	LevelSilent: 4,
+}
+
+// Logger writes leveled logs with attributes given as key-value
+// pairs, its methods have the signatures of the slog.Logger methods.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Default is the Logger used when none is configured,
+// it writes the text logs of level info and above to the standard error.
+var Default Logger = New(os.Stderr, Text, LevelInfo)
+
+// writer is a Logger writing to an io.Writer.
+//
+// 	- out is where the logs are written to.
+// 	- format is the format of the logs, Text or JSON.
+// 	- min is the rank of the minimal level of the written logs.
+// 	- mux serializes the writes for thread safety.
+type writer struct {
+	out    io.Writer
+	format string
+	min    int
+	mux    sync.Mutex
+}
+
+// New returns a new Logger writing the logs of the given level and
+// above to out in the given format, an unknown format selects the
+// Text format and an unknown level selects LevelInfo.
+//
+// # Parameters:
+//
+// 	- out (io.Writer): where the logs are written to, example: os.Stderr
+// 	- format (string): the format of the logs, Text or JSON.
+// 	- level (string): the minimal level of the logs, example: LevelWarn
+//
+// # Example:
+//
+// 	log := logger.New(os.Stderr, logger.JSON, logger.LevelWarn)
+func New(out io.Writer, format string, level string) Logger {
+	min, ok := levels[level]
+	if !ok {
+		min = levels[LevelInfo]
+	}
+
+	return &writer{out: out, format: format, min: min}
+}
+
+// ValidLevel reports whether level is one of the levels.
+func ValidLevel(level string) bool {
+	_, ok := levels[level]
+
+	return ok
+}
+
+// Debug writes a debug log.
+func (w *writer) Debug(msg string, args ...any) {
+	w.log(LevelDebug, msg, args)
+}
+
+// Info writes an info log.
+func (w *writer) Info(msg string, args ...any) {
+	w.log(LevelInfo, msg, args)
+}
+
+// Warn writes a warning log.
+func (w *writer) Warn(msg string, args ...any) {
+	w.log(LevelWarn, msg, args)
+}
+
+// Error writes an error log.
+func (w *writer) Error(msg string, args ...any) {
+	w.log(LevelError, msg, args)
+}
+
+// log writes a log in the format of the writer,
+// unless its level is below the level of the writer.
+//
+// # Parameters:
+//
+// 	- level (string): the level of the log, example: info
+// 	- msg (string): the message of the log.
+// 	- args ([]any): the attributes of the log as key-value pairs.
+//
+// # Example:
+//
+// 	w.log("info", "client connected", []any{"connID", id})
+func (w *writer) log(level string, msg string, args []any) {
+	if levels[level] < w.min {
+		return
+	}
+	now := time.Now()
+
+	var line []byte
+	if w.format == JSON {
+		line = encodeJSON(now, level, msg, args)
+	} else {
+		line = encodeText(now, level, msg, args)
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.out.Write(line)
+}
+
+// encodeText encodes a log as a line of key=value attributes.
+func encodeText(now time.Time, level string, msg string, args []any) []byte {
+	var b strings.Builder
+	b.WriteString(now.Format("2006/01/02 15:04:05 "))
+	b.WriteString(strings.ToUpper(level))
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		key, value := pair(args, i)
+		fmt.Fprintf(&b, " %s=%s", key, quote(fmt.Sprint(value)))
+	}
+	b.WriteString("\n")
+
+	return []byte(b.String())
+}
+
+// encodeJSON encodes a log as a JSON object on a line, keeping
+// the order of the attributes.
+func encodeJSON(now time.Time, level string, msg string, args []any) []byte {
+	var b strings.Builder
+	b.WriteString(`{"time":`)
+	writeJSON(&b, now.UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, level)
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, msg)
+	for i := 0; i < len(args); i += 2 {
+		key, value := pair(args, i)
+		b.WriteString(",")
+		writeJSON(&b, key)
+		b.WriteString(":")
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		writeJSON(&b, value)
+	}
+	b.WriteString("}\n")
+
+	return []byte(b.String())
+}
+
+// writeJSON writes a value encoded in JSON, or its
+// string representation when it can't be encoded.
+func writeJSON(b *strings.Builder, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(data)
+}
+
+// pair returns the key and the value of the attribute at i,
+// a trailing value without key is logged under "!BADKEY" like slog does.
+func pair(args []any, i int) (string, any) {
+	if i+1 >= len(args) {
+		return "!BADKEY", args[i]
+	}
+
+	return fmt.Sprint(args[i]), args[i+1]
+}
+
+// quote quotes the text values containing spaces, quotes or equal signs.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\n") {
+		return fmt.Sprintf("%q", s)
+	}
+
+	return s
+}
+
+// with is a Logger adding attributes to every log of another Logger.
+type with struct {
+	Logger
+	args []any
+}
+
+// With returns a Logger adding the given attributes to every
+// log of l, example: the component of a package.
+//
+// # Parameters:
+//
+// 	- l (Logger): the Logger to write to.
+// 	- args (...any): the attributes as key-value pairs.
+//
+// # Example:
+//
+// 	log := logger.With(logger.Default, "component", "db")
+func With(l Logger, args ...any) Logger {
+	return &with{Logger: l, args: args}
+}
+
+// Debug writes a debug log with the attributes of w.
+func (w *with) Debug(msg string, args ...any) {
+	w.Logger.Debug(msg, w.merge(args)...)
+}
+
+// Info writes an info log with the attributes of w.
+func (w *with) Info(msg string, args ...any) {
+	w.Logger.Info(msg, w.merge(args)...)
+}
+
+// Warn writes a warning log with the attributes of w.
+func (w *with) Warn(msg string, args ...any) {
+	w.Logger.Warn(msg, w.merge(args)...)
+}
+
+// Error writes an error log with the attributes of w.
+func (w *with) Error(msg string, args ...any) {
+	w.Logger.Error(msg, w.merge(args)...)
+}
+
+// merge returns the attributes of w followed by args.
+func (w *with) merge(args []any) []any {
+	merged := make([]any, 0, len(w.args)+len(args))
+	merged = append(merged, w.args...)
+
+	return append(merged, args...)
+}
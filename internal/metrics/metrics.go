@@ -0,0 +1,122 @@
+// Internal package for the metrics of the socketeer.
+//
+// This package is used in the following way:
+//
+// 	1. Implement the Recorder interface, or use an exporter
+// 		like the statsd package.
+// 	2. Set it as the Metrics of the socketeer before Start().
+// 	3. The internal packages record their metrics with it.
+//
+// Names are dot-separated, example: "messages.sent", exporters
+// add their prefix and translate the names when needed.
+package metrics
+
+import "time"
+
+// Names of the recorded metrics.
+//
+// 	- EventsReceived counts the change events read from the source,
+// 		by collection and operation.
+// 	- EventsThrottled counts the events over the rate of their topic,
+// 		dropped or held, by collection and operation.
+// 	- MessagesSent counts the messages queued to clients,
+// 		by collection, operation and endpoint.
+// 	- MessagesForwarded counts the messages received from the other
+// 		instances of a cluster, by collection.
+// 	- DispatchDuration is the time taken to dispatch a message to every
+// 		client, by collection, operation and endpoint.
+// 	- Connections is the number of connected clients, by endpoint.
+// 	- Connects counts the accepted connections, by endpoint.
+// 	- Disconnects counts the closed connections, by endpoint and reason.
+// 	- ConnectionDuration is the lifetime of the closed connections,
+// 		by endpoint and reason.
+// 	- Evictions counts the clients disconnected by the server,
+// 		by endpoint and reason.
+// 	- ClientRTT is the round-trip time of the pings of the clients,
+// 		by endpoint.
+// 	- SinkDeliveries counts the messages delivered to a sink, by sink.
+// 	- SinkRetries counts the retried deliveries to a sink, by sink.
+// 	- SinkFailures counts the messages a sink failed to deliver, after
+// 		every attempt or with an error which is not retryable, by sink.
+// 	- SinkExhaustions counts the messages a sink failed to deliver
+// 		after every attempt of its retry policy, by sink.
+// 	- DeadLetters counts the messages written to the dead letter queue,
+// 		by sink.
+// 	- StreamReconnects counts the attempts to reopen the change stream
+// 		after an error, by collection.
+const (
+	EventsReceived     = "events.received"
+	EventsThrottled    = "events.throttled"
+	MessagesSent       = "messages.sent"
+	MessagesForwarded  = "messages.forwarded"
+	DispatchDuration   = "dispatch.duration"
+	Connections        = "connections"
+	Connects           = "connects"
+	Disconnects        = "disconnects"
+	ConnectionDuration = "connection.duration"
+	Evictions          = "evictions"
+	ClientRTT          = "client.rtt"
+	SinkDeliveries     = "sink.deliveries"
+	SinkRetries        = "sink.retries"
+	SinkFailures       = "sink.failures"
+	SinkExhaustions    = "sink.exhaustions"
+	DeadLetters        = "dead_letters"
+	StreamReconnects   = "stream.reconnects"
+)
+
+// Tags of the recorded metrics.
+//
+// 	- TagCollection is the collection of the event or message.
+// 	- TagOperation is the type of operation, example: "insert".
+// 	- TagEndpoint is the websocket endpoint of the connection, example: /listen
+// 	- TagReason is the reason a connection was closed, one of the reasons below.
+// 	- TagSink is the name of a sink.
+const (
+	TagCollection = "collection"
+	TagOperation  = "op"
+	TagEndpoint   = "endpoint"
+	TagReason     = "reason"
+	TagSink       = "sink"
+)
+
+// Reasons a connection was closed for.
+//
+// 	- ReasonClientClose is a close initiated by the client.
+// 	- ReasonError is a connection lost without a close handshake,
+// 		example: a proxy or a keepalive timeout cutting the connection.
+// 	- ReasonEviction is a client evicted because it can't keep up.
+// 	- ReasonKick is a client kicked by an operator.
+// 	- ReasonShutdown is a connection closed by the shutdown of the server.
+const (
+	ReasonClientClose = "client_close"
+	ReasonError       = "error"
+	ReasonEviction    = "eviction"
+	ReasonKick        = "kick"
+	ReasonShutdown    = "shutdown"
+)
+
+// Recorder records metrics, it must be safe for concurrent use.
+//
+// 	- Count adds delta to a counter.
+// 	- Gauge sets the value of a gauge.
+// 	- Timing records a duration in a histogram.
+//
+// Tags are optional key-value pairs describing the measurement,
+// example: {"collection": "posts"}
+type Recorder interface {
+	Count(name string, delta int64, tags map[string]string)
+	Gauge(name string, value float64, tags map[string]string)
+	Timing(name string, d time.Duration, tags map[string]string)
+}
+
+// Nop is a Recorder discarding every metric, the default Recorder.
+type Nop struct{}
+
+// Count discards the counter.
+func (Nop) Count(name string, delta int64, tags map[string]string) {}
+
+// Gauge discards the gauge.
+func (Nop) Gauge(name string, value float64, tags map[string]string) {}
+
+// Timing discards the duration.
+func (Nop) Timing(name string, d time.Duration, tags map[string]string) {}
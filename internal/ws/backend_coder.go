@@ -0,0 +1,148 @@
+//go:build socketeer_coder
+
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// coderBackend is the context-aware backend built on coder/websocket,
+// selected with the socketeer_coder build tag.
+type coderBackend struct{}
+
+// defaultBackend is the backend selected at build time.
+var defaultBackend backend = coderBackend{}
+
+// Backend is the name of the backend selected at build time.
+const Backend = "coder/websocket"
+
+// coderConn adapts a *websocket.Conn of coder/websocket to the Conn interface.
+//
+// 	- conn is the underlying connection.
+// 	- ctx is the context of the reads and writes, it lives as long as the connection.
+type coderConn struct {
+	conn *websocket.Conn
+	ctx  context.Context
+}
+
+// errOrigin is the reason of the upgrades rejected by CheckOrigin.
+var errOrigin = errors.New("ws: origin not allowed")
+
+// upgrade upgrades the connection to a websocket connection
+// with coder/websocket, accepting every origin by default.
+func (coderBackend) upgrade(res http.ResponseWriter, req *http.Request, subprotocols []string, opts UpgradeOptions) (Conn, error) {
+	if opts.CheckOrigin != nil && !opts.CheckOrigin(req) {
+		if opts.Error != nil {
+			opts.Error(res, req, http.StatusForbidden, errOrigin)
+		} else {
+			http.Error(res, errOrigin.Error(), http.StatusForbidden)
+		}
+		return nil, errOrigin
+	}
+
+	compression := websocket.CompressionDisabled
+	if opts.EnableCompression {
+		compression = websocket.CompressionContextTakeover
+	}
+	conn, err := websocket.Accept(res, req, &websocket.AcceptOptions{
+		Subprotocols:       append(subprotocols[:len(subprotocols):len(subprotocols)], opts.Subprotocols...),
+		InsecureSkipVerify: true,
+		CompressionMode:    compression,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &coderConn{
+		conn: conn,
+		ctx:  context.Background(),
+	}, nil
+}
+
+// dialReadLimit is the maximal size of the messages read from the
+// connections opened by dial, which carry whole messages.
+const dialReadLimit = 16 << 20
+
+// dial opens a websocket connection with coder/websocket.
+func (coderBackend) dial(ctx context.Context, url string, header http.Header) (Conn, error) {
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(dialReadLimit)
+
+	return &coderConn{
+		conn: conn,
+		ctx:  context.Background(),
+	}, nil
+}
+
+// isUnexpectedClose reports whether err is a close frame
+// other than going away or an abnormal closure.
+func (coderBackend) isUnexpectedClose(err error) bool {
+	status := websocket.CloseStatus(err)
+
+	return status != -1 && status != websocket.StatusGoingAway && status != websocket.StatusAbnormalClosure
+}
+
+// isClientClose reports whether err is a normal or going away close frame.
+func (coderBackend) isClientClose(err error) bool {
+	status := websocket.CloseStatus(err)
+
+	return status == websocket.StatusNormalClosure || status == websocket.StatusGoingAway
+}
+
+// ReadMessage reads the next message from the connection.
+func (c *coderConn) ReadMessage() (int, []byte, error) {
+	typ, data, err := c.conn.Read(c.ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	if typ == websocket.MessageBinary {
+		return BinaryMessage, data, nil
+	}
+
+	return TextMessage, data, nil
+}
+
+// WriteMessage writes a message to the connection.
+func (c *coderConn) WriteMessage(messageType int, data []byte) error {
+	typ := websocket.MessageText
+	if messageType == BinaryMessage {
+		typ = websocket.MessageBinary
+	}
+
+	return c.conn.Write(c.ctx, typ, data)
+}
+
+// WriteClose sends a close frame with the given code and reason,
+// waiting for the close handshake of the peer.
+func (c *coderConn) WriteClose(code int, reason string) error {
+	return c.conn.Close(websocket.StatusCode(code), reason)
+}
+
+// Subprotocol returns the negotiated subprotocol.
+func (c *coderConn) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
+// Ping sends a ping frame and waits for the pong of the peer.
+func (c *coderConn) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	err := c.conn.Ping(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
+
+// Close closes the connection without waiting for the close handshake.
+func (c *coderConn) Close() error {
+	return c.conn.CloseNow()
+}
@@ -0,0 +1,130 @@
+//go:build !socketeer_coder
+
+package ws
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// gorillaBackend is the default backend, built on gorilla/websocket.
+type gorillaBackend struct{}
+
+// gorillaConn adapts a *websocket.Conn of gorilla/websocket to the
+// Conn interface, which it already satisfies except for WriteClose
+// and Ping.
+//
+// 	- pongs receives the payloads of the pongs read from the peer.
+type gorillaConn struct {
+	*websocket.Conn
+	pongs chan string
+}
+
+// defaultBackend is the backend selected at build time.
+var defaultBackend backend = gorillaBackend{}
+
+// Backend is the name of the backend selected at build time.
+const Backend = "gorilla/websocket"
+
+// upgrade upgrades the connection to a websocket connection
+// with gorilla/websocket, accepting every origin by default.
+func (gorillaBackend) upgrade(res http.ResponseWriter, req *http.Request, subprotocols []string, opts UpgradeOptions) (Conn, error) {
+	upgrader := websocket.Upgrader{
+		HandshakeTimeout:  opts.HandshakeTimeout,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		Subprotocols:      append(subprotocols[:len(subprotocols):len(subprotocols)], opts.Subprotocols...),
+		CheckOrigin:       opts.CheckOrigin,
+		Error:             opts.Error,
+		EnableCompression: opts.EnableCompression,
+	}
+	if opts.ReadBufferSize > 0 {
+		upgrader.ReadBufferSize = opts.ReadBufferSize
+	}
+	if opts.WriteBufferSize > 0 {
+		upgrader.WriteBufferSize = opts.WriteBufferSize
+	}
+	if upgrader.CheckOrigin == nil {
+		upgrader.CheckOrigin = func(r *http.Request) bool {
+			return true
+		}
+	}
+
+	conn, err := upgrader.Upgrade(res, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &gorillaConn{Conn: conn, pongs: make(chan string, 1)}
+	conn.SetPongHandler(func(data string) error {
+		select {
+		case c.pongs <- data:
+		default:
+		}
+		return nil
+	})
+
+	return c, nil
+}
+
+// dial opens a websocket connection with gorilla/websocket.
+func (gorillaBackend) dial(ctx context.Context, url string, header http.Header) (Conn, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &gorillaConn{Conn: conn, pongs: make(chan string, 1)}
+	conn.SetPongHandler(func(data string) error {
+		select {
+		case c.pongs <- data:
+		default:
+		}
+		return nil
+	})
+
+	return c, nil
+}
+
+// isUnexpectedClose reports whether err is a close frame
+// other than going away or an abnormal closure.
+func (gorillaBackend) isUnexpectedClose(err error) bool {
+	return websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure)
+}
+
+// isClientClose reports whether err is a normal or going away close frame.
+func (gorillaBackend) isClientClose(err error) bool {
+	return websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+}
+
+// WriteClose sends a close frame with the given code and reason.
+func (c *gorillaConn) WriteClose(code int, reason string) error {
+	return c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Time{})
+}
+
+// Ping sends a ping frame with a unique payload and waits for the
+// pong echoing it, the pongs of the previous pings are ignored.
+func (c *gorillaConn) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	payload := strconv.FormatInt(start.UnixNano(), 36)
+	deadline, _ := ctx.Deadline()
+	err := c.WriteControl(websocket.PingMessage, []byte(payload), deadline)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case data := <-c.pongs:
+			if data == payload {
+				return time.Since(start), nil
+			}
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package ws
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/chaos"
+	"github.com/darthsalad/socketeer/internal/event"
+	"github.com/darthsalad/socketeer/internal/logger"
+)
+
+// client is a websocket connection together with its subscriptions
+// and the queue of the messages waiting to be written to it.
+//
+// 	- id is the connection ID of the client.
+// 	- session is the session ID of the client, empty once
+// 		the session can't be resumed anymore.
+// 	- cursor is the sequence number of the last message queued.
+// 	- identity is the authenticated identity of the client, if any.
+// 	- conn is the websocket connection.
+// 	- version is the protocol version negotiated by the client.
+// 	- zstd is whether the client negotiated the zstd compression
+// 		of the large messages.
+// 	- binary is whether the client receives the messages in binary frames.
+// 	- topics are the topics the client subscribed to with their filter,
+// 		a client without any subscription receives every update.
+// 	- fields are the fields the client asked for in its subscriptions,
+// 		sorted, by topic, every field of a topic without any.
+// 	- scope returns the filter of the identity of the client on a
+// 		topic, nil when the WebSocket has no Scope.
+// 	- send is the queue of the frames to write, closed on shutdown.
+// 	- done is closed once the queue is flushed and the close frame is sent.
+// 	- closeCode and closeReason are sent in the close frame.
+// 	- shutdownOnce guards the closing of send.
+// 	- log is the Logger of the client, adding its connection ID.
+// 	- connected is when the client connected.
+// 	- remoteAddr is the address of the client.
+// 	- rtt is the round-trip time of the last ping in nanoseconds, 0
+// 		until a pong is read.
+// 	- maxBatch is the maximal number of envelopes written in a frame,
+// 		see batch().
+// 	- meter aggregates the usage of the identity of the client, nil
+// 		when the WebSocket has no Meter.
+type client struct {
+	id           string
+	session      string
+	cursor       uint64
+	identity     string
+	conn         Conn
+	version      int
+	zstd         bool
+	binary       bool
+	topics       map[string]event.Filter
+	fields       map[string][]string
+	scope        func(identity string, topic string) event.Filter
+	send         chan frame
+	done         chan struct{}
+	closeCode    int
+	closeReason  string
+	shutdownOnce sync.Once
+	log          logger.Logger
+	connected    time.Time
+	remoteAddr   string
+	rtt          atomic.Int64
+	maxBatch     int
+	meter        *meter
+}
+
+// frame is a message waiting to be written to a client.
+//
+// 	- messageType is the type of the message, TextMessage or BinaryMessage.
+// 	- data is the encoded message.
+// 	- batchable is whether the message is an envelope of the third
+// 		protocol version, which can be batched with the next ones.
+// 	- count is the number of messages of a batch, 0 for one message.
+type frame struct {
+	messageType int
+	data        []byte
+	batchable   bool
+	count       int
+}
+
+// controlMessage is a message sent by a client to manage
+// its subscriptions, example: {"type": "subscribe", "topic": "posts"}
+//
+// 	- Type is the type of the message, "subscribe" or "unsubscribe".
+// 	- Topic is the topic, which is the name of a watched collection.
+// 	- Filter is the filter of a subscription, example: {"tenant": "acme"},
+// 		a subscription without filter receives every message of the topic.
+// 	- Fields are the fields sent to the client for the topic, example:
+// 		["title", "price"], among the selected keys, every selected key
+// 		when empty.
+type controlMessage struct {
+	Type   string       `json:"type"`
+	Topic  string       `json:"topic"`
+	Filter event.Filter `json:"filter,omitempty"`
+	Fields []string     `json:"fields,omitempty"`
+}
+
+// newClient returns a new client for the connection without any
+// subscription, with a queue holding up to buffer frames, logging
+// to log with its connection ID.
+func newClient(conn Conn, version int, buffer int, log logger.Logger) *client {
+	id := newID()
+
+	return &client{
+		id:        id,
+		conn:      conn,
+		version:   version,
+		topics:    make(map[string]event.Filter),
+		fields:    make(map[string][]string),
+		send:      make(chan frame, buffer),
+		done:      make(chan struct{}),
+		log:       logger.With(log, "connID", id),
+		connected: time.Now(),
+	}
+}
+
+// newID returns a new random connection ID.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// hello queues the hello message of the second protocol version,
+// which tells the client its connection ID, its session token and
+// whether the large messages are compressed.
+func (c *client) hello(token string) {
+	if c.version < ProtocolV2 {
+		return
+	}
+
+	env := envelope{V: c.version, Type: "hello", ID: c.id, Session: token}
+	if c.zstd {
+		env.Compression = CompressZstd
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		c.log.Error("encoding hello failed", "error", err)
+		return
+	}
+	c.enqueue(TextMessage, data)
+}
+
+// Codes of the error messages sent to the clients of the second
+// protocol version when a control message is rejected, example:
+// {"v": 2, "type": "error", "code": "subscription_limit", "topic": "posts",
+// "error": "too many subscriptions, the limit is 16"}
+//
+// 	- ErrorSubscriptionLimit is a subscription over MaxSubscriptions.
+const (
+	ErrorSubscriptionLimit = "subscription_limit"
+)
+
+// reject queues an error message for a control message about a topic
+// which was rejected. The clients of the first protocol version, which
+// only receive data, are not told.
+//
+// # Parameters:
+//
+// 	- code (string): the code of the error, example: ErrorSubscriptionLimit
+// 	- topic (string): the topic of the control message.
+// 	- reason (string): the description of the error.
+//
+// # Example:
+//
+// 	c.reject(ErrorSubscriptionLimit, ctrl.Topic, "too many subscriptions")
+func (c *client) reject(code string, topic string, reason string) {
+	if c.version < ProtocolV2 {
+		return
+	}
+
+	env := envelope{V: c.version, Type: "error", Topic: topic, Code: code, Error: reason}
+	data, err := json.Marshal(env)
+	if err != nil {
+		c.log.Error("encoding error failed", "error", err)
+		return
+	}
+	c.enqueue(TextMessage, data)
+}
+
+// enqueue queues a frame for the client without blocking,
+// it reports false when the queue of the client is full.
+//
+// It must not be called after shutdown(), which the WebSocket
+// ensures by only enqueuing to the clients of its clients map.
+func (c *client) enqueue(messageType int, data []byte) bool {
+	return c.push(frame{messageType: messageType, data: data})
+}
+
+// push queues a frame for the client without blocking, like enqueue().
+func (c *client) push(f frame) bool {
+	select {
+	case c.send <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdown stops accepting frames, the write loop sends the close
+// frame with the given code and reason once the queue is flushed.
+func (c *client) shutdown(code int, reason string) {
+	c.shutdownOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.send)
+	})
+}
+
+// writeLoop writes the queued frames to the connection until the
+// client is shut down, then sends the close frame and closes done.
+// Frames are discarded once a write failed. The batchable frames are
+// written in batches when the client has a maxBatch, see batch().
+//
+// # Parameters:
+//
+// 	- inj (*chaos.Injector): the fault injector slowing down writes, may be nil.
+//
+// # Example:
+//
+// 	go c.writeLoop(w.Chaos)
+func (c *client) writeLoop(inj *chaos.Injector) {
+	defer close(c.done)
+
+	var (
+		failed bool
+		held   []frame
+	)
+	for {
+		var f frame
+		if len(held) > 0 {
+			f, held = held[0], held[1:]
+		} else {
+			var ok bool
+			f, ok = <-c.send
+			if !ok {
+				break
+			}
+		}
+		if failed {
+			continue
+		}
+		if delay := inj.Delay(); delay > 0 {
+			time.Sleep(delay)
+		}
+		if f.batchable && c.maxBatch > 1 {
+			f, held = c.batch(f)
+		}
+
+		err := c.conn.WriteMessage(f.messageType, f.data)
+		if err != nil {
+			c.log.Warn("write failed", "error", err)
+			failed = true
+			continue
+		}
+		if c.meter != nil {
+			messages := f.count
+			if messages == 0 {
+				messages = 1
+			}
+			c.meter.add(c.identity, messages, len(f.data))
+		}
+	}
+
+	if !failed {
+		c.conn.WriteClose(c.closeCode, c.closeReason)
+	}
+}
+
+// batch returns a frame holding the given batchable frame and the
+// batchable frames of the same type already queued after it, up to
+// maxBatch, as a JSON array of envelopes. The frames are only batched
+// when they are queued faster than they are written, a frame alone
+// is returned as is. The first frame which can't be batched is
+// returned as held, to be written after the batch.
+//
+// # Parameters:
+//
+// 	- first (frame): the first batchable frame.
+//
+// # Example:
+//
+// 	f, held = c.batch(f)
+func (c *client) batch(first frame) (frame, []frame) {
+	batch := [][]byte{first.data}
+	var held []frame
+collect:
+	for len(batch) < c.maxBatch {
+		select {
+		case f, ok := <-c.send:
+			if !ok {
+				break collect
+			}
+			if !f.batchable || f.messageType != first.messageType {
+				held = append(held, f)
+				break collect
+			}
+			batch = append(batch, f.data)
+		default:
+			break collect
+		}
+	}
+	if len(batch) == 1 {
+		return first, held
+	}
+
+	data := make([]byte, 0, 2+len(batch)+len(first.data)*len(batch))
+	data = append(data, '[')
+	data = append(data, bytes.Join(batch, []byte{','})...)
+	data = append(data, ']')
+
+	return frame{messageType: first.messageType, data: data, count: len(batch)}, held
+}
+
+// wants reports whether the client should receive a message: it
+// subscribed to its topic and the message matches the filter of the
+// subscription, or it didn't subscribe to any topic and the message
+// matches the scope of its identity. The snapshots are sent to the
+// clients of the second protocol version interested in their topic,
+// see snapshot(). The operations on a collection, like a drop, are
+// not sent to the clients of the first version, which couldn't tell
+// them from the changes of a document, unless they carry the raw
+// change, which has its operation type.
+func (c *client) wants(msg event.Message) bool {
+	if msg.OperationType == event.OpSnapshot {
+		_, ok := c.topics[msg.Topic]
+		return c.version != ProtocolV1 && (ok || len(c.topics) == 0)
+	}
+	if c.version == ProtocolV1 && msg.RawChange == nil && event.IsCollectionOp(msg.OperationType) {
+		return false
+	}
+	if len(c.topics) == 0 {
+		return c.scope == nil || c.scope(c.identity, msg.Topic).Matches(msg)
+	}
+	f, ok := c.topics[msg.Topic]
+
+	return ok && f.Matches(msg)
+}
+
+// snapshot returns a snapshot with the documents the client wants,
+// the ones matching the filter of its subscription to the topic, or
+// the scope of its identity when it didn't subscribe to any topic.
+// The other messages are returned as is.
+func (c *client) snapshot(msg event.Message) event.Message {
+	if msg.OperationType != event.OpSnapshot {
+		return msg
+	}
+	f, ok := c.topics[msg.Topic]
+	if !ok && c.scope != nil {
+		f = c.scope(c.identity, msg.Topic)
+	}
+	if len(f) == 0 {
+		return msg
+	}
+
+	docs := make([]event.Document, 0, len(msg.Documents))
+	for _, doc := range msg.Documents {
+		if f.MatchesDocument(doc) {
+			docs = append(docs, doc)
+		}
+	}
+	msg.Documents = docs
+
+	return msg
+}
+
+// handleMessage applies a control message received from a client.
+// Messages which are not valid control messages are logged and ignored.
+//
+// # Parameters:
+//
+// 	- c (*client): the client the message was received from.
+// 	- msg ([]byte): the message.
+//
+// # Example:
+//
+// 	w.handleMessage(c, []byte(`{"type": "subscribe", "topic": "posts"}`))
+func (w *WebSocket) handleMessage(c *client, msg []byte) {
+	var ctrl controlMessage
+	err := json.Unmarshal(msg, &ctrl)
+	if err != nil || ctrl.Topic == "" {
+		c.log.Debug("ignoring message", "message", string(msg))
+		return
+	}
+
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	switch ctrl.Type {
+	case "subscribe":
+		if _, ok := c.topics[ctrl.Topic]; !ok && w.MaxSubscriptions > 0 && len(c.topics) >= w.MaxSubscriptions {
+			c.log.Warn("subscription limit reached", "collection", ctrl.Topic, "limit", w.MaxSubscriptions)
+			c.reject(ErrorSubscriptionLimit, ctrl.Topic, fmt.Sprintf("too many subscriptions, the limit is %d", w.MaxSubscriptions))
+			return
+		}
+		if c.scope != nil {
+			ctrl.Filter = scoped(ctrl.Filter, c.scope(c.identity, ctrl.Topic))
+		}
+		c.topics[ctrl.Topic] = ctrl.Filter
+		c.fields[ctrl.Topic] = sortedFields(ctrl.Fields)
+		c.log.Debug("subscribed", "collection", ctrl.Topic, "filter", ctrl.Filter, "fields", ctrl.Fields)
+	case "unsubscribe":
+		delete(c.topics, ctrl.Topic)
+		delete(c.fields, ctrl.Topic)
+		c.log.Debug("unsubscribed", "collection", ctrl.Topic)
+	default:
+		c.log.Debug("ignoring message", "type", ctrl.Type)
+	}
+}
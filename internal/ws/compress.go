@@ -0,0 +1,93 @@
+package ws
+
+import (
+	"net/http"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressZstd is the value of the "compress" query parameter asking
+// the server to compress the large messages with zstd.
+const CompressZstd = "zstd"
+
+// FramesBinary is the value of the "frames" query parameter asking
+// the server to send the messages in binary frames.
+const FramesBinary = "binary"
+
+// zstdEncoder compresses the messages, EncodeAll() is safe for
+// concurrent use.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// frameKey identifies the frame of a message shared by the clients
+// of a protocol version, with or without compression and binary frames,
+// asking for the same fields.
+//
+// 	- version is the protocol version of the clients.
+// 	- zstd is whether the clients negotiated the compression.
+// 	- binary is whether the clients receive binary frames.
+// 	- fields is the projection of the clients, see projection().
+type frameKey struct {
+	version int
+	zstd    bool
+	binary  bool
+	fields  string
+}
+
+// compresses reports whether a new client negotiated the compression
+// of the large messages: it speaks the second protocol version, asked
+// for it with the compress=zstd query parameter and the server
+// compresses the messages of CompressThreshold bytes or more.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the upgrade request.
+// 	- version (int): the negotiated protocol version.
+//
+// # Example:
+//
+// 	c.zstd = w.compresses(req, c.version)
+func (w *WebSocket) compresses(req *http.Request, version int) bool {
+	return w.CompressThreshold > 0 && version >= ProtocolV2 && req.URL.Query().Get("compress") == CompressZstd
+}
+
+// binaryFrames reports whether a new client receives the messages in
+// binary frames: every client does with BinaryFrames, the others ask
+// for it with the frames=binary query parameter.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the upgrade request.
+//
+// # Example:
+//
+// 	c.binary = w.binaryFrames(req)
+func (w *WebSocket) binaryFrames(req *http.Request) bool {
+	return w.BinaryFrames || req.URL.Query().Get("frames") == FramesBinary
+}
+
+// pack returns the message type and the data of the frame of an encoded
+// message for a client: the message compressed with zstd in a binary
+// frame when the client negotiated it and the message has at least
+// CompressThreshold bytes, the message as is otherwise, in a binary
+// frame for the clients receiving binary frames and in a text frame
+// for the others. The compressed messages start with the magic number
+// of zstd, 0x28 0xB5 0x2F 0xFD, which no JSON message starts with.
+//
+// # Parameters:
+//
+// 	- c (*client): the client.
+// 	- data ([]byte): the encoded message.
+//
+// # Example:
+//
+// 	c.enqueue(w.pack(c, data))
+func (w *WebSocket) pack(c *client, data []byte) (int, []byte) {
+	if !c.zstd || len(data) < w.CompressThreshold {
+		if c.binary {
+			return BinaryMessage, data
+		}
+		return TextMessage, data
+	}
+
+	return BinaryMessage, zstdEncoder.EncodeAll(data, nil)
+}
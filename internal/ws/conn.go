@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Message types of the websocket protocol, shared by all backends.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+// Conn is an interface for a single websocket connection,
+// it hides the websocket library behind the WebSocket type.
+//
+// Ping sends a ping frame and returns the round-trip time once the
+// pong of the peer is read, which requires a concurrent ReadMessage.
+//
+// The backend is selected at build time:
+//
+// 	- gorilla/websocket is the default backend (backend_gorilla.go).
+// 	- coder/websocket is used when building with the socketeer_coder
+// 		build tag (backend_coder.go), example: go build -tags socketeer_coder
+type Conn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteClose(code int, reason string) error
+	Subprotocol() string
+	Ping(ctx context.Context) (time.Duration, error)
+	Close() error
+}
+
+// UpgradeOptions configure the upgrade of the websocket connections,
+// the zero value accepts every origin with the defaults of the backend.
+//
+// 	- HandshakeTimeout bounds the handshake, gorilla/websocket only.
+// 	- ReadBufferSize is the size of the read buffer, 1024 bytes by
+// 		default, gorilla/websocket only.
+// 	- WriteBufferSize is the size of the write buffer, 1024 bytes by
+// 		default, gorilla/websocket only.
+// 	- Subprotocols are offered after the socketeer ones, for the
+// 		applications layering their own protocol on the connections.
+// 	- CheckOrigin reports whether the Origin of an upgrade request is
+// 		allowed, every origin is when nil.
+// 	- Error writes the response of a rejected upgrade, http.Error
+// 		when nil. With coder/websocket, it is only called for the
+// 		rejected origins.
+// 	- EnableCompression negotiates the permessage-deflate extension
+// 		with the clients supporting it.
+type UpgradeOptions struct {
+	HandshakeTimeout  time.Duration
+	ReadBufferSize    int
+	WriteBufferSize   int
+	Subprotocols      []string
+	CheckOrigin       func(req *http.Request) bool
+	Error             func(res http.ResponseWriter, req *http.Request, status int, reason error)
+	EnableCompression bool
+}
+
+// backend is the interface implemented by the websocket
+// library selected at build time.
+//
+// 	- upgrade upgrades an http request to a websocket connection,
+// 		negotiating one of the given subprotocols, with the given options.
+// 	- isUnexpectedClose reports whether a read error is a close
+// 		frame other than going away or an abnormal closure.
+// 	- isClientClose reports whether a read error is a normal
+// 		or going away close frame sent by the client.
+// 	- dial opens a websocket connection to a server.
+type backend interface {
+	upgrade(res http.ResponseWriter, req *http.Request, subprotocols []string, opts UpgradeOptions) (Conn, error)
+	isUnexpectedClose(err error) bool
+	isClientClose(err error) bool
+	dial(ctx context.Context, url string, header http.Header) (Conn, error)
+}
+
+// Dial opens a websocket connection to a server with the backend
+// selected at build time, for the links between the instances of
+// a cluster.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the handshake.
+// 	- url (string): the URL of the endpoint, example: ws://10.0.0.2:8080/cluster/stream
+// 	- header (http.Header): the headers of the handshake, may be nil.
+//
+// # Example:
+//
+// 	conn, err := ws.Dial(ctx, url, http.Header{"Authorization": {"Bearer " + secret}})
+func Dial(ctx context.Context, url string, header http.Header) (Conn, error) {
+	return defaultBackend.dial(ctx, url, header)
+}
@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"sort"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// Filters returns the distinct filters of the subscriptions of the
+// connected clients and of the suspended sessions, by topic: the
+// equivalent filters of many clients, example: the clients of the
+// same tenant, are only returned once. A topic with a subscription
+// without filter maps to nil, and the topics without subscription
+// are missing. Filters returns nil when a client didn't subscribe to
+// any topic, as it receives every message.
+//
+// The change source filters the changes with them, so that the
+// database only sends the changes some client wants.
+//
+// # Example:
+//
+// 	filters := w.Filters()
+func (w *WebSocket) Filters() map[string][]event.Filter {
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	filters := make(map[string][]event.Filter)
+	seen := make(map[string]map[string]struct{})
+	add := func(topics map[string]event.Filter) bool {
+		if len(topics) == 0 {
+			return false
+		}
+		for topic, f := range topics {
+			current, ok := filters[topic]
+			if ok && current == nil {
+				continue
+			}
+			if f == nil {
+				filters[topic] = nil
+				continue
+			}
+			if seen[topic] == nil {
+				seen[topic] = make(map[string]struct{})
+			}
+			key := f.Key()
+			if _, ok := seen[topic][key]; ok {
+				continue
+			}
+			seen[topic][key] = struct{}{}
+			filters[topic] = append(current, f)
+		}
+		return true
+	}
+
+	for _, c := range w.clients {
+		if !add(c.topics) {
+			return nil
+		}
+	}
+	now := time.Now()
+	for _, s := range w.sessions {
+		if now.After(s.expires) {
+			continue
+		}
+		if !add(s.topics) {
+			return nil
+		}
+	}
+
+	for _, fs := range filters {
+		sort.Slice(fs, func(i, j int) bool {
+			return fs[i].Key() < fs[j].Key()
+		})
+	}
+
+	return filters
+}
+
+// scoped returns the filter of a subscription restricted to a scope,
+// the fields of the scope override the ones of the filter, so that a
+// client can't widen its scope.
+//
+// # Parameters:
+//
+// 	- f (event.Filter): the filter of the subscription, if any.
+// 	- scope (event.Filter): the scope of the identity of the client.
+//
+// # Example:
+//
+// 	filter := scoped(ctrl.Filter, event.Filter{"tenant": "acme"})
+func scoped(f event.Filter, scope event.Filter) event.Filter {
+	if len(scope) == 0 {
+		return f
+	}
+	merged := make(event.Filter, len(f)+len(scope))
+	for field, value := range f {
+		merged[field] = value
+	}
+	for field, value := range scope {
+		merged[field] = value
+	}
+
+	return merged
+}
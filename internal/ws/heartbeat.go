@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// heartbeats sends a heartbeat message to the clients of the second
+// protocol version every Heartbeat, until the WebSocket is stopped.
+// It carries the time of the server and the sequence number of the
+// last dispatched message, example:
+// {"v": 2, "type": "heartbeat", "seq": 42, "ts": "2024-01-01T00:00:00Z"}
+// so that the clients detect the gaps of a silent connection and
+// measure the skew of their clock. Like the hello message, it is sent
+// in a text frame, and a client whose queue is full skips it.
+//
+// This method is called internally when the WebSocket is started.
+//
+// # Example:
+//
+// 	go w.heartbeats()
+func (w *WebSocket) heartbeats() {
+	ticker := time.NewTicker(w.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		w.clientsMux.Lock()
+		now := time.Now()
+		env := envelope{Type: "heartbeat", Seq: w.seq, Time: &now}
+		frames := make(map[int][]byte)
+		for _, c := range w.clients {
+			if c.version < ProtocolV2 {
+				continue
+			}
+			data, ok := frames[c.version]
+			if !ok {
+				env.V = c.version
+				var err error
+				data, err = json.Marshal(env)
+				if err != nil {
+					w.Log.Error("encoding heartbeat failed", "error", err)
+					break
+				}
+				frames[c.version] = data
+			}
+			c.enqueue(TextMessage, data)
+		}
+		w.clientsMux.Unlock()
+	}
+}
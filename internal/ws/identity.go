@@ -0,0 +1,272 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+	"github.com/darthsalad/socketeer/internal/metrics"
+)
+
+// DefaultOfflineQueue is the default number of messages kept
+// for an identity without any connected client.
+const DefaultOfflineQueue = 64
+
+// Errors returned by the identity sessions.
+var (
+	ErrUnauthorized    = errors.New("ws: unauthorized")
+	ErrTooManyConns    = errors.New("ws: too many connections for the identity")
+	ErrUnknownIdentity = errors.New("ws: unknown identity")
+)
+
+// BearerSubprotocol is the Sec-WebSocket-Protocol entry preceding
+// the bearer token of the browsers, which can't set the Authorization
+// header of a websocket: new WebSocket(url, ["bearer", token]). It is
+// echoed back when the client doesn't offer a socketeer subprotocol,
+// the token never is.
+const BearerSubprotocol = "bearer"
+
+// identity is the session of an authenticated identity, shared by
+// all its connections, example: the tabs and devices of a user.
+//
+// 	- clients are the connected clients of the identity by connection ID.
+// 	- queue are the messages sent to the identity while none of its
+// 		clients was connected, delivered to the next client.
+type identity struct {
+	clients map[string]*client
+	queue   []event.Message
+}
+
+// authenticate returns the identity of an upgrade request, which is
+// empty when neither Authenticate nor AuthenticateToken is set.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the upgrade request.
+//
+// # Example:
+//
+// 	id, err := w.authenticate(req)
+func (w *WebSocket) authenticate(req *http.Request) (string, error) {
+	var id string
+	var err error
+	switch {
+	case w.Authenticate != nil:
+		id, err = w.Authenticate(req)
+	case w.AuthenticateToken != nil:
+		token := w.requestToken(req)
+		if token == "" {
+			return "", ErrUnauthorized
+		}
+		id, err = w.AuthenticateToken(token)
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", ErrUnauthorized
+	}
+
+	return id, nil
+}
+
+// requestToken returns the token of an upgrade request, taken from
+// the Authorization header, from the entry following "bearer" in the
+// Sec-WebSocket-Protocol header, from the TokenQuery parameter or from
+// the TokenCookie cookie, in this order, empty if there is none.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the upgrade request.
+//
+// # Example:
+//
+// 	token := w.requestToken(req)
+func (w *WebSocket) requestToken(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	var protocols []string
+	for _, header := range req.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(header, ",") {
+			protocols = append(protocols, strings.TrimSpace(p))
+		}
+	}
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == BearerSubprotocol {
+			return protocols[i+1]
+		}
+	}
+
+	if w.TokenQuery != "" {
+		if token := req.URL.Query().Get(w.TokenQuery); token != "" {
+			return token
+		}
+	}
+	if w.TokenCookie != "" {
+		if cookie, err := req.Cookie(w.TokenCookie); err == nil {
+			return cookie.Value
+		}
+	}
+
+	return ""
+}
+
+// admit reports whether another client of the identity can connect
+// without going over MaxConnsPerIdentity.
+func (w *WebSocket) admit(id string) bool {
+	if id == "" || w.MaxConnsPerIdentity <= 0 {
+		return true
+	}
+
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	ident, ok := w.identities[id]
+
+	return !ok || len(ident.clients) < w.MaxConnsPerIdentity
+}
+
+// addLocked adds a client to the clients map and to its identity,
+// and delivers the offline queue of the identity to the client.
+// The caller must hold clientsMux.
+func (w *WebSocket) addLocked(c *client) {
+	w.clients[c.id] = c
+	tags := w.tagsLocked(nil)
+	w.Metrics.Count(metrics.Connects, 1, tags)
+	w.Metrics.Gauge(metrics.Connections, float64(len(w.clients)), tags)
+	if c.identity == "" {
+		return
+	}
+
+	ident, ok := w.identities[c.identity]
+	if !ok {
+		ident = &identity{clients: make(map[string]*client)}
+		w.identities[c.identity] = ident
+	}
+	ident.clients[c.id] = c
+
+	for _, msg := range ident.queue {
+		f, err := w.frameFor(c, msg)
+		if err != nil || !c.push(f) {
+			c.log.Warn("offline queue dropped", "identity", c.identity)
+			break
+		}
+	}
+	ident.queue = nil
+}
+
+// removeLocked removes a client from the clients map and from its
+// identity, the identity is forgotten with its last client. The
+// disconnection is recorded with its reason, once per client.
+// The caller must hold clientsMux.
+//
+// # Parameters:
+//
+// 	- c (*client): the client to remove.
+// 	- reason (string): why the client is removed, example: metrics.ReasonKick
+//
+// # Example:
+//
+// 	w.removeLocked(c, metrics.ReasonClientClose)
+func (w *WebSocket) removeLocked(c *client, reason string) {
+	if w.clients[c.id] == c {
+		delete(w.clients, c.id)
+		w.Metrics.Gauge(metrics.Connections, float64(len(w.clients)), w.tagsLocked(nil))
+		w.recordDisconnectLocked(c, reason)
+	}
+
+	ident, ok := w.identities[c.identity]
+	if !ok || ident.clients[c.id] != c {
+		return
+	}
+	delete(ident.clients, c.id)
+	if len(ident.clients) == 0 && len(ident.queue) == 0 {
+		delete(w.identities, c.identity)
+	}
+}
+
+// SendToIdentity sends a message to every connected client of an
+// identity, or queues it for the next client of the identity when
+// none is connected, dropping the oldest messages once OfflineQueue
+// is reached. Clients which can't keep up are evicted.
+//
+// # Parameters:
+//
+// 	- id (string): the identity returned by Authenticate.
+// 	- msg (event.Message): the message to send.
+//
+// # Example:
+//
+// 	err := ws.SendToIdentity("user-42", event.Message{Topic: "notifications", Data: data})
+func (w *WebSocket) SendToIdentity(id string, msg event.Message) error {
+	if id == "" {
+		return ErrUnknownIdentity
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	ident, ok := w.identities[id]
+	if !ok {
+		if w.OfflineQueue <= 0 {
+			return ErrUnknownIdentity
+		}
+		ident = &identity{clients: make(map[string]*client)}
+		w.identities[id] = ident
+	}
+
+	if len(ident.clients) == 0 {
+		if w.OfflineQueue <= 0 {
+			return ErrUnknownIdentity
+		}
+		if len(ident.queue) >= w.OfflineQueue {
+			ident.queue = ident.queue[1:]
+		}
+		ident.queue = append(ident.queue, msg)
+		return nil
+	}
+
+	for _, c := range ident.clients {
+		f, err := w.frameFor(c, msg)
+		if err != nil {
+			return err
+		}
+		if !c.push(f) {
+			c.log.Warn("send buffer full, evicting")
+			w.evictLocked(c, CloseTryAgainLater, "send buffer full")
+		}
+	}
+
+	return nil
+}
+
+// Authorize applies the IP filter and the authentication of the
+// websocket endpoint to another request, so that the routes added
+// with Handle() serve the same clients with the same identities.
+//
+// It returns ErrForbidden when the IP filter rejects the client,
+// and the error of the authentication when it fails.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	identity, err := w.Authorize(req)
+func (w *WebSocket) Authorize(req *http.Request) (string, error) {
+	if !w.allowed(req) {
+		return "", ErrForbidden
+	}
+
+	return w.authenticate(req)
+}
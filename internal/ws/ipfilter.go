@@ -0,0 +1,179 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrForbidden is returned to the connections from an address
+// rejected by the IP filter of the WebSocket.
+var ErrForbidden = errors.New("ws: address not allowed")
+
+// ParseCIDRs parses a list of CIDR blocks, a single address is
+// a block of one address.
+//
+// # Parameters:
+//
+// 	- cidrs ([]string): the blocks, example: []string{"10.0.0.0/8", "::1"}
+//
+// # Example:
+//
+// 	nets, err := ws.ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.7"})
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("ws: invalid address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("ws: invalid CIDR block %q", cidr)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// allowed reports whether the IP filter admits the client of an upgrade
+// request: its address must not be in Deny, and must be in Allow when
+// Allow is not empty.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the upgrade request.
+//
+// # Example:
+//
+// 	if !w.allowed(req) {
+// 		http.Error(res, ErrForbidden.Error(), http.StatusForbidden)
+// 	}
+func (w *WebSocket) allowed(req *http.Request) bool {
+	if len(w.Allow) == 0 && len(w.Deny) == 0 {
+		return true
+	}
+
+	ip := remoteIP(req)
+	if ip == nil {
+		return false
+	}
+	if contains(w.Deny, ip) {
+		return false
+	}
+
+	return len(w.Allow) == 0 || contains(w.Allow, ip)
+}
+
+// remoteIP returns the address of the client of a request, nil
+// when it can't be parsed.
+func remoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// realIP wraps a handler so that the RemoteAddr of the requests relayed
+// by the TrustedProxies is the address of the client, taken from the
+// X-Forwarded-For or Forwarded header: the logs, the IP filter, the
+// middleware and the Authenticate function then see the client.
+//
+// The addresses are read from the last one, the one set by the proxy
+// which made the request, and the first untrusted one is the client,
+// so that the addresses forged by a client are ignored.
+//
+// # Parameters:
+//
+// 	- next (http.Handler): the wrapped handler.
+//
+// # Example:
+//
+// 	handler := w.realIP(w.mux)
+func (w *WebSocket) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ip := remoteIP(req)
+		if ip == nil || !contains(w.TrustedProxies, ip) {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		hops := forwardedFor(req)
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(hops[i])
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !contains(w.TrustedProxies, hop) {
+				break
+			}
+		}
+		req.RemoteAddr = ip.String()
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// forwardedFor returns the addresses of the X-Forwarded-For header,
+// or of the Forwarded header (RFC 7239) when it is missing, from the
+// client to the last proxy, without their ports.
+func forwardedFor(req *http.Request) []string {
+	var hops []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, stripPort(strings.TrimSpace(hop)))
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+
+	for _, header := range req.Header.Values("Forwarded") {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, stripPort(strings.Trim(value, `"`)))
+				}
+			}
+		}
+	}
+
+	return hops
+}
+
+// stripPort removes the port and the brackets of an address,
+// example: [2001:db8::17]:4711 is 2001:db8::17
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// contains reports whether ip is in one of nets.
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
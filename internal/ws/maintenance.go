@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// States of the maintenance messages.
+//
+// 	- MaintenanceStarted is sent when the delivery is paused.
+// 	- MaintenanceEnded is sent when it resumes, before the messages
+// 		held during the maintenance.
+const (
+	MaintenanceStarted = "started"
+	MaintenanceEnded   = "ended"
+)
+
+// Maintenance sends a maintenance message to the clients of the second
+// protocol version, with the state and the reason of the maintenance,
+// example:
+// {"v": 2, "type": "maintenance", "ts": "2024-01-01T00:00:00Z",
+// "data": {"state": "started", "reason": "database upgrade"}}
+// The clients connecting during the maintenance get it after the hello
+// message. Like the heartbeats, it is sent in a text frame, and a
+// client whose queue is full skips it.
+//
+// # Parameters:
+//
+// 	- active (bool): whether the maintenance starts or ends.
+// 	- reason (string): the reason of the maintenance, optional.
+//
+// # Example:
+//
+// 	w.Maintenance(true, "database upgrade")
+func (w *WebSocket) Maintenance(active bool, reason string) {
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	now := time.Now()
+	state := MaintenanceEnded
+	if active {
+		state = MaintenanceStarted
+	}
+	env := &envelope{Type: "maintenance", Time: &now, Data: map[string]string{"state": state}}
+	if reason != "" {
+		env.Data["reason"] = reason
+	}
+	w.maintenance = nil
+	if active {
+		w.maintenance = env
+	}
+
+	frames := make(map[int][]byte)
+	for _, c := range w.clients {
+		data, ok := frames[c.version]
+		if !ok {
+			data = c.encodeNotice(env)
+			frames[c.version] = data
+		}
+		if data != nil {
+			c.enqueue(TextMessage, data)
+		}
+	}
+}
+
+// notifyLocked queues the maintenance message of a maintenance in progress
+// for a new client, clientsMux must be held.
+func (w *WebSocket) notifyLocked(c *client) {
+	if w.maintenance == nil {
+		return
+	}
+	if data := c.encodeNotice(w.maintenance); data != nil {
+		c.enqueue(TextMessage, data)
+	}
+}
+
+// encodeNotice returns a notice in the protocol version of the client,
+// nil for the clients of the first version, which only receive data.
+func (c *client) encodeNotice(env *envelope) []byte {
+	if c.version < ProtocolV2 {
+		return nil
+	}
+	notice := *env
+	notice.V = c.version
+	data, err := json.Marshal(notice)
+	if err != nil {
+		c.log.Error("encoding notice failed", "type", env.Type, "error", err)
+		return nil
+	}
+
+	return data
+}
@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"context"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/metrics"
+)
+
+// pings pings a client every PingInterval, until it is disconnected
+// or the WebSocket is stopped, and records the round-trip time of the
+// pongs in the client, shown by Clients(), and in the metrics. A ping
+// without pong within PingInterval is logged and skipped.
+//
+// This method is called internally when a client connects.
+//
+// # Parameters:
+//
+// 	- c (*client): the client to ping.
+//
+// # Example:
+//
+// 	go w.pings(c)
+func (w *WebSocket) pings(c *client) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+		case <-w.stopped:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	ticker := time.NewTicker(w.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, pingCancel := context.WithTimeout(ctx, w.PingInterval)
+		rtt, err := c.conn.Ping(pingCtx)
+		pingCancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				c.log.Debug("ping failed", "error", err)
+			}
+			continue
+		}
+
+		c.rtt.Store(int64(rtt))
+		w.clientsMux.Lock()
+		tags := w.tagsLocked(nil)
+		w.clientsMux.Unlock()
+		w.Metrics.Timing(metrics.ClientRTT, rtt, tags)
+	}
+}
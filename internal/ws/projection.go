@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// sortedFields returns the fields of a projection sorted and without
+// duplicates, nil when there is none.
+//
+// # Parameters:
+//
+// 	- fields ([]string): the fields asked for in a subscription.
+//
+// # Example:
+//
+// 	c.fields[topic] = sortedFields(ctrl.Fields)
+func sortedFields(fields []string) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+	sorted := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if field != "" && !seen[field] {
+			seen[field] = true
+			sorted = append(sorted, field)
+		}
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// projection returns the fields the client asked for in its
+// subscription to a topic, joined, empty when it wants every field.
+// The clients with the same projection share the frames of a message.
+func (c *client) projection(topic string) string {
+	return strings.Join(c.fields[topic], ",")
+}
+
+// project returns a message with the fields the client asked for in
+// its subscription to the topic of the message only, in the data, the
+// full document, the array changes, the patch and the documents of a
+// snapshot. The document key is kept, the raw change is removed. The
+// message is returned as is when the client wants every field.
+//
+// # Parameters:
+//
+// 	- msg (event.Message): the message.
+//
+// # Example:
+//
+// 	msg = c.project(msg)
+func (c *client) project(msg event.Message) event.Message {
+	fields := c.fields[msg.Topic]
+	if len(fields) == 0 {
+		return msg
+	}
+	kept := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		kept[field] = true
+	}
+	only := func(m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+		projected := make(map[string]string, len(fields))
+		for field, value := range m {
+			if kept[field] {
+				projected[field] = value
+			}
+		}
+		return projected
+	}
+
+	msg.Data = only(msg.Data)
+	msg.FullDocument = only(msg.FullDocument)
+	msg.RawChange = nil
+	if len(msg.ArrayChanges) > 0 {
+		changes := make([]event.ArrayChange, 0, len(msg.ArrayChanges))
+		for _, change := range msg.ArrayChanges {
+			if kept[change.Field] {
+				changes = append(changes, change)
+			}
+		}
+		msg.ArrayChanges = changes
+	}
+	if len(msg.Patch) > 0 {
+		var patch map[string]json.RawMessage
+		err := json.Unmarshal(msg.Patch, &patch)
+		if err == nil {
+			for field := range patch {
+				if !kept[field] {
+					delete(patch, field)
+				}
+			}
+			msg.Patch, err = json.Marshal(patch)
+		}
+		if err != nil {
+			msg.Patch = nil
+		}
+	}
+	if len(msg.Documents) > 0 {
+		docs := make([]event.Document, len(msg.Documents))
+		for i, doc := range msg.Documents {
+			docs[i] = event.Document{DocumentKey: doc.DocumentKey, Data: only(doc.Data)}
+		}
+		msg.Documents = docs
+	}
+
+	return msg
+}
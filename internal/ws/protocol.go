@@ -0,0 +1,220 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// Versions of the wire protocol, every connected client is
+// served the version it negotiated so old and new clients
+// can be connected at the same time.
+//
+// 	- ProtocolV1 sends the selected keys as a flat JSON object,
+// 		example: {"title": "Hello"}
+// 	- ProtocolV2 wraps them in an envelope, example:
+// 		{"v": 2, "type": "event", "seq": 1, "topic": "posts", "op": "insert",
+// 		"clusterTime": {"t": 1700000000, "i": 1}, "ts": "2023-11-14T22:13:20.5Z",
+// 		"data": {"title": "Hello"}}
+// 	- ProtocolV3 sends the envelopes of the second version, several
+// 		of them in a JSON array when the messages are queued faster
+// 		than they are written, see MaxBatch, example:
+// 		[{"v": 3, "type": "event", "seq": 1, ...}, {"v": 3, "type": "event", "seq": 2, ...}]
+const (
+	ProtocolV1 = 1
+	ProtocolV2 = 2
+	ProtocolV3 = 3
+)
+
+// subprotocols are the Sec-WebSocket-Protocol values of the
+// supported versions, in order of preference of the server.
+var subprotocols = []string{"socketeer.v3", "socketeer.v2", "socketeer.v1"}
+
+// offeredSubprotocols are the subprotocols accepted by the websocket
+// endpoint: the versions, then the one of the bearer tokens.
+var offeredSubprotocols = append(subprotocols[:len(subprotocols):len(subprotocols)], BearerSubprotocol)
+
+// envelope is a message of the second version of the protocol.
+//
+// 	- V is the version of the protocol.
+// 	- Type is the type of the message, "hello" for the first message
+// 		of a connection, "event" for updates, "heartbeat" for the
+// 		heartbeats, "error" for the rejected control messages and
+// 		"maintenance" for the maintenance notices.
+// 	- ID is the connection ID, sent in the hello message.
+// 	- Session is the session token, sent in the hello message, which
+// 		the client presents on reconnection to resume its session.
+// 	- Compression is "zstd" in the hello message when the messages of
+// 		CompressThreshold bytes or more are sent compressed with zstd,
+// 		in binary frames.
+// 	- Seq is the position of the message, usable as a resume cursor.
+// 	- Topic is the topic of the message, which is the collection name.
+// 	- Op is the type of operation, example: "insert", "update".
+// 	- ClusterTime is the cluster time of the change in the database.
+// 	- Time is the wall-clock time the message was dispatched at.
+// 	- Data are the selected keys of the changed document, or the state
+// 		and the reason of a maintenance message.
+// 	- ArrayChanges are the changes of the selected array fields.
+// 	- DocumentKey is the _id (and shard key) of the changed document.
+// 	- FullDocument is the whole changed document.
+// 	- Patch is the change as a JSON Merge Patch of the selected keys.
+// 	- Documents are the current documents of the topic, in a snapshot.
+// 	- RawChange is the complete change document of the source, as
+// 		relaxed Extended JSON, when it is forwarded.
+// 	- Code identifies the error of an error message, see ErrorCode.
+// 	- Error describes the error of an error message.
+type envelope struct {
+	V            int                 `json:"v"`
+	Type         string              `json:"type"`
+	ID           string              `json:"id,omitempty"`
+	Session      string              `json:"session,omitempty"`
+	Compression  string              `json:"compression,omitempty"`
+	Seq          uint64              `json:"seq,omitempty"`
+	Topic        string              `json:"topic,omitempty"`
+	Op           string              `json:"op,omitempty"`
+	ClusterTime  *event.Timestamp    `json:"clusterTime,omitempty"`
+	Time         *time.Time          `json:"ts,omitempty"`
+	Data         map[string]string   `json:"data,omitempty"`
+	ArrayChanges []event.ArrayChange `json:"arrayChanges,omitempty"`
+	DocumentKey  map[string]string   `json:"documentKey,omitempty"`
+	FullDocument map[string]string   `json:"fullDocument,omitempty"`
+	Patch        json.RawMessage     `json:"patch,omitempty"`
+	Documents    []event.Document    `json:"documents,omitempty"`
+	RawChange    json.RawMessage     `json:"rawChange,omitempty"`
+	Code         string              `json:"code,omitempty"`
+	Error        string              `json:"error,omitempty"`
+}
+
+// negotiate returns the protocol version of a new connection,
+// taken from the negotiated subprotocol, from the "v" query
+// parameter for clients which can't set subprotocols, and
+// falling back to the first version otherwise.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the upgrade request.
+// 	- subprotocol (string): the negotiated subprotocol, empty if none.
+//
+// # Example:
+//
+// 	version := negotiate(req, conn.Subprotocol())
+func negotiate(req *http.Request, subprotocol string) int {
+	switch subprotocol {
+	case "socketeer.v3":
+		return ProtocolV3
+	case "socketeer.v2":
+		return ProtocolV2
+	case "socketeer.v1":
+		return ProtocolV1
+	}
+
+	v, err := strconv.Atoi(req.URL.Query().Get("v"))
+	if err == nil && v >= ProtocolV1 && v <= ProtocolV3 {
+		return v
+	}
+
+	return ProtocolV1
+}
+
+// Encoder encodes the messages sent to the clients in a custom wire
+// format, instead of the JSON of their protocol version, into the
+// data and the message type of a frame, TextMessage or BinaryMessage.
+// It returns ErrDefaultEncoding for the messages it leaves to the JSON.
+type Encoder interface {
+	Encode(msg event.Message) (data []byte, messageType int, err error)
+}
+
+// ErrDefaultEncoding is returned by an Encoder for the messages sent
+// in the JSON of the protocol version of the clients.
+var ErrDefaultEncoding = errors.New("ws: default encoding")
+
+// frameFor returns the frame of a message for a client, with the
+// fields it asked for only, see project(): the message encoded by the
+// Encoder in a frame of its type, binary for the clients
+// receiving binary frames, or the message encoded for the protocol
+// version of the client and packed, see pack(). The uncompressed
+// envelopes of the third version can be batched with the next ones.
+//
+// # Parameters:
+//
+// 	- c (*client): the client.
+// 	- msg (event.Message): the message.
+//
+// # Example:
+//
+// 	f, err := w.frameFor(c, msg)
+func (w *WebSocket) frameFor(c *client, msg event.Message) (frame, error) {
+	msg = c.project(msg)
+	if w.Encoder != nil {
+		data, messageType, err := w.Encoder.Encode(msg)
+		if err == nil {
+			if c.binary {
+				messageType = BinaryMessage
+			}
+			return frame{messageType: messageType, data: data}, nil
+		}
+		if !errors.Is(err, ErrDefaultEncoding) {
+			return frame{}, err
+		}
+	}
+
+	data, err := encode(msg, c.version)
+	if err != nil {
+		return frame{}, err
+	}
+	batchable := c.version >= ProtocolV3 && !(c.zstd && len(data) >= w.CompressThreshold)
+	messageType, data := w.pack(c, data)
+
+	return frame{messageType: messageType, data: data, batchable: batchable}, nil
+}
+
+// encode encodes a message for the given protocol version, the raw
+// change as is for the first version when the message carries one,
+// and the document key of a delete, which has no data.
+//
+// # Parameters:
+//
+// 	- msg (event.Message): the message to encode.
+// 	- version (int): the protocol version of the client.
+//
+// # Example:
+//
+// 	data, err := encode(msg, ProtocolV2)
+func encode(msg event.Message, version int) ([]byte, error) {
+	if version == ProtocolV1 {
+		if msg.RawChange != nil {
+			return msg.RawChange, nil
+		}
+		if msg.OperationType == event.OpDelete && len(msg.DocumentKey) > 0 {
+			return json.Marshal(msg.DocumentKey)
+		}
+		return json.Marshal(msg.Data)
+	}
+
+	env := envelope{
+		V:            version,
+		Type:         "event",
+		Seq:          msg.Seq,
+		Topic:        msg.Topic,
+		Op:           msg.OperationType,
+		Data:         msg.Data,
+		ArrayChanges: msg.ArrayChanges,
+		DocumentKey:  msg.DocumentKey,
+		FullDocument: msg.FullDocument,
+		Patch:        msg.Patch,
+		Documents:    msg.Documents,
+		RawChange:    msg.RawChange,
+	}
+	if !msg.ClusterTime.IsZero() {
+		env.ClusterTime = &msg.ClusterTime
+	}
+	if !msg.Time.IsZero() {
+		env.Time = &msg.Time
+	}
+
+	return json.Marshal(env)
+}
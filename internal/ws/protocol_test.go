@@ -0,0 +1,144 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		subprotocol string
+		want        int
+	}{
+		{"subprotocol v3", "/listen", "socketeer.v3", ProtocolV3},
+		{"subprotocol v2", "/listen", "socketeer.v2", ProtocolV2},
+		{"subprotocol v1", "/listen?v=3", "socketeer.v1", ProtocolV1},
+		{"query v2", "/listen?v=2", "", ProtocolV2},
+		{"query v3", "/listen?v=3", "", ProtocolV3},
+		{"query after a bearer subprotocol", "/listen?v=2", BearerSubprotocol, ProtocolV2},
+		{"unknown version", "/listen?v=4", "", ProtocolV1},
+		{"invalid version", "/listen?v=two", "", ProtocolV1},
+		{"nothing", "/listen", "", ProtocolV1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if got := negotiate(req, tt.subprotocol); got != tt.want {
+				t.Errorf("negotiate(%s, %q) = %d, want %d", tt.target, tt.subprotocol, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	insert := event.Message{
+		Seq:           7,
+		Topic:         "posts",
+		OperationType: "insert",
+		ClusterTime:   event.Timestamp{T: 1700000000, I: 1},
+		Time:          time.Date(2023, 11, 14, 22, 13, 20, 5e8, time.UTC),
+		Data:          map[string]string{"title": "Hello"},
+	}
+	deleted := event.Message{
+		Seq:           8,
+		Topic:         "posts",
+		OperationType: event.OpDelete,
+		DocumentKey:   map[string]string{"_id": "a1"},
+	}
+	raw := insert
+	raw.RawChange = json.RawMessage(`{"operationType":"insert","fullDocument":{"title":"Hello"}}`)
+
+	tests := []struct {
+		name    string
+		msg     event.Message
+		version int
+		want    string
+	}{
+		{"v1 data", insert, ProtocolV1, `{"title":"Hello"}`},
+		{"v1 raw change", raw, ProtocolV1, `{"operationType":"insert","fullDocument":{"title":"Hello"}}`},
+		{"v1 delete", deleted, ProtocolV1, `{"_id":"a1"}`},
+		{"v1 delete without key", event.Message{OperationType: event.OpDelete}, ProtocolV1, `null`},
+		{
+			"v2 insert", insert, ProtocolV2,
+			`{"v":2,"type":"event","seq":7,"topic":"posts","op":"insert","clusterTime":{"t":1700000000,"i":1},"ts":"2023-11-14T22:13:20.5Z","data":{"title":"Hello"}}`,
+		},
+		{
+			"v2 raw change", raw, ProtocolV2,
+			`{"v":2,"type":"event","seq":7,"topic":"posts","op":"insert","clusterTime":{"t":1700000000,"i":1},"ts":"2023-11-14T22:13:20.5Z","data":{"title":"Hello"},` +
+				`"rawChange":{"operationType":"insert","fullDocument":{"title":"Hello"}}}`,
+		},
+		{"v2 delete", deleted, ProtocolV2, `{"v":2,"type":"event","seq":8,"topic":"posts","op":"delete","documentKey":{"_id":"a1"}}`},
+		{
+			"v3 insert", insert, ProtocolV3,
+			`{"v":3,"type":"event","seq":7,"topic":"posts","op":"insert","clusterTime":{"t":1700000000,"i":1},"ts":"2023-11-14T22:13:20.5Z","data":{"title":"Hello"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := encode(tt.msg, tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("encode() = %s\nwant %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrameFor(t *testing.T) {
+	w := &WebSocket{}
+	msg := event.Message{Seq: 1, Topic: "posts", OperationType: "update", Data: map[string]string{"title": "Hi", "body": "Text"}}
+	tests := []struct {
+		name        string
+		client      *client
+		messageType int
+		batchable   bool
+		want        string
+	}{
+		{"v1", &client{version: ProtocolV1}, TextMessage, false, `{"body":"Text","title":"Hi"}`},
+		{"v2", &client{version: ProtocolV2}, TextMessage, false, `{"v":2,"type":"event","seq":1,"topic":"posts","op":"update","data":{"body":"Text","title":"Hi"}}`},
+		{"v3", &client{version: ProtocolV3}, TextMessage, true, `{"v":3,"type":"event","seq":1,"topic":"posts","op":"update","data":{"body":"Text","title":"Hi"}}`},
+		{"v2 binary", &client{version: ProtocolV2, binary: true}, BinaryMessage, false, `{"v":2,"type":"event","seq":1,"topic":"posts","op":"update","data":{"body":"Text","title":"Hi"}}`},
+		{
+			"v2 projected", &client{version: ProtocolV2, fields: map[string][]string{"posts": {"title"}}}, TextMessage, false,
+			`{"v":2,"type":"event","seq":1,"topic":"posts","op":"update","data":{"title":"Hi"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := w.frameFor(tt.client, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.messageType != tt.messageType || f.batchable != tt.batchable || string(f.data) != tt.want {
+				t.Errorf("frameFor() = %d, %v, %s\nwant %d, %v, %s", f.messageType, f.batchable, f.data, tt.messageType, tt.batchable, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	c := &client{version: ProtocolV3, maxBatch: 3, send: make(chan frame, 8)}
+	first := frame{messageType: TextMessage, data: []byte(`{"v":3,"seq":1}`), batchable: true}
+	c.send <- frame{messageType: TextMessage, data: []byte(`{"v":3,"seq":2}`), batchable: true}
+	c.send <- frame{messageType: TextMessage, data: []byte(`{"v":3,"seq":3}`), batchable: true}
+	c.send <- frame{messageType: TextMessage, data: []byte(`{"v":3,"seq":4}`), batchable: true}
+
+	f, held := c.batch(first)
+	if string(f.data) != `[{"v":3,"seq":1},{"v":3,"seq":2},{"v":3,"seq":3}]` || f.count != 3 || len(held) != 0 {
+		t.Errorf("batch() = %s of %d, %d held", f.data, f.count, len(held))
+	}
+
+	c.send <- frame{messageType: TextMessage, data: []byte(`{"type":"heartbeat"}`)}
+	f, held = c.batch(<-c.send)
+	if string(f.data) != `{"v":3,"seq":4}` || f.count != 0 || len(held) != 1 || string(held[0].data) != `{"type":"heartbeat"}` {
+		t.Errorf("batch() before a frame which can't be batched = %s, held %v", f.data, held)
+	}
+}
@@ -0,0 +1,151 @@
+package ws
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// SessionRecord is the record of a session kept by a SessionStore,
+// saved when its client connects and when it disconnects.
+//
+// 	- ID is the session ID.
+// 	- Identity is the identity of the client, empty when anonymous.
+// 	- Topics are the subscriptions of the client with their filter.
+// 	- Fields are the fields of the subscriptions of the client by topic.
+// 	- Cursor is the sequence number of the last message sent to the client.
+// 	- RemoteAddr is the address of the client.
+// 	- Online reports whether the client is connected.
+// 	- Connected is when the client last connected.
+// 	- LastSeen is when the record was saved, the session can be
+// 		resumed until LastSeen plus SessionTTL.
+type SessionRecord struct {
+	ID         string                  `json:"id"`
+	Identity   string                  `json:"identity,omitempty"`
+	Topics     map[string]event.Filter `json:"topics,omitempty"`
+	Fields     map[string][]string     `json:"fields,omitempty"`
+	Cursor     uint64                  `json:"cursor"`
+	RemoteAddr string                  `json:"remoteAddr"`
+	Online     bool                    `json:"online"`
+	Connected  time.Time               `json:"connected"`
+	LastSeen   time.Time               `json:"lastSeen"`
+}
+
+// SessionStore persists the sessions of the clients, so that they
+// survive the restarts of the server and their history can be listed.
+//
+// 	- Save creates or replaces the record of a session.
+// 	- Load returns the record of a session, it reports false when
+// 		there is none.
+// 	- List returns every record, the most recently seen first.
+type SessionStore interface {
+	Save(rec SessionRecord) error
+	Load(id string) (SessionRecord, bool, error)
+	List() ([]SessionRecord, error)
+}
+
+// restore loads the session of an upgrade request from the Sessions
+// store when it isn't kept in memory anymore, after a restart for
+// example, so that resume() finds it. It is called before clientsMux
+// is held, the store being slow.
+//
+// # Parameters:
+//
+// 	- req (*http.Request): the upgrade request.
+// 	- identity (string): the identity of the client.
+//
+// # Example:
+//
+// 	w.restore(req, id)
+func (w *WebSocket) restore(req *http.Request, identity string) {
+	if w.Sessions == nil {
+		return
+	}
+	id, ok := w.verify(req.URL.Query().Get("session"))
+	if !ok {
+		return
+	}
+	w.clientsMux.Lock()
+	_, ok = w.sessions[id]
+	w.clientsMux.Unlock()
+	if ok {
+		return
+	}
+
+	rec, ok, err := w.Sessions.Load(id)
+	if err != nil {
+		w.Log.Error("loading session failed", "session", id, "error", err)
+		w.report(err, map[string]any{"session": id})
+		return
+	}
+	if !ok || rec.Identity != identity {
+		return
+	}
+
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+	if _, ok := w.sessions[id]; !ok {
+		w.sessions[id] = &session{
+			identity: rec.Identity,
+			topics:   rec.Topics,
+			fields:   rec.Fields,
+			cursor:   rec.Cursor,
+			expires:  rec.LastSeen.Add(w.SessionTTL),
+		}
+	}
+}
+
+// sessionRecord returns the record of the session of a client, with
+// a copy of its subscriptions which it changes meanwhile. The caller
+// must hold clientsMux.
+func (w *WebSocket) sessionRecord(c *client, online bool) SessionRecord {
+	var topics map[string]event.Filter
+	if c.topics != nil {
+		topics = make(map[string]event.Filter, len(c.topics))
+		for topic, filter := range c.topics {
+			topics[topic] = filter
+		}
+	}
+	var fields map[string][]string
+	if c.fields != nil {
+		fields = make(map[string][]string, len(c.fields))
+		for topic, f := range c.fields {
+			fields[topic] = f
+		}
+	}
+
+	return SessionRecord{
+		ID:         c.session,
+		Identity:   c.identity,
+		Topics:     topics,
+		Fields:     fields,
+		Cursor:     c.cursor,
+		RemoteAddr: c.remoteAddr,
+		Online:     online,
+		Connected:  c.connected,
+		LastSeen:   time.Now(),
+	}
+}
+
+// save saves the record of a session to the Sessions store, the
+// failures are logged and reported.
+//
+// # Parameters:
+//
+// 	- rec (SessionRecord): the record.
+//
+// # Example:
+//
+// 	w.save(rec)
+func (w *WebSocket) save(rec SessionRecord) {
+	if w.Sessions == nil || rec.ID == "" {
+		return
+	}
+
+	err := w.Sessions.Save(rec)
+	if err != nil {
+		w.Log.Error("saving session failed", "session", rec.ID, "error", err)
+		w.report(err, map[string]any{"session": rec.ID})
+	}
+}
@@ -0,0 +1,222 @@
+package ws
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// Defaults of the session settings.
+const (
+	DefaultSessionTTL  = 5 * time.Minute
+	DefaultHistorySize = 1024
+)
+
+// session is the state of a client kept after it disconnected,
+// so that the client can resume it by presenting its session token.
+//
+// 	- identity is the identity of the client, only the
+// 		same identity can resume the session.
+// 	- topics are the subscriptions of the client with their filter.
+// 	- fields are the fields of the subscriptions of the client by topic.
+// 	- cursor is the sequence number of the last message sent to the client.
+// 	- expires is when the session is forgotten.
+type session struct {
+	identity string
+	topics   map[string]event.Filter
+	fields   map[string][]string
+	cursor   uint64
+	expires  time.Time
+}
+
+// token returns the session token of a session ID, which is
+// the ID followed by its HMAC-SHA256 signature, so that clients
+// can't resume the sessions of other clients.
+//
+// # Parameters:
+//
+// 	- id (string): the session ID.
+//
+// # Example:
+//
+// 	token := w.token(c.session)
+func (w *WebSocket) token(id string) string {
+	mac := hmac.New(sha256.New, w.SessionSecret)
+	mac.Write([]byte(id))
+
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the session ID of a session token,
+// it reports false when the signature is invalid.
+func (w *WebSocket) verify(token string) (string, bool) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+
+	return id, hmac.Equal([]byte(token), []byte(w.token(id)))
+}
+
+// resume restores the session of a new client from the "session"
+// query parameter of the upgrade request: its subscriptions and its
+// cursor, taken from the "cursor" query parameter or from the session.
+// A client without a valid session gets a new one, and resume reports
+// false. The caller must hold clientsMux.
+//
+// # Parameters:
+//
+// 	- c (*client): the new client.
+// 	- req (*http.Request): the upgrade request.
+//
+// # Example:
+//
+// 	if w.resume(c, req) {
+// 		w.replay(c)
+// 	}
+func (w *WebSocket) resume(c *client, req *http.Request) bool {
+	query := req.URL.Query()
+
+	id, ok := w.verify(query.Get("session"))
+	if !ok {
+		c.session = newID()
+		return false
+	}
+	c.session = id
+
+	s, ok := w.sessions[id]
+	if !ok || s.identity != c.identity || time.Now().After(s.expires) {
+		return false
+	}
+	delete(w.sessions, id)
+
+	c.topics = s.topics
+	c.fields = s.fields
+	c.cursor = s.cursor
+	if n, err := strconv.ParseUint(query.Get("cursor"), 10, 64); err == nil {
+		c.cursor = n
+	}
+
+	return true
+}
+
+// replay queues the messages of the history the client missed since
+// its cursor, up to the capacity of its queue. The caller must hold
+// clientsMux so that no message is dispatched in the meantime.
+func (w *WebSocket) replay(c *client) {
+	for _, msg := range w.history {
+		if msg.Seq <= c.cursor || !c.wants(msg) {
+			continue
+		}
+
+		f, err := w.frameFor(c, msg)
+		if err != nil || !c.push(f) {
+			c.log.Warn("replay stopped", "cursor", c.cursor)
+			return
+		}
+		c.cursor = msg.Seq
+	}
+}
+
+// suspend keeps the session of a disconnected client until SessionTTL
+// is over and forgets the expired sessions. The caller must hold clientsMux.
+//
+// # Parameters:
+//
+// 	- c (*client): the disconnected client.
+//
+// # Example:
+//
+// 	w.suspend(c)
+func (w *WebSocket) suspend(c *client) {
+	now := time.Now()
+	for id, s := range w.sessions {
+		if now.After(s.expires) {
+			delete(w.sessions, id)
+		}
+	}
+
+	if c.session == "" {
+		return
+	}
+	w.sessions[c.session] = &session{
+		identity: c.identity,
+		topics:   c.topics,
+		fields:   c.fields,
+		cursor:   c.cursor,
+		expires:  now.Add(w.SessionTTL),
+	}
+}
+
+// record appends a message to the history replayed to resumed
+// sessions, dropping the oldest message once HistorySize is reached.
+// The caller must hold clientsMux.
+func (w *WebSocket) record(msg event.Message) {
+	if w.HistorySize <= 0 {
+		return
+	}
+
+	if len(w.history) >= w.HistorySize {
+		copy(w.history, w.history[1:])
+		w.history = w.history[:len(w.history)-1]
+	}
+	w.history = append(w.history, msg)
+}
+
+// History returns the messages of the history after a sequence number,
+// by increasing sequence number, which want accepts: up to limit of
+// them, and whether more messages follow. Truncated reports that the
+// history dropped messages after the sequence number, which can't be
+// returned anymore.
+//
+// # Parameters:
+//
+// 	- after (uint64): the sequence number of the last message read,
+// 		0 for the oldest message kept.
+// 	- limit (int): the maximal number of messages.
+// 	- want (func(event.Message) bool): whether a message is returned.
+//
+// # Example:
+//
+// 	msgs, more, truncated := w.History(cursor, 100, func(msg event.Message) bool { return true })
+func (w *WebSocket) History(after uint64, limit int, want func(event.Message) bool) (msgs []event.Message, more bool, truncated bool) {
+	w.clientsMux.Lock()
+	history := make([]event.Message, len(w.history))
+	copy(history, w.history)
+	w.clientsMux.Unlock()
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Seq < history[j].Seq
+	})
+	truncated = after > 0 && len(history) > 0 && history[0].Seq > after+1
+
+	for _, msg := range history {
+		if msg.Seq <= after || !want(msg) {
+			continue
+		}
+		if len(msgs) == limit {
+			return msgs, true, truncated
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, false, truncated
+}
+
+// newSecret returns a random secret signing the session tokens,
+// used when no SessionSecret is configured, the tokens are then
+// only valid until the server restarts.
+func newSecret() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+
+	return b
+}
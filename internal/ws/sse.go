@@ -0,0 +1,156 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errStreamClosed is returned by the reads of an event stream once
+// its client went away or it was closed.
+var errStreamClosed = errors.New("ws: event stream closed")
+
+// sseConn adapts a Server-Sent Events response to the Conn interface,
+// so that the clients which can't open a websocket, behind a proxy
+// stripping the upgrades for example, receive the same messages on
+// the same endpoint. An event stream is one way: the messages are
+// written as "data" events, the binary ones base64 encoded as "binary"
+// events, and the reads block until the stream is over.
+//
+// 	- res is the response the events are written to.
+// 	- flusher flushes the events to the client.
+// 	- mux serializes the writes and the closing.
+// 	- done is closed when the stream is over.
+// 	- closeOnce closes done once.
+type sseConn struct {
+	res       http.ResponseWriter
+	flusher   http.Flusher
+	mux       sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// wantsEventStream reports whether a request to the endpoint asks for
+// an event stream instead of a websocket upgrade.
+func wantsEventStream(req *http.Request) bool {
+	return req.Method == http.MethodGet &&
+		!strings.EqualFold(req.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+}
+
+// newSSEConn starts the event stream of a request, it fails when
+// the response can't be flushed.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	conn, err := newSSEConn(res, req)
+func newSSEConn(res http.ResponseWriter, req *http.Request) (*sseConn, error) {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		return nil, errors.New("ws: event stream without flusher")
+	}
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := &sseConn{res: res, flusher: flusher, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-req.Context().Done():
+			c.Close()
+		case <-c.done:
+		}
+	}()
+
+	return c, nil
+}
+
+// ReadMessage blocks until the stream is over, the clients of an
+// event stream send no control message.
+func (c *sseConn) ReadMessage() (int, []byte, error) {
+	<-c.done
+
+	return 0, nil, errStreamClosed
+}
+
+// WriteMessage writes a message as an event.
+func (c *sseConn) WriteMessage(messageType int, data []byte) error {
+	var event bytes.Buffer
+	if messageType == BinaryMessage {
+		event.WriteString("event: binary\ndata: ")
+		event.WriteString(base64.StdEncoding.EncodeToString(data))
+		event.WriteByte('\n')
+	} else {
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			event.WriteString("data: ")
+			event.Write(line)
+			event.WriteByte('\n')
+		}
+	}
+	event.WriteByte('\n')
+
+	return c.write(event.Bytes())
+}
+
+// WriteClose writes a "close" event with the reason, the stream
+// is over once the handler returns.
+func (c *sseConn) WriteClose(code int, reason string) error {
+	return c.write([]byte("event: close\ndata: " + reason + "\n\n"))
+}
+
+// Subprotocol returns no subprotocol, the protocol version of an
+// event stream is taken from its "v" query parameter.
+func (c *sseConn) Subprotocol() string {
+	return ""
+}
+
+// Ping writes a comment, which keeps the proxies from timing the
+// stream out. The client doesn't answer it, the round-trip time
+// is 0.
+func (c *sseConn) Ping(ctx context.Context) (time.Duration, error) {
+	return 0, c.write([]byte(": ping\n\n"))
+}
+
+// Close ends the stream, it waits for the write in progress so that
+// nothing is written once the handler returned.
+func (c *sseConn) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+
+	return nil
+}
+
+// write writes and flushes an event, it fails once the stream is over.
+func (c *sseConn) write(event []byte) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	select {
+	case <-c.done:
+		return errStreamClosed
+	default:
+	}
+	_, err := c.res.Write(event)
+	if err != nil {
+		return err
+	}
+	c.flusher.Flush()
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Stats are the statistics of the connected clients.
+//
+// 	- Clients is the number of connected clients.
+// 	- Identities is the number of connected or queued identities.
+// 	- Sessions is the number of sessions kept for disconnected clients.
+// 	- QueueDepth is the number of frames queued to all the clients.
+// 	- MaxQueueDepth is the largest number of frames queued to a client.
+// 	- History is the number of messages kept for resumed sessions.
+type Stats struct {
+	Clients       int `json:"clients"`
+	Identities    int `json:"identities"`
+	Sessions      int `json:"sessions"`
+	QueueDepth    int `json:"queueDepth"`
+	MaxQueueDepth int `json:"maxQueueDepth"`
+	History       int `json:"history"`
+}
+
+// Stats returns the statistics of the connected clients.
+//
+// # Example:
+//
+// 	stats := ws.Stats()
+func (w *WebSocket) Stats() Stats {
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	stats := Stats{
+		Clients:    len(w.clients),
+		Identities: len(w.identities),
+		Sessions:   len(w.sessions),
+		History:    len(w.history),
+	}
+	for _, c := range w.clients {
+		depth := len(c.send)
+		stats.QueueDepth += depth
+		if depth > stats.MaxQueueDepth {
+			stats.MaxQueueDepth = depth
+		}
+	}
+
+	return stats
+}
+
+// ClientInfo describes a connected client.
+//
+// 	- ID is the connection ID of the client.
+// 	- Identity is the authenticated identity of the client, if any.
+// 	- RemoteAddr is the address of the client.
+// 	- Version is the protocol version negotiated by the client.
+// 	- Connected is when the client connected.
+// 	- Topics are the topics the client subscribed to, every topic when empty.
+// 	- QueueDepth is the number of frames queued to the client.
+// 	- RTT is the round-trip time of the last ping in milliseconds,
+// 		0 until a pong is read or when the clients are not pinged.
+type ClientInfo struct {
+	ID         string    `json:"id"`
+	Identity   string    `json:"identity,omitempty"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Version    int       `json:"version"`
+	Connected  time.Time `json:"connected"`
+	Topics     []string  `json:"topics,omitempty"`
+	QueueDepth int       `json:"queueDepth"`
+	RTT        float64   `json:"rtt"`
+}
+
+// Clients returns the connected clients, oldest first.
+//
+// # Example:
+//
+// 	clients := ws.Clients()
+func (w *WebSocket) Clients() []ClientInfo {
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	clients := make([]ClientInfo, 0, len(w.clients))
+	for _, c := range w.clients {
+		info := ClientInfo{
+			ID:         c.id,
+			Identity:   c.identity,
+			RemoteAddr: c.remoteAddr,
+			Version:    c.version,
+			Connected:  c.connected,
+			QueueDepth: len(c.send),
+			RTT:        float64(c.rtt.Load()) / float64(time.Millisecond),
+		}
+		for topic := range c.topics {
+			info.Topics = append(info.Topics, topic)
+		}
+		sort.Strings(info.Topics)
+		clients = append(clients, info)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Connected.Before(clients[j].Connected)
+	})
+
+	return clients
+}
+
+// Stream upgrades a request to a websocket connection and pushes the
+// value returned by next as JSON every interval, until the connection
+// is closed by the peer or the server is stopped. It is used by the
+// admin endpoints, which authenticate the request beforehand.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the upgrade request.
+// 	- interval (time.Duration): the interval between two values.
+// 	- next (func() any): returns the value to push.
+//
+// # Example:
+//
+// 	w.Stream(res, req, time.Second, func() any { return w.Stats() })
+func (w *WebSocket) Stream(res http.ResponseWriter, req *http.Request, interval time.Duration, next func() any) {
+	if !w.track() {
+		http.Error(res, ErrStopped.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer w.wg.Done()
+
+	conn, closed, ok := w.upgradeStream(res, req)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		data, err := json.Marshal(next())
+		if err != nil {
+			w.Log.Error("encoding stream value failed", "error", err)
+			return
+		}
+		err = conn.WriteMessage(TextMessage, data)
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-closed:
+			return
+		case <-w.stopped:
+			conn.WriteClose(CloseGoingAway, "server shutting down")
+			return
+		}
+	}
+}
+
+// Forward upgrades a request to a websocket connection and writes
+// every frame received on frames to it, until frames is closed, the
+// connection is closed by the peer or the server is stopped. It is
+// used by the admin endpoints, which authenticate the request beforehand.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the upgrade request.
+// 	- frames (<-chan []byte): the frames to write.
+//
+// # Example:
+//
+// 	w.Forward(res, req, frames)
+func (w *WebSocket) Forward(res http.ResponseWriter, req *http.Request, frames <-chan []byte) {
+	if !w.track() {
+		http.Error(res, ErrStopped.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer w.wg.Done()
+
+	conn, closed, ok := w.upgradeStream(res, req)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		select {
+		case data, ok := <-frames:
+			if !ok {
+				conn.WriteClose(CloseNormal, "")
+				return
+			}
+			err := conn.WriteMessage(TextMessage, data)
+			if err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-w.stopped:
+			conn.WriteClose(CloseGoingAway, "server shutting down")
+			return
+		}
+	}
+}
+
+// upgradeStream upgrades the request of a stream and reads the
+// connection in the background, the returned channel is closed once
+// the connection is closed by the peer. It reports false when the
+// upgrade failed.
+func (w *WebSocket) upgradeStream(res http.ResponseWriter, req *http.Request) (Conn, <-chan struct{}, bool) {
+	conn, err := defaultBackend.upgrade(res, req, nil, w.Upgrade)
+	if err != nil {
+		w.Log.Warn("upgrade failed", "remoteAddr", req.RemoteAddr, "error", err)
+		return nil, nil, false
+	}
+
+	closed := make(chan struct{})
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer close(closed)
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return conn, closed, true
+}
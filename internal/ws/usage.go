@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultMeterInterval is the period of the usage summaries when
+// MeterInterval is not set.
+const DefaultMeterInterval = time.Minute
+
+// Usage is the consumption of an identity over a period.
+//
+// 	- Identity is the identity, empty for the anonymous clients.
+// 	- Messages is the number of messages written to its clients,
+// 		every message of a batch counted.
+// 	- Bytes is the number of bytes written to its clients, the
+// 		payloads of the frames.
+// 	- Start is the beginning of the period.
+// 	- End is the end of the period.
+type Usage struct {
+	Identity string    `json:"identity"`
+	Messages uint64    `json:"messages"`
+	Bytes    uint64    `json:"bytes"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
+
+// meter aggregates the usage of the identities over the current period.
+//
+// 	- mux guards the fields.
+// 	- start is the beginning of the period.
+// 	- usage is the usage of the period by identity.
+type meter struct {
+	mux   sync.Mutex
+	start time.Time
+	usage map[string]*Usage
+}
+
+// add adds a written frame to the usage of an identity.
+//
+// # Parameters:
+//
+// 	- identity (string): the identity of the client.
+// 	- messages (int): the number of messages of the frame.
+// 	- bytes (int): the size of the frame.
+//
+// # Example:
+//
+// 	m.add(c.identity, 1, len(f.data))
+func (m *meter) add(identity string, messages int, bytes int) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	u, ok := m.usage[identity]
+	if !ok {
+		u = &Usage{Identity: identity}
+		m.usage[identity] = u
+	}
+	u.Messages += uint64(messages)
+	u.Bytes += uint64(bytes)
+}
+
+// flush returns the usage of the period ending at now, and starts
+// the next period.
+func (m *meter) flush(now time.Time) []Usage {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	usage := make([]Usage, 0, len(m.usage))
+	for _, u := range m.usage {
+		u.Start = m.start
+		u.End = now
+		usage = append(usage, *u)
+	}
+	m.usage = make(map[string]*Usage)
+	m.start = now
+
+	return usage
+}
+
+// meters reports the usage of the identities to Meter every
+// MeterInterval, until the WebSocket is stopped. The usage of the
+// last period is reported by Stop(), once the clients are drained.
+//
+// This method is called internally when the WebSocket is started.
+//
+// # Example:
+//
+// 	go w.meters()
+func (w *WebSocket) meters() {
+	interval := w.MeterInterval
+	if interval <= 0 {
+		interval = DefaultMeterInterval
+	}
+	w.meter.flush(time.Now())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case now := <-ticker.C:
+			w.reportUsage(now)
+		}
+	}
+}
+
+// reportUsage calls Meter with the usage of every identity which
+// consumed anything over the period ending at now.
+func (w *WebSocket) reportUsage(now time.Time) {
+	for _, u := range w.meter.flush(now) {
+		w.Meter(u)
+	}
+}
@@ -6,7 +6,13 @@
 // 	1. Create a new WebSocket type with NewWebSocket().
 // 	2. Start the WebSocket with Start().
 // 	3. Stop the WebSocket with Stop().
-//	4. Dispatch updates to clients with DispatchUpdate().
+//	4. Dispatch updates to clients with Dispatch().
+//
+// Clients may send {"type": "subscribe", "topic": "<collection>"} and
+// {"type": "unsubscribe", "topic": "<collection>"} messages to only receive
+// the updates of some collections, they receive every update otherwise.
+// A subscription can carry a filter and the fields the client wants,
+// example: {"type": "subscribe", "topic": "posts", "fields": ["title"]}
 //
 // No need to call these methods exclusively, they are
 // automatically called and are executed synchronously
@@ -14,46 +20,248 @@
 package ws
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"runtime/debug"
 	"sync"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/darthsalad/socketeer/internal/chaos"
+	"github.com/darthsalad/socketeer/internal/event"
+	"github.com/darthsalad/socketeer/internal/logger"
+	"github.com/darthsalad/socketeer/internal/metrics"
 )
 
 // WebSocket is an interface for handling websocket connections.
 //
-// 	- clients is a map of websocket connections.
+// 	- clients is a map of the connected clients by connection ID.
 // 	- clientsMux is a mutex for clients for thread safety.
+// 	- mux is the router of the http server, serving the websocket
+// 		endpoint and the routes added with Handle().
+// 	- server is the http server, set by Start().
+// 	- DrainTimeout is how long Stop() waits for the in-flight writes
+// 		and the close handshakes before closing the connections.
+// 	- SendBuffer is the number of messages queued per client.
+// 	- Chaos is an optional fault injector, used in tests only.
+// 	- sessions are the sessions of the disconnected clients by session ID.
+// 	- history are the last dispatched messages, replayed to resumed sessions.
+// 	- SessionSecret is the key signing the session tokens.
+// 	- SessionTTL is how long the session of a disconnected client is kept.
+// 	- Sessions persists the sessions, so that they survive the restarts,
+// 		optional.
+// 	- TLSConfig serves the websocket server over TLS when set, with the
+// 		certificates of its GetCertificate.
+// 	- HistorySize is the number of messages kept for resumed sessions.
+// 	- identities are the sessions of the authenticated identities by identity.
+// 	- Authenticate returns the identity of an upgrade request, the
+// 		connection is rejected when it fails, optional.
+// 	- MaxConnsPerIdentity is the maximal number of connections of an
+// 		identity, 0 for no limit.
+// 	- MaxSubscriptions is the maximal number of topics a connection
+// 		subscribes to, 0 for no limit. See ErrorSubscriptionLimit.
+// 	- OfflineQueue is the number of messages kept for an identity
+// 		without any connected client.
+// 	- Metrics records the metrics of the connections and dispatches.
+// 	- Report is called with the unexpected failures, optional.
+// 	- Log is the Logger of the package.
+// 	- endpoint is the websocket endpoint, set by Start() and
+// 		added to the tags of the metrics.
+// 	- Inspect is called with every dispatched message and the connection
+// 		IDs of its recipients, optional. It is called while dispatching
+// 		and must not block.
+// 	- stopped is closed by Stop(), ending the streams.
+// 	- stopOnce guards the closing of stopped.
+// 	- Middleware wraps the upgrade handler of the endpoint, the first
+// 		middleware is the outermost one.
+// 	- ServerMiddleware wraps the handler of the whole server, every
+// 		path, the first middleware is the outermost one.
+// 	- Upgrade configures the upgrade of the connections.
+// 	- AuthenticateToken returns the identity of the bearer token of
+// 		an upgrade request, when Authenticate is nil.
+// 	- TokenQuery is the query parameter carrying the token, if any.
+// 	- TokenCookie is the cookie carrying the token, if any.
+// 	- Allow are the networks allowed to connect, every one when empty.
+// 	- Deny are the networks rejected, even when they are in Allow.
+// 	- TrustedProxies are the networks of the proxies whose forwarding
+// 		headers are trusted for the address of the clients.
+// 	- CompressThreshold is the size from which the messages are
+// 		compressed with zstd for the clients asking for it, 0 to
+// 		never compress.
+// 	- BinaryFrames sends the messages in binary frames to every
+// 		client, instead of to the ones asking for it.
+// 	- Heartbeat is the interval of the heartbeat messages sent to the
+// 		clients of the second protocol version, 0 for none.
+// 	- seq is the sequence number of the last dispatched message.
+// 	- PingInterval is the interval of the pings measuring the
+// 		round-trip time of the clients, 0 for none.
+// 	- Encoder encodes the messages in a custom wire format for every
+// 		client, nil for the JSON of their protocol version.
+// 	- MaxBatch is the maximal number of messages written in a frame
+// 		to the clients of the third protocol version, 0 or 1 for one
+// 		message per frame.
+// 	- Scope returns the filter restricting what an identity receives
+// 		on a topic, merged into the filters of its subscriptions, nil
+// 		for no restriction. Optional.
+// 	- Meter is called with the usage of every identity which consumed
+// 		anything, every MeterInterval, optional. It must not block.
+// 	- MeterInterval is the period of the usage summaries, defaults to
+// 		DefaultMeterInterval.
+// 	- meter aggregates the usage of the current period.
+// 	- wg tracks the connection and stream goroutines, Stop() waits
+// 		for them.
+// 	- maintenance is the maintenance message while a maintenance is in
+// 		progress, sent to the new clients, nil otherwise.
+// 	- SkipDelivered skips the messages at or before the cursor of a
+// 		client, for the sequence numbers resumed from another instance,
+// 		whose clients come with a cursor ahead of this one.
+// 	- Listener is the listener the server accepts the connections on,
+// 		opened by the caller, like a socket passed by systemd, instead
+// 		of listening on the host given to Start(). Optional.
+// 	- err is the failure of the server which made Start() return.
 type WebSocket struct {
-	clients    map[*websocket.Conn]struct{}
-	clientsMux sync.Mutex
+	clients             map[string]*client
+	clientsMux          sync.Mutex
+	mux                 *http.ServeMux
+	server              *http.Server
+	DrainTimeout        time.Duration
+	SendBuffer          int
+	Chaos               *chaos.Injector
+	sessions            map[string]*session
+	history             []event.Message
+	SessionSecret       []byte
+	SessionTTL          time.Duration
+	Sessions            SessionStore
+	TLSConfig           *tls.Config
+	HistorySize         int
+	identities          map[string]*identity
+	Authenticate        func(req *http.Request) (string, error)
+	MaxConnsPerIdentity int
+	MaxSubscriptions    int
+	OfflineQueue        int
+	Metrics             metrics.Recorder
+	Report              func(err error, ctx map[string]any)
+	Log                 logger.Logger
+	endpoint            string
+	Inspect             func(msg event.Message, recipients []string)
+	stopped             chan struct{}
+	stopOnce            sync.Once
+	Middleware          []func(http.Handler) http.Handler
+	ServerMiddleware    []func(http.Handler) http.Handler
+	Upgrade             UpgradeOptions
+	AuthenticateToken   func(token string) (string, error)
+	TokenQuery          string
+	TokenCookie         string
+	Allow               []*net.IPNet
+	Deny                []*net.IPNet
+	TrustedProxies      []*net.IPNet
+	CompressThreshold   int
+	BinaryFrames        bool
+	Scope               func(identity string, topic string) event.Filter
+	Heartbeat           time.Duration
+	seq                 uint64
+	PingInterval        time.Duration
+	Encoder             Encoder
+	MaxBatch            int
+	Meter               func(usage Usage)
+	MeterInterval       time.Duration
+	meter               *meter
+	wg                  sync.WaitGroup
+	maintenance         *envelope
+	SkipDelivered       bool
+	Listener            net.Listener
+	err                 error
 }
 
+// Defaults of the WebSocket settings.
+const (
+	DefaultDrainTimeout = 5 * time.Second
+	DefaultSendBuffer   = 256
+)
+
+// Close codes sent by the server, the 4000-4999 range is
+// reserved by the websocket protocol for applications.
+//
+// 	- CloseNormal is sent when the client closed the connection.
+// 	- CloseGoingAway is sent when the server shuts down, clients should reconnect.
+// 	- ClosePolicyViolation is sent when the client broke a policy, clients should not retry.
+// 	- CloseTryAgainLater is sent when the client can't keep up, clients should retry with backoff.
+// 	- CloseKicked is sent when an operator kicked the client, clients should not retry.
+// 	- CloseAuthExpired is sent when the credentials of the client expired,
+// 		clients should authenticate again before reconnecting.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	ClosePolicyViolation = 1008
+	CloseTryAgainLater   = 1013
+	CloseKicked          = 4000
+	CloseAuthExpired     = 4001
+)
+
+// ErrUnknownClient is returned when kicking a connection which doesn't exist.
+var ErrUnknownClient = errors.New("ws: unknown client")
+
+// ErrStopped is returned to the connections made while the
+// WebSocket is stopping.
+var ErrStopped = errors.New("ws: server stopped")
+
 // NewWebSocket returns a new WebSocket.
 //
 // This method is utilized to create a new WebSocket type 
 // and the clients map is initialized which is initially empty.
+// The session tokens are signed with a random secret.
 //
 // # Example:
 //
 // 	conn := ws.NewWebSocket()
 func NewWebSocket() *WebSocket {
 	return &WebSocket{
-		clients: make(map[*websocket.Conn]struct{}),
+		clients:       make(map[string]*client),
+		mux:           http.NewServeMux(),
+		DrainTimeout:  DefaultDrainTimeout,
+		SendBuffer:    DefaultSendBuffer,
+		sessions:      make(map[string]*session),
+		SessionSecret: newSecret(),
+		SessionTTL:    DefaultSessionTTL,
+		HistorySize:   DefaultHistorySize,
+		identities:    make(map[string]*identity),
+		OfflineQueue:  DefaultOfflineQueue,
+		Metrics:       metrics.Nop{},
+		Log:           logger.With(logger.Default, "component", "ws"),
+		stopped:       make(chan struct{}),
+		meter:         &meter{start: time.Now(), usage: make(map[string]*Usage)},
 	}
 }
 
+// Handle registers an additional http handler served next to the
+// websocket endpoint, it has to be called before Start().
+//
+// # Parameters:
+//
+// 	- pattern (string): the pattern of the route, example: /version
+// 	- handler (http.Handler): the handler of the route.
+//
+// # Example:
+//
+// 	ws.Handle("/version", versionHandler)
+func (w *WebSocket) Handle(pattern string, handler http.Handler) {
+	w.mux.Handle(pattern, handler)
+}
+
 // Start starts the https server and calls the
 // websocketHandler method when a connection is made
 // to upgrade the connection to a websocket connection.
+// It returns right away when the WebSocket is already
+// stopped, Err() then returns ErrStopped.
 //
 // This method is called internally when the socketeer is started.
 //
 // # Parameters:
 // 
 // 	- host (string): the host address to listen on, example: localhost:8080 
+// 		the connections are accepted on the Listener instead, when set.
 // 	- endpoint (string): the endpoint to listen on (without the trailing slash), 
 // 		example: /listen 
 //
@@ -61,15 +269,85 @@ func NewWebSocket() *WebSocket {
 //
 // 	ws.Start("localhost:8080", "/listen") // listens on 'ws://localhost:8080/listen' endpoint
 func (w *WebSocket) Start(host string, endpoint string) {
-	http.HandleFunc(endpoint, w.websocketHandler)
-	err := http.ListenAndServe(host, nil)
-	if err != nil {
-		log.Fatal(err)
+	var handler http.Handler = http.HandlerFunc(w.websocketHandler)
+	for i := len(w.Middleware) - 1; i >= 0; i-- {
+		handler = w.Middleware[i](handler)
+	}
+	w.mux.Handle(endpoint, handler)
+	handler = w.realIP(w.mux)
+	for i := len(w.ServerMiddleware) - 1; i >= 0; i-- {
+		handler = w.ServerMiddleware[i](handler)
+	}
+	server := &http.Server{
+		Addr:      host,
+		Handler:   handler,
+		TLSConfig: w.TLSConfig,
+	}
+
+	w.clientsMux.Lock()
+	select {
+	case <-w.stopped:
+		w.err = ErrStopped
+		w.clientsMux.Unlock()
+		return
+	default:
+	}
+	w.endpoint = endpoint
+	w.server = server
+	w.clientsMux.Unlock()
+
+	if w.Heartbeat > 0 && w.track() {
+		go func() {
+			defer w.wg.Done()
+			w.heartbeats()
+		}()
+	}
+	if w.Meter != nil && w.track() {
+		go func() {
+			defer w.wg.Done()
+			w.meters()
+		}()
+	}
+
+	var err error
+	switch {
+	case w.Listener != nil && w.TLSConfig != nil:
+		err = server.ServeTLS(w.Listener, "", "")
+	case w.Listener != nil:
+		err = server.Serve(w.Listener)
+	case w.TLSConfig != nil:
+		err = server.ListenAndServeTLS("", "")
+	default:
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		w.Log.Error("server failed", "host", host, "error", err)
+		w.report(err, map[string]any{"host": host})
+		w.clientsMux.Lock()
+		w.err = err
+		w.clientsMux.Unlock()
 	}
 }
 
-// Stop stops the websocket server and closes all
-// websocket connections.
+// Err returns the failure of the server which made Start() return,
+// like an address already in use, nil when it was stopped, and
+// ErrStopped when Start() was called once it was stopped.
+//
+// # Example:
+//
+// 	err := ws.Err()
+func (w *WebSocket) Err() error {
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	return w.err
+}
+
+// Stop stops the websocket server and drains all websocket
+// connections: every client gets its queued messages followed by
+// a close frame with code 1001 (going away), and the connections
+// are closed once every client is done or DrainTimeout is over.
+// It returns once the connection goroutines have exited.
 //
 // This method is called internally when the socketeer is stopped.
 //
@@ -77,44 +355,276 @@ func (w *WebSocket) Start(host string, endpoint string) {
 //
 // 	ws.Stop()
 func (w *WebSocket) Stop() {
+	w.StopBy(time.Time{})
+}
+
+// StopBy stops the websocket server like Stop(), the connections
+// being closed at the deadline at the latest, when it comes before
+// the end of the DrainTimeout.
+//
+// # Parameters:
+//
+// 	- deadline (time.Time): the deadline of the drain, zero for none.
+//
+// # Example:
+//
+// 	ws.StopBy(time.Now().Add(10 * time.Second))
+func (w *WebSocket) StopBy(deadline time.Time) {
+	w.clientsMux.Lock()
+	w.stopOnce.Do(func() {
+		close(w.stopped)
+	})
+	server := w.server
+	clients := w.clients
+	w.clients = make(map[string]*client)
+	w.identities = make(map[string]*identity)
+	w.Metrics.Gauge(metrics.Connections, 0, w.tagsLocked(nil))
+	for _, c := range clients {
+		w.recordDisconnectLocked(c, metrics.ReasonShutdown)
+	}
+	w.clientsMux.Unlock()
+
+	for _, c := range clients {
+		c.shutdown(CloseGoingAway, "server shutting down")
+	}
+
+	drain := w.DrainTimeout
+	if !deadline.IsZero() && time.Until(deadline) < drain {
+		drain = time.Until(deadline)
+	}
+	timeout := time.NewTimer(drain)
+	defer timeout.Stop()
+	for _, c := range clients {
+		select {
+		case <-c.done:
+		case <-timeout.C:
+		}
+	}
+
+	for _, c := range clients {
+		c.conn.Close()
+	}
+
+	if server != nil {
+		server.Close()
+	}
+	w.wg.Wait()
+	if w.Meter != nil {
+		w.reportUsage(time.Now())
+	}
+}
+
+// track adds a goroutine serving a connection to the WaitGroup of
+// the WebSocket, it reports false once the WebSocket is stopped, in
+// which case the connection must be refused. The caller must call
+// w.wg.Done() when it returns true.
+func (w *WebSocket) track() bool {
 	w.clientsMux.Lock()
 	defer w.clientsMux.Unlock()
 
-	for client := range w.clients {
-		client.Close()
+	select {
+	case <-w.stopped:
+		return false
+	default:
 	}
+	w.wg.Add(1)
 
-	w.clients = make(map[*websocket.Conn]struct{})
+	return true
 }
 
-// DispatchUpdate dispatches an update to all clients as a
-// websocket message in the form of a byte slice.
+// Dispatch dispatches a message to all clients interested in its
+// topic, encoded according to the protocol version of every client,
+// and keeps it in the history replayed to resumed sessions. The
+// snapshots are encoded for every client, with the documents it
+// wants, and are not kept in the history.
 //
 // This method is called internally when an update is received
 // from the database.
 //
 // # Parameters:
 //
-// 	- update ([]byte): the update to dispatch to clients.
+// 	- msg (event.Message): the message to dispatch to clients.
 //
 // # Example:
 //
-// 	ws.DispatchUpdate([]byte("Hello, world!"))
-func (w *WebSocket) DispatchUpdate(update []byte) {
+// 	ws.Dispatch(event.Message{Topic: "posts", Data: map[string]string{"title": "Hello"}})
+func (w *WebSocket) Dispatch(msg event.Message) {
 	w.clientsMux.Lock()
 	defer w.clientsMux.Unlock()
 
-	for client := range w.clients {
-		err := client.WriteMessage(websocket.TextMessage, update)
-		if err != nil {
-			log.Println(err)
-			return
+	if msg.OperationType != event.OpSnapshot {
+		w.record(msg)
+	}
+	if msg.Seq > w.seq {
+		w.seq = msg.Seq
+	}
+
+	start := time.Now()
+	var sent int64
+	var recipients []string
+	if w.Inspect != nil {
+		recipients = make([]string, 0, len(w.clients))
+	}
+	defer func() {
+		if w.Inspect != nil {
+			w.Inspect(msg, recipients)
 		}
+		tags := w.tagsLocked(map[string]string{
+			metrics.TagCollection: msg.Topic,
+			metrics.TagOperation:  msg.OperationType,
+		})
+		w.Metrics.Count(metrics.MessagesSent, sent, tags)
+		w.Metrics.Timing(metrics.DispatchDuration, time.Since(start), tags)
+	}()
+
+	// A message failing to encode for a frame key is skipped for the
+	// clients of that key only, the others still receive it.
+	frames := make(map[frameKey]frame)
+	failed := make(map[frameKey]bool)
+	for _, client := range w.clients {
+		if !client.wants(msg) || (w.SkipDelivered && msg.Seq <= client.cursor) {
+			continue
+		}
+		if w.Chaos.Drop() {
+			continue
+		}
+
+		key := frameKey{version: client.version, zstd: client.zstd, binary: client.binary, fields: client.projection(msg.Topic)}
+		if failed[key] && msg.OperationType != event.OpSnapshot {
+			continue
+		}
+		f, ok := frames[key]
+		if !ok || msg.OperationType == event.OpSnapshot {
+			var err error
+			f, err = w.frameFor(client, client.snapshot(msg))
+			if err != nil {
+				failed[key] = true
+				w.Log.Error("encoding message failed", "collection", msg.Topic, "seq", msg.Seq, "error", err)
+				w.report(err, map[string]any{"collection": msg.Topic, "seq": msg.Seq})
+				continue
+			}
+			frames[key] = f
+		}
+
+		if !client.push(f) {
+			client.log.Warn("send buffer full, evicting")
+			w.evictLocked(client, CloseTryAgainLater, "send buffer full")
+			continue
+		}
+		if msg.Seq > client.cursor {
+			client.cursor = msg.Seq
+		}
+		sent++
+		if recipients != nil {
+			recipients = append(recipients, client.id)
+		}
+	}
+}
+
+// Kick disconnects a client with a close frame carrying the given
+// code and reason, so the client can decide whether to reconnect.
+// The session of a kicked client can't be resumed.
+//
+// # Parameters:
+//
+// 	- id (string): the connection ID of the client.
+// 	- code (int): the close code, example: CloseKicked
+// 	- reason (string): the human-readable reason.
+//
+// # Example:
+//
+// 	err := ws.Kick(id, ws.CloseKicked, "kicked by an operator")
+func (w *WebSocket) Kick(id string, code int, reason string) error {
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	c, ok := w.clients[id]
+	if !ok {
+		return ErrUnknownClient
+	}
+	c.session = ""
+	w.evictLocked(c, code, reason)
+
+	return nil
+}
+
+// tagsLocked returns the tags of a metric completed with the
+// endpoint. The caller must hold clientsMux.
+func (w *WebSocket) tagsLocked(tags map[string]string) map[string]string {
+	if tags == nil {
+		tags = make(map[string]string, 1)
+	}
+	tags[metrics.TagEndpoint] = w.endpoint
+
+	return tags
+}
+
+// recordDisconnectLocked records the disconnection of a client and the
+// lifetime of its connection with the reason of the disconnection.
+// The caller must hold clientsMux.
+func (w *WebSocket) recordDisconnectLocked(c *client, reason string) {
+	tags := w.tagsLocked(map[string]string{metrics.TagReason: reason})
+	w.Metrics.Count(metrics.Disconnects, 1, tags)
+	w.Metrics.Timing(metrics.ConnectionDuration, time.Since(c.connected), tags)
+}
+
+// report reports an unexpected failure to Report, if set, with
+// the context of the failure completed with the component.
+func (w *WebSocket) report(err error, ctx map[string]any) {
+	if w.Report == nil {
+		return
 	}
+
+	ctx["component"] = "ws"
+	w.Report(err, ctx)
+}
+
+// recoverPanic recovers from a panic of the calling goroutine, logs
+// it with its stack and reports it, so that a failing connection
+// doesn't crash the process. It has to be deferred.
+func (w *WebSocket) recoverPanic(ctx map[string]any) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err := fmt.Errorf("panic: %v", r)
+	w.Log.Error("panic recovered", "error", err, "stack", string(debug.Stack()))
+	w.report(err, ctx)
+}
+
+// evictLocked removes a client, sends it a close frame once its
+// queue is flushed and closes the connection after DrainTimeout
+// at the latest. The caller must hold clientsMux.
+func (w *WebSocket) evictLocked(c *client, code int, reason string) {
+	churn := metrics.ReasonKick
+	if code == CloseTryAgainLater {
+		churn = metrics.ReasonEviction
+	}
+	w.Metrics.Count(metrics.Evictions, 1, w.tagsLocked(map[string]string{metrics.TagReason: churn}))
+	w.removeLocked(c, churn)
+	c.shutdown(code, reason)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		timeout := time.NewTimer(w.DrainTimeout)
+		defer timeout.Stop()
+
+		select {
+		case <-c.done:
+		case <-timeout.C:
+		}
+		c.conn.Close()
+	}()
 }
 
-// websocketHandler upgrades the connection to a websocket connection
-// and adds the connection to the clients map.
+// websocketHandler upgrades the connection to a websocket connection,
+// negotiating the protocol version, authenticates the client, resumes
+// its session and adds the connection to the clients map. The requests
+// accepting text/event-stream get an event stream instead, subscribed
+// to the topics of their "topic" query parameters, every topic when
+// there is none.
 //
 // This method is called internally when a connection is made to the
 // websocket server.
@@ -128,63 +638,134 @@ func (w *WebSocket) DispatchUpdate(update []byte) {
 //
 // 	http.HandleFunc("/listen", ws.websocketHandler)
 func (w *WebSocket) websocketHandler(res http.ResponseWriter, req *http.Request) {
-	upgrader := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool { 
-			return true 
-		},
-	}
-	conn, err := upgrader.Upgrade(res, req, nil)
+	if !w.track() {
+		http.Error(res, ErrStopped.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer w.wg.Done()
+
+	if !w.allowed(req) {
+		w.Log.Warn("connection denied", "remoteAddr", req.RemoteAddr)
+		http.Error(res, ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	id, err := w.authenticate(req)
 	if err != nil {
-		log.Fatal(err)
+		http.Error(res, ErrUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !w.admit(id) {
+		http.Error(res, ErrTooManyConns.Error(), http.StatusTooManyRequests)
 		return
 	}
 
+	stream := wantsEventStream(req)
+	var conn Conn
+	if stream {
+		conn, err = newSSEConn(res, req)
+	} else {
+		conn, err = defaultBackend.upgrade(res, req, offeredSubprotocols, w.Upgrade)
+	}
+	if err != nil {
+		w.Log.Warn("upgrade failed", "remoteAddr", req.RemoteAddr, "error", err)
+		w.report(err, map[string]any{"remoteAddr": req.RemoteAddr})
+		return
+	}
+
+	c := newClient(conn, negotiate(req, conn.Subprotocol()), w.SendBuffer, w.Log)
+	c.identity = id
+	if stream {
+		for _, topic := range req.URL.Query()["topic"] {
+			if w.MaxSubscriptions > 0 && len(c.topics) >= w.MaxSubscriptions {
+				break
+			}
+			c.topics[topic] = nil
+		}
+	} else {
+		c.zstd = w.compresses(req, c.version)
+		c.binary = w.binaryFrames(req)
+	}
+	c.scope = w.Scope
+	c.remoteAddr = req.RemoteAddr
+	c.maxBatch = w.MaxBatch
+	if w.Meter != nil {
+		c.meter = w.meter
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer w.recoverPanic(map[string]any{"connID": c.id})
+		c.writeLoop(w.Chaos)
+	}()
+	if w.PingInterval > 0 {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.pings(c)
+		}()
+	}
+
+	w.restore(req, id)
 	w.clientsMux.Lock()
-	w.clients[conn] = struct{}{}
+	resumed := w.resume(c, req)
+	c.hello(w.token(c.session))
+	w.notifyLocked(c)
+	if resumed {
+		w.replay(c)
+	}
+	w.addLocked(c)
+	rec := w.sessionRecord(c, true)
 	w.clientsMux.Unlock()
+	w.save(rec)
+	c.log.Info("client connected", "version", c.version, "resumed", resumed, "remoteAddr", req.RemoteAddr)
 
-	w.handleConnection(conn)
+	w.handleConnection(c)
 }
 
 // handleConnection handles a websocket connection by reading
-// messages from the connection and logging them to the console.
+// the control messages of the client from the connection.
 //
 // This method is called internally when a connection is made to the
 // websocket server.
 //
 // # Parameters:
 //
-// 	- conn (*websocket.Conn): the websocket connection.
+// 	- c (*client): the client of the websocket connection.
 //
 // # Example:
 //
-// 	ws.handleConnection(conn)
-func (w *WebSocket) handleConnection(conn *websocket.Conn) {
+// 	ws.handleConnection(c)
+func (w *WebSocket) handleConnection(c *client) {
+	conn := c.conn
+	reason := metrics.ReasonClientClose
+	defer w.recoverPanic(map[string]any{"connID": c.id})
 	defer func() {
 		w.clientsMux.Lock()
-		delete(w.clients, conn)
+		w.removeLocked(c, reason)
+		w.suspend(c)
+		rec := w.sessionRecord(c, false)
 		w.clientsMux.Unlock()
+		w.save(rec)
 
+		c.shutdown(CloseNormal, "")
 		conn.Close()
 	}()
 
 	for {
-		msgType, msg, err := conn.ReadMessage()
+		_, msg, err := conn.ReadMessage()
 		if err != nil {
-			log.Println(err)
-			w.clientsMux.Lock()
-			delete(w.clients, conn)
-			w.clientsMux.Unlock()
-
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error reading message: %v", err)
+			if err != errStreamClosed && !defaultBackend.isClientClose(err) {
+				reason = metrics.ReasonError
+			}
+			if defaultBackend.isUnexpectedClose(err) {
+				c.log.Error("reading message failed", "error", err)
+				w.report(err, map[string]any{"connID": c.id})
+			} else {
+				c.log.Info("client disconnected", "reason", err)
 			}
 			break
 		}
 
-		fmt.Println(msgType)
-		fmt.Println(string(msg))
+		w.handleMessage(c, msg)
 	}
 }
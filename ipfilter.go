@@ -0,0 +1,27 @@
+package socketeer
+
+import "github.com/darthsalad/socketeer/internal/ws"
+
+// parseIPFilter parses AllowCIDRs, DenyCIDRs and TrustedProxies, it is
+// called by Start() which fails when one of the blocks is invalid.
+//
+// # Example:
+//
+// 	err := s.parseIPFilter()
+func (s *Socketeer) parseIPFilter() error {
+	allow, err := ws.ParseCIDRs(s.AllowCIDRs)
+	if err != nil {
+		return err
+	}
+	deny, err := ws.ParseCIDRs(s.DenyCIDRs)
+	if err != nil {
+		return err
+	}
+	proxies, err := ws.ParseCIDRs(s.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	s.allowNets, s.denyNets, s.proxyNets = allow, deny, proxies
+
+	return nil
+}
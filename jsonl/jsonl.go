@@ -0,0 +1,213 @@
+// Package jsonl provides a sink of the socketeer appending every
+// message as a JSON line to a local file, rotated by size and age,
+// a cheap audit and debug trail without extra infrastructure.
+//
+// # Usage:
+//
+// 	archive := jsonl.New("archive", "/var/log/socketeer/events.jsonl")
+// 	archive.MaxSize = 100 << 20
+// 	archive.MaxAge = 24 * time.Hour
+// 	archive.MaxBackups = 30
+// 	s.Sinks = []socketeer.Sink{archive}
+//
+// The rotated files are renamed with the time of their rotation,
+// example: events-20240102T150405.000000.jsonl
+package jsonl
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// rotationFormat is the format of the time of the rotated files.
+const rotationFormat = "20060102T150405.000000"
+
+// Sink is a socketeer.Sink appending the messages to a file, one JSON
+// object per line.
+//
+// 	- name is the name of the sink.
+// 	- Path is the path of the current file.
+// 	- MaxSize is the size in bytes from which the file is rotated,
+// 		0 for no limit.
+// 	- MaxAge is the age from which the file is rotated, 0 for no limit.
+// 	- MaxBackups is the number of rotated files kept, the oldest ones
+// 		are removed beyond it, 0 to keep them all.
+// 	- mux guards the file for thread safety.
+// 	- file is the current file, opened on the first message.
+// 	- size is the size of the current file.
+// 	- opened is when the current file was opened.
+type Sink struct {
+	name       string
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	mux        sync.Mutex
+	file       *os.File
+	size       int64
+	opened     time.Time
+}
+
+// New returns a new Sink appending to the file at path, which is
+// created on the first message.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the sink.
+// 	- path (string): the path of the file.
+//
+// # Example:
+//
+// 	archive := jsonl.New("archive", "events.jsonl")
+func New(name string, path string) *Sink {
+	return &Sink{name: name, Path: path}
+}
+
+// Name returns the name of the sink.
+func (s *Sink) Name() string {
+	return s.name
+}
+
+// Deliver appends a message to the file as a JSON line, after rotating
+// the file when it is over MaxSize or MaxAge.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): unused, the writes are local.
+// 	- msg (socketeer.Message): the message.
+//
+// # Example:
+//
+// 	err := archive.Deliver(ctx, msg)
+func (s *Sink) Deliver(ctx context.Context, msg socketeer.Message) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.file != nil && s.full(int64(len(line))) {
+		err = s.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		err = s.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+
+	return err
+}
+
+// Close closes the current file.
+//
+// # Example:
+//
+// 	err := archive.Close()
+func (s *Sink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+// full reports whether the current file has to be rotated before
+// a line of the given size is appended. A line larger than MaxSize
+// still goes to an empty file.
+func (s *Sink) full(size int64) bool {
+	if s.MaxSize > 0 && s.size > 0 && s.size+size > s.MaxSize {
+		return true
+	}
+
+	return s.MaxAge > 0 && time.Since(s.opened) >= s.MaxAge
+}
+
+// open opens the file at Path for appending, creating it and its
+// directory when needed.
+func (s *Sink) open() error {
+	err := os.MkdirAll(filepath.Dir(s.Path), 0o755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+	s.opened = time.Now()
+
+	return nil
+}
+
+// rotate closes the current file, renames it with the time of the
+// rotation and removes the rotated files beyond MaxBackups. The next
+// message opens a new file.
+func (s *Sink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(s.Path)
+	base := strings.TrimSuffix(s.Path, ext)
+	err = os.Rename(s.Path, base+"-"+time.Now().UTC().Format(rotationFormat)+ext)
+	if err != nil {
+		return err
+	}
+
+	return s.prune(base, ext)
+}
+
+// prune removes the oldest rotated files beyond MaxBackups, their
+// names sort by time of rotation.
+func (s *Sink) prune(base string, ext string) error {
+	if s.MaxBackups <= 0 {
+		return nil
+	}
+	rotated, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return err
+	}
+	sort.Strings(rotated)
+
+	for len(rotated) > s.MaxBackups {
+		err = os.Remove(rotated[0])
+		if err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+
+	return nil
+}
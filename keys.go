@@ -0,0 +1,215 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// AdminKeysPath is the path the keys of the collections are read
+// and updated on, behind the AdminToken.
+const AdminKeysPath = "/admin/keys"
+
+// KeysUpdate is the body of a PUT request on AdminKeysPath.
+//
+// 	- Collection is the name of the collection, empty for the keys
+// 		of the collections without keys of their own.
+// 	- Keys are the new keys of the collection.
+type KeysUpdate struct {
+	Collection string   `json:"collection"`
+	Keys       []string `json:"keys"`
+}
+
+// keySet is a compiled list of keys, the keys starting with "^"
+// are regular expressions matched against the names of the fields,
+// example: ^metrics\..*latency$
+//
+// 	- exact are the plain keys.
+// 	- patterns are the compiled regular expressions.
+type keySet struct {
+	exact    map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// isPattern reports whether a key is a regular expression.
+func isPattern(key string) bool {
+	return strings.HasPrefix(key, "^")
+}
+
+// compileKeys compiles a list of keys.
+//
+// # Parameters:
+//
+// 	- keys ([]string): the keys, plain or regular expressions.
+//
+// # Example:
+//
+// 	set, err := compileKeys([]string{"title", "^metrics\\..*latency$"})
+func compileKeys(keys []string) (*keySet, error) {
+	set := &keySet{exact: make(map[string]struct{}, len(keys))}
+	for _, key := range keys {
+		if !isPattern(key) {
+			set.exact[key] = struct{}{}
+			continue
+		}
+		re, err := regexp.Compile(key)
+		if err != nil {
+			return nil, fmt.Errorf("socketeer: key %q: %w", key, err)
+		}
+		set.patterns = append(set.patterns, re)
+	}
+
+	return set, nil
+}
+
+// match reports whether a field is selected by the keys.
+func (k *keySet) match(field string) bool {
+	if _, ok := k.exact[field]; ok {
+		return true
+	}
+	for _, re := range k.patterns {
+		if re.MatchString(field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compileAllKeys compiles the keys given to Start() and the Keys of
+// every collection, keysMux must be held.
+//
+// # Example:
+//
+// 	err := s.compileAllKeys()
+func (s *Socketeer) compileAllKeys() error {
+	defaults, err := compileKeys(s.keys)
+	if err != nil {
+		return err
+	}
+	sets := make(map[string]*keySet, len(s.Keys))
+	for coll, keys := range s.Keys {
+		sets[coll], err = compileKeys(keys)
+		if err != nil {
+			return err
+		}
+	}
+	s.defaultKeySet, s.keySets = defaults, sets
+
+	return nil
+}
+
+// keysFor returns the keys selected from the events of a collection.
+//
+// # Parameters:
+//
+// 	- coll (string): the name of the collection.
+//
+// # Example:
+//
+// 	keys := s.keysFor(ev.Collection)
+func (s *Socketeer) keysFor(coll string) *keySet {
+	s.keysMux.RLock()
+	defer s.keysMux.RUnlock()
+
+	if set, ok := s.keySets[coll]; ok {
+		return set
+	}
+
+	return s.defaultKeySet
+}
+
+// UpdateKeys changes the keys selected from the events of a collection
+// while the socketeer is running, the next events are dispatched with
+// the new keys and the clients stay connected.
+//
+// The keys starting with "^" are regular expressions, an error is
+// returned and the keys are left unchanged when one does not compile.
+//
+// # Parameters:
+//
+// 	- coll (string): the name of the collection, empty for the keys of
+// 		the collections without keys of their own.
+// 	- keys ([]string): the new keys.
+//
+// # Example:
+//
+// 	err := s.UpdateKeys("orders", []string{"status", "total", "currency"})
+func (s *Socketeer) UpdateKeys(coll string, keys []string) error {
+	keys = append([]string(nil), keys...)
+	set, err := compileKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	s.keysMux.Lock()
+	defer s.keysMux.Unlock()
+
+	if coll == "" {
+		s.keys, s.defaultKeySet = keys, set
+		return nil
+	}
+	updated := make(map[string][]string, len(s.Keys)+1)
+	sets := make(map[string]*keySet, len(s.keySets)+1)
+	for c, k := range s.Keys {
+		updated[c] = k
+		sets[c] = s.keySets[c]
+	}
+	updated[coll], sets[coll] = keys, set
+	s.Keys, s.keySets = updated, sets
+
+	return nil
+}
+
+// serveAdminKeys serves the keys of the collections on GET, as the
+// keys of every collection with the default keys under "", and
+// updates the keys of a collection on PUT with a KeysUpdate.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(AdminKeysPath, http.HandlerFunc(s.serveAdminKeys))
+func (s *Socketeer) serveAdminKeys(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var update KeysUpdate
+		err := json.NewDecoder(req.Body).Decode(&update)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = s.UpdateKeys(update.Collection, update.Keys)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.log.Info("keys updated", "collection", update.Collection, "keys", update.Keys)
+	default:
+		res.Header().Set("Allow", "GET, PUT")
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.keysMux.RLock()
+	keys := make(map[string][]string, len(s.Keys)+1)
+	for coll, k := range s.Keys {
+		keys[coll] = k
+	}
+	keys[""] = s.keys
+	s.keysMux.RUnlock()
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(keys)
+}
@@ -0,0 +1,59 @@
+package socketeer
+
+import (
+	"errors"
+
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// Close codes sent to clients when they are disconnected, so client
+// SDKs can decide whether to retry or to surface an error.
+//
+// 	- CloseGoingAway is sent when the server shuts down, clients should reconnect.
+// 	- ClosePolicyViolation is sent when the client broke a policy, clients should not retry.
+// 	- CloseTryAgainLater is sent when the client can't keep up with the
+// 		updates, clients should retry with backoff.
+// 	- CloseKicked is sent when an operator kicked the client, clients should not retry.
+// 	- CloseAuthExpired is sent when the credentials of the client expired,
+// 		clients should authenticate again before reconnecting.
+const (
+	CloseGoingAway       = ws.CloseGoingAway
+	ClosePolicyViolation = ws.ClosePolicyViolation
+	CloseTryAgainLater   = ws.CloseTryAgainLater
+	CloseKicked          = ws.CloseKicked
+	CloseAuthExpired     = ws.CloseAuthExpired
+)
+
+// Errors returned by Kick().
+var (
+	ErrUnknownClient = ws.ErrUnknownClient
+	ErrUnsupported   = errors.New("socketeer: not supported by the broadcaster")
+)
+
+// kicker is implemented by the broadcasters which can
+// disconnect a single client, like the default WebSocket server.
+type kicker interface {
+	Kick(id string, code int, reason string) error
+}
+
+// Kick disconnects a client with a close frame carrying the given
+// code and reason. The connection ID of a client is sent to it in
+// the hello message of the second protocol version.
+//
+// # Parameters:
+//
+// 	- id (string): the connection ID of the client.
+// 	- code (int): the close code, example: CloseKicked
+// 	- reason (string): the human-readable reason.
+//
+// # Example:
+//
+// 	err := s.Kick(id, socketeer.CloseKicked, "banned by an operator")
+func (s *Socketeer) Kick(id string, code int, reason string) error {
+	k, ok := s.WS.(kicker)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	return k.Kick(id, code, reason)
+}
@@ -0,0 +1,205 @@
+// Package lease provides the lease stores of the failover of the
+// socketeer, shared by the primary and the standby regions.
+//
+// # Usage:
+//
+// 	s.Failover = &socketeer.Failover{
+// 		Leases: lease.NewMongo(client.Database("mydb").Collection("leases"), "orders"),
+// 		Region: os.Getenv("REGION"),
+// 	}
+//
+// The expiry of the lease is computed with the clock of the database,
+// so that the clocks of the regions don't have to agree. It needs
+// MongoDB 4.2 or later.
+package lease
+
+import (
+	"context"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultTimeout bounds every round trip to the database.
+const DefaultTimeout = 5 * time.Second
+
+// Mongo is a lease store keeping a lease in a document of a MongoDB
+// collection.
+//
+// 	- coll is the collection.
+// 	- name is the _id of the document, several socketeers watching
+// 		different collections hold their own lease.
+// 	- client is the client connected by Connect(), disconnected by
+// 		Close(), nil for a collection of the application.
+type Mongo struct {
+	coll   *mongo.Collection
+	name   string
+	client *mongo.Client
+}
+
+// NewMongo returns a new Mongo store keeping the lease name in coll.
+//
+// # Parameters:
+//
+// 	- coll (*mongo.Collection): the collection.
+// 	- name (string): the name of the lease.
+//
+// # Example:
+//
+// 	leases := lease.NewMongo(client.Database("mydb").Collection("leases"), "orders")
+func NewMongo(coll *mongo.Collection, name string) *Mongo {
+	return &Mongo{coll: coll, name: name}
+}
+
+// Connect returns a new Mongo store keeping the lease name in the
+// collection collName, the client is disconnected by Close().
+//
+// # Parameters:
+//
+// 	- uriString (string): the MongoDB connection string.
+// 	- dbName (string): the MongoDB database name.
+// 	- collName (string): the name of the collection of the leases.
+// 	- name (string): the name of the lease.
+//
+// # Example:
+//
+// 	leases, err := lease.Connect("mongodb://localhost:27017", "mydb", "leases", "orders")
+func Connect(uriString string, dbName string, collName string, name string) (*Mongo, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uriString))
+	if err != nil {
+		return nil, err
+	}
+	err = client.Ping(context.Background(), nil)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	m := NewMongo(client.Database(dbName).Collection(collName), name)
+	m.client = client
+
+	return m, nil
+}
+
+// Close disconnects the client connected by Connect(), if any.
+//
+// # Example:
+//
+// 	defer leases.Close()
+func (m *Mongo) Close() error {
+	if m.client == nil {
+		return nil
+	}
+
+	return m.client.Disconnect(context.Background())
+}
+
+// record is a lease as stored in the collection.
+//
+// 	- ID is the name of the lease, the _id of the document.
+// 	- Holder is the region holding the lease.
+// 	- Expires is when the lease expires, by the clock of the database.
+// 	- ClusterTime is the cluster time of the checkpoint.
+// 	- Seq is the sequence number of the checkpoint.
+type record struct {
+	ID          string              `bson:"_id"`
+	Holder      string              `bson:"holder"`
+	Expires     time.Time           `bson:"expires"`
+	ClusterTime primitive.Timestamp `bson:"clusterTime"`
+	Seq         int64               `bson:"seq"`
+}
+
+// lease returns the socketeer.Lease of a record.
+func (r record) lease() socketeer.Lease {
+	return socketeer.Lease{
+		Holder:      r.Holder,
+		Expires:     r.Expires,
+		ClusterTime: socketeer.Timestamp{T: r.ClusterTime.T, I: r.ClusterTime.I},
+		Seq:         uint64(r.Seq),
+	}
+}
+
+// Acquire takes the lease for holder when it is free, expired or
+// already held by holder, or whatever its state with steal.
+//
+// # Parameters:
+//
+// 	- holder (string): the region taking the lease.
+// 	- ttl (time.Duration): how long the lease is held.
+// 	- steal (bool): whether the lease is taken from its holder.
+//
+// # Example:
+//
+// 	l, ok, err := m.Acquire("eu-west-1", 10*time.Second, false)
+func (m *Mongo) Acquire(holder string, ttl time.Duration, steal bool) (socketeer.Lease, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: m.name}}
+	if !steal {
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "holder", Value: holder}},
+			bson.D{{Key: "$expr", Value: bson.D{{Key: "$lte", Value: bson.A{"$expires", "$$NOW"}}}}},
+		}})
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: "holder", Value: bson.D{{Key: "$literal", Value: holder}}},
+		{Key: "expires", Value: expires(ttl)},
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var r record
+	err := m.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&r)
+	if mongo.IsDuplicateKeyError(err) {
+		err = m.coll.FindOne(ctx, bson.D{{Key: "_id", Value: m.name}}).Decode(&r)
+		if err != nil {
+			return socketeer.Lease{}, false, err
+		}
+		return r.lease(), false, nil
+	}
+	if err != nil {
+		return socketeer.Lease{}, false, err
+	}
+
+	return r.lease(), true, nil
+}
+
+// Renew extends the lease held by holder by ttl and records the
+// checkpoint, a ttl of 0 releasing it.
+//
+// # Parameters:
+//
+// 	- holder (string): the region holding the lease.
+// 	- ttl (time.Duration): how long the lease is extended by.
+// 	- clusterTime (socketeer.Timestamp): the cluster time of the checkpoint.
+// 	- seq (uint64): the sequence number of the checkpoint.
+//
+// # Example:
+//
+// 	ok, err := m.Renew("eu-west-1", 10*time.Second, ts, seq)
+func (m *Mongo) Renew(holder string, ttl time.Duration, clusterTime socketeer.Timestamp, seq uint64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: m.name}, {Key: "holder", Value: holder}}
+	set := bson.D{{Key: "expires", Value: expires(ttl)}, {Key: "seq", Value: int64(seq)}}
+	if clusterTime != (socketeer.Timestamp{}) {
+		set = append(set, bson.E{Key: "clusterTime", Value: primitive.Timestamp{T: clusterTime.T, I: clusterTime.I}})
+	}
+	res, err := m.coll.UpdateOne(ctx, filter, mongo.Pipeline{{{Key: "$set", Value: set}}})
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount == 1, nil
+}
+
+// expires returns the expression of the expiry of a lease held for
+// ttl, by the clock of the database.
+func expires(ttl time.Duration) bson.D {
+	return bson.D{{Key: "$add", Value: bson.A{"$$NOW", ttl.Milliseconds()}}}
+}
@@ -0,0 +1,212 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// AdminMaintenancePath is the path the maintenance mode is read and
+// switched on, behind the AdminToken.
+const AdminMaintenancePath = "/admin/maintenance"
+
+// DefaultMaintenanceBuffer is the default maximal number of messages
+// held during a maintenance.
+const DefaultMaintenanceBuffer = 100000
+
+// maintainer is implemented by the broadcasters which can notify
+// their clients of a maintenance, like the default WebSocket server.
+type maintainer interface {
+	Maintenance(active bool, reason string)
+}
+
+// MaintenanceStatus is the state of the maintenance mode, as returned
+// by Maintenance() and on AdminMaintenancePath.
+//
+// 	- Active reports whether a maintenance is in progress.
+// 	- Reason is the reason of the maintenance.
+// 	- Since is when the maintenance started.
+// 	- Held is the number of messages held for the clients.
+// 	- Dropped is the number of the oldest messages dropped because
+// 		more than MaintenanceBuffer were held.
+type MaintenanceStatus struct {
+	Active  bool       `json:"active"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+	Held    int        `json:"held"`
+	Dropped int        `json:"dropped"`
+}
+
+// MaintenanceRequest is the body of a POST request on
+// AdminMaintenancePath.
+//
+// 	- Reason is the reason of the maintenance, sent to the clients.
+type MaintenanceRequest struct {
+	Reason string `json:"reason"`
+}
+
+// maintenance is a maintenance in progress.
+//
+// 	- reason is the reason of the maintenance.
+// 	- since is when it started.
+// 	- held are the messages held for the clients, in order.
+// 	- dropped is the number of messages dropped from held.
+type maintenance struct {
+	reason  string
+	since   time.Time
+	held    []Message
+	dropped int
+}
+
+// dispatch dispatches a message to the clients, or holds it during a
+// maintenance, dropping the oldest one held when MaintenanceBuffer are.
+//
+// # Parameters:
+//
+// 	- msg (Message): the message to dispatch.
+//
+// # Example:
+//
+// 	s.dispatch(msg)
+func (s *Socketeer) dispatch(msg Message) {
+	s.maintenanceMux.Lock()
+	if m := s.maintenance; m != nil {
+		limit := s.MaintenanceBuffer
+		if limit <= 0 {
+			limit = DefaultMaintenanceBuffer
+		}
+		if len(m.held) >= limit {
+			if m.dropped == 0 {
+				s.log.Warn("maintenance buffer full, dropping the oldest messages", "limit", limit)
+			}
+			m.held = m.held[1:]
+			m.dropped++
+		}
+		m.held = append(m.held, msg)
+		s.maintenanceMux.Unlock()
+		return
+	}
+	s.maintenanceMux.Unlock()
+
+	s.WS.Dispatch(msg)
+}
+
+// EnterMaintenance pauses the delivery to the clients: the change
+// stream is still consumed and the sinks still fed, but the messages
+// are held until ExitMaintenance(). The clients of the second protocol
+// version are sent a maintenance message with the reason, and so are
+// the ones connecting meanwhile. Entering a maintenance in progress
+// only changes its reason.
+//
+// # Parameters:
+//
+// 	- reason (string): the reason of the maintenance, optional.
+//
+// # Example:
+//
+// 	s.EnterMaintenance("database upgrade")
+func (s *Socketeer) EnterMaintenance(reason string) {
+	s.maintenanceMux.Lock()
+	defer s.maintenanceMux.Unlock()
+
+	if s.maintenance == nil {
+		s.maintenance = &maintenance{since: time.Now()}
+	}
+	s.maintenance.reason = reason
+	if m, ok := s.WS.(maintainer); ok {
+		m.Maintenance(true, reason)
+	}
+	if s.log != nil {
+		s.log.Info("maintenance started", "reason", reason)
+	}
+}
+
+// ExitMaintenance resumes the delivery to the clients: they are sent
+// a maintenance message telling it ended, then the messages held, in
+// order, before the following ones. It returns the number of messages
+// delivered, 0 when no maintenance is in progress.
+//
+// # Example:
+//
+// 	n := s.ExitMaintenance()
+func (s *Socketeer) ExitMaintenance() int {
+	s.maintenanceMux.Lock()
+	defer s.maintenanceMux.Unlock()
+
+	m := s.maintenance
+	if m == nil {
+		return 0
+	}
+	if w, ok := s.WS.(maintainer); ok {
+		w.Maintenance(false, m.reason)
+	}
+	for _, msg := range m.held {
+		s.WS.Dispatch(msg)
+	}
+	s.maintenance = nil
+	if s.log != nil {
+		s.log.Info("maintenance ended", "reason", m.reason, "duration", time.Since(m.since), "held", len(m.held), "dropped", m.dropped)
+	}
+
+	return len(m.held)
+}
+
+// Maintenance returns the state of the maintenance mode.
+//
+// # Example:
+//
+// 	if s.Maintenance().Active { ... }
+func (s *Socketeer) Maintenance() MaintenanceStatus {
+	s.maintenanceMux.Lock()
+	defer s.maintenanceMux.Unlock()
+
+	m := s.maintenance
+	if m == nil {
+		return MaintenanceStatus{}
+	}
+	since := m.since
+
+	return MaintenanceStatus{Active: true, Reason: m.reason, Since: &since, Held: len(m.held), Dropped: m.dropped}
+}
+
+// serveMaintenance serves the MaintenanceStatus on GET, enters the
+// maintenance mode on POST, with an optional MaintenanceRequest, and
+// exits it on DELETE.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(AdminMaintenancePath, http.HandlerFunc(s.serveMaintenance))
+func (s *Socketeer) serveMaintenance(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body MaintenanceRequest
+		if req.ContentLength != 0 {
+			err := json.NewDecoder(req.Body).Decode(&body)
+			if err != nil {
+				http.Error(res, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		s.EnterMaintenance(body.Reason)
+	case http.MethodDelete:
+		s.ExitMaintenance()
+	default:
+		res.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(s.Maintenance())
+}
@@ -0,0 +1,51 @@
+package socketeer
+
+import (
+	"net/http"
+
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// Middleware wraps an http.Handler, like the middleware of the standard
+// net/http stacks: request logging, authentication, panic recovery or
+// rate limiting.
+//
+// # Example:
+//
+// 	func logRequests(next http.Handler) http.Handler {
+// 		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+// 			log.Println(req.RemoteAddr, req.URL)
+// 			next.ServeHTTP(res, req)
+// 		})
+// 	}
+type Middleware = func(http.Handler) http.Handler
+
+// UpgradeOptions configure the upgrade of the websocket connections,
+// in place of the defaults which accept every origin.
+//
+// # Example:
+//
+// 	s.Upgrade = &socketeer.UpgradeOptions{
+// 		HandshakeTimeout:  5 * time.Second,
+// 		CheckOrigin:       func(req *http.Request) bool { return req.Header.Get("Origin") == "https://app.example.com" },
+// 		EnableCompression: true,
+// 	}
+type UpgradeOptions = ws.UpgradeOptions
+
+// WithMiddleware wraps the upgrade handler of the websocket endpoint
+// with middleware, it has to be called before Start(). The middleware
+// run in order, the first one is the outermost, and the ones added
+// by previous calls run first.
+//
+// # Parameters:
+//
+// 	- mw (...Middleware): the middleware.
+//
+// # Example:
+//
+// 	s.WithMiddleware(logRequests, httprate.LimitByIP(100, time.Minute))
+func (s *Socketeer) WithMiddleware(mw ...Middleware) *Socketeer {
+	s.Middleware = append(s.Middleware, mw...)
+
+	return s
+}
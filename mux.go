@@ -0,0 +1,81 @@
+package socketeer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidHandler is returned by Start() and Validate() for a handler
+// of Handlers without a pattern, or with the pattern of a route of the
+// socketeer.
+var ErrInvalidHandler = errors.New("socketeer: invalid handler")
+
+// reservedPaths are the routes of the socketeer, which Handlers
+// can't take.
+var reservedPaths = []string{
+	SchemaPath, VersionPath, LivePath, ReadyPath, AdminStreamPath,
+	AdminTapPath, AdminKeysPath, AdminDocumentsPath, AdminRedrivePath, QueryPath,
+	HistoryPath, AdminSnapshotPath, AdminClientsPath,
+	AdminSessionsPath, AdminMaintenancePath, DashboardPath, ClusterPath,
+}
+
+// checkHandlers checks the patterns of the Handlers.
+//
+// # Parameters:
+//
+// 	- endpoint (string): the websocket endpoint, empty when unknown.
+//
+// # Example:
+//
+// 	err := s.checkHandlers(endpoint)
+func (s *Socketeer) checkHandlers(endpoint string) error {
+	for pattern, handler := range s.Handlers {
+		if pattern == "" || handler == nil {
+			return fmt.Errorf("%w: %q", ErrInvalidHandler, pattern)
+		}
+		if pattern == endpoint {
+			return fmt.Errorf("%w: %q is the websocket endpoint", ErrInvalidHandler, pattern)
+		}
+		for _, path := range reservedPaths {
+			if pattern == path {
+				return fmt.Errorf("%w: %q is a route of the socketeer", ErrInvalidHandler, pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+// serverMiddleware returns the middleware of the whole server: the
+// ServerMiddleware, then the routing of the gRPC requests to GRPC.
+func (s *Socketeer) serverMiddleware() []Middleware {
+	mw := append([]Middleware(nil), s.ServerMiddleware...)
+	if s.GRPC != nil {
+		mw = append(mw, s.routeGRPC)
+	}
+
+	return mw
+}
+
+// routeGRPC routes the gRPC requests, HTTP/2 requests with a gRPC
+// content type, to GRPC whatever their path, and the other ones to
+// next.
+//
+// # Parameters:
+//
+// 	- next (http.Handler): the handler of the other requests.
+//
+// # Example:
+//
+// 	handler := s.routeGRPC(mux)
+func (s *Socketeer) routeGRPC(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+			s.GRPC.ServeHTTP(res, req)
+			return
+		}
+		next.ServeHTTP(res, req)
+	})
+}
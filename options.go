@@ -0,0 +1,408 @@
+package socketeer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/db"
+	"github.com/darthsalad/socketeer/internal/ws"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoDatabase is returned by New() without a database to watch nor
+// a change source.
+var ErrNoDatabase = errors.New("socketeer: no database")
+
+// ErrNoCollection is returned by NewSocketeer(),
+// NewSocketeerWithOptions() and New() without a collection to watch.
+var ErrNoCollection = db.ErrNoCollection
+
+// Option is an option of New(), the options are applied in order.
+//
+// # Example:
+//
+// 	s, err := socketeer.New(uri, socketeer.WithDatabase("mydb"), socketeer.WithCollection("orders"))
+type Option func(*settings)
+
+// settings are the settings built by the options of New().
+//
+// 	- database and collections are the namespaces watched.
+// 	- clientOptions are the options of the client connected to uri.
+// 	- client is the client of the application, used instead of
+// 		connecting one.
+// 	- source and broadcaster replace the default ones.
+// 	- keys are the keys selected when Start() is given nil keys.
+// 	- sendBuffer is the number of messages queued per client of the
+// 		default broadcaster, 0 for its default.
+// 	- apply are the functions setting the fields of the Socketeer.
+type settings struct {
+	database      string
+	collections   []string
+	clientOptions *options.ClientOptions
+	client        *mongo.Client
+	source        ChangeSource
+	broadcaster   Broadcaster
+	keys          []string
+	sendBuffer    int
+	apply         []func(*Socketeer)
+}
+
+// New returns a new Socketeer instance configured with options, the
+// ones which aren't given keeping their defaults. It connects to the
+// MongoDB deployment of uri to watch the collection given with
+// WithDatabase() and WithCollection() or WithCollections(), unless
+// WithClient() or WithSource() are given. Without WithSource(), it
+// returns ErrNoDatabase or ErrNoCollection when no database or no
+// collection is given. The fields of the Socketeer can still be set
+// afterwards, before Start().
+//
+// # Parameters:
+//
+// 	- uri (string): the MongoDB connection string, may be empty with
+// 		WithClientOptions(), WithClient() or WithSource().
+// 	- opts (...Option): the options.
+//
+// # Example:
+//
+// 	s, err := socketeer.New(uri,
+// 		socketeer.WithDatabase("mydb"),
+// 		socketeer.WithCollection("orders"),
+// 		socketeer.WithKeys("status", "total"),
+// 		socketeer.WithUpgrader(socketeer.UpgradeOptions{EnableCompression: true}),
+// 		socketeer.WithSendBuffer(1024),
+// 		socketeer.WithLogging(socketeer.LogJSON, socketeer.LogDebug),
+// 	)
+func New(uri string, opts ...Option) (*Socketeer, error) {
+	var set settings
+	for _, opt := range opts {
+		opt(&set)
+	}
+
+	src := set.source
+	if src == nil {
+		if set.database == "" {
+			return nil, ErrNoDatabase
+		}
+		collections := set.collections
+		if len(collections) == 0 {
+			return nil, ErrNoCollection
+		}
+		if set.client != nil {
+			src = newSource(set.client, set.database, collections)
+		} else {
+			// The options of WithClientOptions() are copied, so that
+			// the URI and the defaults don't change the caller's.
+			clientOptions := options.Client()
+			if set.clientOptions != nil {
+				copied := *set.clientOptions
+				clientOptions = &copied
+			}
+			if uri != "" {
+				clientOptions.ApplyURI(uri)
+			}
+			d, err := connectSource(clientOptions, set.database, collections)
+			if err != nil {
+				return nil, err
+			}
+			src = d
+		}
+	}
+	b := set.broadcaster
+	if b == nil {
+		w := ws.NewWebSocket()
+		if set.sendBuffer > 0 {
+			w.SendBuffer = set.sendBuffer
+		}
+		b = w
+	}
+
+	s := NewSocketeerWith(src, b)
+	s.keys = set.keys
+	for _, apply := range set.apply {
+		apply(s)
+	}
+
+	return s, nil
+}
+
+// WithDatabase sets the MongoDB database watched.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the database.
+//
+// # Example:
+//
+// 	socketeer.WithDatabase("mydb")
+func WithDatabase(name string) Option {
+	return func(set *settings) {
+		set.database = name
+	}
+}
+
+// WithCollection sets the MongoDB collection watched.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the collection.
+//
+// # Example:
+//
+// 	socketeer.WithCollection("orders")
+func WithCollection(name string) Option {
+	return func(set *settings) {
+		set.collections = []string{name}
+	}
+}
+
+// WithCollections sets the MongoDB collections watched, every one of
+// them with its own change stream, their messages being dispatched on
+// the topic of their collection.
+//
+// # Parameters:
+//
+// 	- names (...string): the names of the collections.
+//
+// # Example:
+//
+// 	socketeer.WithCollections("posts", "comments")
+func WithCollections(names ...string) Option {
+	return func(set *settings) {
+		set.collections = names
+	}
+}
+
+// WithClientOptions sets the options of the client connected to the
+// database, for the settings a connection string can't express, like
+// a custom TLS configuration. The uri given to New() is applied on
+// top of them, unless it is empty.
+//
+// # Parameters:
+//
+// 	- clientOptions (*options.ClientOptions): the options of the client.
+//
+// # Example:
+//
+// 	socketeer.WithClientOptions(options.Client().SetTLSConfig(tlsConfig))
+func WithClientOptions(clientOptions *options.ClientOptions) Option {
+	return func(set *settings) {
+		set.clientOptions = clientOptions
+	}
+}
+
+// WithClient watches the database with the connected client of the
+// application instead of connecting to uri, the client is left
+// connected when the socketeer is stopped.
+//
+// # Parameters:
+//
+// 	- client (*mongo.Client): the connected client.
+//
+// # Example:
+//
+// 	socketeer.WithClient(client)
+func WithClient(client *mongo.Client) Option {
+	return func(set *settings) {
+		set.client = client
+	}
+}
+
+// WithSource reads the events from a ChangeSource instead of a MongoDB
+// change stream, the database options are then ignored.
+//
+// # Parameters:
+//
+// 	- src (ChangeSource): the source to read events from.
+//
+// # Example:
+//
+// 	socketeer.WithSource(sourcetest.New())
+func WithSource(src ChangeSource) Option {
+	return func(set *settings) {
+		set.source = src
+	}
+}
+
+// WithBroadcaster dispatches the events with a Broadcaster instead of
+// the default WebSocket server, WithSendBuffer() is then ignored.
+//
+// # Parameters:
+//
+// 	- b (Broadcaster): the broadcaster to dispatch events with.
+//
+// # Example:
+//
+// 	socketeer.WithBroadcaster(myBroadcaster)
+func WithBroadcaster(b Broadcaster) Option {
+	return func(set *settings) {
+		set.broadcaster = b
+	}
+}
+
+// WithMatch filters the changes on the server, see the Match field.
+//
+// # Parameters:
+//
+// 	- match (Match): the criteria.
+//
+// # Example:
+//
+// 	socketeer.WithMatch(socketeer.Match{Operations: []string{"insert", "update"}})
+func WithMatch(match Match) Option {
+	return With(func(s *Socketeer) {
+		s.Match = &match
+	})
+}
+
+// WithPipeline appends aggregation stages to the pipeline of the
+// change stream, see the Pipeline field.
+//
+// # Parameters:
+//
+// 	- pipeline (mongo.Pipeline): the stages.
+//
+// # Example:
+//
+// 	socketeer.WithPipeline(mongo.Pipeline{
+// 		{{Key: "$match", Value: bson.D{{Key: "operationType", Value: bson.D{{Key: "$in", Value: bson.A{"insert", "update"}}}}}}},
+// 	})
+func WithPipeline(pipeline mongo.Pipeline) Option {
+	return With(func(s *Socketeer) {
+		s.Pipeline = pipeline
+	})
+}
+
+// WithKeys sets the keys selected from the events of the collections
+// without keys in the Keys field, used when Start() is given nil
+// keys. The keys starting with "^" are regular expressions.
+//
+// # Parameters:
+//
+// 	- keys (...string): the keys.
+//
+// # Example:
+//
+// 	socketeer.WithKeys("status", "total")
+func WithKeys(keys ...string) Option {
+	return func(set *settings) {
+		set.keys = keys
+	}
+}
+
+// WithUpgrader sets the options of the upgrade of the websocket
+// connections, see the Upgrade field.
+//
+// # Parameters:
+//
+// 	- upgrade (UpgradeOptions): the upgrade options.
+//
+// # Example:
+//
+// 	socketeer.WithUpgrader(socketeer.UpgradeOptions{HandshakeTimeout: 5 * time.Second})
+func WithUpgrader(upgrade UpgradeOptions) Option {
+	return With(func(s *Socketeer) {
+		s.Upgrade = &upgrade
+	})
+}
+
+// WithSendBuffer sets the number of messages queued per client of the
+// default WebSocket server before it is treated as a slow consumer.
+//
+// # Parameters:
+//
+// 	- n (int): the number of messages.
+//
+// # Example:
+//
+// 	socketeer.WithSendBuffer(1024)
+func WithSendBuffer(n int) Option {
+	return func(set *settings) {
+		set.sendBuffer = n
+	}
+}
+
+// WithTimeouts sets the timeouts of the socketeer, see the fields of
+// the same names, 0 keeping a default.
+//
+// # Parameters:
+//
+// 	- drain (time.Duration): the DrainTimeout.
+// 	- heartbeat (time.Duration): the HeartbeatTimeout.
+// 	- dispatch (time.Duration): the DispatchTimeout.
+//
+// # Example:
+//
+// 	socketeer.WithTimeouts(10*time.Second, time.Minute, 0)
+func WithTimeouts(drain time.Duration, heartbeat time.Duration, dispatch time.Duration) Option {
+	return With(func(s *Socketeer) {
+		s.DrainTimeout = drain
+		s.HeartbeatTimeout = heartbeat
+		s.DispatchTimeout = dispatch
+	})
+}
+
+// WithLogging sets the format and the level of the logs, see the
+// LogFormat and LogLevel fields.
+//
+// # Parameters:
+//
+// 	- format (string): LogText or LogJSON.
+// 	- level (string): the minimal level, example: LogDebug.
+//
+// # Example:
+//
+// 	socketeer.WithLogging(socketeer.LogJSON, socketeer.LogDebug)
+func WithLogging(format string, level string) Option {
+	return With(func(s *Socketeer) {
+		s.LogFormat = format
+		s.LogLevel = level
+	})
+}
+
+// WithLogger writes the logs of the socketeer with a Logger, like a
+// *slog.Logger, instead of the standard error.
+//
+// # Parameters:
+//
+// 	- l (Logger): the logger.
+//
+// # Example:
+//
+// 	socketeer.WithLogger(slog.Default())
+func WithLogger(l Logger) Option {
+	return With(func(s *Socketeer) {
+		s.Logger = l
+	})
+}
+
+// WithMetrics sets the metrics of the socketeer.
+//
+// # Parameters:
+//
+// 	- m (Metrics): the metrics.
+//
+// # Example:
+//
+// 	socketeer.WithMetrics(statsdClient)
+func WithMetrics(m Metrics) Option {
+	return With(func(s *Socketeer) {
+		s.Metrics = m
+	})
+}
+
+// With sets the fields of the Socketeer which have no option of their
+// own, once it is built.
+//
+// # Parameters:
+//
+// 	- apply (func(*Socketeer)): the function setting the fields.
+//
+// # Example:
+//
+// 	socketeer.With(func(s *socketeer.Socketeer) { s.AdminToken = token })
+func With(apply func(*Socketeer)) Option {
+	return func(set *settings) {
+		set.apply = append(set.apply, apply)
+	}
+}
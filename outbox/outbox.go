@@ -0,0 +1,520 @@
+// Package outbox provides a ChangeSource implementing the
+// transactional outbox pattern: the application inserts the events
+// it publishes in a dedicated outbox collection, in the same MongoDB
+// transaction as its own writes, and the Source dispatches every
+// outbox document at least once before marking or deleting it.
+//
+// An outbox document names its topic and carries the dispatched
+// fields in its payload, the operation type defaults to "insert":
+//
+// 	{"_id": ObjectId("..."), "topic": "orders", "op": "update",
+// 	"payload": {"status": "shipped"}}
+//
+// # Usage:
+//
+// 	src, err := outbox.Connect("mongodb://localhost:27017", "mydb", "outbox")
+// 	if err != nil {
+// 		log.Fatal(err)
+// 	}
+// 	src.Mode = outbox.Delete
+// 	s := socketeer.NewSocketeerWithSource(src)
+// 	s.Start([]string{"status"}, "localhost:8080", "/listen")
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Modes of the Source, what happens to an outbox document once it
+// is claimed for dispatch.
+//
+// 	- Mark sets the MarkField of the document to the dispatch time,
+// 		the dispatched documents are kept for auditing.
+// 	- Delete deletes the document.
+const (
+	Mark   = "mark"
+	Delete = "delete"
+)
+
+// DefaultMarkField is the field set on the dispatched documents in
+// the Mark mode.
+const DefaultMarkField = "dispatchedAt"
+
+// DefaultLeaseField is the field set on the claimed documents, to the
+// time their claim expires at.
+const DefaultLeaseField = "claimedUntil"
+
+// DefaultLeaseTTL is how long a document stays claimed by default.
+const DefaultLeaseTTL = 30 * time.Second
+
+// The bounds of the delay before the change stream is reopened after a
+// resumable error, doubled per consecutive attempt.
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// settleTimeout bounds the marking or deletion of a dispatched
+// document, which outlives the disconnection of the Source.
+const settleTimeout = 5 * time.Second
+
+// Source is a socketeer.ChangeSource dispatching the documents of
+// an outbox collection.
+//
+// A document is claimed, by setting its LeaseField with a filter on
+// its pending and unclaimed state, before it is handed to the
+// socketeer, and only marked or deleted once handed: when several
+// Sources watch the same outbox, or a document is seen both in the
+// backlog and in the change stream, it is dispatched once. When the
+// Source crashes or the socketeer fails in between, the claim expires
+// after LeaseTTL and the document is dispatched again by the sweep of
+// the backlog run every LeaseTTL, so that no event is lost.
+//
+// 	- Coll is the outbox collection.
+// 	- Mode is Mark (default) or Delete.
+// 	- MarkField is the field set in the Mark mode, defaults to
+// 		DefaultMarkField.
+// 	- LeaseField is the field claiming a document, defaults to
+// 		DefaultLeaseField.
+// 	- LeaseTTL is how long a document stays claimed, it must exceed the
+// 		time the socketeer takes to handle an event and the clock skew
+// 		between the Sources, defaults to DefaultLeaseTTL.
+// 	- Resume persists the resume token of the change stream, which is
+// 		reopened after it on a restart, optional.
+// 	- last is the resume token of the last change handled, the change
+// 		stream is reopened after it on a resumable error.
+// 	- saved is when the resume token was saved last.
+// 	- client is the client disconnected with the Source, nil if it
+// 		is not owned by the Source.
+// 	- ctx is cancelled when the Source is disconnected.
+// 	- cancel cancels ctx.
+// 	- heartbeat is called after every round trip of the change
+// 		stream, set with OnHeartbeat().
+type Source struct {
+	Coll       *mongo.Collection
+	Mode       string
+	MarkField  string
+	LeaseField string
+	LeaseTTL   time.Duration
+	Resume     socketeer.ResumeStore
+	last       bson.Raw
+	saved      time.Time
+	client     *mongo.Client
+	ctx        context.Context
+	cancel     context.CancelFunc
+	heartbeat  func()
+}
+
+// document is an outbox document.
+//
+// 	- ID is the _id of the document.
+// 	- Topic is the topic of the event, the collection name
+// 		of the outbox when empty.
+// 	- Op is the operation type of the event, "insert" when empty.
+// 	- Payload are the fields of the event.
+type document struct {
+	ID      any    `bson:"_id"`
+	Topic   string `bson:"topic"`
+	Op      string `bson:"op"`
+	Payload bson.M `bson:"payload"`
+}
+
+// New returns a new Source dispatching the documents of coll.
+//
+// # Parameters:
+//
+// 	- coll (*mongo.Collection): the outbox collection.
+//
+// # Example:
+//
+// 	src := outbox.New(client.Database("mydb").Collection("outbox"))
+func New(coll *mongo.Collection) *Source {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Source{
+		Coll:   coll,
+		Mode:   Mark,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Connect returns a new Source dispatching the documents of the outbox
+// collection collName, the client is disconnected with the Source.
+//
+// # Parameters:
+//
+// 	- uriString (string): the MongoDB connection string.
+// 	- dbName (string): the MongoDB database name.
+// 	- collName (string): the name of the outbox collection.
+//
+// # Example:
+//
+// 	src, err := outbox.Connect("mongodb://localhost:27017", "mydb", "outbox")
+func Connect(uriString string, dbName string, collName string) (*Source, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uriString))
+	if err != nil {
+		return nil, err
+	}
+	err = client.Ping(context.Background(), nil)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	s := New(client.Database(dbName).Collection(collName))
+	s.client = client
+
+	return s, nil
+}
+
+// Listen dispatches the pending documents of the outbox, oldest first,
+// then the inserted ones until the Source is disconnected. The change
+// stream is reopened after the last change handled on a network error
+// or a resumable server error, with an exponential backoff, and after
+// the token of the Resume store on a restart.
+//
+// # Parameters:
+//
+// 	- handle (func(socketeer.Event) error): the function called for every
+// 		document, the Source stops and returns the error if it fails.
+//
+// # Example:
+//
+// 	err := src.Listen(s.process)
+func (s *Source) Listen(handle func(socketeer.Event) error) error {
+	if s.Mode != Mark && s.Mode != Delete {
+		return errors.New("outbox: unknown mode " + s.Mode)
+	}
+
+	if s.Resume != nil {
+		token, err := s.Resume.Load()
+		if err != nil {
+			return fmt.Errorf("outbox: loading the resume token: %w", err)
+		}
+		s.last = token
+	}
+	backoff := minBackoff
+	for {
+		err := s.watch(handle)
+		if s.ctx.Err() != nil || err == nil {
+			return s.stopped(err)
+		}
+		if !resumable(err) {
+			return err
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// watch opens the change stream, after the last change handled if
+// any, dispatches the backlog, then the inserted documents, sweeping
+// the backlog again every LeaseTTL. It returns nil when the change
+// stream ends.
+//
+// # Parameters:
+//
+// 	- handle (func(socketeer.Event) error): the function called for every document.
+//
+// # Example:
+//
+// 	err := s.watch(handle)
+func (s *Source) watch(handle func(socketeer.Event) error) error {
+	// The stream is opened before the backlog is read so that no
+	// document inserted in between is missed, the claim dedupes them.
+	opts := options.ChangeStream()
+	if s.last != nil {
+		opts.SetStartAfter(s.last)
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	stream, err := s.Coll.Watch(s.ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	err = s.sweep(handle)
+	if err != nil {
+		return err
+	}
+	swept := time.Now()
+	for {
+		if !stream.TryNext(s.ctx) {
+			if stream.Err() != nil || stream.ID() == 0 {
+				s.checkpoint(stream, true)
+				return stream.Err()
+			}
+			s.beat()
+			err = s.checkpoint(stream, false)
+			if err == nil && time.Since(swept) >= s.leaseTTL() {
+				err = s.sweep(handle)
+				swept = time.Now()
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		s.beat()
+
+		var change struct {
+			FullDocument document `bson:"fullDocument"`
+		}
+		err = stream.Decode(&change)
+		if err == nil {
+			err = s.dispatch(change.FullDocument, handle)
+		}
+		if err == nil {
+			err = s.checkpoint(stream, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sweep dispatches the pending documents which aren't claimed, or
+// whose claim expired, oldest first.
+func (s *Source) sweep(handle func(socketeer.Event) error) error {
+	cursor, err := s.Coll.Find(s.ctx, s.claimable(time.Now()), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(s.ctx) {
+		var doc document
+		err = cursor.Decode(&doc)
+		if err == nil {
+			err = s.dispatch(doc, handle)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// checkpoint records the resume token of the change stream, and saves
+// it to the Resume store every second at most, or right away when
+// forced.
+func (s *Source) checkpoint(stream *mongo.ChangeStream, force bool) error {
+	token := stream.ResumeToken()
+	if token == nil {
+		return nil
+	}
+	s.last = append(bson.Raw(nil), token...)
+	if s.Resume == nil || (!force && time.Since(s.saved) < time.Second) {
+		return nil
+	}
+
+	err := s.Resume.Save(s.last)
+	if err != nil {
+		return fmt.Errorf("outbox: saving the resume token: %w", err)
+	}
+	s.saved = time.Now()
+
+	return nil
+}
+
+// claimable returns the filter of the documents not dispatched yet,
+// and not claimed or whose claim expired.
+func (s *Source) claimable(now time.Time) bson.M {
+	filter := bson.M{"$or": bson.A{
+		bson.M{s.leaseField(): bson.M{"$exists": false}},
+		bson.M{s.leaseField(): bson.M{"$lte": primitive.NewDateTimeFromTime(now)}},
+	}}
+	if s.Mode == Mark {
+		filter[s.markField()] = bson.M{"$exists": false}
+	}
+
+	return filter
+}
+
+// markField returns the field set in the Mark mode.
+func (s *Source) markField() string {
+	if s.MarkField == "" {
+		return DefaultMarkField
+	}
+
+	return s.MarkField
+}
+
+// leaseField returns the field claiming a document.
+func (s *Source) leaseField() string {
+	if s.LeaseField == "" {
+		return DefaultLeaseField
+	}
+
+	return s.LeaseField
+}
+
+// leaseTTL returns how long a document stays claimed.
+func (s *Source) leaseTTL() time.Duration {
+	if s.LeaseTTL <= 0 {
+		return DefaultLeaseTTL
+	}
+
+	return s.LeaseTTL
+}
+
+// dispatch claims an outbox document, hands it to the socketeer, then
+// marks or deletes it. The documents claimed by someone else are
+// skipped, and the claim is released when the handle function fails.
+//
+// # Parameters:
+//
+// 	- doc (document): the outbox document.
+// 	- handle (func(socketeer.Event) error): the function called with the event.
+//
+// # Example:
+//
+// 	err := s.dispatch(doc, handle)
+func (s *Source) dispatch(doc document, handle func(socketeer.Event) error) error {
+	now := time.Now()
+	filter := s.claimable(now)
+	filter["_id"] = doc.ID
+	claim := bson.M{"$set": bson.M{s.leaseField(): primitive.NewDateTimeFromTime(now.Add(s.leaseTTL()))}}
+	res, err := s.Coll.UpdateOne(s.ctx, filter, claim)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount != 1 {
+		return nil
+	}
+
+	ev := socketeer.Event{
+		OperationType: doc.Op,
+		Collection:    doc.Topic,
+		Fields:        doc.Payload,
+		DocumentKey:   map[string]any{"_id": doc.ID},
+	}
+	if ev.OperationType == "" {
+		ev.OperationType = "insert"
+	}
+	if ev.Collection == "" {
+		ev.Collection = s.Coll.Name()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancel()
+	err = handle(ev)
+	if err != nil {
+		s.Coll.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$unset": bson.M{s.leaseField(): ""}})
+		return err
+	}
+	if s.Mode == Delete {
+		_, err = s.Coll.DeleteOne(ctx, bson.M{"_id": doc.ID})
+		return err
+	}
+	_, err = s.Coll.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{
+		"$set":   bson.M{s.markField(): primitive.NewDateTimeFromTime(time.Now())},
+		"$unset": bson.M{s.leaseField(): ""},
+	})
+
+	return err
+}
+
+// resumable reports whether the change stream can be reopened after
+// an error: a network error, a timeout or a server error labelled as
+// resumable.
+func resumable(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+
+	return errors.As(err, &serverErr) && serverErr.HasErrorLabel("ResumableChangeStreamError")
+}
+
+// stopped returns nil once the Source is disconnected, as the
+// failures it causes are expected, and err otherwise.
+func (s *Source) stopped(err error) error {
+	if s.ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// SetResume sets the store persisting the resume token of the change
+// stream, it has to be called before Listen().
+//
+// # Parameters:
+//
+// 	- store (socketeer.ResumeStore): the store.
+//
+// # Example:
+//
+// 	src.SetResume(resume.NewFile("/var/lib/socketeer/outbox.token"))
+func (s *Source) SetResume(store socketeer.ResumeStore) {
+	s.Resume = store
+}
+
+// OnHeartbeat sets the function called after every round trip of the
+// change stream, it has to be called before Listen().
+//
+// # Parameters:
+//
+// 	- heartbeat (func()): the function to call.
+//
+// # Example:
+//
+// 	src.OnHeartbeat(func() { last.Store(time.Now().UnixNano()) })
+func (s *Source) OnHeartbeat(heartbeat func()) {
+	s.heartbeat = heartbeat
+}
+
+// beat calls the heartbeat function, if any.
+func (s *Source) beat() {
+	if s.heartbeat != nil {
+		s.heartbeat()
+	}
+}
+
+// Ping checks that the database of the outbox can be reached.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the round trip to the database.
+//
+// # Example:
+//
+// 	err := src.Ping(ctx)
+func (s *Source) Ping(ctx context.Context) error {
+	return s.Coll.Database().Client().Ping(ctx, nil)
+}
+
+// Disconnect stops the Source, and disconnects its client when it
+// was created with Connect().
+//
+// # Example:
+//
+// 	src.Disconnect()
+func (s *Source) Disconnect() error {
+	s.cancel()
+	if s.client != nil {
+		return s.client.Disconnect(context.Background())
+	}
+
+	return nil
+}
@@ -0,0 +1,366 @@
+// Package parquet provides a format of the archive sink writing its
+// objects as Parquet files, which Athena, BigQuery or DuckDB query in
+// place without a conversion step.
+//
+// # Usage:
+//
+// 	cold := archive.New("analytics", archive.NewS3("events-lake", "eu-west-1", accessKey, secretKey))
+// 	cold.Format = parquet.Format{Columns: []string{"status", "total"}}
+// 	s.Sinks = []socketeer.Sink{cold}
+//
+// Every file has a single row group of the messages of its window,
+// with the columns seq, topic, op, ts (a timestamp in milliseconds),
+// documentKey and data (both as JSON) and one string column per field
+// of the data of Columns, null for the messages without it, example:
+//
+// 	SELECT topic, status, count(*) FROM 'events/2024/01/02/*.parquet' GROUP BY ALL;
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/darthsalad/socketeer"
+	"github.com/darthsalad/socketeer/archive"
+)
+
+// magic starts and ends every Parquet file.
+const magic = "PAR1"
+
+// Physical types, converted types, repetitions, encodings, codec and
+// page type of the Parquet format.
+const (
+	typeInt64       = 2
+	typeByteArray   = 6
+	convertedNone   = -1
+	convertedUTF8   = 0
+	convertedMillis = 9
+	required        = 0
+	optional        = 1
+	encodingPlain   = 0
+	encodingRLE     = 3
+	codecGzip       = 2
+	pageData        = 0
+)
+
+// fixedColumns are the names of the columns of every file.
+var fixedColumns = []string{"seq", "topic", "op", "ts", "documentKey", "data"}
+
+// Format is an archive.Format writing the batches as Parquet files,
+// gzip compressed.
+//
+// 	- Columns are the fields of the data of the messages with a column
+// 		of their own, besides the JSON of the whole data.
+type Format struct {
+	Columns []string
+}
+
+// Extension returns the extension of the files, ".parquet".
+func (f Format) Extension() string {
+	return ".parquet"
+}
+
+// ContentType returns the content type of the files.
+func (f Format) ContentType() string {
+	return "application/vnd.apache.parquet"
+}
+
+// NewBatch returns a new empty batch, which fails to add messages when
+// a field of Columns is repeated or has the name of a fixed column.
+func (f Format) NewBatch() archive.Batch {
+	b := &batch{
+		columns: []*column{
+			{name: "seq", physical: typeInt64, converted: convertedNone},
+			{name: "topic", physical: typeByteArray, converted: convertedUTF8},
+			{name: "op", physical: typeByteArray, converted: convertedUTF8},
+			{name: "ts", physical: typeInt64, converted: convertedMillis},
+			{name: "documentKey", physical: typeByteArray, converted: convertedUTF8, optional: true},
+			{name: "data", physical: typeByteArray, converted: convertedUTF8},
+		},
+		fields: f.Columns,
+	}
+
+	names := make(map[string]bool, len(fixedColumns)+len(f.Columns))
+	for _, name := range fixedColumns {
+		names[name] = true
+	}
+	for _, field := range f.Columns {
+		if names[field] {
+			b.err = fmt.Errorf("parquet: duplicate column %q", field)
+		}
+		names[field] = true
+		b.columns = append(b.columns, &column{
+			name: field, physical: typeByteArray, converted: convertedUTF8, optional: true,
+		})
+	}
+
+	return b
+}
+
+// column is a column of a batch, its values in the order of the rows.
+//
+// 	- name is the name of the column.
+// 	- physical is the physical type, typeInt64 or typeByteArray.
+// 	- converted is the converted type, convertedNone for none.
+// 	- optional reports whether the column is nullable.
+// 	- defined reports, for every row, whether the value is not null.
+// 	- int64s are the values of an int64 column.
+// 	- binaries are the values not null of a byte array column.
+type column struct {
+	name      string
+	physical  int32
+	converted int32
+	optional  bool
+	defined   []bool
+	int64s    []int64
+	binaries  [][]byte
+}
+
+// batch is a batch of the Parquet format.
+//
+// 	- columns are the columns, the fixed ones then the fields.
+// 	- fields are the fields of the data with a column.
+// 	- rows is the number of rows.
+// 	- err is the failure of the Columns of the Format.
+type batch struct {
+	columns []*column
+	fields  []string
+	rows    int
+	err     error
+}
+
+// Add appends a message as a row.
+func (b *batch) Add(msg socketeer.Message) error {
+	if b.err != nil {
+		return b.err
+	}
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+	var documentKey []byte
+	if len(msg.DocumentKey) > 0 {
+		documentKey, err = json.Marshal(msg.DocumentKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	b.columns[0].int64s = append(b.columns[0].int64s, int64(msg.Seq))
+	b.columns[1].binaries = append(b.columns[1].binaries, []byte(msg.Topic))
+	b.columns[2].binaries = append(b.columns[2].binaries, []byte(msg.OperationType))
+	b.columns[3].int64s = append(b.columns[3].int64s, msg.Time.UnixMilli())
+	b.columns[4].add(documentKey, documentKey != nil)
+	b.columns[5].binaries = append(b.columns[5].binaries, data)
+	for i, field := range b.fields {
+		value, ok := msg.Data[field]
+		b.columns[len(fixedColumns)+i].add([]byte(value), ok)
+	}
+	b.rows++
+
+	return nil
+}
+
+// add appends a value of a nullable byte array column.
+func (c *column) add(value []byte, defined bool) {
+	c.defined = append(c.defined, defined)
+	if defined {
+		c.binaries = append(c.binaries, value)
+	}
+}
+
+// Seal writes the Parquet file: the magic, a page per column, then the
+// footer with the schema and the single row group.
+func (b *batch) Seal() ([]byte, error) {
+	var file bytes.Buffer
+	file.WriteString(magic)
+
+	chunks := make([]chunk, len(b.columns))
+	for i, c := range b.columns {
+		page := c.page()
+		compressed, err := compress(page)
+		if err != nil {
+			return nil, err
+		}
+		header := b.pageHeader(len(page), len(compressed))
+
+		chunks[i] = chunk{
+			offset:       int64(file.Len()),
+			uncompressed: int64(header.Len() + len(page)),
+			compressed:   int64(header.Len() + len(compressed)),
+		}
+		file.Write(header.Bytes())
+		file.Write(compressed)
+	}
+
+	footer := b.footer(chunks)
+	file.Write(footer.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(footer.Len()))
+	file.WriteString(magic)
+
+	return file.Bytes(), nil
+}
+
+// chunk is the position of a column chunk in the file.
+//
+// 	- offset is the offset of its page header.
+// 	- uncompressed is its size, header included, before compression.
+// 	- compressed is its size, header included, in the file.
+type chunk struct {
+	offset       int64
+	uncompressed int64
+	compressed   int64
+}
+
+// page returns the content of the data page of a column, before
+// compression: the definition levels of a nullable column, then the
+// values not null, PLAIN encoded.
+func (c *column) page() []byte {
+	var page bytes.Buffer
+	if c.optional {
+		levels := levels(c.defined)
+		binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+		page.Write(levels)
+	}
+	for _, v := range c.int64s {
+		binary.Write(&page, binary.LittleEndian, v)
+	}
+	for _, v := range c.binaries {
+		binary.Write(&page, binary.LittleEndian, uint32(len(v)))
+		page.Write(v)
+	}
+
+	return page.Bytes()
+}
+
+// levels encodes the definition levels of a nullable column with the
+// RLE hybrid encoding, as runs of a bit width of 1.
+func levels(defined []bool) []byte {
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	for i := 0; i < len(defined); {
+		j := i
+		for j < len(defined) && defined[j] == defined[i] {
+			j++
+		}
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(j-i)<<1)])
+		if defined[i] {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		i = j
+	}
+
+	return buf.Bytes()
+}
+
+// compress compresses a page with gzip.
+func compress(page []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(page)
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+
+	return buf.Bytes(), err
+}
+
+// pageHeader returns the header of a data page of the batch.
+//
+// # Parameters:
+//
+// 	- uncompressed (int): the size of the page before compression.
+// 	- compressed (int): the size of the page in the file.
+//
+// # Example:
+//
+// 	header := b.pageHeader(len(page), len(compressed))
+func (b *batch) pageHeader(uncompressed int, compressed int) *encoder {
+	e := newEncoder()
+	e.i32(1, pageData)
+	e.i32(2, int32(uncompressed))
+	e.i32(3, int32(compressed))
+	e.begin(5)
+	e.i32(1, int32(b.rows))
+	e.i32(2, encodingPlain)
+	e.i32(3, encodingRLE)
+	e.i32(4, encodingRLE)
+	e.end()
+	e.end()
+
+	return e
+}
+
+// footer returns the metadata of the file: the schema, then the row
+// group of the chunks of the columns.
+//
+// # Parameters:
+//
+// 	- chunks ([]chunk): the chunks of the columns, in order.
+//
+// # Example:
+//
+// 	footer := b.footer(chunks)
+func (b *batch) footer(chunks []chunk) *encoder {
+	e := newEncoder()
+	e.i32(1, 1)
+
+	e.list(2, thriftStruct, len(b.columns)+1)
+	e.open()
+	e.binary(4, "schema")
+	e.i32(5, int32(len(b.columns)))
+	e.end()
+	for _, c := range b.columns {
+		e.open()
+		e.i32(1, c.physical)
+		if c.optional {
+			e.i32(3, optional)
+		} else {
+			e.i32(3, required)
+		}
+		e.binary(4, c.name)
+		if c.converted != convertedNone {
+			e.i32(6, c.converted)
+		}
+		e.end()
+	}
+	e.i64(3, int64(b.rows))
+
+	var size int64
+	e.list(4, thriftStruct, 1)
+	e.open()
+	e.list(1, thriftStruct, len(b.columns))
+	for i, c := range b.columns {
+		e.open()
+		e.i64(2, chunks[i].offset)
+		e.begin(3)
+		e.i32(1, c.physical)
+		e.list(2, thriftI32, 2)
+		e.zigzag(encodingPlain)
+		e.zigzag(encodingRLE)
+		e.list(3, thriftBinary, 1)
+		e.str(c.name)
+		e.i32(4, codecGzip)
+		e.i64(5, int64(b.rows))
+		e.i64(6, chunks[i].uncompressed)
+		e.i64(7, chunks[i].compressed)
+		e.i64(9, chunks[i].offset)
+		e.end()
+		e.end()
+		size += chunks[i].uncompressed
+	}
+	e.i64(2, size)
+	e.i64(3, int64(b.rows))
+	e.end()
+
+	e.binary(6, "socketeer")
+	e.end()
+
+	return e
+}
@@ -0,0 +1,325 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// schemaColumn is a column of the schema of a file.
+type schemaColumn struct {
+	name      string
+	physical  int64
+	optional  bool
+	converted int64
+}
+
+// file is a Parquet file read back: its schema, its number of rows
+// and the values of its columns by name, nil for the nulls.
+type file struct {
+	columns []schemaColumn
+	rows    int64
+	values  map[string][]any
+}
+
+// readFile reads a file written by a batch, checking its structure on
+// the way: the magic, the footer, and a single row group of a gzip
+// compressed PLAIN data page per column.
+func readFile(t *testing.T, b []byte) file {
+	t.Helper()
+	if len(b) < 12 || string(b[:4]) != magic || string(b[len(b)-4:]) != magic {
+		t.Fatalf("no magic around the file")
+	}
+	size := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	d := &decoder{b: b[len(b)-8-size : len(b)-8]}
+	meta, err := d.structure()
+	if err != nil {
+		t.Fatalf("reading the footer: %v", err)
+	}
+	if d.pos != size {
+		t.Fatalf("footer of %d bytes, %d read", size, d.pos)
+	}
+	if meta[1] != int64(1) || meta[6] != "socketeer" {
+		t.Errorf("version %v, created by %v", meta[1], meta[6])
+	}
+
+	f := file{rows: meta[3].(int64), values: make(map[string][]any)}
+	schema := meta[2].([]any)
+	root := schema[0].(map[int16]any)
+	if root[4] != "schema" || root[5] != int64(len(schema)-1) {
+		t.Fatalf("root of the schema = %v", root)
+	}
+	for _, elem := range schema[1:] {
+		c := elem.(map[int16]any)
+		converted, ok := c[6].(int64)
+		if !ok {
+			converted = convertedNone
+		}
+		f.columns = append(f.columns, schemaColumn{
+			name:      c[4].(string),
+			physical:  c[1].(int64),
+			optional:  c[3] == int64(optional),
+			converted: converted,
+		})
+	}
+
+	groups := meta[4].([]any)
+	if len(groups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(groups))
+	}
+	group := groups[0].(map[int16]any)
+	if group[3] != f.rows {
+		t.Errorf("row group of %v rows, file of %d", group[3], f.rows)
+	}
+	chunks := group[1].([]any)
+	if len(chunks) != len(f.columns) {
+		t.Fatalf("%d column chunks, %d columns", len(chunks), len(f.columns))
+	}
+	var total int64
+	for i, elem := range chunks {
+		c := f.columns[i]
+		chunk := elem.(map[int16]any)
+		cm := chunk[3].(map[int16]any)
+		if cm[1] != c.physical || cm[3].([]any)[0] != c.name || cm[4] != int64(codecGzip) || cm[5] != f.rows {
+			t.Errorf("metadata of the chunk of %s = %v", c.name, cm)
+		}
+		offset := cm[9].(int64)
+		if chunk[2] != offset {
+			t.Errorf("chunk of %s at %v, its page at %d", c.name, chunk[2], offset)
+		}
+		f.values[c.name] = readPage(t, b[offset:offset+cm[7].(int64)], c, cm[6].(int64), f.rows)
+		total += cm[6].(int64)
+	}
+	if group[2] != total {
+		t.Errorf("row group of %v bytes, chunks of %d", group[2], total)
+	}
+
+	return f
+}
+
+// readPage reads the values of the data page of a column chunk.
+func readPage(t *testing.T, b []byte, c schemaColumn, uncompressed int64, rows int64) []any {
+	t.Helper()
+	d := &decoder{b: b}
+	header, err := d.structure()
+	if err != nil {
+		t.Fatalf("reading the page header of %s: %v", c.name, err)
+	}
+	data := header[5].(map[int16]any)
+	if header[1] != int64(pageData) || data[1] != rows || data[2] != int64(encodingPlain) {
+		t.Fatalf("page header of %s = %v", c.name, header)
+	}
+	if int64(d.pos)+header[3].(int64) != int64(len(b)) || int64(d.pos)+header[2].(int64) != uncompressed {
+		t.Fatalf("page of %s: header of %d bytes, sizes %v and %v, chunk of %d bytes", c.name, d.pos, header[2], header[3], len(b))
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(b[d.pos:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(page)) != header[2].(int64) {
+		t.Fatalf("page of %s of %d bytes, want %v", c.name, len(page), header[2])
+	}
+
+	defined := make([]bool, rows)
+	for i := range defined {
+		defined[i] = true
+	}
+	r := bytes.NewReader(page)
+	if c.optional {
+		defined = readLevels(t, r, rows)
+	}
+	values := make([]any, rows)
+	for i := range values {
+		if !defined[i] {
+			continue
+		}
+		switch c.physical {
+		case typeInt64:
+			var v int64
+			err = binary.Read(r, binary.LittleEndian, &v)
+			values[i] = v
+		case typeByteArray:
+			var n uint32
+			err = binary.Read(r, binary.LittleEndian, &n)
+			v := make([]byte, n)
+			if err == nil {
+				_, err = io.ReadFull(r, v)
+			}
+			values[i] = string(v)
+		}
+		if err != nil {
+			t.Fatalf("reading value %d of %s: %v", i, c.name, err)
+		}
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d bytes left in the page of %s", r.Len(), c.name)
+	}
+
+	return values
+}
+
+// readLevels reads the definition levels of a nullable column, RLE
+// runs of a bit width of 1 prefixed by their length.
+func readLevels(t *testing.T, r *bytes.Reader, rows int64) []bool {
+	t.Helper()
+	var size uint32
+	err := binary.Read(r, binary.LittleEndian, &size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := make([]byte, size)
+	_, err = io.ReadFull(r, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var defined []bool
+	lr := bytes.NewReader(levels)
+	for lr.Len() > 0 {
+		header, err := binary.ReadUvarint(lr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header&1 != 0 {
+			t.Fatalf("bit-packed run, want RLE runs")
+		}
+		level, err := lr.ReadByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := uint64(0); i < header>>1; i++ {
+			defined = append(defined, level == 1)
+		}
+	}
+	if int64(len(defined)) != rows {
+		t.Fatalf("%d definition levels, want %d", len(defined), rows)
+	}
+
+	return defined
+}
+
+// seal adds the messages to a new batch of the format and seals it.
+func seal(t *testing.T, f Format, msgs []socketeer.Message) []byte {
+	t.Helper()
+	b := f.NewBatch()
+	for _, msg := range msgs {
+		err := b.Add(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, err := b.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func TestRoundTrip(t *testing.T) {
+	at := time.Date(2024, 1, 2, 15, 4, 5, 123e6, time.UTC)
+	msgs := []socketeer.Message{
+		{Seq: 1, Topic: "orders", OperationType: "insert", Time: at, DocumentKey: map[string]string{"_id": "a1"}, Data: map[string]string{"status": "paid", "total": "12.5"}},
+		{Seq: 2, Topic: "orders", OperationType: "update", Time: at.Add(time.Second), Data: map[string]string{"total": "13"}},
+		{Seq: 3, Topic: "orders", OperationType: "delete", Time: at.Add(2 * time.Second), DocumentKey: map[string]string{"_id": "a1"}},
+	}
+	f := readFile(t, seal(t, Format{Columns: []string{"status", "total"}}, msgs))
+
+	want := []schemaColumn{
+		{"seq", typeInt64, false, convertedNone},
+		{"topic", typeByteArray, false, convertedUTF8},
+		{"op", typeByteArray, false, convertedUTF8},
+		{"ts", typeInt64, false, convertedMillis},
+		{"documentKey", typeByteArray, true, convertedUTF8},
+		{"data", typeByteArray, false, convertedUTF8},
+		{"status", typeByteArray, true, convertedUTF8},
+		{"total", typeByteArray, true, convertedUTF8},
+	}
+	if !reflect.DeepEqual(f.columns, want) {
+		t.Errorf("schema = %v, want %v", f.columns, want)
+	}
+	if f.rows != 3 {
+		t.Fatalf("%d rows, want 3", f.rows)
+	}
+
+	for i, msg := range msgs {
+		data, _ := json.Marshal(msg.Data)
+		var documentKey any
+		if msg.DocumentKey != nil {
+			key, _ := json.Marshal(msg.DocumentKey)
+			documentKey = string(key)
+		}
+		row := map[string]any{
+			"seq":         int64(msg.Seq),
+			"topic":       msg.Topic,
+			"op":          msg.OperationType,
+			"ts":          msg.Time.UnixMilli(),
+			"documentKey": documentKey,
+			"data":        string(data),
+		}
+		for _, field := range []string{"status", "total"} {
+			row[field] = nil
+			if v, ok := msg.Data[field]; ok {
+				row[field] = v
+			}
+		}
+		for name, v := range row {
+			if got := f.values[name][i]; got != v {
+				t.Errorf("row %d, %s = %#v, want %#v", i, name, got, v)
+			}
+		}
+	}
+}
+
+func TestRoundTripLongRuns(t *testing.T) {
+	var msgs []socketeer.Message
+	for i := 0; i < 300; i++ {
+		data := map[string]string{}
+		if i < 200 || i%3 == 0 {
+			data["status"] = fmt.Sprint("s", i)
+		}
+		msgs = append(msgs, socketeer.Message{Seq: uint64(i + 1), Topic: "orders", Data: data})
+	}
+	f := readFile(t, seal(t, Format{Columns: []string{"status"}}, msgs))
+
+	if f.rows != 300 {
+		t.Fatalf("%d rows, want 300", f.rows)
+	}
+	for i, v := range f.values["status"] {
+		want, ok := msgs[i].Data["status"]
+		if ok && v != want || !ok && v != nil {
+			t.Errorf("row %d, status = %#v, want %q", i, v, want)
+		}
+		if f.values["documentKey"][i] != nil {
+			t.Errorf("row %d, documentKey = %#v, want null", i, f.values["documentKey"][i])
+		}
+	}
+}
+
+func TestRoundTripEmpty(t *testing.T) {
+	f := readFile(t, seal(t, Format{}, nil))
+	if f.rows != 0 || len(f.columns) != len(fixedColumns) {
+		t.Errorf("%d rows, %d columns, want 0 and %d", f.rows, len(f.columns), len(fixedColumns))
+	}
+}
+
+func TestDuplicateColumn(t *testing.T) {
+	for _, columns := range [][]string{{"seq"}, {"status", "status"}} {
+		err := Format{Columns: columns}.NewBatch().Add(socketeer.Message{Seq: 1})
+		if err == nil {
+			t.Errorf("Add() with the columns %q succeeded", columns)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Types of the Thrift compact protocol used by the Parquet metadata.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// encoder writes the Parquet metadata with the Thrift compact protocol,
+// the encoding of the page headers and of the footer.
+//
+// 	- Buffer holds the encoded bytes.
+// 	- ids are the ids of the last fields written, one per open struct.
+type encoder struct {
+	bytes.Buffer
+	ids []int16
+}
+
+// newEncoder returns an encoder with the top-level struct open.
+func newEncoder() *encoder {
+	return &encoder{ids: []int16{0}}
+}
+
+// varint writes an unsigned varint.
+func (e *encoder) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+// zigzag writes a signed integer as a zigzag varint.
+func (e *encoder) zigzag(v int64) {
+	e.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+// field writes the header of a field of the open struct, with the
+// delta of its id when it is small.
+func (e *encoder) field(id int16, kind byte) {
+	last := &e.ids[len(e.ids)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		e.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		e.WriteByte(kind)
+		e.zigzag(int64(id))
+	}
+	*last = id
+}
+
+// i32 writes an i32 field.
+func (e *encoder) i32(id int16, v int32) {
+	e.field(id, thriftI32)
+	e.zigzag(int64(v))
+}
+
+// i64 writes an i64 field.
+func (e *encoder) i64(id int16, v int64) {
+	e.field(id, thriftI64)
+	e.zigzag(v)
+}
+
+// binary writes a string field.
+func (e *encoder) binary(id int16, v string) {
+	e.field(id, thriftBinary)
+	e.str(v)
+}
+
+// str writes a string, as a field value or a list element.
+func (e *encoder) str(v string) {
+	e.varint(uint64(len(v)))
+	e.WriteString(v)
+}
+
+// list writes the header of a list field, followed by its n elements.
+func (e *encoder) list(id int16, kind byte, n int) {
+	e.field(id, thriftList)
+	if n < 15 {
+		e.WriteByte(byte(n)<<4 | kind)
+		return
+	}
+	e.WriteByte(0xf0 | kind)
+	e.varint(uint64(n))
+}
+
+// begin opens a struct field, closed by end().
+func (e *encoder) begin(id int16) {
+	e.field(id, thriftStruct)
+	e.open()
+}
+
+// open opens a struct, an element of a list of structs or the value
+// of a struct field.
+func (e *encoder) open() {
+	e.ids = append(e.ids, 0)
+}
+
+// end closes the open struct.
+func (e *encoder) end() {
+	e.WriteByte(0)
+	e.ids = e.ids[:len(e.ids)-1]
+}
@@ -0,0 +1,158 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// decoder reads the Thrift compact protocol, the structs as maps of
+// their values by field id, the lists as []any.
+//
+// 	- b are the encoded bytes.
+// 	- pos is the offset of the next byte to read.
+type decoder struct {
+	b   []byte
+	pos int
+}
+
+var errShort = errors.New("thrift: short buffer")
+
+func (d *decoder) byte() (byte, error) {
+	if d.pos >= len(d.b) {
+		return 0, errShort
+	}
+	d.pos++
+
+	return d.b[d.pos-1], nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.b[d.pos:])
+	if n <= 0 {
+		return 0, errShort
+	}
+	d.pos += n
+
+	return v, nil
+}
+
+func (d *decoder) zigzag() (int64, error) {
+	v, err := d.varint()
+
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+// value reads a value of a Thrift type.
+func (d *decoder) value(kind byte) (any, error) {
+	switch kind {
+	case thriftI32, thriftI64:
+		return d.zigzag()
+	case thriftBinary:
+		n, err := d.varint()
+		if err != nil {
+			return nil, err
+		}
+		if d.pos+int(n) > len(d.b) {
+			return nil, errShort
+		}
+		d.pos += int(n)
+
+		return string(d.b[d.pos-int(n) : d.pos]), nil
+	case thriftList:
+		header, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(header >> 4)
+		if n == 15 {
+			n, err = d.varint()
+			if err != nil {
+				return nil, err
+			}
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i], err = d.value(header & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return list, nil
+	case thriftStruct:
+		return d.structure()
+	}
+
+	return nil, fmt.Errorf("thrift: unexpected type %d", kind)
+}
+
+// structure reads a struct up to its stop field.
+func (d *decoder) structure() (map[int16]any, error) {
+	fields := make(map[int16]any)
+	var id int16
+	for {
+		header, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if header == 0 {
+			return fields, nil
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := d.zigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		fields[id], err = d.value(header & 0x0f)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	e := newEncoder()
+	e.i32(1, -3)
+	e.i64(2, 1<<40)
+	e.binary(20, "far field")
+	e.list(21, thriftI32, 20)
+	for i := 0; i < 20; i++ {
+		e.zigzag(int64(-i))
+	}
+	e.begin(22)
+	e.binary(1, "nested")
+	e.end()
+	e.list(23, thriftStruct, 1)
+	e.open()
+	e.i64(3, -1)
+	e.end()
+	e.end()
+
+	d := &decoder{b: e.Bytes()}
+	fields, err := d.structure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.pos != len(d.b) {
+		t.Errorf("%d bytes left", len(d.b)-d.pos)
+	}
+	if fields[1] != int64(-3) || fields[2] != int64(1<<40) || fields[20] != "far field" {
+		t.Errorf("fields = %v", fields)
+	}
+	list := fields[21].([]any)
+	if len(list) != 20 || list[19] != int64(-19) {
+		t.Errorf("list = %v", list)
+	}
+	if nested := fields[22].(map[int16]any); nested[1] != "nested" {
+		t.Errorf("struct = %v", nested)
+	}
+	if elem := fields[23].([]any)[0].(map[int16]any); elem[3] != int64(-1) {
+		t.Errorf("list of structs = %v", elem)
+	}
+}
@@ -0,0 +1,70 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// mergePatch returns the selected keys of an insert or an update as a
+// JSON Merge Patch (RFC 7386), the dotted keys of the updated fields
+// become nested objects and the removed fields become null, example:
+// {"address": {"city": "Paris"}, "nickname": null}.
+//
+// The changes of array elements, like "items.3", can't be expressed
+// as a merge patch and are left out, see ArrayChanges.
+//
+// # Parameters:
+//
+// 	- ev (Event): the event.
+// 	- keys (*keySet): the selected keys.
+//
+// # Example:
+//
+// 	msg.Patch, err = mergePatch(ev, keys)
+func mergePatch(ev Event, keys *keySet) (json.RawMessage, error) {
+	patch := make(map[string]any)
+	for key, value := range ev.Fields {
+		if keys.match(key) {
+			setPath(patch, key, value)
+		}
+	}
+	for _, key := range ev.Removed {
+		if keys.match(key) {
+			setPath(patch, key, nil)
+		}
+	}
+
+	return json.Marshal(patch)
+}
+
+// setPath sets the value at a dotted path of a patch, creating the
+// intermediate objects, it leaves the paths through array elements out.
+//
+// # Parameters:
+//
+// 	- patch (map[string]any): the patch.
+// 	- path (string): the dotted path, example: "address.city".
+// 	- value (any): the value, nil to remove the field.
+//
+// # Example:
+//
+// 	setPath(patch, "address.city", "Paris")
+func setPath(patch map[string]any, path string, value any) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[1:] {
+		if _, err := strconv.Atoi(part); err == nil {
+			return
+		}
+	}
+
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := patch[part].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			patch[part] = next
+		}
+		patch = next
+	}
+	patch[parts[len(parts)-1]] = value
+}
@@ -0,0 +1,210 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+	"github.com/darthsalad/socketeer/internal/metrics"
+)
+
+// process is the dispatch pipeline every event goes through,
+// it selects the configured keys from the fields of the event
+// and dispatches them to the websocket clients as a Message.
+// The description of an operation on the collection, like a schema
+// operation, a rename or a drop, is dispatched whole,
+// a delete with the document key of the deleted document.
+// A panic while processing the event is reported and the event skipped.
+//
+// The events over the Throttle of their topic are dropped, or held and
+// dispatched later.
+//
+// This method is handed to the ChangeSource when the socketeer is started.
+//
+// # Parameters:
+//
+// 	- ev (Event): the event to dispatch.
+//
+// # Example:
+//
+// 	s.DB.Listen(s.process)
+func (s *Socketeer) process(ev Event) error {
+	defer s.recoverPanic(map[string]any{"component": "pipeline", "collection": ev.Collection, "op": ev.OperationType})
+	s.beat()
+	if !s.owns(ev.Collection) || !s.watches(ev) {
+		return nil
+	}
+	s.events.Add(1)
+	s.record(ev)
+	s.dispatching.Store(time.Now().UnixNano())
+	defer s.dispatching.Store(0)
+	if s.Failover != nil {
+		defer s.mark(ev)
+	}
+
+	s.Metrics.Count(metrics.EventsReceived, 1, map[string]string{
+		metrics.TagCollection: ev.Collection,
+		metrics.TagOperation:  ev.OperationType,
+	})
+
+	if view, ok := s.Views[ev.Collection]; ok && viewed(ev.OperationType) {
+		ev, ok = view.apply(ev)
+		if !ok {
+			return nil
+		}
+	}
+	ev = s.transform(ev)
+	if len(s.Throttles) > 0 {
+		s.throttleMux.Lock()
+		defer s.throttleMux.Unlock()
+		if !s.throttle(ev) {
+			return nil
+		}
+	}
+	s.emit(ev)
+
+	return nil
+}
+
+// emit selects the configured keys from the fields of an event and
+// dispatches them as a Message, to the clients and the sinks.
+//
+// # Parameters:
+//
+// 	- ev (Event): the event to dispatch.
+//
+// # Example:
+//
+// 	s.emit(ev)
+func (s *Socketeer) emit(ev Event) {
+	var responseMap = make(map[string]string)
+	var arrayChanges []ArrayChange
+	if event.IsCollectionOp(ev.OperationType) {
+		responseMap = describe(ev.Fields)
+	} else {
+		keys := s.keysFor(ev.Collection)
+		for key, value := range ev.Fields {
+			if s.ArrayChanges {
+				if change, ok := arrayChange(key, value); ok && keys.match(change.Field) {
+					arrayChanges = append(arrayChanges, change)
+					continue
+				}
+			}
+			if keys.match(key) {
+				responseMap[key] = fmt.Sprintf("%v", value)
+			}
+		}
+		if s.ArrayChanges {
+			for field, size := range ev.Truncated {
+				if keys.match(field) {
+					arrayChanges = append(arrayChanges, ArrayChange{Field: field, Index: size, Action: ArrayTruncate})
+				}
+			}
+			sortArrayChanges(arrayChanges)
+		}
+	}
+
+	msg := Message{
+		Topic:         ev.Collection,
+		OperationType: ev.OperationType,
+		ClusterTime:   ev.ClusterTime,
+		Time:          time.Now(),
+		Data:          responseMap,
+		ArrayChanges:  arrayChanges,
+		RawChange:     ev.RawChange,
+	}
+	if (s.IncludeDocumentKey || ev.OperationType == event.OpDelete) && len(ev.DocumentKey) > 0 {
+		msg.DocumentKey = describe(ev.DocumentKey)
+	}
+	if s.IncludeFullDocument && len(ev.FullDocument) > 0 {
+		msg.FullDocument = describe(ev.FullDocument)
+	}
+	if s.MergePatch && !event.IsCollectionOp(ev.OperationType) && ev.OperationType != event.OpDelete {
+		patch, err := mergePatch(ev, s.keysFor(ev.Collection))
+		if err != nil {
+			s.report(err, map[string]any{"component": "pipeline", "collection": ev.Collection})
+		} else {
+			msg.Patch = patch
+		}
+	}
+	msg = s.aliasMessage(msg)
+	s.dispatchMux.Lock()
+	msg.Seq = s.seq.Add(1)
+	s.track(msg)
+	if s.Flow != nil {
+		defer s.dispatchMux.Unlock()
+		s.route(msg)
+		return
+	}
+	s.dispatch(msg)
+	s.dispatchMux.Unlock()
+	if !s.inspected {
+		s.inspect(msg, nil)
+	}
+	s.deliver(msg)
+}
+
+// publish numbers a message and dispatches it to the clients, under
+// dispatchMux like emit(), so that the messages published by the
+// other goroutines reach the clients in the order of their Seq.
+//
+// # Parameters:
+//
+// 	- msg (Message): the message to dispatch, its Seq is set.
+//
+// # Example:
+//
+// 	s.publish(Message{Topic: topic, OperationType: OpSnapshot, Documents: docs})
+func (s *Socketeer) publish(msg Message) {
+	s.dispatchMux.Lock()
+	defer s.dispatchMux.Unlock()
+
+	msg.Seq = s.seq.Add(1)
+	s.dispatch(msg)
+}
+
+// describe returns every field of the description of an operation on
+// the collection, or of a document, the strings as is and
+// the other values as JSON.
+//
+// # Parameters:
+//
+// 	- fields (map[string]any): the description of the operation.
+//
+// # Example:
+//
+// 	data := describe(ev.Fields)
+func describe(fields map[string]any) map[string]string {
+	data := make(map[string]string, len(fields))
+	for key, value := range fields {
+		data[key] = jsonString(value)
+	}
+
+	return data
+}
+
+// jsonString returns a string as is, an ObjectID as its hex
+// representation and the other values as JSON.
+//
+// # Parameters:
+//
+// 	- value (any): the value.
+//
+// # Example:
+//
+// 	str := jsonString(item)
+func jsonString(value any) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	if id, ok := value.(interface{ Hex() string }); ok {
+		return id.Hex()
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(b)
+}
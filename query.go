@@ -0,0 +1,253 @@
+package socketeer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/darthsalad/socketeer/internal/event"
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// QueryPath is the path the current state of the collections is read
+// on, with the keys, the authentication and the Scope of the websocket
+// endpoint, so that the clients bootstrap their state with the rules
+// of their updates. Example: GET /query?topic=posts&filter={"tenant":"acme"}&limit=10
+const QueryPath = "/query"
+
+// Limits of the number of documents returned by Query().
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// Errors returned by Query().
+var (
+	ErrQueryUnsupported = errors.New("socketeer: queries not supported by the change source")
+	ErrUnknownTopic     = errors.New("socketeer: unknown topic")
+)
+
+// querier is implemented by the change sources which can read the
+// current documents of a collection, like the default DB.
+type querier interface {
+	Find(ctx context.Context, coll string, filter event.Filter, fields []string, limit int64) ([]map[string]any, error)
+}
+
+// authorizer is implemented by the broadcasters which can apply the
+// IP filter and the authentication of their endpoint to a request,
+// like the default WebSocket server.
+type authorizer interface {
+	Authorize(req *http.Request) (string, error)
+}
+
+// Document is the current state of a document, as returned by Query()
+// and carried by the snapshots.
+type Document = event.Document
+
+// QueryResult is the response of QueryPath.
+//
+// 	- Topic is the queried topic.
+// 	- Documents are the matching documents.
+type QueryResult struct {
+	Topic     string     `json:"topic"`
+	Documents []Document `json:"documents"`
+}
+
+// Query returns the current documents of a watched collection matching
+// a filter, with the fields selected by the keys and the view of the
+// collection. The
+// values are compared as strings, like the filters of the subscriptions.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the query.
+// 	- topic (string): the name of the collection.
+// 	- filter (map[string]string): the filter, every document when empty.
+// 	- limit (int): the maximal number of documents, DefaultQueryLimit
+// 		when 0, at most MaxQueryLimit.
+//
+// # Example:
+//
+// 	docs, err := s.Query(ctx, "posts", map[string]string{"tenant": "acme"}, 10)
+func (s *Socketeer) Query(ctx context.Context, topic string, filter map[string]string, limit int) ([]Document, error) {
+	if _, ok := s.DB.(querier); !ok {
+		return nil, ErrQueryUnsupported
+	}
+	if lister, ok := s.DB.(collectionLister); ok {
+		watched := false
+		for _, coll := range lister.Collections() {
+			watched = watched || coll == topic
+		}
+		if !watched {
+			return nil, ErrUnknownTopic
+		}
+	}
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	return s.find(ctx, topic, filter, limit)
+}
+
+// find returns up to limit current documents of a collection matching
+// a filter, through the view of the collection, if any: the documents
+// it filters out are skipped after the limit is applied.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the query.
+// 	- topic (string): the name of the collection.
+// 	- filter (map[string]string): the filter, every document when empty.
+// 	- limit (int): the maximal number of documents.
+//
+// # Example:
+//
+// 	docs, err := s.find(ctx, "posts", nil, 100)
+func (s *Socketeer) find(ctx context.Context, topic string, filter map[string]string, limit int) ([]Document, error) {
+	q, ok := s.DB.(querier)
+	if !ok {
+		return nil, ErrQueryUnsupported
+	}
+
+	keys := s.keysFor(topic)
+	view, viewed := s.Views[topic]
+	fields := keys.projection()
+	if viewed {
+		fields = nil
+	}
+	docs, err := q.Find(ctx, topic, s.unaliasFilter(filter), fields, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		d := Document{
+			DocumentKey: s.aliasMap(describe(map[string]any{"_id": doc["_id"]})),
+			Data:        make(map[string]string),
+		}
+		if viewed {
+			ev, ok := view.apply(Event{OperationType: "insert", Collection: topic, Fields: doc})
+			if !ok {
+				continue
+			}
+			doc = ev.Fields
+		}
+		doc = s.transformFields(doc)
+		for key, value := range doc {
+			if keys.match(key) {
+				d.Data[s.alias(key)] = fmt.Sprintf("%v", value)
+			}
+		}
+		result = append(result, d)
+	}
+
+	return result, nil
+}
+
+// projection returns the plain keys of the set, sorted, nil when it
+// has regular expressions as the fields they select aren't known
+// before the documents are read.
+func (k *keySet) projection() []string {
+	if len(k.patterns) > 0 {
+		return nil
+	}
+	fields := make([]string, 0, len(k.exact))
+	for field := range k.exact {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// serveQuery answers the GET requests on QueryPath with a QueryResult:
+// the topic and limit query parameters name the collection and bound
+// the number of documents, and the filter parameter is a JSON filter,
+// like the one of a subscription, which the Scope of the identity of
+// the request restricts.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(QueryPath, http.HandlerFunc(s.serveQuery))
+func (s *Socketeer) serveQuery(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		res.Header().Set("Allow", "GET")
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var identity string
+	if a, ok := s.WS.(authorizer); ok {
+		var err error
+		identity, err = a.Authorize(req)
+		if errors.Is(err, ws.ErrForbidden) {
+			http.Error(res, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(res, ws.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	params := req.URL.Query()
+	topic := params.Get("topic")
+	if topic == "" {
+		http.Error(res, "missing topic", http.StatusBadRequest)
+		return
+	}
+	var limit int
+	if l := params.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			http.Error(res, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	filter := make(map[string]string)
+	if f := params.Get("filter"); f != "" {
+		err := json.Unmarshal([]byte(f), &filter)
+		if err != nil || filter == nil {
+			http.Error(res, "invalid filter", http.StatusBadRequest)
+			return
+		}
+	}
+	if s.Scope != nil {
+		for field, value := range s.Scope(identity, topic) {
+			filter[field] = value
+		}
+	}
+
+	docs, err := s.Query(req.Context(), topic, filter, limit)
+	switch {
+	case errors.Is(err, ErrQueryUnsupported):
+		http.Error(res, err.Error(), http.StatusNotImplemented)
+		return
+	case errors.Is(err, ErrUnknownTopic):
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		s.log.Warn("query failed", "topic", topic, "error", err)
+		http.Error(res, "query failed", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(QueryResult{Topic: topic, Documents: docs})
+}
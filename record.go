@@ -0,0 +1,92 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// RecordedEvent is an event recorded with Record(), replayed
+// by the replay package.
+//
+// 	- Offset is the time since the start of the recording, in
+// 		nanoseconds when encoded in JSON.
+// 	- Event is the recorded event.
+type RecordedEvent struct {
+	Offset time.Duration `json:"offset"`
+	Event
+}
+
+// recorder writes the RecordedEvents to a writer.
+//
+// 	- enc encodes the events, one JSON object per line.
+// 	- start is when the recording started.
+type recorder struct {
+	enc   *json.Encoder
+	start time.Time
+}
+
+// Record writes every event read from the change source to out, one
+// RecordedEvent per line with its offset from the start of the
+// recording, until the returned function is called. Recordings are
+// replayed with the replay package, at the original or at an
+// accelerated speed, to reproduce bugs or load test consumers.
+//
+// Events are written before they are dispatched and are never dropped,
+// so out should be fast, like a buffered file. The values of the fields
+// are recorded as JSON, so types without a JSON equivalent are replayed
+// as their JSON encoding, example: an ObjectID as its hex string.
+//
+// # Parameters:
+//
+// 	- out (io.Writer): where the events are written to, example: a file.
+//
+// # Example:
+//
+// 	f, err := os.Create("events.jsonl")
+// 	stop := s.Record(f)
+// 	defer stop()
+func (s *Socketeer) Record(out io.Writer) func() {
+	r := &recorder{
+		enc:   json.NewEncoder(out),
+		start: time.Now(),
+	}
+
+	s.recordersMux.Lock()
+	if s.recorders == nil {
+		s.recorders = make(map[*recorder]struct{})
+	}
+	s.recorders[r] = struct{}{}
+	s.recordersMux.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.recordersMux.Lock()
+			delete(s.recorders, r)
+			s.recordersMux.Unlock()
+		})
+	}
+}
+
+// record writes an event to every recorder.
+//
+// # Parameters:
+//
+// 	- ev (Event): the event read from the change source.
+//
+// # Example:
+//
+// 	s.record(ev)
+func (s *Socketeer) record(ev Event) {
+	s.recordersMux.Lock()
+	defer s.recordersMux.Unlock()
+
+	for r := range s.recorders {
+		err := r.enc.Encode(RecordedEvent{Offset: time.Since(r.start), Event: ev})
+		if err != nil {
+			s.log.Warn("recording event failed", "collection", ev.Collection, "error", err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package registry provides the session stores of the socketeer,
+// persisting the sessions of its clients so that they survive the
+// restarts of the server and are listed on the admin API.
+//
+// # Usage:
+//
+// 	s.SessionSecret = []byte(os.Getenv("SESSION_SECRET"))
+// 	s.Sessions = registry.NewMongo(client.Database("mydb").Collection("sessions"))
+//
+// The records are kept until they are deleted, a TTL index on the
+// lastSeen field of the collection bounds the history:
+//
+// 	db.sessions.createIndex({lastSeen: 1}, {expireAfterSeconds: 2592000})
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mongo is a session store keeping the sessions in a MongoDB
+// collection, one document per session.
+//
+// 	- coll is the collection.
+type Mongo struct {
+	coll *mongo.Collection
+}
+
+// NewMongo returns a new Mongo store keeping the sessions in coll.
+//
+// # Parameters:
+//
+// 	- coll (*mongo.Collection): the collection.
+//
+// # Example:
+//
+// 	s.Sessions = registry.NewMongo(client.Database("mydb").Collection("sessions"))
+func NewMongo(coll *mongo.Collection) *Mongo {
+	return &Mongo{coll: coll}
+}
+
+// record is a session as stored in the collection, the session
+// is kept as JSON so that its filters are read back unchanged.
+//
+// 	- ID is the session ID, the _id of the document.
+// 	- Identity is the identity of the client, for queries.
+// 	- Online reports whether the client is connected, for queries.
+// 	- LastSeen is when the session was saved, for queries and TTL indexes.
+// 	- Session is the session record as JSON.
+type record struct {
+	ID       string    `bson:"_id"`
+	Identity string    `bson:"identity"`
+	Online   bool      `bson:"online"`
+	LastSeen time.Time `bson:"lastSeen"`
+	Session  string    `bson:"session"`
+}
+
+// Save creates or replaces the record of a session.
+//
+// # Parameters:
+//
+// 	- rec (socketeer.SessionRecord): the record.
+//
+// # Example:
+//
+// 	err := m.Save(rec)
+func (m *Mongo) Save(rec socketeer.SessionRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.coll.ReplaceOne(context.Background(), bson.M{"_id": rec.ID}, record{
+		ID:       rec.ID,
+		Identity: rec.Identity,
+		Online:   rec.Online,
+		LastSeen: rec.LastSeen,
+		Session:  string(data),
+	}, options.Replace().SetUpsert(true))
+
+	return err
+}
+
+// Load returns the record of a session, it reports false when
+// the collection has none.
+//
+// # Parameters:
+//
+// 	- id (string): the session ID.
+//
+// # Example:
+//
+// 	rec, ok, err := m.Load(id)
+func (m *Mongo) Load(id string) (socketeer.SessionRecord, bool, error) {
+	var r record
+	err := m.coll.FindOne(context.Background(), bson.M{"_id": id}).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return socketeer.SessionRecord{}, false, nil
+	}
+	if err != nil {
+		return socketeer.SessionRecord{}, false, err
+	}
+
+	var rec socketeer.SessionRecord
+	err = json.Unmarshal([]byte(r.Session), &rec)
+	if err != nil {
+		return socketeer.SessionRecord{}, false, err
+	}
+
+	return rec, true, nil
+}
+
+// List returns every session of the collection, the most
+// recently seen first.
+//
+// # Example:
+//
+// 	records, err := m.List()
+func (m *Mongo) List() ([]socketeer.SessionRecord, error) {
+	ctx := context.Background()
+	cursor, err := m.coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "lastSeen", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]socketeer.SessionRecord, 0, len(records))
+	for _, r := range records {
+		var rec socketeer.SessionRecord
+		err = json.Unmarshal([]byte(r.Session), &rec)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, rec)
+	}
+
+	return sessions, nil
+}
@@ -0,0 +1,196 @@
+// Package replay provides a ChangeSource replaying the events
+// recorded with the Record() method of a socketeer, so that a
+// production incident can be reproduced or downstream consumers
+// load tested without a MongoDB deployment.
+//
+// Replayed events go through the same dispatch pipeline as the
+// ones read from a change stream, at the original speed of the
+// recording or accelerated.
+//
+// # Usage:
+//
+// 	src, err := replay.Open("events.jsonl", 10) // 10 times faster
+// 	if err != nil {
+// 		log.Fatal(err)
+// 	}
+// 	s := socketeer.NewSocketeerWithSource(src)
+// 	s.Start([]string{"title"}, "localhost:8080", "/listen")
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// Source is a socketeer.ChangeSource replaying a recording.
+//
+// 	- r is the recording, one socketeer.RecordedEvent per line.
+// 	- closer closes the recording, nil if it is not owned by the Source.
+// 	- speed is the factor the recording is accelerated by,
+// 		0 replays the events without waiting.
+// 	- since is the cluster time of the first event replayed, every
+// 		event is replayed when zero. See StartAt().
+// 	- done is closed when the Source is disconnected.
+// 	- doneOnce guards the closing of done.
+type Source struct {
+	r        io.Reader
+	closer   io.Closer
+	speed    float64
+	since    time.Time
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// New returns a new Source replaying the recording read from r.
+//
+// # Parameters:
+//
+// 	- r (io.Reader): the recording, one socketeer.RecordedEvent per line.
+// 	- speed (float64): the factor the recording is accelerated by, 1 for
+// 		the original speed, 0 to replay the events without waiting.
+//
+// # Example:
+//
+// 	src := replay.New(f, 1)
+func New(r io.Reader, speed float64) *Source {
+	return &Source{
+		r:     r,
+		speed: speed,
+		done:  make(chan struct{}),
+	}
+}
+
+// Open returns a new Source replaying the recording file at path,
+// the file is closed when the Source is disconnected.
+//
+// # Parameters:
+//
+// 	- path (string): the path of the recording file.
+// 	- speed (float64): the factor the recording is accelerated by.
+//
+// # Example:
+//
+// 	src, err := replay.Open("events.jsonl", 10)
+func Open(path string, speed float64) (*Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	src := New(f, speed)
+	src.closer = f
+
+	return src, nil
+}
+
+// Listen calls handle for every recorded event, waiting between
+// two events for the time elapsed between them in the recording
+// divided by the speed. It returns once the recording is over or
+// the Source is disconnected, as required by socketeer.ChangeSource.
+// The events before the time given to StartAt() are skipped, and the
+// waits are counted from the first event replayed.
+//
+// # Parameters:
+//
+// 	- handle (func(socketeer.Event) error): the function called for every event.
+//
+// # Example:
+//
+// 	err := src.Listen(func(ev socketeer.Event) error { return nil })
+func (s *Source) Listen(handle func(socketeer.Event) error) error {
+	dec := json.NewDecoder(bufio.NewReader(s.r))
+	dec.UseNumber()
+	start := time.Now()
+	var skipped time.Duration
+
+	for {
+		var rec socketeer.RecordedEvent
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if s.skips(rec.Event) {
+			skipped = rec.Offset
+			continue
+		}
+
+		if s.speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Offset-skipped) / s.speed))
+			timer := time.NewTimer(time.Until(due))
+			select {
+			case <-timer.C:
+			case <-s.done:
+				timer.Stop()
+				return nil
+			}
+		}
+
+		select {
+		case <-s.done:
+			return nil
+		default:
+		}
+
+		err = handle(rec.Event)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// StartAt skips the recorded events whose cluster time is before t,
+// the events without cluster time are replayed. It has to be called
+// before Listen().
+//
+// # Parameters:
+//
+// 	- t (time.Time): the cluster time of the first event, to the second.
+//
+// # Example:
+//
+// 	src.StartAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+func (s *Source) StartAt(t time.Time) {
+	s.since = t
+}
+
+// skips reports whether an event is before the time given to StartAt().
+func (s *Source) skips(ev socketeer.Event) bool {
+	if s.since.IsZero() || ev.ClusterTime.IsZero() {
+		return false
+	}
+
+	return int64(ev.ClusterTime.T) < s.since.Unix()
+}
+
+// Disconnect stops Listen and closes the recording file
+// opened by Open().
+//
+// # Example:
+//
+// 	src.Disconnect()
+func (s *Source) Disconnect() error {
+	var err error
+	s.doneOnce.Do(func() {
+		close(s.done)
+		if s.closer != nil {
+			err = s.closer.Close()
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,124 @@
+package socketeer
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// DefaultErrorsBuffer is the number of failures queued on Errors().
+const DefaultErrorsBuffer = 64
+
+// failer is implemented by the broadcasters which report the failure
+// of their server, like the default WebSocket server.
+type failer interface {
+	Err() error
+}
+
+// failed reports whether the server of a broadcaster failed, a server
+// started once the socketeer was stopped didn't fail.
+func failed(f failer) bool {
+	err := f.Err()
+
+	return err != nil && !errors.Is(err, ws.ErrStopped)
+}
+
+// drainer is implemented by the broadcasters which drain their clients
+// until a deadline when they are stopped, like the default WebSocket
+// server.
+type drainer interface {
+	StopBy(deadline time.Time)
+}
+
+// Reporter is notified of the unexpected failures of the socketeer,
+// like a failing change stream, a failed upgrade, a message which
+// can't be encoded or a recovered panic, so that they can be sent to an error tracker
+// like Sentry or Rollbar without a dependency on its SDK.
+//
+// The context describes the failure, example:
+// {"component": "ws", "connID": "8f2c1e0a9b7d6c5e"}
+//
+// # Example:
+//
+// 	type sentryReporter struct{}
+//
+// 	func (sentryReporter) Report(err error, ctx map[string]any) {
+// 		sentry.WithScope(func(scope *sentry.Scope) {
+// 			scope.SetContext("socketeer", ctx)
+// 			sentry.CaptureException(err)
+// 		})
+// 	}
+//
+// 	s.Reporter = sentryReporter{}
+type Reporter interface {
+	Report(err error, ctx map[string]any)
+}
+
+// report counts an unexpected failure and reports it to the Reporter, if any.
+//
+// # Parameters:
+//
+// 	- err (error): the failure.
+// 	- ctx (map[string]any): the context of the failure.
+//
+// # Example:
+//
+// 	s.report(err, map[string]any{"component": "source"})
+func (s *Socketeer) report(err error, ctx map[string]any) {
+	s.errors.Add(1)
+	if s.Reporter != nil {
+		s.Reporter.Report(err, ctx)
+	}
+	s.Errors()
+	select {
+	case s.errCh <- err:
+	default:
+	}
+}
+
+// Errors returns the channel the unexpected failures of the socketeer
+// are sent on, the ones reported to the Reporter, like a change which
+// can't be decoded, a failed upgrade or a sink failing, so that the
+// application decides which ones are fatal. The failures sent while
+// DefaultErrorsBuffer are queued are dropped, so that an application
+// which doesn't receive them isn't blocked. The channel isn't closed.
+//
+// # Example:
+//
+// 	go func() {
+// 		for err := range s.Errors() {
+// 			log.Println("socketeer:", err)
+// 		}
+// 	}()
+func (s *Socketeer) Errors() <-chan error {
+	s.errChOnce.Do(func() {
+		s.errCh = make(chan error, DefaultErrorsBuffer)
+	})
+
+	return s.errCh
+}
+
+// recoverPanic recovers from a panic of the calling goroutine, logs
+// it with its stack and reports it, so that a failing event or hook
+// doesn't crash the process. It has to be deferred.
+//
+// # Parameters:
+//
+// 	- ctx (map[string]any): the context of the failure.
+//
+// # Example:
+//
+// 	defer s.recoverPanic(map[string]any{"component": "pipeline"})
+func (s *Socketeer) recoverPanic(ctx map[string]any) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err := fmt.Errorf("panic: %v", r)
+	s.log.Error("panic recovered", "error", err, "stack", string(debug.Stack()))
+	s.report(err, ctx)
+}
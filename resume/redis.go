@@ -0,0 +1,213 @@
+package resume
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redis is a store keeping the resume token in a key of a Redis server,
+// shared by the instances which take over one another. It speaks the
+// RESP protocol over a single connection, dialed again after an error.
+//
+// 	- Password authenticates the connection when set.
+// 	- DB is the number of the database selected, 0 by default.
+// 	- addr is the address of the server, example: "localhost:6379".
+// 	- key is the key of the token.
+// 	- mux serializes the commands on the connection.
+// 	- conn and r are the connection and its reader, nil until the
+// 		first command or after an error.
+type Redis struct {
+	Password string
+	DB       int
+	addr     string
+	key      string
+	mux      sync.Mutex
+	conn     net.Conn
+	r        *bufio.Reader
+}
+
+// NewRedis returns a new Redis store keeping the token in key, the
+// server is dialed by the first command.
+//
+// # Parameters:
+//
+// 	- addr (string): the address of the server.
+// 	- key (string): the key of the token.
+//
+// # Example:
+//
+// 	store := resume.NewRedis("localhost:6379", "socketeer:orders:token")
+func NewRedis(addr string, key string) *Redis {
+	return &Redis{addr: addr, key: key}
+}
+
+// Close closes the connection to the server.
+//
+// # Example:
+//
+// 	defer store.Close()
+func (r *Redis) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn, r.r = nil, nil
+
+	return err
+}
+
+// Load returns the token of the key, nil when it doesn't exist.
+//
+// # Example:
+//
+// 	token, err := store.Load()
+func (r *Redis) Load() ([]byte, error) {
+	reply, err := r.do("GET", r.key)
+	if err != nil {
+		return nil, err
+	}
+	token, _ := reply.([]byte)
+
+	return token, nil
+}
+
+// Save replaces the token of the key.
+//
+// # Parameters:
+//
+// 	- token ([]byte): the resume token.
+//
+// # Example:
+//
+// 	err := store.Save(token)
+func (r *Redis) Save(token []byte) error {
+	_, err := r.do("SET", r.key, string(token))
+
+	return err
+}
+
+// do sends a command and returns its reply: a string for the simple
+// strings, an int64 for the integers, a []byte for the bulk strings and
+// nil for the null ones. The connection is closed on an I/O error, so
+// that the next command dials the server again.
+//
+// # Parameters:
+//
+// 	- args (...string): the command and its arguments.
+//
+// # Example:
+//
+// 	reply, err := r.do("GET", r.key)
+func (r *Redis) do(args ...string) (any, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.conn == nil {
+		err := r.dial()
+		if err != nil {
+			return nil, err
+		}
+	}
+	reply, err := r.roundTrip(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		r.conn.Close()
+		r.conn, r.r = nil, nil
+	}
+
+	return reply, err
+}
+
+// dial connects to the server, then authenticates and selects the DB
+// when set.
+func (r *Redis) dial() error {
+	conn, err := net.DialTimeout("tcp", r.addr, DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	r.conn, r.r = conn, bufio.NewReader(conn)
+
+	if r.Password != "" {
+		_, err = r.roundTrip("AUTH", r.Password)
+	}
+	if err == nil && r.DB != 0 {
+		_, err = r.roundTrip("SELECT", strconv.Itoa(r.DB))
+	}
+	if err != nil {
+		conn.Close()
+		r.conn, r.r = nil, nil
+		return err
+	}
+
+	return nil
+}
+
+// roundTrip writes a command on the connection and reads its reply.
+func (r *Redis) roundTrip(args ...string) (any, error) {
+	r.conn.SetDeadline(time.Now().Add(DefaultTimeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(r.conn, b.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return r.readReply()
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "resume: redis: " + string(e)
+}
+
+// readReply reads a reply of the server, an error reply being returned
+// as a redisError.
+func (r *Redis) readReply() (any, error) {
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("resume: redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(r.r, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:n], nil
+	}
+
+	return nil, fmt.Errorf("resume: redis: unexpected reply %q", line)
+}
@@ -0,0 +1,309 @@
+package resume
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is a Redis server keeping the keys in memory, recording the
+// commands it receives.
+//
+// 	- ln is the listener of the server.
+// 	- mux guards the fields below.
+// 	- keys are the values by key.
+// 	- commands are the commands received, in order.
+// 	- conns is the number of connections accepted.
+// 	- drop closes the connection instead of answering the next command.
+type fakeRedis struct {
+	ln       net.Listener
+	mux      sync.Mutex
+	keys     map[string]string
+	commands [][]string
+	conns    int
+	drop     bool
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, keys: make(map[string]string)}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+
+	return f
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mux.Lock()
+		f.conns++
+		f.mux.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		f.mux.Lock()
+		f.commands = append(f.commands, args)
+		if f.drop {
+			f.drop = false
+			f.mux.Unlock()
+			return
+		}
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			reply = "+OK\r\n"
+			if args[1] != "secret" {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case "SELECT":
+			reply = "+OK\r\n"
+		case "GET":
+			value, ok := f.keys[args[1]]
+			reply = "$-1\r\n"
+			if ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+			}
+		case "SET":
+			f.keys[args[1]] = args[2]
+			reply = "+OK\r\n"
+		default:
+			reply = "-ERR unknown command '" + args[0] + "'\r\n"
+		}
+		f.mux.Unlock()
+		io.WriteString(conn, reply)
+	}
+}
+
+// readCommand reads a command, an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("not an array: %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("not a bulk string: %q", line)
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		if string(buf[size:]) != "\r\n" {
+			return nil, fmt.Errorf("bulk string of %d bytes not terminated", size)
+		}
+		args[i] = string(buf[:size])
+	}
+
+	return args, nil
+}
+
+func (f *fakeRedis) received() ([][]string, int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return append([][]string(nil), f.commands...), f.conns
+}
+
+func TestRedisSaveLoad(t *testing.T) {
+	f := newFakeRedis(t)
+	store := NewRedis(f.ln.Addr().String(), "socketeer:orders")
+	defer store.Close()
+
+	token, err := store.Load()
+	if err != nil || token != nil {
+		t.Fatalf("Load() of a missing key = %q, %v, want nil", token, err)
+	}
+	saved := []byte("\x1a\x00\x00\x00\x02_data\x00\r\n\x00\x00\x00tok\r\n\x00\x00")
+	err = store.Save(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = store.Load()
+	if err != nil || string(token) != string(saved) {
+		t.Errorf("Load() = %q, %v, want %q", token, err, saved)
+	}
+
+	commands, conns := f.received()
+	want := [][]string{{"GET", "socketeer:orders"}, {"SET", "socketeer:orders", string(saved)}, {"GET", "socketeer:orders"}}
+	if !reflect.DeepEqual(commands, want) || conns != 1 {
+		t.Errorf("commands = %q on %d connections, want %q on 1", commands, conns, want)
+	}
+}
+
+func TestRedisAuthSelect(t *testing.T) {
+	f := newFakeRedis(t)
+	store := NewRedis(f.ln.Addr().String(), "token")
+	store.Password = "secret"
+	store.DB = 3
+	defer store.Close()
+
+	_, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands, _ := f.received()
+	want := [][]string{{"AUTH", "secret"}, {"SELECT", "3"}, {"GET", "token"}}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+
+	wrong := NewRedis(f.ln.Addr().String(), "token")
+	wrong.Password = "guess"
+	_, err = wrong.Load()
+	var replyErr redisError
+	if !errors.As(err, &replyErr) || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Load() with a wrong password = %v, want a WRONGPASS reply", err)
+	}
+	if wrong.conn != nil {
+		t.Errorf("connection kept after a failed AUTH")
+	}
+}
+
+func TestRedisErrorReplyKeepsConnection(t *testing.T) {
+	f := newFakeRedis(t)
+	store := NewRedis(f.ln.Addr().String(), "token")
+	defer store.Close()
+
+	_, err := store.do("NOPE")
+	var replyErr redisError
+	if !errors.As(err, &replyErr) || err.Error() != "resume: redis: ERR unknown command 'NOPE'" {
+		t.Fatalf("do() = %v, want the error reply", err)
+	}
+	_, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, conns := f.received(); conns != 1 {
+		t.Errorf("%d connections, want 1", conns)
+	}
+}
+
+func TestRedisRedialAfterIOError(t *testing.T) {
+	f := newFakeRedis(t)
+	store := NewRedis(f.ln.Addr().String(), "token")
+	defer store.Close()
+
+	err := store.Save([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mux.Lock()
+	f.drop = true
+	f.mux.Unlock()
+	_, err = store.Load()
+	if err == nil {
+		t.Fatal("Load() on a dropped connection succeeded")
+	}
+	token, err := store.Load()
+	if err != nil || string(token) != "a" {
+		t.Errorf("Load() after a redial = %q, %v, want \"a\"", token, err)
+	}
+	if _, conns := f.received(); conns != 2 {
+		t.Errorf("%d connections, want 2", conns)
+	}
+}
+
+func TestRoundTripEncoding(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	store := &Redis{conn: client, r: bufio.NewReader(client)}
+
+	written := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := io.ReadAtLeast(server, buf, len("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\n"))
+		written <- string(buf[:n])
+		io.WriteString(server, ":7\r\n")
+	}()
+	reply, err := store.roundTrip("SET", "k", "a\r\nb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-written; got != "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\n" {
+		t.Errorf("command written as %q", got)
+	}
+	if reply != int64(7) {
+		t.Errorf("reply = %#v, want int64(7)", reply)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		reply any
+		err   string
+	}{
+		{"simple string", "+OK\r\n", "OK", ""},
+		{"integer", ":-12\r\n", int64(-12), ""},
+		{"bulk string", "$5\r\nab\r\nc\r\n", []byte("ab\r\nc"), ""},
+		{"empty bulk string", "$0\r\n\r\n", []byte{}, ""},
+		{"null bulk string", "$-1\r\n", nil, ""},
+		{"error", "-ERR wrong type\r\n", nil, "resume: redis: ERR wrong type"},
+		{"empty reply", "\r\n", nil, "resume: redis: empty reply"},
+		{"array", "*1\r\n$1\r\na\r\n", nil, `resume: redis: unexpected reply "*1"`},
+		{"bad integer", ":x\r\n", int64(0), `strconv.ParseInt: parsing "x": invalid syntax`},
+		{"short bulk string", "$5\r\nab", nil, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Redis{r: bufio.NewReader(strings.NewReader(tt.input))}
+			reply, err := r.readReply()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("readReply() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reply, tt.reply) {
+				t.Errorf("readReply() = %#v, want %#v", reply, tt.reply)
+			}
+		})
+	}
+}
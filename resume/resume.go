@@ -0,0 +1,228 @@
+// Package resume provides the stores of the resume token of the change
+// stream of the socketeer, so that a restarted socketeer resumes after
+// the last change it handled instead of missing the ones made while it
+// was down.
+//
+// # Usage:
+//
+// 	s.Resume = resume.NewFile("/var/lib/socketeer/orders.token")
+//
+// The changes are delivered at least once: the token is saved every
+// second, the changes handled since the last save are dispatched again
+// after a crash. The token must still be in the oplog window when the
+// socketeer restarts, otherwise the change stream fails to resume.
+package resume
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultTimeout bounds every round trip to the database.
+const DefaultTimeout = 5 * time.Second
+
+// ErrEmptyFile is returned by File.Load() for an empty file, which
+// Save() never leaves: the file was truncated or made by hand.
+var ErrEmptyFile = errors.New("resume: empty token file")
+
+// File is a store keeping the resume token in a local file, for a
+// single instance with a persistent volume.
+//
+// 	- path is the path of the file.
+type File struct {
+	path string
+}
+
+// NewFile returns a new File store keeping the token in the file at
+// path, created by the first save.
+//
+// # Parameters:
+//
+// 	- path (string): the path of the file.
+//
+// # Example:
+//
+// 	store := resume.NewFile("/var/lib/socketeer/orders.token")
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Load returns the token of the file, nil when it doesn't exist, it
+// fails with ErrEmptyFile when the file is empty.
+//
+// # Example:
+//
+// 	token, err := store.Load()
+func (f *File) Load() ([]byte, error) {
+	token, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(token) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyFile, f.path)
+	}
+
+	return token, nil
+}
+
+// Save replaces the token of the file, through a temporary file renamed
+// over it so that a crash never leaves a partial token.
+//
+// # Parameters:
+//
+// 	- token ([]byte): the resume token.
+//
+// # Example:
+//
+// 	err := store.Save(token)
+func (f *File) Save(token []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(token)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Mongo is a store keeping the resume token in a document of a MongoDB
+// collection, shared by the instances which take over one another.
+//
+// 	- coll is the collection.
+// 	- name is the _id of the document, several socketeers watching
+// 		different collections keep their own token.
+// 	- client is the client connected by Connect(), disconnected by
+// 		Close(), nil for a collection of the application.
+type Mongo struct {
+	coll   *mongo.Collection
+	name   string
+	client *mongo.Client
+}
+
+// record is the document of a token.
+//
+// 	- Token is the resume token.
+// 	- Updated is when it was saved.
+type record struct {
+	Token   bson.Raw  `bson:"token"`
+	Updated time.Time `bson:"updated"`
+}
+
+// NewMongo returns a new Mongo store keeping the token name in coll.
+//
+// # Parameters:
+//
+// 	- coll (*mongo.Collection): the collection.
+// 	- name (string): the name of the token.
+//
+// # Example:
+//
+// 	store := resume.NewMongo(client.Database("mydb").Collection("resumeTokens"), "orders")
+func NewMongo(coll *mongo.Collection, name string) *Mongo {
+	return &Mongo{coll: coll, name: name}
+}
+
+// Connect returns a new Mongo store keeping the token name in the
+// collection collName, the client is disconnected by Close().
+//
+// # Parameters:
+//
+// 	- uriString (string): the MongoDB connection string.
+// 	- dbName (string): the MongoDB database name.
+// 	- collName (string): the name of the collection of the tokens.
+// 	- name (string): the name of the token.
+//
+// # Example:
+//
+// 	store, err := resume.Connect("mongodb://localhost:27017", "mydb", "resumeTokens", "orders")
+func Connect(uriString string, dbName string, collName string, name string) (*Mongo, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uriString))
+	if err != nil {
+		return nil, err
+	}
+	err = client.Ping(context.Background(), nil)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	m := NewMongo(client.Database(dbName).Collection(collName), name)
+	m.client = client
+
+	return m, nil
+}
+
+// Close disconnects the client connected by Connect(), the client of
+// the application is left connected.
+//
+// # Example:
+//
+// 	defer store.Close()
+func (m *Mongo) Close() error {
+	if m.client == nil {
+		return nil
+	}
+
+	return m.client.Disconnect(context.Background())
+}
+
+// Load returns the token of the document, nil when it doesn't exist.
+//
+// # Example:
+//
+// 	token, err := store.Load()
+func (m *Mongo) Load() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	var r record
+	err := m.coll.FindOne(ctx, bson.D{{Key: "_id", Value: m.name}}).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Token, nil
+}
+
+// Save replaces the token of the document, creating it if needed.
+//
+// # Parameters:
+//
+// 	- token ([]byte): the resume token.
+//
+// # Example:
+//
+// 	err := store.Save(token)
+func (m *Mongo) Save(token []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	update := bson.D{{Key: "$set", Value: record{Token: token, Updated: time.Now()}}}
+	_, err := m.coll.UpdateOne(ctx, bson.D{{Key: "_id", Value: m.name}}, update, options.Update().SetUpsert(true))
+
+	return err
+}
@@ -0,0 +1,44 @@
+package resume
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLoad(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFile(filepath.Join(dir, "orders.token"))
+
+	token, err := store.Load()
+	if err != nil || token != nil {
+		t.Fatalf("Load() of a missing file = %q, %v, want nil", token, err)
+	}
+	err = store.Save([]byte("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = store.Load()
+	if err != nil || string(token) != "token" {
+		t.Errorf("Load() = %q, %v, want \"token\"", token, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("%d files left by Save(), want 1", len(entries))
+	}
+
+	err = os.WriteFile(store.path, nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Load()
+	if !errors.Is(err, ErrEmptyFile) {
+		t.Errorf("Load() of an empty file = %v, want ErrEmptyFile", err)
+	}
+
+	_, err = NewFile(dir).Load()
+	if err == nil {
+		t.Errorf("Load() of a directory succeeded")
+	}
+}
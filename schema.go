@@ -0,0 +1,200 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// SchemaPath is the path the JSON Schema of the outgoing messages is served on.
+const SchemaPath = "/.well-known/socketeer-schema"
+
+// router is implemented by the broadcasters serving additional
+// http routes, like the default WebSocket server.
+type router interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// collectionLister is implemented by the change sources which
+// know the collections they watch ahead of time.
+type collectionLister interface {
+	Collections() []string
+}
+
+// Schema returns a JSON Schema (draft 2020-12) describing the
+// messages of every protocol version, with the set of fields
+// dispatched for every watched collection.
+//
+// It is served on SchemaPath once the socketeer is started,
+// so consumers can validate messages and generate bindings.
+//
+// # Example:
+//
+// 	schema := s.Schema()
+func (s *Socketeer) Schema() map[string]any {
+	var collections []string
+	if lister, ok := s.DB.(collectionLister); ok {
+		collections = lister.Collections()
+	}
+
+	s.keysMux.RLock()
+	defaults, perKeys := s.keys, s.Keys
+	s.keysMux.RUnlock()
+
+	for coll := range perKeys {
+		listed := false
+		for _, c := range collections {
+			listed = listed || c == coll
+		}
+		if !listed {
+			collections = append(collections, coll)
+		}
+	}
+	sort.Strings(collections)
+
+	data := dataSchema(s.aliasKeys(defaults))
+	if len(perKeys) > 0 {
+		data = map[string]any{
+			"type":                 "object",
+			"additionalProperties": map[string]any{"type": "string"},
+		}
+	}
+
+	topic := map[string]any{"type": "string"}
+	perCollection := make(map[string]any, len(collections))
+	for _, coll := range collections {
+		if keys, ok := perKeys[coll]; ok {
+			perCollection[coll] = dataSchema(s.aliasKeys(keys))
+		} else {
+			perCollection[coll] = map[string]any{"$ref": "#/$defs/data"}
+		}
+	}
+	if len(collections) > 0 {
+		topic = map[string]any{"enum": collections}
+	}
+
+	defs := map[string]any{
+		"data": data,
+		"v1": map[string]any{
+			"$ref": "#/$defs/data",
+		},
+		"v2": map[string]any{
+			"type":     "object",
+			"required": []string{"v", "type"},
+			"properties": map[string]any{
+				"v":     map[string]any{"const": 2},
+				"type":  map[string]any{"type": "string"},
+				"seq":   map[string]any{"type": "integer", "minimum": 1},
+				"topic": topic,
+				"op":    map[string]any{"type": "string"},
+				"clusterTime": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"t": map[string]any{"type": "integer"},
+						"i": map[string]any{"type": "integer"},
+					},
+				},
+				"ts":   map[string]any{"type": "string", "format": "date-time"},
+				"data": map[string]any{"$ref": "#/$defs/data"},
+				"documentKey": map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]any{"type": "string"},
+				},
+				"fullDocument": map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]any{"type": "string"},
+				},
+				"patch":     map[string]any{"type": "object"},
+				"rawChange": map[string]any{"type": "object"},
+				"arrayChanges": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type":     "object",
+						"required": []string{"field", "index", "action"},
+						"properties": map[string]any{
+							"field":  map[string]any{"type": "string"},
+							"index":  map[string]any{"type": "integer", "minimum": 0},
+							"path":   map[string]any{"type": "string"},
+							"action": map[string]any{"enum": []string{ArraySet, ArrayTruncate}},
+							"value":  map[string]any{"type": "string"},
+						},
+					},
+				},
+			},
+		},
+		"collections": perCollection,
+	}
+
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     SchemaPath,
+		"title":   "socketeer message",
+		"oneOf": []any{
+			map[string]any{"$ref": "#/$defs/v1"},
+			map[string]any{"$ref": "#/$defs/v2"},
+		},
+		"$defs": defs,
+	}
+}
+
+// dataSchema returns the JSON Schema of the data of the messages
+// carrying the given keys.
+//
+// # Parameters:
+//
+// 	- keys ([]string): the selected keys.
+//
+// # Example:
+//
+// 	data := dataSchema(s.keys)
+func dataSchema(keys []string) map[string]any {
+	fields := make(map[string]any, len(keys))
+	patterns := make(map[string]any)
+	for _, key := range keys {
+		if isPattern(key) {
+			patterns[key] = map[string]any{"type": "string"}
+			continue
+		}
+		fields[key] = map[string]any{"type": "string"}
+	}
+
+	data := map[string]any{
+		"type":                 "object",
+		"properties":           fields,
+		"additionalProperties": false,
+	}
+	if len(patterns) > 0 {
+		data["patternProperties"] = patterns
+	}
+
+	return data
+}
+
+// serveSchema serves the JSON Schema of the outgoing messages.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(SchemaPath, http.HandlerFunc(s.serveSchema))
+func (s *Socketeer) serveSchema(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(res).Encode(s.Schema())
+}
+
+// aliasKeys returns the keys renamed to their payload name, see Aliases.
+func (s *Socketeer) aliasKeys(keys []string) []string {
+	if len(s.Aliases) == 0 {
+		return keys
+	}
+
+	aliased := make([]string, len(keys))
+	for i, key := range keys {
+		aliased[i] = s.alias(key)
+	}
+
+	return aliased
+}
@@ -0,0 +1,244 @@
+// Package schemaregistry is a client for Confluent-compatible
+// schema registries, used by the binary encodings of the socketeer
+// to register the schemas of their messages and to embed the
+// schema IDs in the encoded messages.
+//
+// This package is used in the following way:
+//
+// 	1. Create a new Client type with New().
+// 	2. Register the schema of a topic with Register().
+// 	3. Prefix every encoded message with the schema ID with Frame().
+// 	4. Consumers split frames with Parse() and resolve IDs with Schema().
+//
+// Registered schemas and resolved IDs are cached, so the
+// registry is only queried once per schema.
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Schema types understood by the registry.
+const (
+	Avro     = "AVRO"
+	Protobuf = "PROTOBUF"
+	JSON     = "JSON"
+)
+
+// magicByte is the first byte of every framed message.
+const magicByte = 0
+
+// ErrInvalidFrame is returned by Parse for messages which
+// are not in the wire format of the registry.
+var ErrInvalidFrame = errors.New("schemaregistry: invalid frame")
+
+// Client is a client of a schema registry.
+//
+// 	- baseURL is the url of the registry.
+// 	- HTTPClient is the http client used for the requests.
+// 	- Username and Password are sent with basic authentication when set.
+// 	- ids caches the IDs of the registered schemas by subject and schema.
+// 	- schemas caches the resolved schemas by ID.
+// 	- cacheMux is a mutex for the caches for thread safety.
+type Client struct {
+	baseURL    string
+	HTTPClient *http.Client
+	Username   string
+	Password   string
+	ids        map[string]int
+	schemas    map[int]string
+	cacheMux   sync.Mutex
+}
+
+// New returns a new Client for the registry at baseURL.
+//
+// # Parameters:
+//
+// 	- baseURL (string): the url of the registry, example: http://localhost:8081
+//
+// # Example:
+//
+// 	registry := schemaregistry.New("http://localhost:8081")
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		ids:        make(map[string]int),
+		schemas:    make(map[int]string),
+	}
+}
+
+// Subject returns the subject of the values of a topic with
+// the default topic name strategy of the registry.
+//
+// # Example:
+//
+// 	schemaregistry.Subject("posts") // "posts-value"
+func Subject(topic string) string {
+	return topic + "-value"
+}
+
+// Register registers a schema under a subject, or looks up its ID
+// when it is already registered, and returns the schema ID.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): the context of the request.
+// 	- subject (string): the subject, example: posts-value
+// 	- schemaType (string): the type of the schema, Avro, Protobuf or JSON.
+// 	- schema (string): the schema definition.
+//
+// # Example:
+//
+// 	id, err := registry.Register(ctx, schemaregistry.Subject("posts"), schemaregistry.Avro, schema)
+func (c *Client) Register(ctx context.Context, subject string, schemaType string, schema string) (int, error) {
+	key := subject + "\x00" + schemaType + "\x00" + schema
+
+	c.cacheMux.Lock()
+	id, ok := c.ids[key]
+	c.cacheMux.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	body := map[string]string{"schema": schema}
+	if schemaType != Avro {
+		body["schemaType"] = schemaType
+	}
+
+	var res struct {
+		ID int `json:"id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", body, &res)
+	if err != nil {
+		return 0, err
+	}
+
+	c.cacheMux.Lock()
+	c.ids[key] = res.ID
+	c.schemas[res.ID] = schema
+	c.cacheMux.Unlock()
+
+	return res.ID, nil
+}
+
+// Schema resolves a schema ID to its definition.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): the context of the request.
+// 	- id (int): the schema ID.
+//
+// # Example:
+//
+// 	schema, err := registry.Schema(ctx, id)
+func (c *Client) Schema(ctx context.Context, id int) (string, error) {
+	c.cacheMux.Lock()
+	schema, ok := c.schemas[id]
+	c.cacheMux.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	var res struct {
+		Schema string `json:"schema"`
+	}
+	err := c.do(ctx, http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &res)
+	if err != nil {
+		return "", err
+	}
+
+	c.cacheMux.Lock()
+	c.schemas[id] = res.Schema
+	c.cacheMux.Unlock()
+
+	return res.Schema, nil
+}
+
+// Frame prefixes an encoded message with the magic byte and the
+// big-endian schema ID, as expected by the registry-aware consumers.
+//
+// # Parameters:
+//
+// 	- id (int): the schema ID.
+// 	- payload ([]byte): the encoded message.
+//
+// # Example:
+//
+// 	msg := schemaregistry.Frame(id, payload)
+func Frame(id int, payload []byte) []byte {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = magicByte
+	binary.BigEndian.PutUint32(frame[1:], uint32(id))
+
+	return append(frame, payload...)
+}
+
+// Parse splits a framed message into its schema ID and payload.
+//
+// # Parameters:
+//
+// 	- frame ([]byte): the framed message.
+//
+// # Example:
+//
+// 	id, payload, err := schemaregistry.Parse(msg)
+func Parse(frame []byte) (int, []byte, error) {
+	if len(frame) < 5 || frame[0] != magicByte {
+		return 0, nil, ErrInvalidFrame
+	}
+
+	return int(binary.BigEndian.Uint32(frame[1:5])), frame[5:], nil
+}
+
+// do sends a request to the registry and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method string, path string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		var registryErr struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		json.NewDecoder(res.Body).Decode(&registryErr)
+		return fmt.Errorf("schemaregistry: %s %s: %d %s", method, path, registryErr.ErrorCode, registryErr.Message)
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
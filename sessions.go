@@ -0,0 +1,97 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// AdminSessionsPath is the path the sessions kept by the Sessions
+// store are listed on, with a GET behind the AdminToken, optionally
+// restricted to an identity with ?identity=<identity>.
+const AdminSessionsPath = "/admin/sessions"
+
+// SessionRecord is the record of the session of a client kept by
+// a SessionStore: its identity, subscriptions, cursor, and when it
+// connected and was last seen.
+type SessionRecord = ws.SessionRecord
+
+// SessionStore persists the sessions of the clients, so that they
+// survive the restarts of the server, the registry package provides
+// a MongoDB implementation.
+//
+// 	- Save creates or replaces the record of a session.
+// 	- Load returns the record of a session, it reports false when
+// 		there is none.
+// 	- List returns every record, the most recently seen first.
+type SessionStore = ws.SessionStore
+
+// SessionHistory returns the sessions kept by the Sessions store,
+// the most recently seen first, the ones of an identity only when
+// identity isn't empty.
+//
+// # Parameters:
+//
+// 	- identity (string): the identity, empty for every session.
+//
+// # Example:
+//
+// 	sessions, err := s.SessionHistory("alice")
+func (s *Socketeer) SessionHistory(identity string) ([]SessionRecord, error) {
+	if s.Sessions == nil {
+		return nil, ErrUnsupported
+	}
+
+	records, err := s.Sessions.List()
+	if err != nil || identity == "" {
+		return records, err
+	}
+
+	filtered := make([]SessionRecord, 0, len(records))
+	for _, rec := range records {
+		if rec.Identity == identity {
+			filtered = append(filtered, rec)
+		}
+	}
+
+	return filtered, nil
+}
+
+// serveSessions answers the GET requests on AdminSessionsPath
+// with the sessions kept by the Sessions store.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(AdminSessionsPath, http.HandlerFunc(s.serveSessions))
+func (s *Socketeer) serveSessions(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodGet {
+		res.Header().Set("Allow", "GET")
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	records, err := s.SessionHistory(req.URL.Query().Get("identity"))
+	if err == ErrUnsupported {
+		http.Error(res, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(records)
+}
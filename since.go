@@ -0,0 +1,29 @@
+package socketeer
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSinceUnsupported is returned by Start() and Validate() when Since
+// is set and the change source can't start at an operation time.
+var ErrSinceUnsupported = errors.New("socketeer: the change source can't start at an operation time")
+
+// starter is implemented by the change sources which can start at an
+// operation time in the past, like the default DB and the replayed
+// recordings.
+type starter interface {
+	StartAt(t time.Time)
+}
+
+// checkSince checks that the change source supports Since, if set.
+func (s *Socketeer) checkSince() error {
+	if s.Since.IsZero() {
+		return nil
+	}
+	if _, ok := s.DB.(starter); !ok {
+		return ErrSinceUnsupported
+	}
+
+	return nil
+}
@@ -0,0 +1,470 @@
+package socketeer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/metrics"
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// Defaults of the delivery to the sinks, see RetryPolicy.
+//
+// 	- DefaultSinkAttempts is the number of attempts to deliver
+// 		a message to a sink before it is dead lettered.
+// 	- DefaultSinkBackoff is the wait before the second attempt,
+// 		doubled before every following one.
+// 	- DefaultSinkBuffer is the number of messages queued per sink.
+const (
+	DefaultSinkAttempts = 3
+	DefaultSinkBackoff  = 100 * time.Millisecond
+	DefaultSinkBuffer   = 1024
+)
+
+// ErrSinkOverflow is the failure of the messages dead lettered because
+// the queue of their sink was full.
+var ErrSinkOverflow = errors.New("socketeer: sink queue full")
+
+// ErrInvalidRetryPolicy is returned by Start() and Validate() for a
+// sink whose RetryPolicy has a Jitter outside of [0, 1].
+var ErrInvalidRetryPolicy = errors.New("socketeer: invalid retry policy")
+
+// Sink is a destination the dispatched messages are delivered to,
+// besides the websocket clients, like a webhook or a message broker.
+//
+// 	- Name identifies the sink in the metrics and the dead letters,
+// 		it has to be unique among the Sinks of a socketeer.
+// 	- Deliver delivers a message, a failure is retried and the
+// 		message is dead lettered once every attempt failed.
+//
+// A sink declares its own retry policy with a RetryPolicy() RetryPolicy
+// method, the sinks without one are retried with the defaults, and
+// WithRetry() sets the policy of a sink from the outside. A sink
+// implementing io.Closer is closed by Stop(), once its queue is drained.
+//
+// # Example:
+//
+// 	type webhook struct{ url string }
+//
+// 	func (w webhook) Name() string { return "webhook" }
+//
+// 	func (w webhook) Deliver(ctx context.Context, msg socketeer.Message) error {
+// 		body, _ := json.Marshal(msg)
+// 		req, _ := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+// 		res, err := http.DefaultClient.Do(req)
+// 		if err != nil {
+// 			return err
+// 		}
+// 		res.Body.Close()
+// 		return nil
+// 	}
+//
+// 	s.Sinks = []socketeer.Sink{webhook{url: "https://example.com/hook"}}
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, msg Message) error
+}
+
+// RetryPolicy is how the failed deliveries to a sink are retried.
+//
+// 	- MaxAttempts is the number of attempts before the message is dead
+// 		lettered, defaults to DefaultSinkAttempts.
+// 	- Backoff is the wait before the second attempt, doubled before
+// 		every following one, defaults to DefaultSinkBackoff.
+// 	- MaxBackoff caps the wait between two attempts, 0 for no cap.
+// 	- Jitter randomizes every wait by up to this fraction of it, between
+// 		0 and 1, so that the retries of many messages are spread. Start()
+// 		rejects the other values with ErrInvalidRetryPolicy.
+// 	- Retryable reports whether a failure is worth retrying, the message
+// 		is dead lettered right away when it returns false, every failure
+// 		is retried when it is nil.
+//
+// # Example:
+//
+// 	socketeer.RetryPolicy{
+// 		MaxAttempts: 10,
+// 		Backoff:     time.Second,
+// 		MaxBackoff:  time.Minute,
+// 		Jitter:      0.2,
+// 		Retryable:   func(err error) bool { return !errors.Is(err, errBadRequest) },
+// 	}
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+	Retryable   func(err error) bool
+}
+
+// retryPolicer is implemented by the sinks declaring their own retry policy.
+type retryPolicer interface {
+	RetryPolicy() RetryPolicy
+}
+
+// retrySink is a Sink with the retry policy set by WithRetry().
+//
+// 	- Sink is the wrapped sink.
+// 	- policy is the retry policy.
+type retrySink struct {
+	Sink
+	policy RetryPolicy
+}
+
+// RetryPolicy returns the retry policy of the sink.
+func (r retrySink) RetryPolicy() RetryPolicy {
+	return r.policy
+}
+
+// Close closes the wrapped sink when it implements io.Closer.
+func (r retrySink) Close() error {
+	if c, ok := r.Sink.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// WithRetry returns the sink with the given retry policy.
+//
+// # Parameters:
+//
+// 	- sink (Sink): the sink.
+// 	- policy (RetryPolicy): the retry policy of the sink.
+//
+// # Example:
+//
+// 	s.Sinks = []socketeer.Sink{
+// 		socketeer.WithRetry(hook, socketeer.RetryPolicy{MaxAttempts: 10, Jitter: 0.2}),
+// 	}
+func WithRetry(sink Sink, policy RetryPolicy) Sink {
+	return retrySink{Sink: sink, policy: policy}
+}
+
+// retryPolicy returns the retry policy of a sink with the defaults applied.
+//
+// # Parameters:
+//
+// 	- sink (Sink): the sink.
+//
+// # Example:
+//
+// 	policy := retryPolicy(sink)
+func retryPolicy(sink Sink) RetryPolicy {
+	var policy RetryPolicy
+	if p, ok := sink.(retryPolicer); ok {
+		policy = p.RetryPolicy()
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultSinkAttempts
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = DefaultSinkBackoff
+	}
+
+	return policy
+}
+
+// checkRetryPolicies checks the retry policies of the Sinks.
+func (s *Socketeer) checkRetryPolicies() error {
+	for _, sink := range s.Sinks {
+		if jitter := retryPolicy(sink).Jitter; jitter < 0 || jitter > 1 {
+			return fmt.Errorf("%w: jitter %v of sink %q not between 0 and 1", ErrInvalidRetryPolicy, jitter, sink.Name())
+		}
+	}
+
+	return nil
+}
+
+// wait returns the wait before an attempt, from the second on.
+//
+// # Parameters:
+//
+// 	- attempt (int): the attempt about to be made.
+//
+// # Example:
+//
+// 	time.Sleep(policy.wait(attempt))
+func (p RetryPolicy) wait(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 2; i < attempt; i++ {
+		wait *= 2
+		if p.MaxBackoff > 0 && wait >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+	}
+	// A Jitter over 1, rejected by Start(), never makes the wait
+	// negative.
+	if wait < 0 {
+		wait = 0
+	}
+
+	return wait
+}
+
+// startSinks starts a goroutine per sink delivering the messages of
+// its own queue, so that the sinks are delivered to concurrently, and
+// a slow or failing sink holds back neither the clients nor the other
+// sinks. Every sink still receives the messages in order.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Example:
+//
+// 	s.startSinks()
+func (s *Socketeer) startSinks() {
+	size := s.SinkBuffer
+	if size <= 0 {
+		size = DefaultSinkBuffer
+	}
+
+	s.sinkQueues = make([]chan Message, len(s.Sinks))
+	if len(s.Sinks) > 0 {
+		s.overflow = make(chan overflowed, size)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.drainOverflow()
+		}()
+	}
+	for i, sink := range s.Sinks {
+		queue := make(chan Message, size)
+		s.sinkQueues[i] = queue
+		s.wg.Add(1)
+		go func(sink Sink) {
+			defer s.wg.Done()
+			s.drainSink(sink, queue)
+		}(sink)
+	}
+}
+
+// drainSink delivers the messages of the queue of a sink until the
+// socketeer is stopped, then the messages left in the queue, the
+// messages the sink fails to deliver are dead lettered. The sink is
+// closed last, when it implements io.Closer.
+//
+// # Parameters:
+//
+// 	- sink (Sink): the sink.
+// 	- queue (chan Message): the queue of the sink.
+//
+// # Example:
+//
+// 	go s.drainSink(sink, queue)
+func (s *Socketeer) drainSink(sink Sink, queue chan Message) {
+	for {
+		select {
+		case msg := <-queue:
+			s.deliverOne(sink, msg)
+			continue
+		case <-s.done:
+		}
+
+		for {
+			select {
+			case msg := <-queue:
+				s.deliverOne(sink, msg)
+			default:
+				s.closeSink(sink)
+				return
+			}
+		}
+	}
+}
+
+// overflowed is a message dead lettered because the queue of its sink
+// was full.
+//
+// 	- sink is the sink.
+// 	- msg is the message.
+type overflowed struct {
+	sink Sink
+	msg  Message
+}
+
+// drainOverflow dead letters the messages the sinks had no room for
+// until the socketeer is stopped, then the ones left in the queue.
+//
+// # Example:
+//
+// 	go s.drainOverflow()
+func (s *Socketeer) drainOverflow() {
+	for {
+		select {
+		case o := <-s.overflow:
+			s.deadLetter(o.sink, o.msg, 0, ErrSinkOverflow)
+			continue
+		case <-s.done:
+		}
+
+		for {
+			select {
+			case o := <-s.overflow:
+				s.deadLetter(o.sink, o.msg, 0, ErrSinkOverflow)
+			default:
+				return
+			}
+		}
+	}
+}
+
+// closeSink closes a sink implementing io.Closer, the failure is
+// reported.
+func (s *Socketeer) closeSink(sink Sink) {
+	c, ok := sink.(io.Closer)
+	if !ok {
+		return
+	}
+
+	err := c.Close()
+	if err != nil {
+		s.log.Error("closing sink failed", "sink", sink.Name(), "error", err)
+		s.report(err, map[string]any{"component": "sink", "sink": sink.Name()})
+	}
+}
+
+// deliver queues a message to every sink, a message whose sink has a
+// full queue is queued to be dead lettered with ErrSinkOverflow, and
+// lost when that queue is full too. Before Start(), the message is
+// delivered to the sinks one after the other.
+//
+// # Parameters:
+//
+// 	- msg (Message): the message to deliver.
+//
+// # Example:
+//
+// 	s.deliver(msg)
+func (s *Socketeer) deliver(msg Message) {
+	for i := range s.Sinks {
+		s.deliverAt(i, msg)
+	}
+}
+
+// deliverAt queues a message to the sink at an index of Sinks, see
+// deliver().
+//
+// # Parameters:
+//
+// 	- i (int): the index of the sink.
+// 	- msg (Message): the message to deliver.
+//
+// # Example:
+//
+// 	s.deliverAt(0, msg)
+func (s *Socketeer) deliverAt(i int, msg Message) {
+	sink := s.Sinks[i]
+	if s.sinkQueues == nil {
+		s.deliverOne(sink, msg)
+		return
+	}
+
+	select {
+	case s.sinkQueues[i] <- msg:
+		return
+	default:
+	}
+	s.Metrics.Count(metrics.SinkFailures, 1, map[string]string{metrics.TagSink: sink.Name()})
+	select {
+	case s.overflow <- overflowed{sink: sink, msg: msg}:
+	default:
+		s.log.Error("message lost", "sink", sink.Name(), "seq", msg.Seq, "error", ErrSinkOverflow)
+		s.report(ErrSinkOverflow, map[string]any{"component": "sink", "sink": sink.Name(), "seq": msg.Seq})
+	}
+}
+
+// deliverOne delivers a message to a sink, it is dead lettered
+// when the sink fails to deliver it.
+//
+// # Parameters:
+//
+// 	- sink (Sink): the sink.
+// 	- msg (Message): the message to deliver.
+//
+// # Example:
+//
+// 	s.deliverOne(sink, msg)
+func (s *Socketeer) deliverOne(sink Sink, msg Message) {
+	attempts, err := s.deliverTo(sink, msg)
+	if err != nil {
+		s.deadLetter(sink, msg, attempts, err)
+	}
+}
+
+// deliverTo delivers a message to a sink according to its retry policy,
+// it returns the number of attempts and the last failure. The waits
+// between the attempts end early when the deliveries are cancelled by
+// Stop(), the message is then given up.
+//
+// # Parameters:
+//
+// 	- sink (Sink): the sink.
+// 	- msg (Message): the message to deliver.
+//
+// # Example:
+//
+// 	attempts, err := s.deliverTo(sink, msg)
+func (s *Socketeer) deliverTo(sink Sink, msg Message) (int, error) {
+	tags := map[string]string{metrics.TagSink: sink.Name()}
+	policy := retryPolicy(sink)
+	ctx := s.sinkContext()
+
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			s.Metrics.Count(metrics.SinkRetries, 1, tags)
+			wait := time.NewTimer(policy.wait(attempt))
+			select {
+			case <-wait.C:
+			case <-ctx.Done():
+				wait.Stop()
+				s.Metrics.Count(metrics.SinkFailures, 1, tags)
+				return attempt - 1, err
+			}
+		}
+		err = sink.Deliver(ctx, msg)
+		if err == nil {
+			s.Metrics.Count(metrics.SinkDeliveries, 1, tags)
+			return attempt, nil
+		}
+		s.log.Warn("sink delivery failed", "sink", sink.Name(), "seq", msg.Seq, "attempt", attempt, "error", err)
+		if policy.Retryable != nil && !policy.Retryable(err) {
+			s.Metrics.Count(metrics.SinkFailures, 1, tags)
+			return attempt, err
+		}
+	}
+	s.Metrics.Count(metrics.SinkFailures, 1, tags)
+	s.Metrics.Count(metrics.SinkExhaustions, 1, tags)
+
+	return policy.MaxAttempts, err
+}
+
+// sinkContext returns the context of the deliveries to the sinks,
+// the background context before Start() on a Socketeer not made by
+// a constructor.
+func (s *Socketeer) sinkContext() context.Context {
+	if s.sinkCtx == nil {
+		return context.Background()
+	}
+
+	return s.sinkCtx
+}
+
+// drainTimeout returns how long Stop() drains the clients and the
+// sinks, DrainTimeout or its default.
+func (s *Socketeer) drainTimeout() time.Duration {
+	if s.DrainTimeout > 0 {
+		return s.DrainTimeout
+	}
+
+	return ws.DefaultDrainTimeout
+}
@@ -0,0 +1,148 @@
+package socketeer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// AdminSnapshotPath is the path a snapshot is broadcast on demand on,
+// with a POST behind the AdminToken, for every watched collection or
+// for the one of the topic query parameter.
+const AdminSnapshotPath = "/admin/snapshot"
+
+// DefaultSnapshotLimit is the maximal number of documents of a snapshot
+// when SnapshotLimit is not set.
+const DefaultSnapshotLimit = 10000
+
+// SnapshotResult is the response of AdminSnapshotPath.
+//
+// 	- Topics are the topics a snapshot was broadcast for.
+type SnapshotResult struct {
+	Topics []string `json:"topics"`
+}
+
+// Snapshot broadcasts the current documents of collections, one message
+// per topic with the "snapshot" operation type carrying the documents,
+// so that the long-lived clients heal from the updates they missed.
+//
+// The documents have the keys and go through the view of their
+// collection, and every client only receives the documents matching
+// the filter of its subscription, or the Scope of its identity. The
+// clients of the first protocol version, which only receive the data
+// of the updates, don't get snapshots. The snapshots are not delivered
+// to the sinks, nor kept in the history.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the queries.
+// 	- topics (...string): the collections, every watched collection
+// 		when empty.
+//
+// # Example:
+//
+// 	topics, err := s.Snapshot(ctx)
+func (s *Socketeer) Snapshot(ctx context.Context, topics ...string) ([]string, error) {
+	if _, ok := s.DB.(querier); !ok {
+		return nil, ErrQueryUnsupported
+	}
+	if len(topics) == 0 {
+		if lister, ok := s.DB.(collectionLister); ok {
+			topics = lister.Collections()
+		}
+	}
+	limit := s.SnapshotLimit
+	if limit <= 0 {
+		limit = DefaultSnapshotLimit
+	}
+
+	sent := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		docs, err := s.find(ctx, topic, nil, limit)
+		if err != nil {
+			return sent, err
+		}
+		s.publish(Message{
+			Topic:         topic,
+			OperationType: OpSnapshot,
+			Time:          time.Now(),
+			Documents:     docs,
+		})
+		sent = append(sent, topic)
+	}
+
+	return sent, nil
+}
+
+// snapshots broadcasts a snapshot of every watched collection every
+// SnapshotInterval, until the socketeer is stopped.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Example:
+//
+// 	go s.snapshots()
+func (s *Socketeer) snapshots() {
+	ticker := time.NewTicker(s.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.SnapshotInterval)
+		_, err := s.Snapshot(ctx)
+		cancel()
+		if err != nil {
+			s.log.Warn("snapshot failed", "error", err)
+			s.report(err, map[string]any{"component": "snapshot"})
+		}
+	}
+}
+
+// serveSnapshot broadcasts a snapshot on the POST requests on
+// AdminSnapshotPath and answers with a SnapshotResult.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(AdminSnapshotPath, http.HandlerFunc(s.serveSnapshot))
+func (s *Socketeer) serveSnapshot(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", "POST")
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var topics []string
+	if topic := req.URL.Query().Get("topic"); topic != "" {
+		topics = append(topics, topic)
+	}
+	sent, err := s.Snapshot(req.Context(), topics...)
+	if errors.Is(err, ErrQueryUnsupported) {
+		http.Error(res, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(SnapshotResult{Topics: sent})
+}
@@ -4,7 +4,7 @@
 //
 // This package is used in the following way:
 //
-// 	1. Create a new Socketeer type with NewSocketeer().
+// 	1. Create a new Socketeer type with New() and its options.
 // 	2. Start the Socketeer with Start().
 // 	3. Stop the Socketeer with Stop().
 //
@@ -21,29 +21,645 @@
 package socketeer
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
+	"github.com/darthsalad/socketeer/internal/chaos"
 	"github.com/darthsalad/socketeer/internal/db"
+	"github.com/darthsalad/socketeer/internal/event"
+	"github.com/darthsalad/socketeer/internal/logger"
+	"github.com/darthsalad/socketeer/internal/metrics"
 	"github.com/darthsalad/socketeer/internal/ws"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Socketeer is the main type of the package.
-// It contains a pointer to a DB(internal/db.go) type and a pointer
-// to a WebSocket(internal/ws.go) type.
+// It contains a ChangeSource the events are read from, by default
+// a DB(internal/db.go) type, and a Broadcaster the events are
+// dispatched with, by default a WebSocket(internal/ws.go) type.
+//
+// 	- DB is the ChangeSource the events are read from.
+// 	- WS is the Broadcaster the events are dispatched with.
+// 	- keys are the keys selected from every event, set by Start()
+// 		or WithKeys().
+// 	- seq is the sequence number of the last dispatched message.
+// 	- dispatchMux serializes the numbering of the messages with their
+// 		dispatch, so that the clients receive them in the order of
+// 		their Seq, see publish().
+// 	- DrainTimeout is how long Stop() waits for the clients to receive
+// 		their queued messages and the close frame, defaults to 5s.
+// 	- Chaos enables fault injection when set before Start(),
+// 		it is meant for tests only and must stay nil in production.
+// 	- SessionSecret is the key signing the session tokens, which clients
+// 		present on reconnection to restore their subscriptions and receive
+// 		the updates they missed. A random key is used when it is empty, the
+// 		tokens are then invalidated by a restart.
+// 	- SessionTTL is how long the session of a disconnected client
+// 		can be resumed, defaults to 5m.
+// 	- Sessions persists the sessions of the clients, their identity,
+// 		subscriptions, cursor and when they were last seen, so that
+// 		they survive the restarts and are listed on AdminSessionsPath.
+// 		It requires a SessionSecret, optional. See the registry package.
+// 	- Authenticate returns the identity of a client from its upgrade
+// 		request, the connection is rejected when it returns an error.
+// 		The connections of an identity share a session, see SendToIdentity().
+// 	- MaxConnsPerIdentity is the maximal number of connections
+// 		of an identity, 0 for no limit.
+// 	- Meter is called with the Usage of every identity which consumed
+// 		anything, the messages and bytes written to its clients, every
+// 		MeterInterval and once the socketeer is stopped, so that the
+// 		operators bill or alert on the consumption per customer. The
+// 		anonymous clients are metered under the empty identity. It is
+// 		called from a single goroutine and must not block. Optional.
+// 	- MeterInterval is the period of the usage summaries, defaults to
+// 		DefaultMeterInterval.
+// 	- MaxSubscriptions is the maximal number of topics a connection
+// 		subscribes to, with their filter and fields, so that a client
+// 		can't create unbounded state on the server, 0 (default) for no
+// 		limit. The subscriptions over it are rejected with an error
+// 		message, see ErrorSubscriptionLimit.
+// 	- OfflineQueue is the number of messages sent with SendToIdentity()
+// 		kept for an identity without any connected client, defaults to 64.
+// 	- Metrics records the metrics of the socketeer when set before
+// 		Start(), example: a statsd.Client of the statsd package.
+// 	- Reporter is notified of the unexpected failures when set before Start().
+// 	- LogFormat is the format of the logs, LogText (default) or LogJSON
+// 		which writes a JSON object per line for log aggregators.
+// 	- LogLevel is the minimal level of the logs, LogInfo by default,
+// 		LogDebug logs every event and LogSilent disables the logs.
+// 	- Logger writes the logs instead, when set before Start(), with
+// 		the component as attribute, LogFormat and LogLevel are then
+// 		ignored: the levels are filtered by the Logger.
+// 	- log is the Logger of the socketeer, set by Start().
+// 	- HeartbeatTimeout is how long the change source can go without a
+// 		heartbeat before the readiness probe fails, defaults to 30s.
+// 	- DispatchTimeout is how long a dispatch can run before the
+// 		liveness probe fails, defaults to 30s.
+// 	- listening is whether the change source is listening.
+// 	- heartbeats is whether the change source reports heartbeats.
+// 	- lastBeat is the time of the last heartbeat in nanoseconds.
+// 	- dispatching is the start time of the running dispatch in
+// 		nanoseconds, 0 when no dispatch is running.
+// 	- AdminToken is the bearer token of the admin endpoints, which
+// 		are disabled when it is empty.
+// 	- events is the number of events read from the change source.
+// 	- started is the time of Start() in nanoseconds.
+// 	- errors is the number of unexpected failures.
+// 	- topics is the number of events per topic.
+// 	- recent are the last dispatched messages, oldest first.
+// 	- statsMux is a mutex for topics and recent for thread safety.
+// 	- Dashboard serves the monitoring dashboard on DashboardPath,
+// 		behind the AdminToken.
+// 	- taps are the taps added with Tap().
+// 	- tapsMux is a mutex for taps for thread safety.
+// 	- inspected is whether the Broadcaster hands the dispatched
+// 		messages and their recipients to the taps itself.
+// 	- recorders are the recorders added with Record().
+// 	- recordersMux is a mutex for recorders for thread safety.
+// 	- BatchSize is the maximal number of changes per batch of the change
+// 		stream, 0 for the default of the server. Larger batches save round
+// 		trips under a high event volume.
+// 	- MaxAwaitTime is how long the server waits for new changes before
+// 		answering a round trip of the change stream, 0 for the default of
+// 		the server (1s). Shorter waits lower the latency of the heartbeats
+// 		at the cost of more round trips.
+// 	- Since is the operation time the change stream starts at, to the
+// 		second, for the backfills after an outage, zero (default) for
+// 		now. It has to be within the oplog window, and the change source
+// 		has to support it, like the default one and the replayed
+// 		recordings.
+// 	- Collation is the collation of the change stream, for the pipelines
+// 		relying on locale-specific comparisons, nil by default.
+// 	- Match filters the changes of the default DB on the server, with a
+// 		$match stage compiled from operation types, field values and
+// 		document key prefixes, nil for every change.
+// 	- Pipeline are the aggregation stages appended to the pipeline of
+// 		the change stream of the default DB, so that the changes are
+// 		filtered or reshaped on the server instead of in the socketeer,
+// 		like a $match on the operation types or a $project. The stages
+// 		must keep the fields of the change events the socketeer reads,
+// 		like operationType, ns, documentKey, fullDocument and
+// 		updateDescription, and the _id, the resume token.
+// 	- ShowExpandedEvents dispatches the schema operations of the watched
+// 		collection, like OpCreateIndexes, with their description as data,
+// 		for the admin tooling observing the schema live.
+// 	- FollowRename keeps watching a collection under its new name after
+// 		a rename, the messages are then dispatched on the new topic. The
+// 		rename is dispatched as OpRename either way, so the clients can
+// 		subscribe to the new topic, and the change source stops after it
+// 		when FollowRename is not set.
+// 	- Resume persists the resume token of the change stream of the
+// 		default DB, or of the change sources with a SetResume method,
+// 		like an outbox, so that a restarted socketeer resumes after the
+// 		last change it handled and dispatches the changes made while it
+// 		was down, unless Since is set. See the resume package.
+// 	- ReconnectBackoff is the first delay before the change stream of
+// 		the default DB is reopened after a network error or a primary
+// 		stepdown, after the last change handled, defaults to 500ms. The
+// 		delay is random, up to ReconnectBackoff doubled per attempt.
+// 	- ReconnectMaxBackoff caps the delay, defaults to 30s.
+// 	- MaxReconnects is the number of consecutive attempts to reopen the
+// 		change stream before Start() returns the error, 0 for no limit.
+// 	- OnReconnect is called before every attempt to reopen the change
+// 		stream, optional. The attempts are counted in the
+// 		stream.reconnects metric as well.
+// 	- UpdateLookup makes the change stream look up the current document
+// 		of every update, whose selected keys are dispatched like the ones
+// 		of an insert, instead of the updated fields only, so that the
+// 		clients get the unchanged fields too. The update of a document
+// 		deleted since keeps its updated fields. It costs a query per
+// 		update to the server.
+// 	- ReopenOnDrop keeps watching a collection after it is dropped, its
+// 		changes are dispatched again once it is created again. The drop
+// 		is dispatched as OpDrop either way, followed by OpInvalidate,
+// 		and the change source stops with ErrInvalidated after them when
+// 		ReopenOnDrop is not set.
+// 	- RawChanges forwards the complete change documents of the change
+// 		stream, as relaxed Extended JSON, in the rawChange of the messages
+// 		and to the sinks, for the CDC consumers which want everything
+// 		MongoDB provides, and dispatches the changes otherwise ignored,
+// 		like the drops. The clients of the first protocol version get
+// 		the raw change instead of the data. The keys, views, aliases,
+// 		transforms and redactions don't apply to the raw changes.
+// 	- Keys are the keys selected from the events of a collection by
+// 		collection name, example: {"orders": {"status", "total"}}. The
+// 		keys given to Start() are selected for the other collections.
+// 		It must not be changed after Start(), use UpdateKeys() instead.
+// 	- ArrayChanges dispatches the changes of the elements of the selected
+// 		array fields as ArrayChange descriptors, instead of dotted keys
+// 		like "items.3" in the data, so clients can patch lists precisely.
+// 	- IncludeDocumentKey adds the _id (and shard key) of the changed
+// 		document to the messages, so clients know which record to update.
+// 	- IncludeFullDocument adds the whole changed document to the
+// 		messages, when the change source knows it: always for an insert,
+// 		for an update only when the change stream looks it up.
+// 	- MergePatch adds the change to the messages as a JSON Merge Patch
+// 		(RFC 7386) of the selected keys, with the removed fields as null,
+// 		which clients apply to their copy of the document as is.
+// 	- Sinks are the destinations the messages are delivered to besides
+// 		the websocket clients. Every sink has its own queue and goroutine,
+// 		so that they are delivered to concurrently and a slow or failing
+// 		sink holds back neither the clients nor the other sinks; each
+// 		one receives the messages in order.
+// 	- SinkBuffer is the number of messages queued per sink, defaults
+// 		to DefaultSinkBuffer. The messages a sink has no room for are
+// 		dead lettered with ErrSinkOverflow.
+// 	- sinkQueues are the queues of the Sinks, set by Start().
+// 	- overflow is the queue of the messages the Sinks had no room for,
+// 		dead lettered by their own goroutine so that a slow
+// 		DeadLetterQueue holds back neither the change stream nor the
+// 		sinks, set by Start().
+// 	- sinkCtx is the context of the deliveries to the Sinks, cancelled
+// 		by sinkCancel when Stop() gives up draining them.
+// 	- Flow routes the messages to the clients and the Sinks through
+// 		named transform stages, every message goes to the clients and
+// 		to every sink when it is nil. See Flow.
+// 	- flowStages are the stages of the Flow in the order they run,
+// 		set by Start().
+// 	- Encoder encodes the messages sent to the websocket clients in a
+// 		custom wire format, nil (default) for the JSON of their protocol
+// 		version. See Encoder.
+// 	- Templates are the text/template templates the messages sent to
+// 		the websocket clients are rendered with in text frames, by topic,
+// 		"" for every other topic, example: "{{.Data.title}} was {{.OperationType}}ed".
+// 		The messages of the topics without template are encoded by the
+// 		Encoder, or in JSON. See TemplateFuncs.
+// 	- templates are the parsed Templates, set by Start().
+// 	- Aliases are the payload names of database fields, example:
+// 		{"created_at": "createdAt", "internal_status": "status"}, so that
+// 		the clients aren't coupled to the naming of the database. They
+// 		apply to the data, document keys, full documents, array changes
+// 		and patches of the messages, to the snapshots and the queries,
+// 		and to the JSON Schema, while the filters of the subscriptions,
+// 		the queries and the Scope use the payload names. The Keys and
+// 		the Views use the database fields.
+// 	- aliasFields are the database fields by payload name, set by Start().
+// 	- Transforms are the Transformers of the values of the fields, by
+// 		database field, applied after the Views, before the keys are
+// 		selected, to the messages, the snapshots and the queries.
+// 	- Throttles are the ceilings of the event rate by topic, "" for
+// 		every other topic, independent of the clients. See Throttle.
+// 	- throttles are the token buckets of the throttled topics.
+// 	- throttleMux guards throttles and serializes the dispatch of the
+// 		events with the release of the held ones.
+// 	- DeadLetters keeps the messages the Sinks failed to deliver, they
+// 		are only logged and reported when it is nil.
+// 	- Middleware wraps the upgrade handler of the websocket endpoint,
+// 		see WithMiddleware().
+// 	- Upgrade configures the upgrade of the websocket connections:
+// 		handshake timeout, buffer sizes, extra subprotocols, origin
+// 		check, error responses and compression, optional.
+// 	- Handlers are served next to the websocket endpoint by pattern,
+// 		on the same listener, example: the REST API of the application.
+// 	- GRPC serves the gRPC requests, the HTTP/2 requests with a gRPC
+// 		content type, whatever their path, example: a *grpc.Server.
+// 		HTTP/2 is negotiated with ALPN over TLS, a ServerMiddleware
+// 		like h2c.NewHandler serves it over cleartext.
+// 	- ServerMiddleware wraps the handler of the whole server, every
+// 		path, the first middleware is the outermost one.
+// 	- Cluster partitions the topics across the instances of a cluster,
+// 		each one watching and dispatching the events of its topics only
+// 		and forwarding their messages to the other ones, optional.
+// 		See Cluster.
+// 	- ring is the hash ring of the Cluster, set by Start().
+// 	- AuthenticateToken returns the identity of a client from its bearer
+// 		token, when Authenticate is nil. The token is taken from the
+// 		Authorization header, or from the Sec-WebSocket-Protocol header
+// 		for the browsers: new WebSocket(url, ["bearer", token]). The
+// 		connections without a token are rejected.
+// 	- TokenQuery is the name of a query parameter carrying the token of
+// 		AuthenticateToken, example: "access_token". The URLs end up in
+// 		the access logs of the proxies, prefer short-lived tokens.
+// 	- TokenCookie is the name of a cookie carrying the token of
+// 		AuthenticateToken, example: the session cookie of the application.
+// 	- AllowCIDRs are the networks allowed to connect to the websocket
+// 		endpoint, example: "10.0.0.0/8", every one when empty.
+// 	- DenyCIDRs are the networks rejected before the upgrade, even when
+// 		they are in AllowCIDRs, example: the addresses of abusive clients.
+// 	- TrustedProxies are the networks of the proxies in front of the
+// 		server, example: the subnet of the load balancers. The address
+// 		of the clients of their requests is taken from the X-Forwarded-For
+// 		or Forwarded header, for the logs, the IP filter, the middleware
+// 		and Authenticate, which see it as the RemoteAddr of the request.
+// 	- CompressThreshold is the size in bytes from which the messages
+// 		are compressed with zstd and sent in binary frames, for the
+// 		clients of the second protocol version connecting with the
+// 		compress=zstd query parameter, 0 (default) to never compress.
+// 	- BinaryFrames sends the messages in binary frames instead of text
+// 		frames, as required by the clients of binary encodings. Without
+// 		it, the clients ask for binary frames with the frames=binary
+// 		query parameter.
+// 	- FilterPushdown pushes the filters of the subscriptions down to
+// 		the change stream, so that the database only sends the inserts
+// 		and the looked up updates some client wants, instead of every
+// 		change being filtered for every client. The sinks, taps, records
+// 		and history then miss the changes no client subscribed to.
+// 	- DocumentIDs restricts the stream to the documents with these _id
+// 		values, pushed down to the change stream, nil (default) for every
+// 		document. The operations on the collection still pass. The
+// 		set is updated while running with SetDocumentIDs() or on
+// 		AdminDocumentsPath.
+// 	- documentIDs is the set of DocumentIDs, updated while running.
+// 	- idsMux serializes the updates of documentIDs.
+// 	- MaintenanceBuffer is the maximal number of messages held for the
+// 		clients during a maintenance, the oldest ones are dropped over
+// 		it, defaults to DefaultMaintenanceBuffer. See EnterMaintenance().
+// 	- maintenance is the maintenance in progress, nil when none.
+// 	- maintenanceMux is a mutex for maintenance, held while dispatching
+// 		the messages held, so that they are delivered first.
+// 	- Failover runs the socketeer as the primary or the standby of an
+// 		active/passive pair of regions, nil (default) to always watch.
+// 		See Failover.
+// 	- standby reports whether the socketeer waits for the lease.
+// 	- leaseLost reports whether the primary lost its lease.
+// 	- checkpoint is the checkpoint of the last event handled by the
+// 		primary, guarded by checkpointMux.
+// 	- Views are the views applied to the events of the collections
+// 		before their keys are selected, by collection name, so that the
+// 		clients get the shape of a view. See View.
+// 	- SnapshotInterval is the interval of the snapshots broadcast with
+// 		the current documents of every watched collection, 0 (default)
+// 		for no periodic snapshot. See Snapshot().
+// 	- SnapshotLimit is the maximal number of documents of a snapshot,
+// 		defaults to DefaultSnapshotLimit.
+// 	- ClientHeartbeat is the interval of the heartbeat messages sent to
+// 		the clients of the second protocol version, with the time of the
+// 		server and the sequence number of the last message, so that they
+// 		detect silent gaps and measure the skew of their clock, 0
+// 		(default) for none. Unlike HeartbeatTimeout, it is about the
+// 		clients, not the change source.
+// 	- PingInterval is the interval of the pings measuring the round-trip
+// 		time of every client, shown by Clients() and recorded in the
+// 		metrics, 0 (default) for none.
+// 	- MaxBatch is the maximal number of messages written in a single
+// 		frame, as a JSON array of envelopes, to the clients of the third
+// 		protocol version when the messages are queued faster than they
+// 		are written, 0 (default) or 1 for one message per frame.
+// 	- TLSCertFile and TLSKeyFile are the PEM files of the certificate
+// 		and private key the websocket server is served over TLS with.
+// 		They are reloaded when they change, every TLSReloadInterval,
+// 		so that a certificate rotation doesn't drop the connected
+// 		clients: the new certificate applies to the new connections.
+// 	- TLSReloadInterval is the interval the certificate files are
+// 		checked for changes at, defaults to DefaultTLSReloadInterval.
+// 	- GetCertificate returns the certificate of a TLS handshake, instead
+// 		of the certificate files, example: the one of an ACME manager.
+// 	- Listener is the listener the websocket server accepts the
+// 		connections on instead of listening on the host given to
+// 		Start(), like the socket passed by systemd, see
+// 		InheritedListeners(). The host still names the server in the
+// 		logs. Optional.
+// 	- cert is the certificate loaded from the certificate files.
+// 	- certFiles are the modification times of the loaded files.
+// 	- Scope returns the filter restricting what an identity receives
+// 		on a topic, example: {"tenant": "acme"} for the users of a
+// 		tenant, nil for no restriction. It is merged into the filters
+// 		of the subscriptions, overriding their fields, applied to the
+// 		clients without subscription and to the queries on QueryPath.
+// 	- allowNets, denyNets and proxyNets are the parsed AllowCIDRs,
+// 		DenyCIDRs and TrustedProxies, set by Start().
+// 	- keySets are the compiled Keys by collection name, set by Start().
+// 	- defaultKeySet are the compiled keys given to Start().
+// 	- keysMux is a mutex for keys, Keys and their compiled sets
+// 		for thread safety.
+// 	- wg tracks the goroutines of Start(), Stop() waits for them.
+// 	- done is closed by Stop() to end the periodic goroutines and
+// 		the goroutines of the sinks.
+// 	- stopOnce guards the closing of done.
+// 	- startMux serializes Start(), until it listens for changes, with
+// 		Stop(), a Start() after Stop() returns right away.
+// 	- errCh is the channel of Errors(), created once by errChOnce.
 type Socketeer struct {
-	DB *db.DB
-	WS *ws.WebSocket
+	DB                  ChangeSource
+	WS                  Broadcaster
+	DrainTimeout        time.Duration
+	Chaos               *ChaosConfig
+	SessionSecret       []byte
+	SessionTTL          time.Duration
+	Sessions            SessionStore
+	Authenticate        func(req *http.Request) (identity string, err error)
+	MaxConnsPerIdentity int
+	MaxSubscriptions    int
+	Meter               func(usage Usage)
+	MeterInterval       time.Duration
+	OfflineQueue        int
+	Metrics             Metrics
+	Reporter            Reporter
+	LogFormat           string
+	LogLevel            string
+	Logger              Logger
+	log                 logger.Logger
+	HeartbeatTimeout    time.Duration
+	DispatchTimeout     time.Duration
+	listening           atomic.Bool
+	heartbeats          bool
+	lastBeat            atomic.Int64
+	dispatching         atomic.Int64
+	AdminToken          string
+	events              atomic.Uint64
+	started             atomic.Int64
+	errors              atomic.Uint64
+	topics              map[string]uint64
+	recent              []Message
+	statsMux            sync.Mutex
+	Dashboard           bool
+	taps                map[*tap]struct{}
+	tapsMux             sync.Mutex
+	inspected           bool
+	recorders           map[*recorder]struct{}
+	recordersMux        sync.Mutex
+	keys                []string
+	seq                 atomic.Uint64
+	dispatchMux         sync.Mutex
+	BatchSize           int32
+	MaxAwaitTime        time.Duration
+	Since               time.Time
+	Collation           *Collation
+	Match               *Match
+	Pipeline            mongo.Pipeline
+	ShowExpandedEvents  bool
+	FollowRename        bool
+	ReopenOnDrop        bool
+	UpdateLookup        bool
+	Resume              ResumeStore
+	ReconnectBackoff    time.Duration
+	ReconnectMaxBackoff time.Duration
+	MaxReconnects       int
+	OnReconnect         func(attempt ReconnectAttempt)
+	RawChanges          bool
+	Keys                map[string][]string
+	ArrayChanges        bool
+	IncludeDocumentKey  bool
+	IncludeFullDocument bool
+	MergePatch          bool
+	Sinks               []Sink
+	SinkBuffer          int
+	sinkQueues          []chan Message
+	overflow            chan overflowed
+	sinkCtx             context.Context
+	sinkCancel          context.CancelFunc
+	Flow                *Flow
+	flowStages          []FlowStage
+	Encoder             Encoder
+	Templates           map[string]string
+	templates           map[string]*template.Template
+	Aliases             map[string]string
+	aliasFields         map[string]string
+	Transforms          map[string]Transformer
+	Throttles           map[string]Throttle
+	throttles           map[string]*topicThrottle
+	throttleMux         sync.Mutex
+	DeadLetters         DeadLetterQueue
+	Middleware          []Middleware
+	Upgrade             *UpgradeOptions
+	Handlers            map[string]http.Handler
+	GRPC                http.Handler
+	ServerMiddleware    []Middleware
+	Cluster             *Cluster
+	ring                *ring
+	AuthenticateToken   func(token string) (identity string, err error)
+	TokenQuery          string
+	TokenCookie         string
+	AllowCIDRs          []string
+	DenyCIDRs           []string
+	allowNets           []*net.IPNet
+	denyNets            []*net.IPNet
+	TrustedProxies      []string
+	proxyNets           []*net.IPNet
+	CompressThreshold   int
+	BinaryFrames        bool
+	FilterPushdown      bool
+	DocumentIDs         []any
+	documentIDs         atomic.Pointer[idSet]
+	idsMux              sync.Mutex
+	MaintenanceBuffer   int
+	maintenance         *maintenance
+	maintenanceMux      sync.Mutex
+	Failover            *Failover
+	standby             atomic.Bool
+	leaseLost           atomic.Bool
+	checkpoint          Lease
+	checkpointMux       sync.Mutex
+	Scope               func(identity string, topic string) map[string]string
+	Views               map[string]View
+	SnapshotInterval    time.Duration
+	SnapshotLimit       int
+	ClientHeartbeat     time.Duration
+	PingInterval        time.Duration
+	MaxBatch            int
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSReloadInterval   time.Duration
+	GetCertificate      func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+	Listener            net.Listener
+	cert                atomic.Pointer[tls.Certificate]
+	certFiles           certFiles
+	keySets             map[string]*keySet
+	defaultKeySet       *keySet
+	keysMux             sync.RWMutex
+	wg                  sync.WaitGroup
+	done                chan struct{}
+	stopOnce            sync.Once
+	startMux            sync.Mutex
+	errCh               chan error
+	errChOnce           sync.Once
+}
+
+// Metrics records the counters, gauges and timings of the socketeer,
+// it lets the metrics be exported to any telemetry stack.
+//
+// # Example:
+//
+// 	s.Metrics, err = statsd.New("localhost:8125")
+type Metrics = metrics.Recorder
+
+// ResumeStore persists the resume token of the change stream, see the
+// Resume field of Socketeer. The resume package provides a file, a
+// MongoDB and a Redis store.
+//
+// 	- Load returns the saved token, nil when none was saved.
+// 	- Save saves the token of the last change handled, a BSON document,
+// 		every second at most and when the change stream ends.
+//
+// # Example:
+//
+// 	s.Resume = resume.NewFile("/var/lib/socketeer/orders.token")
+type ResumeStore = db.ResumeStore
+
+// resumeSetter is implemented by the change sources other than the
+// default DB which persist their resume token, like a Multi or an
+// outbox.
+type resumeSetter interface {
+	SetResume(store ResumeStore)
 }
 
-// Version and Build are the version and build of the package.
+// ReconnectAttempt is an attempt to reopen the change stream after an
+// error, see the OnReconnect field of Socketeer.
+//
+// 	- Collection is the watched collection.
+// 	- Attempt is the number of the attempt, from 1, reset once the
+// 		change stream is reopened.
+// 	- Delay is the delay before the attempt.
+// 	- Err is the error which ended the change stream, or the one of
+// 		the previous attempt.
+//
+// # Example:
+//
+// 	s.OnReconnect = func(attempt socketeer.ReconnectAttempt) {
+// 		log.Printf("reconnecting to %s in %s: %v", attempt.Collection, attempt.Delay, attempt.Err)
+// 	}
+type ReconnectAttempt = db.ReconnectAttempt
+
+// Logger writes the leveled logs of the socketeer with attributes
+// given as key-value pairs, see the Logger field of Socketeer. Its
+// methods have the signatures of the ones of *slog.Logger, which
+// implements it, so that the logs of the socketeer, of its change
+// stream and of its connections go to the structured logging of the
+// application.
+//
+// # Example:
+//
+// 	s.Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("service", "orders")
+type Logger = logger.Logger
+
+// Formats of the logs, see the LogFormat field of Socketeer.
+//
+// 	- LogText writes a line per log, example:
+// 		2023/11/14 22:13:20 INFO client connected component=ws connID=8f2c1e0a9b7d6c5e
+// 	- LogJSON writes a JSON object per line, example:
+// 		{"time":"2023-11-14T22:13:20.5Z","level":"info","msg":"client connected","component":"ws","connID":"8f2c1e0a9b7d6c5e"}
+const (
+	LogText = logger.Text
+	LogJSON = logger.JSON
+)
+
+// Levels of the logs, see the LogLevel field of Socketeer.
+//
+// 	- LogDebug logs every event, message and subscription.
+// 	- LogInfo logs the lifecycle of the server and of the connections.
+// 	- LogWarn logs the recoverable failures, like evicted clients.
+// 	- LogError logs the unexpected failures.
+// 	- LogSilent disables the logs.
+const (
+	LogDebug  = logger.LevelDebug
+	LogInfo   = logger.LevelInfo
+	LogWarn   = logger.LevelWarn
+	LogError  = logger.LevelError
+	LogSilent = logger.LevelSilent
+)
+
+// ChaosConfig configures the faults injected in chaos mode:
+// change stream disconnects, slow client writes and dropped frames,
+// each at a configurable rate and driven by a seeded random source
+// so that a test run can be reproduced.
+//
+// # Example:
+//
+// 	s.Chaos = &socketeer.ChaosConfig{
+// 		Seed:            1,
+// 		DisconnectRate:  0.01,
+// 		SlowClientRate:  0.1,
+// 		SlowClientDelay: 200 * time.Millisecond,
+// 		DropRate:        0.05,
+// 	}
+type ChaosConfig = chaos.Config
+
+// Match is a declarative filter of the changes, compiled into a $match
+// stage of the change stream, see the Match field of Socketeer. The
+// operations on the collection, like the renames and the drops, always
+// match.
+//
+// 	- Operations are the operation types matched, every one when empty.
+// 	- Fields are the values of the fields of the full document matched,
+// 		by dotted path. The updates have a full document with
+// 		UpdateLookup only, and the deletes have none.
+// 	- KeyPrefixes are the prefixes of the string _id of the documents
+// 		matched, one of them at least.
+//
+// # Example:
+//
+// 	s.Match = &socketeer.Match{
+// 		Operations:  []string{"insert", "update"},
+// 		Fields:      map[string]any{"status": "paid"},
+// 		KeyPrefixes: []string{"acme:"},
+// 	}
+type Match = db.Match
+
+// Collation is the collation of the change stream, see the Collation
+// field of Socketeer.
+//
+// # Example:
+//
+// 	s.Collation = &socketeer.Collation{Locale: "fr", Strength: 1}
+type Collation = options.Collation
+
+// Version, Commit and BuildDate are the version and build of the package,
+// Commit and BuildDate are set with ldflags when building a binary:
+//
+// 	go build -ldflags "-X github.com/darthsalad/socketeer.Commit=$(git rev-parse HEAD) \
+// 		-X github.com/darthsalad/socketeer.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
 var (
-	Version = "1.0.1"
+	Version   = "1.0.1"
+	Commit    = "unknown"
+	BuildDate = "unknown"
 )
 
 // NewSocketeer returns a new Socketeer instance
 // with a new DB and WebSocket instance.
 //
+// With several collections, every one of them is watched with its own
+// change stream, on a shared connection, and their messages are
+// dispatched through the same server, on the topic of their collection.
+//
 // This method has to be exclusively called as per the requirements
 // of the implementation and needs.
 //
@@ -51,32 +667,166 @@ var (
 //
 // 	- uriString (string): the MongoDB connection string.
 // 	- dbName (string): the MongoDB database name.
-// 	- collName (string): the MongoDB collection name.
+// 	- collNames (...string): the MongoDB collection names, one at least.
+//
+// # Example:
+//
+// 	s, err := socketeer.NewSocketeer(uri, dbName, "posts", "comments")
+func NewSocketeer(uriString string, dbName string, collNames ...string) (*Socketeer, error) {
+	return NewSocketeerWithOptions(options.Client().ApplyURI(uriString), dbName, collNames...)
+}
+
+// NewSocketeerWithOptions returns a new Socketeer instance like
+// NewSocketeer(), connecting to the database with fully built client
+// options instead of a connection string, for a custom TLS
+// configuration, the AWS IAM credentials or the monitors of the driver.
+//
+// # Parameters:
+//
+// 	- clientOptions (*options.ClientOptions): the options of the client.
+// 	- dbName (string): the MongoDB database name.
+// 	- collNames (...string): the MongoDB collection names, one at least.
+//
+// # Example:
+//
+// 	opts := options.Client().ApplyURI(uri).SetTLSConfig(tlsConfig)
+// 	s, err := socketeer.NewSocketeerWithOptions(opts, dbName, collName)
+func NewSocketeerWithOptions(clientOptions *options.ClientOptions, dbName string, collNames ...string) (*Socketeer, error) {
+	src, err := connectSource(clientOptions, dbName, collNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSocketeerWith(src, ws.NewWebSocket()), nil
+}
+
+// connectSource returns the default change source of some collections,
+// connecting to the database: a DB for one collection, a Multi for
+// several.
+//
+// # Parameters:
+//
+// 	- clientOptions (*options.ClientOptions): the options of the client.
+// 	- dbName (string): the MongoDB database name.
+// 	- collNames ([]string): the MongoDB collection names.
 //
 // # Example:
 //
-// 	s, err := socketeer.NewSocketeer(uri, dbName, collName)
-func NewSocketeer(uriString string, dbName string, collName string) (*Socketeer, error) {
-	db, err := db.Connect(uriString, dbName, collName)
+// 	src, err := connectSource(options.Client().ApplyURI(uri), dbName, collNames)
+func connectSource(clientOptions *options.ClientOptions, dbName string, collNames []string) (ChangeSource, error) {
+	if len(collNames) == 1 {
+		d, err := db.ConnectWithOptions(clientOptions, dbName, collNames[0])
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	m, err := db.ConnectMulti(clientOptions, dbName, collNames)
 	if err != nil {
 		return nil, err
 	}
 
+	return m, nil
+}
+
+// NewSocketeerWithClient returns a new Socketeer instance watching
+// a collection with the client of the application, so that the
+// socketeer reuses its connections and credentials. The client is
+// left connected when the socketeer is stopped.
+//
+// # Parameters:
+//
+// 	- client (*mongo.Client): the connected client.
+// 	- dbName (string): the MongoDB database name.
+// 	- collNames (...string): the MongoDB collection names, one at least.
+//
+// # Example:
+//
+// 	s := socketeer.NewSocketeerWithClient(client, dbName, collName)
+func NewSocketeerWithClient(client *mongo.Client, dbName string, collNames ...string) *Socketeer {
+	return NewSocketeerWith(newSource(client, dbName, collNames), ws.NewWebSocket())
+}
+
+// newSource returns the default change source of some collections with
+// the client of the application: a DB for one collection, a Multi for
+// several.
+//
+// # Parameters:
+//
+// 	- client (*mongo.Client): the connected client.
+// 	- dbName (string): the MongoDB database name.
+// 	- collNames ([]string): the MongoDB collection names.
+//
+// # Example:
+//
+// 	src := newSource(client, dbName, collNames)
+func newSource(client *mongo.Client, dbName string, collNames []string) ChangeSource {
+	if len(collNames) == 1 {
+		return db.New(client, dbName, collNames[0])
+	}
+
+	return db.NewMulti(client, dbName, collNames)
+}
+
+// NewSocketeerWithSource returns a new Socketeer instance
+// reading its events from the given ChangeSource instead of
+// a MongoDB change stream, with a new WebSocket instance.
+//
+// This is mostly useful in tests together with the sourcetest package.
+//
+// # Parameters:
+//
+// 	- src (ChangeSource): the source to read events from.
+//
+// # Example:
+//
+// 	src := sourcetest.New()
+// 	s := socketeer.NewSocketeerWithSource(src)
+func NewSocketeerWithSource(src ChangeSource) *Socketeer {
+	return NewSocketeerWith(src, ws.NewWebSocket())
+}
+
+// NewSocketeerWith returns a new Socketeer instance
+// wired to the given ChangeSource and Broadcaster, which
+// allows mocking either side or plugging alternative backends.
+//
+// # Parameters:
+//
+// 	- src (ChangeSource): the source to read events from.
+// 	- b (Broadcaster): the broadcaster to dispatch events with.
+//
+// # Example:
+//
+// 	s := socketeer.NewSocketeerWith(sourcetest.New(), myBroadcaster)
+func NewSocketeerWith(src ChangeSource, b Broadcaster) *Socketeer {
+	sinkCtx, sinkCancel := context.WithCancel(context.Background())
+
 	return &Socketeer{
-		DB: db,
-		WS: ws.NewWebSocket(),
-	}, nil
+		DB:         src,
+		WS:         b,
+		done:       make(chan struct{}),
+		sinkCtx:    sinkCtx,
+		sinkCancel: sinkCancel,
+	}
 }
 
 // Start starts the socketeer by starting the WebSocket server
 // and listening for changes in the database.
 //
+// It returns once the socketeer is stopped, nil, or when the change
+// stream or the server fail, with the failure: the process is left
+// running for the application to decide whether it is fatal. The
+// failures which don't stop the socketeer are sent on Errors().
+//
 // This method has to be exclusively called as per the requirements
 // of the implementation and needs.
 //
 // # Parameters:
 //
-// 	- keys ([]string): the keys to listen for changes on.
+// 	- keys ([]string): the keys to listen for changes on, for the
+// 		collections without keys in the Keys field, nil for the ones
+// 		given to WithKeys(). The keys starting with "^" are regular
+// 		expressions, example: ^metrics\..*latency$
 // 	- host (string): the host address to listen on, example: localhost:8080
 // 	- endpoint (string): the endpoint to listen on (without the trailing slash),
 // 		example: /listen
@@ -85,21 +835,185 @@ func NewSocketeer(uriString string, dbName string, collName string) (*Socketeer,
 //
 // 	s.Start([]string{"title", "text"}, "localhost:8080", "/listen")
 func (s *Socketeer) Start(keys []string, host string, endpoint string) error {
-	fmt.Printf("Socketeer started\nVersion: %s", Version)
+	s.startMux.Lock()
+	starting := true
+	defer func() {
+		if starting {
+			s.startMux.Unlock()
+		}
+	}()
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	s.wg.Add(1)
+	defer s.wg.Done()
+
+	s.keysMux.Lock()
+	if keys != nil {
+		s.keys = keys
+	}
+	err := s.compileAllKeys()
+	s.keysMux.Unlock()
+	if err != nil {
+		return err
+	}
+	err = s.parseIPFilter()
+	if err != nil {
+		return err
+	}
+	err = s.checkViews()
+	if err != nil {
+		return err
+	}
+	err = s.checkFlow()
+	if err != nil {
+		return err
+	}
+	err = s.checkRetryPolicies()
+	if err != nil {
+		return err
+	}
+	err = s.checkTemplates()
+	if err != nil {
+		return err
+	}
+	err = s.checkAliases()
+	if err != nil {
+		return err
+	}
+	err = s.checkThrottles()
+	if err != nil {
+		return err
+	}
+	err = s.checkSince()
+	if err != nil {
+		return err
+	}
+	err = s.checkTLS()
+	if err != nil {
+		return err
+	}
+	err = s.checkHandlers(endpoint)
+	if err != nil {
+		return err
+	}
+	err = s.checkCluster()
+	if err != nil {
+		return err
+	}
+	err = s.checkFailover()
+	if err != nil {
+		return err
+	}
+
+	s.configure()
+	s.started.Store(time.Now().UnixNano())
+	s.log.Info("socketeer started", "version", Version, "host", host, "endpoint", endpoint)
+
+	if r, ok := s.WS.(router); ok {
+		r.Handle(SchemaPath, http.HandlerFunc(s.serveSchema))
+		r.Handle(VersionPath, http.HandlerFunc(s.serveVersion))
+		r.Handle(LivePath, http.HandlerFunc(s.serveLive))
+		r.Handle(ReadyPath, http.HandlerFunc(s.serveReady))
+		r.Handle(AdminStreamPath, http.HandlerFunc(s.serveAdminStream))
+		r.Handle(AdminTapPath, http.HandlerFunc(s.serveAdminTap))
+		r.Handle(AdminKeysPath, http.HandlerFunc(s.serveAdminKeys))
+		r.Handle(AdminDocumentsPath, http.HandlerFunc(s.serveAdminDocuments))
+		r.Handle(AdminMaintenancePath, http.HandlerFunc(s.serveMaintenance))
+		r.Handle(AdminRedrivePath, http.HandlerFunc(s.serveRedrive))
+		r.Handle(QueryPath, http.HandlerFunc(s.serveQuery))
+		r.Handle(HistoryPath, http.HandlerFunc(s.serveHistory))
+		r.Handle(AdminSnapshotPath, http.HandlerFunc(s.serveSnapshot))
+		r.Handle(AdminClientsPath, http.HandlerFunc(s.serveClients))
+		r.Handle(AdminSessionsPath, http.HandlerFunc(s.serveSessions))
+		r.Handle(ClusterPath, http.HandlerFunc(s.serveCluster))
+		if s.Dashboard {
+			r.Handle(DashboardPath, http.HandlerFunc(s.serveDashboard))
+		}
+		for pattern, handler := range s.Handlers {
+			r.Handle(pattern, handler)
+		}
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.WS.Start(host, endpoint)
+		if f, ok := s.WS.(failer); ok && failed(f) {
+			s.DB.Disconnect()
+		}
+	}()
+	if s.sinkCtx == nil {
+		s.sinkCtx, s.sinkCancel = context.WithCancel(context.Background())
+	}
+	s.startSinks()
+	s.startCluster()
+	if len(s.Throttles) > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.releaseThrottled()
+		}()
+	}
+	if s.TLSCertFile != "" {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.reloadCertificates()
+		}()
+	}
+	if s.SnapshotInterval > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.snapshots()
+		}()
+	}
+	starting = false
+	s.startMux.Unlock()
 
-	go s.WS.Start(host, endpoint)
+	if s.Failover != nil {
+		lease, acquired, err := s.acquireLease()
+		if err != nil {
+			return nil
+		}
+		s.resumeFrom(lease)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.holdLease(acquired)
+		}()
+	}
 
-	err := s.DB.Listen(s.WS, keys)
+	s.listening.Store(true)
+	err = s.DB.Listen(s.process)
+	s.listening.Store(false)
+	if s.leaseLost.Load() {
+		err = ErrLeaseLost
+	}
+	if f, ok := s.WS.(failer); ok && failed(f) {
+		return fmt.Errorf("socketeer: server: %w", f.Err())
+	}
 	if err != nil {
-		log.Fatal(err)
+		s.report(err, map[string]any{"component": "source"})
 		return err
 	}
 
 	return nil
 }
 
-// Stop stops the socketeer by stopping the WebSocket server
-// and disconnecting from the database.
+// Stop stops the socketeer by disconnecting from the database
+// and draining the WebSocket server: the clients receive their
+// queued messages and a close frame with code 1001 (going away)
+// before the connections are closed, for up to DrainTimeout, and
+// the sinks deliver the messages left in their queue, the deliveries
+// and their retries being cancelled once DrainTimeout is over. A maintenance
+// in progress is ended first, so that the messages held are sent.
+// It returns once every goroutine started by Start() has exited.
 //
 // This method has to be exclusively called as per the requirements
 // of the implementation and needs.
@@ -108,13 +1022,269 @@ func (s *Socketeer) Start(keys []string, host string, endpoint string) error {
 //
 // 	s.Stop()
 func (s *Socketeer) Stop() error {
-	defer func() {
-		s.Stop()
-		fmt.Println("Socketeer stopped gracefully.")
-	}()
+	return s.stop(time.Time{})
+}
 
+// stop stops the socketeer like Stop(), the clients and the sinks
+// being drained until the deadline at the latest.
+//
+// # Parameters:
+//
+// 	- deadline (time.Time): the deadline of the drain, zero for none.
+//
+// # Example:
+//
+// 	s.stop(deadline)
+func (s *Socketeer) stop(deadline time.Time) error {
+	s.startMux.Lock()
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+	s.stopOnce.Do(func() { close(s.done) })
+	s.startMux.Unlock()
+	if s.sinkCancel != nil {
+		drain := s.drainTimeout()
+		if !deadline.IsZero() && time.Until(deadline) < drain {
+			drain = time.Until(deadline)
+		}
+		timer := time.AfterFunc(drain, s.sinkCancel)
+		defer timer.Stop()
+	}
 	s.DB.Disconnect()
-	s.WS.Stop()
+	s.ExitMaintenance()
+	if d, ok := s.WS.(drainer); ok && !deadline.IsZero() {
+		d.StopBy(deadline)
+	} else {
+		s.WS.Stop()
+	}
+	s.wg.Wait()
+	if s.log != nil {
+		s.log.Info("socketeer stopped")
+	}
 
 	return nil
 }
+
+// StartContext starts the socketeer like Start(), and stops it like
+// Stop() when the context is done, the change stream and the WebSocket
+// server included. It returns once the socketeer is stopped, nil when
+// it is stopped by the context.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): the context of the socketeer.
+// 	- keys ([]string): the keys to listen for changes on, see Start().
+// 	- host (string): the host address to listen on.
+// 	- endpoint (string): the endpoint to listen on.
+//
+// # Example:
+//
+// 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+// 	defer stop()
+// 	err := s.StartContext(ctx, nil, "0.0.0.0:8080", "/listen")
+func (s *Socketeer) StartContext(ctx context.Context, keys []string, host string, endpoint string) error {
+	returned := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-returned:
+		}
+	}()
+
+	err := s.Start(keys, host, endpoint)
+	close(returned)
+	<-stopped
+
+	return err
+}
+
+// StopContext stops the socketeer like Stop(), within the deadline of
+// the context: the clients are drained for up to DrainTimeout or until
+// the deadline, whichever comes first, and it returns the error of the
+// context when the socketeer isn't stopped by then, the goroutines left
+// exiting in the background.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): the context bounding the shutdown.
+//
+// # Example:
+//
+// 	ctx, cancel := context.WithTimeout(context.Background(), 25*time.Second)
+// 	defer cancel()
+// 	err := s.StopContext(ctx)
+func (s *Socketeer) StopContext(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.stop(deadline)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// configure applies the settings of the socketeer to the default
+// DB and WebSocket implementations, custom ChangeSource and
+// Broadcaster implementations are left untouched.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Example:
+//
+// 	s.configure()
+func (s *Socketeer) configure() {
+	var injector *chaos.Injector
+	if s.Chaos != nil {
+		injector = chaos.New(*s.Chaos)
+	}
+
+	if s.Metrics == nil {
+		s.Metrics = metrics.Nop{}
+	}
+	base := s.Logger
+	if base == nil {
+		base = logger.New(os.Stderr, s.LogFormat, s.LogLevel)
+	}
+	s.log = logger.With(base, "component", "socketeer")
+	if s.DocumentIDs != nil {
+		s.SetDocumentIDs(s.DocumentIDs)
+	}
+
+	if h, ok := s.DB.(heartbeater); ok {
+		h.OnHeartbeat(s.beat)
+		s.heartbeats = true
+	}
+	if st, ok := s.DB.(starter); ok && !s.Since.IsZero() {
+		st.StartAt(s.Since)
+	}
+
+	switch d := s.DB.(type) {
+	case *db.DB:
+		s.configureDB(d, injector, base)
+	case *db.Multi:
+		for _, w := range d.DBs {
+			s.configureDB(w, injector, base)
+		}
+	}
+	if r, ok := s.DB.(resumeSetter); ok && s.Resume != nil {
+		r.SetResume(s.Resume)
+	}
+	if w, ok := s.WS.(*ws.WebSocket); ok {
+		w.Chaos = injector
+		w.Log = logger.With(base, "component", "ws")
+		w.SkipDelivered = s.Failover != nil
+		if s.DrainTimeout > 0 {
+			w.DrainTimeout = s.DrainTimeout
+		}
+		if len(s.SessionSecret) > 0 {
+			w.SessionSecret = s.SessionSecret
+		}
+		if s.SessionTTL > 0 {
+			w.SessionTTL = s.SessionTTL
+		}
+		w.Sessions = s.Sessions
+		w.TLSConfig = s.tlsConfig()
+		w.Listener = s.Listener
+		if s.Authenticate != nil {
+			w.Authenticate = s.Authenticate
+		}
+		if s.AuthenticateToken != nil {
+			w.AuthenticateToken = s.AuthenticateToken
+		}
+		w.TokenQuery = s.TokenQuery
+		w.TokenCookie = s.TokenCookie
+		w.Allow = s.allowNets
+		w.Deny = s.denyNets
+		w.TrustedProxies = s.proxyNets
+		w.CompressThreshold = s.CompressThreshold
+		w.BinaryFrames = s.BinaryFrames
+		w.Heartbeat = s.ClientHeartbeat
+		w.PingInterval = s.PingInterval
+		w.MaxBatch = s.MaxBatch
+		w.Encoder = s.Encoder
+		if len(s.templates) > 0 {
+			w.Encoder = templateEncoder{templates: s.templates, next: s.Encoder}
+		}
+		w.MaxConnsPerIdentity = s.MaxConnsPerIdentity
+		w.MaxSubscriptions = s.MaxSubscriptions
+		w.Meter = s.Meter
+		w.MeterInterval = s.MeterInterval
+		if s.OfflineQueue > 0 {
+			w.OfflineQueue = s.OfflineQueue
+		}
+		w.Metrics = s.Metrics
+		w.Report = s.report
+		w.Inspect = s.inspect
+		w.Middleware = s.Middleware
+		w.ServerMiddleware = s.serverMiddleware()
+		if s.Upgrade != nil {
+			w.Upgrade = *s.Upgrade
+		}
+		if s.Scope != nil {
+			w.Scope = func(identity string, topic string) event.Filter {
+				return s.Scope(identity, topic)
+			}
+		}
+		s.inspected = true
+	}
+}
+
+// configureDB configures a watcher of the default change source with
+// the fields of the socketeer.
+//
+// # Parameters:
+//
+// 	- d (*db.DB): the watcher.
+// 	- injector (*chaos.Injector): the fault injector, nil without Chaos.
+// 	- base (Logger): the logger the logs of the watcher derive from.
+//
+// # Example:
+//
+// 	s.configureDB(d, injector, base)
+func (s *Socketeer) configureDB(d *db.DB, injector *chaos.Injector, base Logger) {
+	d.Chaos = injector
+	d.Log = logger.With(base, "component", "db")
+	d.BatchSize = s.BatchSize
+	d.MaxAwaitTime = s.MaxAwaitTime
+	d.Report = s.report
+	d.Collation = s.Collation
+	d.Match = s.Match
+	d.Pipeline = s.Pipeline
+	d.ShowExpandedEvents = s.ShowExpandedEvents
+	d.RawChanges = s.RawChanges
+	d.FollowRename = s.FollowRename
+	d.ReopenOnDrop = s.ReopenOnDrop
+	d.UpdateLookup = s.UpdateLookup
+	d.Resume = s.Resume
+	d.MinBackoff = s.ReconnectBackoff
+	d.MaxBackoff = s.ReconnectMaxBackoff
+	d.MaxReconnects = s.MaxReconnects
+	d.OnReconnect = s.reconnecting
+	d.DocumentIDs = s.pushedIDs
+	if s.ring != nil {
+		d.Owns = s.owns
+	}
+	if w, ok := s.WS.(*ws.WebSocket); ok && s.FilterPushdown {
+		d.Filters = w.Filters
+		if len(s.aliasFields) > 0 {
+			d.Filters = func() map[string][]event.Filter {
+				filters := w.Filters()
+				for _, fs := range filters {
+					for i, f := range fs {
+						fs[i] = s.unaliasFilter(f)
+					}
+				}
+				return filters
+			}
+		}
+	}
+}
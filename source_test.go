@@ -0,0 +1,126 @@
+package socketeer_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+	"github.com/darthsalad/socketeer/sourcetest"
+)
+
+// recorder is a Broadcaster keeping the dispatched messages.
+//
+// 	- mux is a mutex for messages for thread safety.
+// 	- messages are the dispatched messages, in order.
+// 	- stop is closed by Stop to return from Start.
+type recorder struct {
+	mux      sync.Mutex
+	messages []socketeer.Message
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (r *recorder) Start(host string, endpoint string) {
+	<-r.stop
+}
+
+func (r *recorder) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+func (r *recorder) Dispatch(msg socketeer.Message) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.messages = append(r.messages, msg)
+}
+
+// collector is a Sink handing the delivered messages to a channel.
+type collector chan socketeer.Message
+
+func (c collector) Name() string {
+	return "collector"
+}
+
+func (c collector) Deliver(ctx context.Context, msg socketeer.Message) error {
+	c <- msg
+	return nil
+}
+
+func TestSource(t *testing.T) {
+	src := sourcetest.New()
+	b := &recorder{stop: make(chan struct{})}
+	sink := make(collector, 8)
+	s := socketeer.NewSocketeerWith(src, b)
+	s.Sinks = []socketeer.Sink{sink}
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start([]string{"title"}, "localhost:0", "/listen")
+	}()
+
+	events := []socketeer.Event{
+		{OperationType: "insert", Collection: "posts", Fields: map[string]any{"title": "Hello", "body": "Text"}},
+		{OperationType: "update", Collection: "posts", Fields: map[string]any{"body": "Edited"}},
+		{OperationType: "update", Collection: "posts", Fields: map[string]any{"title": "Hello again"}},
+	}
+	for _, ev := range events {
+		err := src.Emit(ev)
+		if err != nil {
+			t.Fatalf("Emit(%v) = %v", ev.Fields, err)
+		}
+	}
+
+	// Only the selected keys are dispatched, the messages are
+	// numbered in the order of the source.
+	want := []struct {
+		seq  uint64
+		op   string
+		data map[string]string
+	}{
+		{1, "insert", map[string]string{"title": "Hello"}},
+		{2, "update", map[string]string{}},
+		{3, "update", map[string]string{"title": "Hello again"}},
+	}
+	b.mux.Lock()
+	dispatched := b.messages
+	b.mux.Unlock()
+	if len(dispatched) != len(want) {
+		t.Fatalf("%d messages dispatched, want %d: %v", len(dispatched), len(want), dispatched)
+	}
+	for i, w := range want {
+		msg := dispatched[i]
+		if msg.Seq != w.seq || msg.Topic != "posts" || msg.OperationType != w.op || !reflect.DeepEqual(msg.Data, w.data) {
+			t.Errorf("message %d = %+v, want seq %d, %s of %v", i, msg, w.seq, w.op, w.data)
+		}
+	}
+	for i := range want {
+		select {
+		case msg := <-sink:
+			if msg.Seq != dispatched[i].Seq || !reflect.DeepEqual(msg.Data, dispatched[i].Data) {
+				t.Errorf("delivered %+v, want %+v", msg, dispatched[i])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d not delivered to the sink", i)
+		}
+	}
+
+	err := s.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-started:
+		if err != nil {
+			t.Errorf("Start() = %v, want nil once stopped", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start() didn't return after Stop()")
+	}
+	err = src.Emit(events[0])
+	if !errors.Is(err, sourcetest.ErrDisconnected) {
+		t.Errorf("Emit() after Stop() = %v, want ErrDisconnected", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Package sourcetest provides an in-memory ChangeSource for
+// testing applications built on the socketeer without a
+// running MongoDB deployment.
+//
+// Events emitted on the Source go through the same dispatch
+// pipeline as the ones read from a change stream, so the
+// selected keys and the messages received by the websocket
+// clients are exactly the ones seen in production.
+//
+// # Usage:
+//
+// 	src := sourcetest.New()
+// 	s := socketeer.NewSocketeerWithSource(src)
+// 	go s.Start([]string{"title"}, "localhost:8080", "/listen")
+//
+// 	err := src.Emit(socketeer.Event{
+// 		OperationType: "insert",
+// 		Collection:    "posts",
+// 		Fields:        map[string]any{"title": "Hello"},
+// 	})
+package sourcetest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// ErrDisconnected is returned by Emit once the Source is disconnected.
+var ErrDisconnected = errors.New("sourcetest: source disconnected")
+
+// Source is an in-memory socketeer.ChangeSource.
+//
+// 	- events is the channel Emit hands events to Listen with.
+// 	- done is closed when the Source is disconnected.
+// 	- doneOnce guards the closing of done.
+type Source struct {
+	events   chan emission
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// emission is an event waiting to be processed together with
+// the channel the result of the pipeline is reported on.
+type emission struct {
+	event  socketeer.Event
+	result chan error
+}
+
+// New returns a new Source, ready to be handed to
+// socketeer.NewSocketeerWithSource().
+//
+// # Example:
+//
+// 	src := sourcetest.New()
+func New() *Source {
+	return &Source{
+		events: make(chan emission),
+		done:   make(chan struct{}),
+	}
+}
+
+// Emit feeds an event into the pipeline of the socketeer the
+// Source is attached to and blocks until it has been processed.
+//
+// It returns the error of the pipeline, or ErrDisconnected
+// when the Source was disconnected in the meantime.
+//
+// # Parameters:
+//
+// 	- ev (socketeer.Event): the event to feed into the pipeline.
+//
+// # Example:
+//
+// 	err := src.Emit(socketeer.Event{OperationType: "update", Fields: fields})
+func (s *Source) Emit(ev socketeer.Event) error {
+	e := emission{
+		event:  ev,
+		result: make(chan error, 1),
+	}
+
+	select {
+	case s.events <- e:
+	case <-s.done:
+		return ErrDisconnected
+	}
+
+	select {
+	case err := <-e.result:
+		return err
+	case <-s.done:
+		return ErrDisconnected
+	}
+}
+
+// Listen calls handle for every emitted event until the
+// Source is disconnected, as required by socketeer.ChangeSource.
+//
+// # Parameters:
+//
+// 	- handle (func(socketeer.Event) error): the function called for every event.
+//
+// # Example:
+//
+// 	src.Listen(func(ev socketeer.Event) error { return nil })
+func (s *Source) Listen(handle func(socketeer.Event) error) error {
+	for {
+		select {
+		case e := <-s.events:
+			e.result <- handle(e.event)
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+// Disconnect stops Listen and makes every pending
+// and later Emit return ErrDisconnected.
+//
+// # Example:
+//
+// 	src.Disconnect()
+func (s *Source) Disconnect() error {
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+
+	return nil
+}
@@ -0,0 +1,162 @@
+// Package statsd exports the metrics of the socketeer to a StatsD
+// server over UDP, in the DogStatsD format for Datadog agents or in
+// the Graphite tag format for Graphite-based stacks.
+//
+// This package is used in the following way:
+//
+// 	1. Create a new Client type with New().
+// 	2. Set the Prefix, the Tags and the Format of the Client if needed.
+// 	3. Set the Client as the Metrics of the socketeer before Start().
+// 	4. Close the Client with Close() once the socketeer is stopped.
+//
+// # Example:
+//
+// 	m, err := statsd.New("localhost:8125")
+// 	if err != nil {
+// 		log.Fatal(err)
+// 	}
+// 	m.Prefix = "socketeer."
+// 	m.Tags = map[string]string{"env": "production"}
+// 	s.Metrics = m
+package statsd
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the tags of the metrics.
+//
+// 	- DogStatsD appends the tags to the line, example: socketeer.connects:1|c|#env:production
+// 	- Graphite appends the tags to the name, example: socketeer.connects;env=production:1|c
+const (
+	DogStatsD = iota
+	Graphite
+)
+
+// Client is a StatsD client, it is safe for concurrent use.
+// Every metric is sent in its own datagram and send errors
+// are ignored, so that metrics never slow down the dispatches.
+//
+// 	- conn is the UDP connection to the server.
+// 	- Prefix is prepended to the name of every metric, example: socketeer.
+// 	- Tags are added to every metric, example: {"env": "production"}
+// 	- Format is the format of the tags, DogStatsD or Graphite.
+type Client struct {
+	conn   net.Conn
+	Prefix string
+	Tags   map[string]string
+	Format int
+}
+
+// New returns a new Client sending the metrics to addr.
+//
+// # Parameters:
+//
+// 	- addr (string): the address of the StatsD server, example: localhost:8125
+//
+// # Example:
+//
+// 	m, err := statsd.New("localhost:8125")
+func New(addr string) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn}, nil
+}
+
+// Count adds delta to a counter.
+func (c *Client) Count(name string, delta int64, tags map[string]string) {
+	c.send(name, strconv.FormatInt(delta, 10), "c", tags)
+}
+
+// Gauge sets the value of a gauge.
+func (c *Client) Gauge(name string, value float64, tags map[string]string) {
+	c.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// Timing records a duration in milliseconds.
+func (c *Client) Timing(name string, d time.Duration, tags map[string]string) {
+	ms := float64(d) / float64(time.Millisecond)
+	c.send(name, strconv.FormatFloat(ms, 'f', -1, 64), "ms", tags)
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// send sends a metric in the configured format.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the metric, without the prefix.
+// 	- value (string): the formatted value.
+// 	- kind (string): the StatsD type of the metric, example: c
+// 	- tags (map[string]string): the tags of the measurement, added to Tags.
+//
+// # Example:
+//
+// 	c.send("connects", "1", "c", nil)
+func (c *Client) send(name string, value string, kind string, tags map[string]string) {
+	pairs := c.tags(tags)
+
+	var b strings.Builder
+	b.WriteString(sanitize(c.Prefix + name))
+	if c.Format == Graphite {
+		for _, pair := range pairs {
+			b.WriteString(";" + pair[0] + "=" + pair[1])
+		}
+	}
+	b.WriteString(":" + value + "|" + kind)
+	if c.Format == DogStatsD && len(pairs) > 0 {
+		b.WriteString("|#")
+		for i, pair := range pairs {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(pair[0] + ":" + pair[1])
+		}
+	}
+
+	c.conn.Write([]byte(b.String()))
+}
+
+// tags merges the tags of a measurement with Tags,
+// sorted by key so that the lines are stable.
+func (c *Client) tags(tags map[string]string) [][2]string {
+	merged := make(map[string]string, len(c.Tags)+len(tags))
+	for key, value := range c.Tags {
+		merged[key] = value
+	}
+	for key, value := range tags {
+		merged[key] = value
+	}
+
+	pairs := make([][2]string, 0, len(merged))
+	for key, value := range merged {
+		pairs = append(pairs, [2]string{sanitize(key), sanitize(value)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0]
+	})
+
+	return pairs
+}
+
+// sanitize replaces the characters reserved by the
+// StatsD line formats with underscores.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '#', ',', ';', '=', '@', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,176 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// AdminTapPath is the path of the admin websocket endpoint
+// streaming a TapRecord for every dispatched message.
+const AdminTapPath = "/admin/tap"
+
+// tapBuffer is the number of records queued per tap, the records
+// of a tap which can't keep up are dropped so that taps never slow
+// down the dispatches.
+const tapBuffer = 1024
+
+// TapRecord is a dispatched message as seen by a tap.
+//
+// 	- Message is the dispatched message with its envelope metadata.
+// 	- Recipients are the connection IDs of the clients the message was
+// 		queued to, null when the Broadcaster doesn't report them.
+type TapRecord struct {
+	Message
+	Recipients []string `json:"recipients"`
+}
+
+// tap writes the TapRecords to a writer, one JSON object per line.
+//
+// 	- records is the queue of the records to write.
+// 	- done is closed once the queue is drained.
+// 	- want reports whether a message is tapped, every one when nil.
+type tap struct {
+	records chan TapRecord
+	done    chan struct{}
+	want    func(msg Message) bool
+}
+
+// Tap mirrors every dispatched message to out as a TapRecord, one JSON
+// object per line, until the returned function is called. Taps don't
+// affect the clients: the records are written in the background and
+// dropped when out can't keep up.
+//
+// # Parameters:
+//
+// 	- out (io.Writer): where the records are written to, example: os.Stdout
+//
+// # Example:
+//
+// 	untap := s.Tap(os.Stdout)
+// 	defer untap()
+func (s *Socketeer) Tap(out io.Writer) func() {
+	return s.tapWhere(out, nil)
+}
+
+// tapWhere mirrors the dispatched messages want accepts to out, like
+// Tap().
+//
+// # Parameters:
+//
+// 	- out (io.Writer): where the records are written to.
+// 	- want (func(Message) bool): whether a message is tapped, every
+// 		one when nil.
+//
+// # Example:
+//
+// 	untap := s.tapWhere(frames, func(msg Message) bool { return s.owns(msg.Topic) })
+func (s *Socketeer) tapWhere(out io.Writer, want func(msg Message) bool) func() {
+	t := &tap{
+		records: make(chan TapRecord, tapBuffer),
+		done:    make(chan struct{}),
+		want:    want,
+	}
+
+	go func() {
+		defer close(t.done)
+		enc := json.NewEncoder(out)
+		for record := range t.records {
+			enc.Encode(record)
+		}
+	}()
+
+	s.tapsMux.Lock()
+	if s.taps == nil {
+		s.taps = make(map[*tap]struct{})
+	}
+	s.taps[t] = struct{}{}
+	s.tapsMux.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.tapsMux.Lock()
+			delete(s.taps, t)
+			close(t.records)
+			s.tapsMux.Unlock()
+			<-t.done
+		})
+	}
+}
+
+// inspect hands a dispatched message to every tap without blocking.
+//
+// # Parameters:
+//
+// 	- msg (Message): the dispatched message.
+// 	- recipients ([]string): the connection IDs of the recipients, nil if unknown.
+//
+// # Example:
+//
+// 	s.inspect(msg, []string{"8f2c1e0a9b7d6c5e"})
+func (s *Socketeer) inspect(msg Message, recipients []string) {
+	s.tapsMux.Lock()
+	defer s.tapsMux.Unlock()
+
+	for t := range s.taps {
+		if t.want != nil && !t.want(msg) {
+			continue
+		}
+		select {
+		case t.records <- TapRecord{Message: msg, Recipients: recipients}:
+		default:
+		}
+	}
+}
+
+// frameWriter is an io.Writer sending every write as a frame on a
+// channel, dropping the frames when the channel is full.
+type frameWriter chan []byte
+
+// Write sends a copy of p on the channel without blocking.
+func (f frameWriter) Write(p []byte) (int, error) {
+	select {
+	case f <- append([]byte(nil), p...):
+	default:
+	}
+
+	return len(p), nil
+}
+
+// forwarder is implemented by the broadcasters which
+// can forward frames to a websocket connection.
+type forwarder interface {
+	Forward(res http.ResponseWriter, req *http.Request, frames <-chan []byte)
+}
+
+// serveAdminTap serves the admin tap, a websocket connection
+// receiving a TapRecord for every dispatched message.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the upgrade request.
+//
+// # Example:
+//
+// 	r.Handle(AdminTapPath, http.HandlerFunc(s.serveAdminTap))
+func (s *Socketeer) serveAdminTap(res http.ResponseWriter, req *http.Request) {
+	if !s.authorizeAdmin(req) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	f, ok := s.WS.(forwarder)
+	if !ok {
+		http.Error(res, ErrUnsupported.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	frames := make(frameWriter, tapBuffer)
+	untap := s.Tap(frames)
+	defer untap()
+
+	f.Forward(res, req, frames)
+}
@@ -0,0 +1,111 @@
+package socketeer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// TemplateFuncs are the functions of the templates of the messages,
+// besides the builtins of text/template.
+//
+// 	- json returns the JSON of a value, to embed a field in a JSON
+// 		payload, example: {"text": {{json .Data.title}}}
+// 	- upper and lower change the case of a string.
+// 	- default returns its first argument when the second one is
+// 		empty, example: {{default "untitled" .Data.title}}
+var TemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"default": func(def string, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+}
+
+// ParseTemplate parses a template of the messages, executed with a
+// Message, with the TemplateFuncs.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the template, used in the errors.
+// 	- text (string): the template.
+//
+// # Example:
+//
+// 	t, err := socketeer.ParseTemplate("slack", `{"text": {{json (printf "New post: %s" .Data.title)}}}`)
+func ParseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(TemplateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// renderTemplate executes a template with a message.
+//
+// # Parameters:
+//
+// 	- t (*template.Template): the template.
+// 	- msg (Message): the message.
+//
+// # Example:
+//
+// 	data, err := renderTemplate(t, msg)
+func renderTemplate(t *template.Template, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// checkTemplates parses the Templates and keeps them by topic.
+func (s *Socketeer) checkTemplates() error {
+	templates := make(map[string]*template.Template, len(s.Templates))
+	for topic, text := range s.Templates {
+		t, err := ParseTemplate(topic, text)
+		if err != nil {
+			return fmt.Errorf("socketeer: template of %q: %w", topic, err)
+		}
+		templates[topic] = t
+	}
+	s.templates = templates
+
+	return nil
+}
+
+// templateEncoder is the Encoder rendering the messages of the topics
+// with a template in text frames, and leaving the other ones to the
+// Encoder of the socketeer, if any.
+//
+// 	- templates are the templates by topic, "" for every other topic.
+// 	- next is the Encoder of the socketeer, nil for the JSON.
+type templateEncoder struct {
+	templates map[string]*template.Template
+	next      Encoder
+}
+
+// Encode renders the message with the template of its topic.
+func (e templateEncoder) Encode(msg Message) ([]byte, int, error) {
+	t, ok := e.templates[msg.Topic]
+	if !ok {
+		t, ok = e.templates[""]
+	}
+	if !ok {
+		if e.next != nil {
+			return e.next.Encode(msg)
+		}
+		return nil, 0, ErrDefaultEncoding
+	}
+
+	data, err := renderTemplate(t, msg)
+
+	return data, TextMessage, err
+}
@@ -0,0 +1,312 @@
+package socketeer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+	"github.com/darthsalad/socketeer/internal/metrics"
+)
+
+// Overflow policies of a Throttle, what happens to the events of a
+// topic over its rate.
+//
+// 	- ThrottleDrop drops them (default).
+// 	- ThrottleCoalesce holds them, merging the changes of a document
+// 		into a single event, and releases them as the rate allows.
+const (
+	ThrottleDrop     = "drop"
+	ThrottleCoalesce = "coalesce"
+)
+
+// DefaultThrottleBacklog is the maximal number of events held by a
+// coalescing Throttle when its Backlog is not set.
+const DefaultThrottleBacklog = 10000
+
+// throttleTick is the interval the held events are released at.
+const throttleTick = 100 * time.Millisecond
+
+// ErrInvalidThrottle is returned by Start() and Validate() for a
+// Throttle without a positive rate or with an unknown policy.
+var ErrInvalidThrottle = errors.New("socketeer: invalid throttle")
+
+// Throttle is the ceiling of the event rate of a topic, which bounds
+// the work of the whole pipeline during the write storms, whatever
+// the clients connected. Only the changes of documents are throttled,
+// never the operations on the collection, like the renames.
+//
+// 	- Rate is the maximal number of events per second, the topic can
+// 		burst up to a second of events after a quiet period.
+// 	- Policy is ThrottleDrop or ThrottleCoalesce, ThrottleDrop when empty.
+// 	- Backlog is the maximal number of events held by ThrottleCoalesce,
+// 		the events of other documents are dropped beyond it, defaults
+// 		to DefaultThrottleBacklog.
+//
+// # Example:
+//
+// 	s.Throttles = map[string]socketeer.Throttle{
+// 		"prices": {Rate: 50, Policy: socketeer.ThrottleCoalesce},
+// 		"":       {Rate: 1000},
+// 	}
+type Throttle struct {
+	Rate    float64 `json:"rate"`
+	Policy  string  `json:"policy,omitempty"`
+	Backlog int     `json:"backlog,omitempty"`
+}
+
+// topicThrottle is the token bucket of a throttled topic.
+//
+// 	- tokens are the events the topic can dispatch right away.
+// 	- last is when the tokens were last refilled.
+// 	- held are the events held by ThrottleCoalesce, in order.
+// 	- docs are the indexes of the held events by document key.
+type topicThrottle struct {
+	Throttle
+	tokens float64
+	last   time.Time
+	held   []Event
+	docs   map[string]int
+}
+
+// checkThrottles checks the Throttles.
+func (s *Socketeer) checkThrottles() error {
+	for topic, t := range s.Throttles {
+		if t.Rate <= 0 {
+			return fmt.Errorf("%w: rate of %q is not positive", ErrInvalidThrottle, topic)
+		}
+		if t.Policy != "" && t.Policy != ThrottleDrop && t.Policy != ThrottleCoalesce {
+			return fmt.Errorf("%w: unknown policy %q of %q", ErrInvalidThrottle, t.Policy, topic)
+		}
+		if t.Backlog < 0 {
+			return fmt.Errorf("%w: negative backlog of %q", ErrInvalidThrottle, topic)
+		}
+	}
+
+	return nil
+}
+
+// throttle reports whether an event is dispatched right away, under
+// the Throttle of its topic, "" for every other topic. The events over
+// the rate are dropped, or held to be released by releaseThrottled().
+// It must be called with throttleMux held.
+//
+// # Parameters:
+//
+// 	- ev (Event): the event.
+//
+// # Example:
+//
+// 	if !s.throttle(ev) {
+// 		return nil
+// 	}
+func (s *Socketeer) throttle(ev Event) bool {
+	if event.IsCollectionOp(ev.OperationType) {
+		return true
+	}
+	t := s.topicThrottle(ev.Collection)
+	if t == nil {
+		return true
+	}
+
+	t.refill(time.Now())
+	if len(t.held) == 0 && t.tokens >= 1 {
+		t.tokens--
+		return true
+	}
+
+	s.Metrics.Count(metrics.EventsThrottled, 1, map[string]string{
+		metrics.TagCollection: ev.Collection,
+		metrics.TagOperation:  ev.OperationType,
+	})
+	if t.Policy == ThrottleCoalesce {
+		t.hold(ev)
+	}
+
+	return false
+}
+
+// topicThrottle returns the bucket of a topic, nil when the topic
+// isn't throttled. It must be called with throttleMux held.
+func (s *Socketeer) topicThrottle(topic string) *topicThrottle {
+	if t, ok := s.throttles[topic]; ok {
+		return t
+	}
+	settings, ok := s.Throttles[topic]
+	if !ok {
+		settings, ok = s.Throttles[""]
+	}
+	if !ok {
+		return nil
+	}
+
+	if s.throttles == nil {
+		s.throttles = make(map[string]*topicThrottle)
+	}
+	t := &topicThrottle{Throttle: settings, tokens: settings.Rate, last: time.Now()}
+	s.throttles[topic] = t
+
+	return t
+}
+
+// refill adds the tokens earned since the last refill, up to a second
+// of events.
+func (t *topicThrottle) refill(now time.Time) {
+	t.tokens += now.Sub(t.last).Seconds() * t.Rate
+	if t.tokens > t.Rate {
+		t.tokens = t.Rate
+	}
+	t.last = now
+}
+
+// hold holds an event, merged into the held event of its document if
+// any, it is dropped when the backlog is full.
+//
+// # Parameters:
+//
+// 	- ev (Event): the event.
+//
+// # Example:
+//
+// 	t.hold(ev)
+func (t *topicThrottle) hold(ev Event) {
+	key := ""
+	if len(ev.DocumentKey) > 0 {
+		key = jsonString(ev.DocumentKey)
+		if i, ok := t.docs[key]; ok {
+			t.held[i] = coalesce(t.held[i], ev)
+			return
+		}
+	}
+
+	backlog := t.Backlog
+	if backlog == 0 {
+		backlog = DefaultThrottleBacklog
+	}
+	if len(t.held) >= backlog {
+		return
+	}
+	if key != "" {
+		if t.docs == nil {
+			t.docs = make(map[string]int)
+		}
+		t.docs[key] = len(t.held)
+	}
+	t.held = append(t.held, ev)
+}
+
+// release returns the held events the tokens allow.
+func (t *topicThrottle) release(now time.Time) []Event {
+	t.refill(now)
+	n := 0
+	for n < len(t.held) && t.tokens >= 1 {
+		t.tokens--
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	released := t.held[:n:n]
+	t.held = t.held[n:]
+	for key, i := range t.docs {
+		if i < n {
+			delete(t.docs, key)
+		} else {
+			t.docs[key] = i - n
+		}
+	}
+
+	return released
+}
+
+// coalesce merges the later change of a document into the earlier
+// one: an update is applied to the fields of the earlier insert,
+// replace or update, keeping its operation type and taking the raw
+// change of the later one, the other operations replace the earlier
+// change.
+//
+// # Parameters:
+//
+// 	- prev (Event): the earlier change.
+// 	- next (Event): the later change.
+//
+// # Example:
+//
+// 	held[i] = coalesce(held[i], ev)
+func coalesce(prev Event, next Event) Event {
+	if next.OperationType != "update" || (prev.OperationType != "insert" && prev.OperationType != event.OpReplace && prev.OperationType != "update") {
+		return next
+	}
+
+	merged := prev
+	merged.ClusterTime = next.ClusterTime
+	merged.RawChange = next.RawChange
+	merged.Fields = make(map[string]any, len(prev.Fields)+len(next.Fields))
+	for field, value := range prev.Fields {
+		merged.Fields[field] = value
+	}
+	for _, field := range next.Removed {
+		delete(merged.Fields, field)
+	}
+	for field, value := range next.Fields {
+		merged.Fields[field] = value
+	}
+	if prev.OperationType == "update" {
+		merged.Removed = nil
+		for _, field := range prev.Removed {
+			if _, ok := next.Fields[field]; !ok {
+				merged.Removed = append(merged.Removed, field)
+			}
+		}
+		merged.Removed = append(merged.Removed, next.Removed...)
+		merged.Truncated = make(map[string]int, len(prev.Truncated)+len(next.Truncated))
+		for field, size := range prev.Truncated {
+			merged.Truncated[field] = size
+		}
+		for field, size := range next.Truncated {
+			merged.Truncated[field] = size
+		}
+	}
+	if next.FullDocument != nil {
+		merged.FullDocument = next.FullDocument
+	}
+
+	return merged
+}
+
+// releaseThrottled dispatches the held events as the rates of their
+// topic allow, every throttleTick until the socketeer is stopped. The
+// events still held then are dropped.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Example:
+//
+// 	go s.releaseThrottled()
+func (s *Socketeer) releaseThrottled() {
+	ticker := time.NewTicker(throttleTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.releaseAt(now)
+		}
+	}
+}
+
+// releaseAt dispatches the held events the tokens allow at now.
+func (s *Socketeer) releaseAt(now time.Time) {
+	defer s.recoverPanic(map[string]any{"component": "throttle"})
+	s.throttleMux.Lock()
+	defer s.throttleMux.Unlock()
+
+	for _, t := range s.throttles {
+		for _, ev := range t.release(now) {
+			s.emit(ev)
+		}
+	}
+}
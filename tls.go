@@ -0,0 +1,140 @@
+package socketeer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultTLSReloadInterval is the interval the certificate files are
+// checked for changes at when TLSReloadInterval is not set.
+const DefaultTLSReloadInterval = 30 * time.Second
+
+// ErrInvalidTLS is returned by Start() and Validate() when the TLS
+// settings are incomplete or conflicting, or the certificate can't
+// be loaded.
+var ErrInvalidTLS = errors.New("socketeer: invalid TLS settings")
+
+// certFiles is the state of the certificate files, the modification
+// times of the loaded ones.
+//
+// 	- cert is the modification time of the certificate file.
+// 	- key is the modification time of the key file.
+type certFiles struct {
+	cert time.Time
+	key  time.Time
+}
+
+// checkTLS checks the TLS settings and loads the certificate files
+// when they are set.
+func (s *Socketeer) checkTLS() error {
+	if s.GetCertificate != nil && (s.TLSCertFile != "" || s.TLSKeyFile != "") {
+		return fmt.Errorf("%w: both GetCertificate and certificate files set", ErrInvalidTLS)
+	}
+	if (s.TLSCertFile == "") != (s.TLSKeyFile == "") {
+		return fmt.Errorf("%w: the certificate and key files go together", ErrInvalidTLS)
+	}
+	if s.TLSReloadInterval < 0 {
+		return fmt.Errorf("%w: negative reload interval", ErrInvalidTLS)
+	}
+	if s.TLSCertFile == "" {
+		return nil
+	}
+
+	_, err := s.loadCertificate()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTLS, err)
+	}
+
+	return nil
+}
+
+// tlsConfig returns the TLS configuration of the websocket server,
+// nil when it serves plain HTTP.
+func (s *Socketeer) tlsConfig() *tls.Config {
+	if s.GetCertificate != nil {
+		return &tls.Config{GetCertificate: s.GetCertificate, MinVersion: tls.VersionTLS12}
+	}
+	if s.TLSCertFile == "" {
+		return nil
+	}
+
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.cert.Load(), nil
+		},
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// loadCertificate loads the certificate files if they changed since
+// they were loaded, the new certificate is served to the following
+// handshakes while the established connections are left untouched.
+// Files which fail to load are only tried again once they change.
+// It reports whether the certificate was loaded.
+//
+// # Example:
+//
+// 	reloaded, err := s.loadCertificate()
+func (s *Socketeer) loadCertificate() (bool, error) {
+	certInfo, err := os.Stat(s.TLSCertFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(s.TLSKeyFile)
+	if err != nil {
+		return false, err
+	}
+	files := certFiles{cert: certInfo.ModTime(), key: keyInfo.ModTime()}
+	if s.cert.Load() != nil && files == s.certFiles {
+		return false, nil
+	}
+	s.certFiles = files
+
+	cert, err := tls.LoadX509KeyPair(s.TLSCertFile, s.TLSKeyFile)
+	if err != nil {
+		return false, err
+	}
+	s.cert.Store(&cert)
+
+	return true, nil
+}
+
+// reloadCertificates reloads the certificate files every
+// TLSReloadInterval until the socketeer is stopped, so that a
+// certificate rotation doesn't need a restart. A certificate which
+// fails to load, half written for example, is reported and the
+// previous one is kept until the next attempt.
+//
+// This method is called internally when the socketeer is started.
+//
+// # Example:
+//
+// 	go s.reloadCertificates()
+func (s *Socketeer) reloadCertificates() {
+	interval := s.TLSReloadInterval
+	if interval == 0 {
+		interval = DefaultTLSReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			reloaded, err := s.loadCertificate()
+			if err != nil {
+				s.log.Error("reloading certificate failed", "cert", s.TLSCertFile, "error", err)
+				s.report(err, map[string]any{"component": "tls", "cert": s.TLSCertFile})
+				continue
+			}
+			if reloaded {
+				s.log.Info("certificate reloaded", "cert", s.TLSCertFile)
+			}
+		}
+	}
+}
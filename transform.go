@@ -0,0 +1,160 @@
+package socketeer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/event"
+)
+
+// Transformer transforms the value of a field before it is encoded,
+// it returns the value as is when it doesn't apply to its type.
+//
+// # Example:
+//
+// 	s.Transforms = map[string]socketeer.Transformer{
+// 		"created_at": socketeer.RFC3339,
+// 		"price":      socketeer.Round(2),
+// 		"email":      socketeer.Lower,
+// 	}
+type Transformer func(value any) any
+
+// RFC3339 formats the dates in RFC 3339 with the UTC time zone,
+// example: 2024-01-01T00:00:00Z
+func RFC3339(value any) any {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case interface{ Time() time.Time }:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	}
+
+	return value
+}
+
+// Round returns a Transformer rounding the floating point numbers
+// to a number of decimals.
+//
+// # Parameters:
+//
+// 	- decimals (int): the number of decimals.
+//
+// # Example:
+//
+// 	s.Transforms = map[string]socketeer.Transformer{"price": socketeer.Round(2)}
+func Round(decimals int) Transformer {
+	scale := math.Pow(10, float64(decimals))
+
+	return func(value any) any {
+		switch v := value.(type) {
+		case float64:
+			return math.Round(v*scale) / scale
+		case float32:
+			return math.Round(float64(v)*scale) / scale
+		}
+		return value
+	}
+}
+
+// Lower lowercases the strings.
+func Lower(value any) any {
+	if s, ok := value.(string); ok {
+		return strings.ToLower(s)
+	}
+
+	return value
+}
+
+// Upper uppercases the strings.
+func Upper(value any) any {
+	if s, ok := value.(string); ok {
+		return strings.ToUpper(s)
+	}
+
+	return value
+}
+
+// Trim removes the leading and trailing white space of the strings.
+func Trim(value any) any {
+	if s, ok := value.(string); ok {
+		return strings.TrimSpace(s)
+	}
+
+	return value
+}
+
+// ParseTransformer returns the Transformer of a specification of the
+// configuration files: "rfc3339", "round:<decimals>", "lower", "upper"
+// or "trim".
+//
+// # Parameters:
+//
+// 	- spec (string): the specification.
+//
+// # Example:
+//
+// 	t, err := socketeer.ParseTransformer("round:2")
+func ParseTransformer(spec string) (Transformer, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+	switch {
+	case name == "rfc3339" && !hasArg:
+		return RFC3339, nil
+	case name == "lower" && !hasArg:
+		return Lower, nil
+	case name == "upper" && !hasArg:
+		return Upper, nil
+	case name == "trim" && !hasArg:
+		return Trim, nil
+	case name == "round" && hasArg:
+		decimals, err := strconv.Atoi(arg)
+		if err != nil || decimals < 0 {
+			return nil, fmt.Errorf("socketeer: transformer %q: invalid number of decimals", spec)
+		}
+		return Round(decimals), nil
+	}
+
+	return nil, fmt.Errorf("socketeer: unknown transformer %q", spec)
+}
+
+// transformFields returns the fields with the Transforms applied,
+// the fields themselves when no transformer applies.
+func (s *Socketeer) transformFields(fields map[string]any) map[string]any {
+	if len(s.Transforms) == 0 || len(fields) == 0 {
+		return fields
+	}
+
+	transformed := make(map[string]any, len(fields))
+	for field, value := range fields {
+		if t, ok := s.Transforms[field]; ok && value != nil {
+			value = t(value)
+		}
+		transformed[field] = value
+	}
+
+	return transformed
+}
+
+// transform applies the Transforms to the fields and the full document
+// of an event, the descriptions of the operations on the collection
+// are left as is.
+//
+// # Parameters:
+//
+// 	- ev (Event): the event.
+//
+// # Example:
+//
+// 	ev = s.transform(ev)
+func (s *Socketeer) transform(ev Event) Event {
+	if len(s.Transforms) == 0 || event.IsCollectionOp(ev.OperationType) {
+		return ev
+	}
+
+	ev.Fields = s.transformFields(ev.Fields)
+	ev.FullDocument = s.transformFields(ev.FullDocument)
+
+	return ev
+}
@@ -0,0 +1,178 @@
+package socketeer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/darthsalad/socketeer/internal/logger"
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// validatePingTimeout bounds the round trip to the change source
+// made by Validate().
+const validatePingTimeout = 5 * time.Second
+
+// minSessionSecret is the minimal length of a SessionSecret.
+const minSessionSecret = 16
+
+// pinger is implemented by the change sources which can check
+// that their backend is reachable, like the default DB.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Check is the result of one of the checks of Validate().
+//
+// 	- Name is the name of the check, example: "source".
+// 	- OK is whether the check passed.
+// 	- Error is the reason of the failure, if any.
+type Check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// ValidationReport is the result of Validate().
+//
+// 	- OK is whether every check passed.
+// 	- Checks are the checks in the order they were run.
+type ValidationReport struct {
+	OK     bool    `json:"ok"`
+	Checks []Check `json:"checks"`
+}
+
+// Add appends the result of a check to the report.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the check.
+// 	- err (error): the reason of the failure, nil when the check passed.
+//
+// # Example:
+//
+// 	report.Add("config", errors.Join(cfg.Validate()...))
+func (r *ValidationReport) Add(name string, err error) {
+	c := Check{Name: name, OK: err == nil}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	r.Checks = append(r.Checks, c)
+	r.OK = r.OK && c.OK
+}
+
+// Validate checks the settings of the socketeer and pings its change
+// source without starting any listener, it is meant to be run before
+// a deploy to catch the mistakes which would only show up at Start().
+//
+// # Example:
+//
+// 	report := s.Validate()
+// 	if !report.OK {
+// 		log.Fatal(report)
+// 	}
+func (s *Socketeer) Validate() ValidationReport {
+	report := ValidationReport{OK: true}
+
+	var err error
+	switch s.LogFormat {
+	case "", LogText, LogJSON:
+	default:
+		err = errors.New("unknown log format " + s.LogFormat)
+	}
+	report.Add("log format", err)
+
+	err = nil
+	if s.LogLevel != "" && !logger.ValidLevel(s.LogLevel) {
+		err = errors.New("unknown log level " + s.LogLevel)
+	}
+	report.Add("log level", err)
+
+	err = nil
+	if len(s.SessionSecret) != 0 && len(s.SessionSecret) < minSessionSecret {
+		err = errors.New("session secret shorter than 16 bytes")
+	}
+	if s.Sessions != nil && len(s.SessionSecret) == 0 {
+		err = errors.New("session store without a session secret, the tokens don't survive a restart")
+	}
+	report.Add("session secret", err)
+
+	err = nil
+	if s.Dashboard && s.AdminToken == "" {
+		err = errors.New("dashboard enabled without an admin token")
+	}
+	report.Add("admin", err)
+
+	err = nil
+	if s.DrainTimeout < 0 || s.MeterInterval < 0 || s.SessionTTL < 0 || s.HeartbeatTimeout < 0 || s.DispatchTimeout < 0 || s.SnapshotInterval < 0 || s.ClientHeartbeat < 0 || s.PingInterval < 0 {
+		err = errors.New("negative timeout")
+	}
+	report.Add("timeouts", err)
+
+	err = nil
+	if s.BatchSize < 0 || s.MaxAwaitTime < 0 {
+		err = errors.New("negative batch size or max await time")
+	}
+	report.Add("change stream", err)
+
+	err = nil
+	if s.MaxBatch < 0 {
+		err = errors.New("negative max batch")
+	}
+	report.Add("batching", err)
+
+	err = nil
+	if s.MaxConnsPerIdentity < 0 || s.MaxSubscriptions < 0 {
+		err = errors.New("negative connection or subscription limit")
+	}
+	report.Add("limits", err)
+
+	_, err = ws.ParseCIDRs(s.AllowCIDRs)
+	if err == nil {
+		_, err = ws.ParseCIDRs(s.DenyCIDRs)
+	}
+	if err == nil {
+		_, err = ws.ParseCIDRs(s.TrustedProxies)
+	}
+	report.Add("ip filter", err)
+
+	s.keysMux.Lock()
+	err = s.compileAllKeys()
+	s.keysMux.Unlock()
+	report.Add("keys", err)
+	report.Add("views", s.checkViews())
+
+	err = nil
+	if s.SinkBuffer < 0 {
+		err = errors.New("negative sink buffer")
+	}
+	names := make(map[string]struct{}, len(s.Sinks))
+	for _, sink := range s.Sinks {
+		if _, ok := names[sink.Name()]; ok {
+			err = errors.Join(err, fmt.Errorf("duplicate sink %q", sink.Name()))
+		}
+		names[sink.Name()] = struct{}{}
+	}
+	err = errors.Join(err, s.checkRetryPolicies())
+	report.Add("sinks", err)
+	report.Add("flow", s.checkFlow())
+	report.Add("templates", s.checkTemplates())
+	report.Add("aliases", s.checkAliases())
+	report.Add("throttles", s.checkThrottles())
+	report.Add("since", s.checkSince())
+	report.Add("tls", s.checkTLS())
+	report.Add("handlers", s.checkHandlers(""))
+	report.Add("cluster", s.checkCluster())
+	report.Add("failover", s.checkFailover())
+
+	err = nil
+	if p, ok := s.DB.(pinger); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), validatePingTimeout)
+		err = p.Ping(ctx)
+		cancel()
+	}
+	report.Add("source", err)
+
+	return report
+}
@@ -0,0 +1,72 @@
+package socketeer
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"github.com/darthsalad/socketeer/internal/ws"
+)
+
+// VersionPath is the path the build information is served on.
+const VersionPath = "/version"
+
+// BuildInfo is the build information and enabled features
+// of a running socketeer, served on VersionPath.
+//
+// 	- Version is the version of the package.
+// 	- Commit is the git commit the binary was built from.
+// 	- BuildDate is the date the binary was built at.
+// 	- GoVersion is the version of Go the binary was built with.
+// 	- Features are the enabled features, example: {"tls": false}
+type BuildInfo struct {
+	Version   string         `json:"version"`
+	Commit    string         `json:"commit"`
+	BuildDate string         `json:"buildDate"`
+	GoVersion string         `json:"goVersion"`
+	Features  map[string]any `json:"features"`
+}
+
+// BuildInfo returns the build information and the features
+// enabled on the socketeer, so operators can confirm what is deployed.
+//
+// # Example:
+//
+// 	info := s.BuildInfo()
+// 	fmt.Println(info.Version, info.Commit)
+func (s *Socketeer) BuildInfo() BuildInfo {
+	sinks := make([]string, 0, len(s.Sinks))
+	for _, sink := range s.Sinks {
+		sinks = append(sinks, sink.Name())
+	}
+
+	return BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Features: map[string]any{
+			"backend":   ws.Backend,
+			"protocols": []int{ws.ProtocolV1, ws.ProtocolV2, ws.ProtocolV3},
+			"chaos":     s.Chaos != nil,
+			"cluster":   s.Cluster != nil,
+			"tls":       s.TLSCertFile != "" || s.GetCertificate != nil,
+			"sinks":     sinks,
+		},
+	}
+}
+
+// serveVersion serves the build information as JSON.
+//
+// # Parameters:
+//
+// 	- res (http.ResponseWriter): the response writer.
+// 	- req (*http.Request): the request.
+//
+// # Example:
+//
+// 	r.Handle(VersionPath, http.HandlerFunc(s.serveVersion))
+func (s *Socketeer) serveVersion(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(s.BuildInfo())
+}
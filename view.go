@@ -0,0 +1,669 @@
+package socketeer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/darthsalad/socketeer/internal/event"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// View is a pipeline of aggregation stages applied to the events of a
+// collection before their keys are selected, like the definition of a
+// MongoDB view, which change streams can't watch: the clients get the
+// shape of the view instead of the one of the collection. Example:
+//
+// 	socketeer.View{
+// 		{"$match": map[string]any{"status": map[string]any{"$ne": "draft"}}},
+// 		{"$addFields": map[string]any{"author": map[string]any{"$concat": []any{"$first", " ", "$last"}}}},
+// 		{"$project": map[string]any{"first": 0, "last": 0}},
+// 	}
+//
+// The stages are $match, with the equality, $eq, $ne, $gt, $gte, $lt,
+// $lte, $in, $nin, $exists, $and, $or and $nor; $project, including,
+// excluding or computing fields; $addFields and its alias $set; and
+// $unset. The expressions are the field paths, example: "$author.name",
+// $literal, $concat, $toUpper, $toLower, $toString, $add, $subtract,
+// $multiply, $divide, $ifNull, $cond, $eq, $ne, $gt, $gte, $lt, $lte,
+// $and, $or and $not.
+//
+// A view is applied to the full document of an event when it has one,
+// example: the inserts and the looked up updates, and to its fields
+// otherwise. Its output replaces them, and the events it filters out
+// are not dispatched. The operations on the collection, like the
+// renames and the drops, and the deletes are dispatched as is.
+type View []map[string]any
+
+// viewStages are the supported stages, by name.
+var viewStages = map[string]func(doc map[string]any, spec any) (map[string]any, bool){
+	"$match":     matchStage,
+	"$project":   projectStage,
+	"$addFields": addFieldsStage,
+	"$set":       addFieldsStage,
+	"$unset":     unsetStage,
+}
+
+// viewOperators are the supported expression operators, by name,
+// they are called with the evaluated arguments.
+var viewOperators = map[string]func(args []any) any{
+	"$concat": func(args []any) any {
+		var b strings.Builder
+		for _, arg := range args {
+			str, ok := arg.(string)
+			if !ok {
+				return nil
+			}
+			b.WriteString(str)
+		}
+		return b.String()
+	},
+	"$toUpper": func(args []any) any { return strings.ToUpper(toString(first(args))) },
+	"$toLower": func(args []any) any { return strings.ToLower(toString(first(args))) },
+	"$toString": func(args []any) any {
+		if first(args) == nil {
+			return nil
+		}
+		return toString(first(args))
+	},
+	"$add":      arithmetic(func(a, b float64) float64 { return a + b }),
+	"$subtract": arithmetic(func(a, b float64) float64 { return a - b }),
+	"$multiply": arithmetic(func(a, b float64) float64 { return a * b }),
+	"$divide":   arithmetic(func(a, b float64) float64 { return a / b }),
+	"$ifNull": func(args []any) any {
+		for _, arg := range args {
+			if arg != nil {
+				return arg
+			}
+		}
+		return nil
+	},
+	"$cond": func(args []any) any {
+		if len(args) != 3 {
+			return nil
+		}
+		if truthy(args[0]) {
+			return args[1]
+		}
+		return args[2]
+	},
+	"$eq":  func(args []any) any { return len(args) == 2 && equal(args[0], args[1]) },
+	"$ne":  func(args []any) any { return len(args) == 2 && !equal(args[0], args[1]) },
+	"$gt":  comparison(func(c int) bool { return c > 0 }),
+	"$gte": comparison(func(c int) bool { return c >= 0 }),
+	"$lt":  comparison(func(c int) bool { return c < 0 }),
+	"$lte": comparison(func(c int) bool { return c <= 0 }),
+	"$and": func(args []any) any {
+		for _, arg := range args {
+			if !truthy(arg) {
+				return false
+			}
+		}
+		return true
+	},
+	"$or": func(args []any) any {
+		for _, arg := range args {
+			if truthy(arg) {
+				return true
+			}
+		}
+		return false
+	},
+	"$not": func(args []any) any { return !truthy(first(args)) },
+}
+
+// matchOperators are the supported query operators of $match, by name.
+var matchOperators = map[string]func(value any, found bool, arg any) bool{
+	"$eq":  func(value any, found bool, arg any) bool { return equal(value, arg) },
+	"$ne":  func(value any, found bool, arg any) bool { return !equal(value, arg) },
+	"$gt":  ordered(func(c int) bool { return c > 0 }),
+	"$gte": ordered(func(c int) bool { return c >= 0 }),
+	"$lt":  ordered(func(c int) bool { return c < 0 }),
+	"$lte": ordered(func(c int) bool { return c <= 0 }),
+	"$in":  func(value any, found bool, arg any) bool { return in(value, arg) },
+	"$nin": func(value any, found bool, arg any) bool { return !in(value, arg) },
+	"$exists": func(value any, found bool, arg any) bool {
+		return found == truthy(arg)
+	},
+}
+
+// Check reports the first stage or operator of the view which is not
+// supported, the views are checked by Start() and Validate().
+//
+// # Example:
+//
+// 	err := view.Check()
+func (v View) Check() error {
+	for i, stage := range v {
+		if len(stage) != 1 {
+			return fmt.Errorf("socketeer: view stage %d must have a single key", i)
+		}
+		for name, spec := range stage {
+			if _, ok := viewStages[name]; !ok {
+				return fmt.Errorf("socketeer: view stage %d: unsupported stage %s", i, name)
+			}
+			var err error
+			switch name {
+			case "$match":
+				err = checkQuery(spec)
+			case "$project", "$addFields", "$set":
+				fields, ok := asDocument(spec)
+				if !ok {
+					return fmt.Errorf("socketeer: view stage %d: %s takes a document", i, name)
+				}
+				for _, expr := range fields {
+					if err == nil {
+						err = checkExpr(expr)
+					}
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("socketeer: view stage %d: %w", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkQuery reports the first unsupported operator of a query.
+func checkQuery(spec any) error {
+	query, ok := asDocument(spec)
+	if !ok {
+		return errors.New("$match takes a document")
+	}
+	for field, cond := range query {
+		switch field {
+		case "$and", "$or", "$nor":
+			for _, sub := range asArray(cond) {
+				err := checkQuery(sub)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(field, "$") {
+			return fmt.Errorf("unsupported query operator %s", field)
+		}
+		ops, ok := asDocument(cond)
+		if !ok || !isOperator(ops) {
+			continue
+		}
+		for op := range ops {
+			if _, ok := matchOperators[op]; !ok {
+				return fmt.Errorf("unsupported query operator %s", op)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkExpr reports the first unsupported operator of an expression.
+func checkExpr(expr any) error {
+	if arr, ok := expr.([]any); ok {
+		for _, e := range arr {
+			err := checkExpr(e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	doc, ok := asDocument(expr)
+	if !ok {
+		return nil
+	}
+	if !isOperator(doc) {
+		for _, e := range doc {
+			err := checkExpr(e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(doc) != 1 {
+		return errors.New("an expression must have a single operator")
+	}
+	for op, args := range doc {
+		if op == "$literal" {
+			return nil
+		}
+		if _, ok := viewOperators[op]; !ok {
+			return fmt.Errorf("unsupported operator %s", op)
+		}
+		return checkExpr(args)
+	}
+
+	return nil
+}
+
+// viewed reports whether the events of an operation type go through
+// the views, the ones carrying a document.
+func viewed(op string) bool {
+	return !event.IsCollectionOp(op) && op != event.OpDelete
+}
+
+// checkViews checks the Views of every collection.
+func (s *Socketeer) checkViews() error {
+	for coll, view := range s.Views {
+		err := view.Check()
+		if err != nil {
+			return fmt.Errorf("%w, in the view of %s", err, coll)
+		}
+	}
+
+	return nil
+}
+
+// apply runs the view on the document of an event, and reports
+// whether the event passes its $match stages.
+//
+// # Parameters:
+//
+// 	- ev (Event): the event.
+//
+// # Example:
+//
+// 	ev, ok := view.apply(ev)
+func (v View) apply(ev Event) (Event, bool) {
+	doc := ev.Fields
+	if len(ev.FullDocument) > 0 {
+		doc = ev.FullDocument
+	}
+	for _, stage := range v {
+		for name, spec := range stage {
+			var ok bool
+			doc, ok = viewStages[name](doc, spec)
+			if !ok {
+				return ev, false
+			}
+		}
+	}
+
+	ev.Fields = doc
+	if len(ev.FullDocument) > 0 {
+		ev.FullDocument = doc
+	}
+
+	return ev, true
+}
+
+// matchStage keeps the documents matching a query.
+func matchStage(doc map[string]any, spec any) (map[string]any, bool) {
+	query, _ := asDocument(spec)
+
+	return doc, matches(doc, query)
+}
+
+// matches reports whether a document matches a query.
+func matches(doc map[string]any, query map[string]any) bool {
+	for field, cond := range query {
+		switch field {
+		case "$and", "$or", "$nor":
+			some, all := false, true
+			for _, sub := range asArray(cond) {
+				q, _ := asDocument(sub)
+				m := matches(doc, q)
+				some = some || m
+				all = all && m
+			}
+			if (field == "$and" && !all) || (field == "$or" && !some) || (field == "$nor" && some) {
+				return false
+			}
+			continue
+		}
+
+		value, found := lookup(doc, field)
+		ops, ok := asDocument(cond)
+		if !ok || !isOperator(ops) {
+			if !equal(value, cond) {
+				return false
+			}
+			continue
+		}
+		for op, arg := range ops {
+			if !matchOperators[op](value, found, arg) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// projectStage includes, excludes or computes the fields of a document,
+// the _id is kept unless it is excluded.
+func projectStage(doc map[string]any, spec any) (map[string]any, bool) {
+	fields, _ := asDocument(spec)
+
+	exclusion := true
+	for field, expr := range fields {
+		if field != "_id" && !isFlag(expr, false) {
+			exclusion = false
+		}
+	}
+
+	out := make(map[string]any)
+	if exclusion {
+		for field, value := range doc {
+			out[field] = value
+		}
+		for field := range fields {
+			delete(out, field)
+		}
+		return out, true
+	}
+
+	if id, ok := doc["_id"]; ok {
+		out["_id"] = id
+	}
+	for field, expr := range fields {
+		switch {
+		case isFlag(expr, false):
+			delete(out, field)
+		case isFlag(expr, true):
+			if value, ok := lookup(doc, field); ok {
+				out[field] = value
+			}
+		default:
+			if value := eval(doc, expr); value != nil {
+				out[field] = value
+			}
+		}
+	}
+
+	return out, true
+}
+
+// addFieldsStage computes fields of a document, the expressions see
+// the document before the stage.
+func addFieldsStage(doc map[string]any, spec any) (map[string]any, bool) {
+	fields, _ := asDocument(spec)
+
+	out := make(map[string]any, len(doc)+len(fields))
+	for field, value := range doc {
+		out[field] = value
+	}
+	for field, expr := range fields {
+		out[field] = eval(doc, expr)
+	}
+
+	return out, true
+}
+
+// unsetStage removes a field or a list of fields of a document.
+func unsetStage(doc map[string]any, spec any) (map[string]any, bool) {
+	out := make(map[string]any, len(doc))
+	for field, value := range doc {
+		out[field] = value
+	}
+	if field, ok := spec.(string); ok {
+		delete(out, field)
+	}
+	for _, field := range asArray(spec) {
+		if f, ok := field.(string); ok {
+			delete(out, f)
+		}
+	}
+
+	return out, true
+}
+
+// eval evaluates an expression on a document.
+func eval(doc map[string]any, expr any) any {
+	if path, ok := expr.(string); ok && strings.HasPrefix(path, "$") {
+		value, _ := lookup(doc, path[1:])
+		return value
+	}
+	if arr, ok := expr.([]any); ok {
+		out := make([]any, 0, len(arr))
+		for _, e := range arr {
+			out = append(out, eval(doc, e))
+		}
+		return out
+	}
+	fields, ok := asDocument(expr)
+	if !ok {
+		return expr
+	}
+	if !isOperator(fields) {
+		out := make(map[string]any, len(fields))
+		for field, e := range fields {
+			out[field] = eval(doc, e)
+		}
+		return out
+	}
+
+	for op, args := range fields {
+		if op == "$literal" {
+			return args
+		}
+		var evaluated []any
+		if arr, ok := args.([]any); ok {
+			for _, arg := range arr {
+				evaluated = append(evaluated, eval(doc, arg))
+			}
+		} else {
+			evaluated = []any{eval(doc, args)}
+		}
+		return viewOperators[op](evaluated)
+	}
+
+	return nil
+}
+
+// lookup returns the value of a dotted path in a document, and
+// whether it was found.
+func lookup(doc map[string]any, path string) (any, bool) {
+	var value any = doc
+	for _, part := range strings.Split(path, ".") {
+		sub, ok := asDocument(value)
+		if !ok {
+			return nil, false
+		}
+		value, ok = sub[part]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// asDocument returns a document as a map, whichever way it was
+// decoded or written.
+func asDocument(value any) (map[string]any, bool) {
+	switch doc := value.(type) {
+	case map[string]any:
+		return doc, true
+	case primitive.M:
+		return doc, true
+	case primitive.D:
+		return doc.Map(), true
+	}
+
+	return nil, false
+}
+
+// asArray returns an array as a slice of values.
+func asArray(value any) []any {
+	switch arr := value.(type) {
+	case []any:
+		return arr
+	case primitive.A:
+		return arr
+	case []string:
+		out := make([]any, 0, len(arr))
+		for _, s := range arr {
+			out = append(out, s)
+		}
+		return out
+	}
+
+	return nil
+}
+
+// isOperator reports whether the keys of a document are operators.
+func isOperator(doc map[string]any) bool {
+	for key := range doc {
+		return strings.HasPrefix(key, "$")
+	}
+
+	return false
+}
+
+// isFlag reports whether a value of $project is an inclusion flag,
+// 1 or true, or an exclusion one, 0 or false.
+func isFlag(value any, include bool) bool {
+	if b, ok := value.(bool); ok {
+		return b == include
+	}
+	if n, ok := toFloat(value); ok {
+		return (n != 0) == include
+	}
+
+	return false
+}
+
+// truthy reports whether a value is true in an expression.
+func truthy(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	if n, ok := toFloat(value); ok {
+		return n != 0
+	}
+
+	return true
+}
+
+// first returns the first argument, nil when there is none.
+func first(args []any) any {
+	if len(args) == 0 {
+		return nil
+	}
+
+	return args[0]
+}
+
+// toString returns a value as a string, formatted like the data
+// of the messages.
+func toString(value any) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// toFloat returns a number as a float64, and whether it is a number.
+func toFloat(value any) (float64, bool) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+
+	return 0, false
+}
+
+// compare orders two numbers or two strings, and reports whether
+// they can be ordered.
+func compare(a any, b any) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, ok := a.(string)
+	y, ok2 := b.(string)
+	if !ok || !ok2 {
+		return 0, false
+	}
+
+	return strings.Compare(x, y), true
+}
+
+// equal reports whether two values are equal, the numbers of
+// different types being compared by value.
+func equal(a any, b any) bool {
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// in reports whether a value is in an array.
+func in(value any, arr any) bool {
+	for _, v := range asArray(arr) {
+		if equal(value, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// arithmetic returns an operator folding its numeric arguments,
+// nil when one of them is not a number.
+func arithmetic(op func(a, b float64) float64) func(args []any) any {
+	return func(args []any) any {
+		if len(args) == 0 {
+			return nil
+		}
+		acc, ok := toFloat(args[0])
+		if !ok {
+			return nil
+		}
+		for _, arg := range args[1:] {
+			n, ok := toFloat(arg)
+			if !ok {
+				return nil
+			}
+			acc = op(acc, n)
+		}
+		return acc
+	}
+}
+
+// comparison returns an expression operator ordering its two
+// arguments, false when they can't be ordered.
+func comparison(test func(c int) bool) func(args []any) any {
+	return func(args []any) any {
+		if len(args) != 2 {
+			return false
+		}
+		c, ok := compare(args[0], args[1])
+		return ok && test(c)
+	}
+}
+
+// ordered returns a query operator comparing the value of a field.
+func ordered(test func(c int) bool) func(value any, found bool, arg any) bool {
+	return func(value any, found bool, arg any) bool {
+		c, ok := compare(value, arg)
+		return ok && test(c)
+	}
+}
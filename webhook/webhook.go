@@ -0,0 +1,154 @@
+// Package webhook provides a sink of the socketeer posting every
+// message as JSON to an HTTP endpoint, or rendered with a template.
+//
+// # Usage:
+//
+// 	s.Sinks = []socketeer.Sink{webhook.New("audit", "https://example.com/hook")}
+//
+// or, to post a Slack-friendly text:
+//
+// 	hook := webhook.New("slack", "https://hooks.slack.com/services/...")
+// 	hook.Template, err = socketeer.ParseTemplate("slack", `{"text": {{json (printf "New post: %s" .Data.title)}}}`)
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/darthsalad/socketeer"
+)
+
+// DefaultTimeout bounds every request of a Sink when Client is nil.
+const DefaultTimeout = 10 * time.Second
+
+// StatusError is the failure of a request answered with a status
+// other than 2xx.
+//
+// 	- Status is the status code of the response.
+type StatusError struct {
+	Status int
+}
+
+// Error returns the description of the failure.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook: status %d", e.Status)
+}
+
+// Sink is a socketeer.Sink posting the messages to a URL.
+//
+// 	- name is the name of the sink.
+// 	- URL is the URL the messages are posted to.
+// 	- Headers are added to every request, like an Authorization header.
+// 	- Client sends the requests, a client with DefaultTimeout when nil.
+// 	- Template renders the body of the requests from the message, see
+// 		socketeer.ParseTemplate(), the JSON of the message when nil.
+// 	- ContentType is the content type of the body, application/json
+// 		when empty.
+type Sink struct {
+	name        string
+	URL         string
+	Headers     map[string]string
+	Client      *http.Client
+	Template    *template.Template
+	ContentType string
+}
+
+// New returns a new Sink posting to url.
+//
+// # Parameters:
+//
+// 	- name (string): the name of the sink.
+// 	- url (string): the URL the messages are posted to.
+//
+// # Example:
+//
+// 	hook := webhook.New("audit", "https://example.com/hook")
+func New(name string, url string) *Sink {
+	return &Sink{name: name, URL: url}
+}
+
+// Name returns the name of the sink.
+func (s *Sink) Name() string {
+	return s.name
+}
+
+// Deliver posts a message as JSON, or rendered with the Template,
+// it fails unless the response status is 2xx.
+//
+// # Parameters:
+//
+// 	- ctx (context.Context): bounds the request.
+// 	- msg (socketeer.Message): the message.
+//
+// # Example:
+//
+// 	err := hook.Deliver(ctx, msg)
+func (s *Sink) Deliver(ctx context.Context, msg socketeer.Message) error {
+	body, err := s.body(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	contentType := s.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &StatusError{Status: res.StatusCode}
+	}
+
+	return nil
+}
+
+// body returns the body of the request of a message.
+func (s *Sink) body(msg socketeer.Message) ([]byte, error) {
+	if s.Template == nil {
+		return json.Marshal(msg)
+	}
+
+	var buf bytes.Buffer
+	err := s.Template.Execute(&buf, msg)
+
+	return buf.Bytes(), err
+}
+
+// RetryPolicy retries the failures with the defaults of the socketeer,
+// except the 4xx statuses other than 408 and 429, which a retry won't fix.
+//
+// # Example:
+//
+// 	policy := hook.RetryPolicy()
+func (s *Sink) RetryPolicy() socketeer.RetryPolicy {
+	return socketeer.RetryPolicy{
+		Retryable: func(err error) bool {
+			var status *StatusError
+			if !errors.As(err, &status) {
+				return true
+			}
+			return status.Status >= 500 || status.Status == http.StatusRequestTimeout || status.Status == http.StatusTooManyRequests
+		},
+	}
+}